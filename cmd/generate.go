@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli/v2"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+var prompt string
+var maxNewTokens int
+var temperature float64
+var topK int
+var topP float64
+var repetitionPenalty float64
+var seed int64
+var stopSequences cli.StringSlice
+
+var generateCommand = &cli.Command{
+	Name:  "generate",
+	Usage: "Generate text from a prompt with a decoder-only model",
+	Description: `Generate expects a prompt, passed with --prompt or on stdin, and streams the generated text to stdout as it is produced.
+				`,
+	ArgsUsage: `
+				--model: model name or path to the folder of the decoder-only onnx model, looked up like in the run command.
+				--prompt: the prompt to continue. If omitted, the prompt is read from stdin.
+				--maxNewTokens: maximum number of tokens to generate.
+				--temperature, --topK, --topP: enable sampling with these parameters. Without them, decoding is greedy.
+				--repetitionPenalty: penalty applied to the tokens already in the sequence.
+				--seed: seed of the sampling, for deterministic outputs.
+				--stop: comma separated sequences that stop the generation.
+				--onnxruntimeSharedLibrary: path to the onnxruntime.so library.
+				`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "model",
+			Usage:       "Path to the model",
+			Aliases:     []string{"p"},
+			Destination: &modelPath,
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "prompt",
+			Usage:       "Prompt to continue",
+			Destination: &prompt,
+		},
+		&cli.IntFlag{
+			Name:        "maxNewTokens",
+			Usage:       "Maximum number of generated tokens",
+			Destination: &maxNewTokens,
+			Value:       50,
+		},
+		&cli.Float64Flag{
+			Name:        "temperature",
+			Usage:       "Sampling temperature",
+			Destination: &temperature,
+		},
+		&cli.IntFlag{
+			Name:        "topK",
+			Usage:       "Sample among the k most likely tokens",
+			Destination: &topK,
+		},
+		&cli.Float64Flag{
+			Name:        "topP",
+			Usage:       "Nucleus sampling probability",
+			Destination: &topP,
+		},
+		&cli.Float64Flag{
+			Name:        "repetitionPenalty",
+			Usage:       "Penalty for tokens already in the sequence",
+			Destination: &repetitionPenalty,
+			Value:       1,
+		},
+		&cli.Int64Flag{
+			Name:        "seed",
+			Usage:       "Seed for sampling",
+			Destination: &seed,
+		},
+		&cli.StringSliceFlag{
+			Name:        "stop",
+			Usage:       "Stop sequences",
+			Destination: &stopSequences,
+		},
+		&cli.StringFlag{
+			Name:        "onnxruntimeSharedLibrary",
+			Usage:       "Path to onnxruntime.so",
+			Aliases:     []string{"s"},
+			Destination: &sharedLibraryPath,
+		},
+		&cli.StringFlag{
+			Name:        "modelFolder",
+			Usage:       "Folder where to store downloaded models. Falls back to $HOME/hugot/models if not specified",
+			Aliases:     []string{"f"},
+			Destination: &modelsDir,
+		},
+	},
+	Action: func(ctx *cli.Context) (err error) {
+		if prompt == "" && !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+			promptBytes, readErr := io.ReadAll(os.Stdin)
+			if readErr != nil {
+				return readErr
+			}
+			prompt = strings.TrimSpace(string(promptBytes))
+		}
+		if prompt == "" {
+			return errors.New("a prompt is required, pass it with --prompt or on stdin")
+		}
+
+		session, err := newCliSession(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errors.Join(err, session.Destroy())
+		}()
+
+		modelPath, err = resolveModelPath(ctx.Context, session, modelPath)
+		if err != nil {
+			return err
+		}
+
+		options := []hugot.TextGenerationOption{
+			pipelines.WithMaxTokens(maxNewTokens),
+			pipelines.WithRepetitionPenalty(float32(repetitionPenalty)),
+			pipelines.WithStopSequences(stopSequences.Value()),
+		}
+		if temperature > 0 {
+			options = append(options, pipelines.WithTemperature(float32(temperature)))
+		}
+		if topK > 0 {
+			options = append(options, pipelines.WithTopK(topK))
+		}
+		if topP > 0 {
+			options = append(options, pipelines.WithTopP(float32(topP)))
+		}
+		if ctx.IsSet("seed") {
+			options = append(options, pipelines.WithSeed(seed))
+		}
+
+		config := hugot.TextGenerationConfig{
+			ModelPath: modelPath,
+			Name:      "cliGenerationPipeline",
+			Options:   options,
+		}
+		pipe, err := hugot.NewPipeline(session, config)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = pipe.Stream(prompt, func(token pipelines.GeneratedToken) error {
+			_, writeErr := fmt.Fprint(os.Stdout, token.Text)
+			return writeErr
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout)
+		return err
+	},
+}
@@ -27,19 +27,24 @@ var outputPath string
 var pipelineType string
 var sharedLibraryPath string
 var batchSize int
+var maxTokensPerBatch int
 var modelsDir string
+var labels cli.StringSlice
 
 var runCommand = &cli.Command{
 	Name:  "run",
 	Usage: "Run a huggingface pipeline on input data",
 	Description: `Run expects a path to a file with input in .jsonl format. Each json line in the file must be of the format {"input": "input string"} to be processed.
+				Pipelines that support text pairs (e.g. textClassification with NLI or paraphrase models) also accept lines of the format {"input": "first string", "pair": "second string"}.
 				`,
 	ArgsUsage: `
 				--input: path to a .jsonl file or a folder with .jsonl files to process. If omitted, the input will be read from stdin.
 				--output: path to a folder where to write the output. If omitted, the output will be sent to stdout.
 				--model: model name or path to the .onnx model to load. The hugot cli looks for models with this chain: first use the provided path. If the path does not exist, look for a model
 				with this name at $HOME/hugot/models. Finally, try to download the model from Huggingface and use it.
-				--type: pipeline type. Currently implemented types are: featureExtraction, tokenClassification, and textClassification (only single label)
+				--type: pipeline type. Currently implemented types are: featureExtraction, tokenClassification, textClassification (only single label), zeroShotClassification, fillMask, and text2textGeneration
+				--labels: comma separated candidate labels for the zeroShotClassification pipeline.
+				--maxTokensPerBatch: if set, the inputs of a batch are sorted by length and run in sub-batches of at most this many tokens, padding included, to minimise padding.
 				--onnxruntimeSharedLibrary: path to the onnxruntime.so library. If not provided, the cli will try to load it from $HOME/lib/hugot/onnxruntime.so, and from /usr/lib/onnxruntime.so in the last instance.
 				`,
 	Flags: []cli.Flag{
@@ -84,6 +89,12 @@ var runCommand = &cli.Command{
 			Required:    false,
 			Value:       20,
 		},
+		&cli.IntFlag{
+			Name:        "maxTokensPerBatch",
+			Usage:       "Maximum number of tokens, padding included, of the length bucketed sub-batches of a batch",
+			Destination: &maxTokensPerBatch,
+			Required:    false,
+		},
 		&cli.StringFlag{
 			Name:        "modelFolder",
 			Usage:       "Folder where to store downloaded models. Falls back to $HOME/hugot/models if not specified",
@@ -92,30 +103,16 @@ var runCommand = &cli.Command{
 			Required:    false,
 			Value:       "",
 		},
+		&cli.StringSliceFlag{
+			Name:        "labels",
+			Usage:       "Candidate labels for zero shot classification",
+			Aliases:     []string{"l"},
+			Destination: &labels,
+			Required:    false,
+		},
 	},
 	Action: func(ctx *cli.Context) error {
-		var opts []hugot.WithOption
-
-		if modelsDir == "" {
-			userDir, err := os.UserHomeDir()
-			if err != nil {
-				return err
-			}
-			modelsDir = util.PathJoinSafe(userDir, "hugot", "models")
-		}
-
-		if sharedLibraryPath != "" {
-			opts = append(opts, hugot.WithOnnxLibraryPath(sharedLibraryPath))
-		} else {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				if exists, err := util.FileSystem.Exists(ctx.Context, path.Join(homeDir, "lib", "hugot", "onnxruntime.so")); err != nil && exists {
-					opts = append(opts, hugot.WithOnnxLibraryPath(path.Join(homeDir, "lib", "hugot", "onnxruntime.so")))
-				}
-			}
-		}
-
-		session, err := hugot.NewSession(opts...)
+		session, err := newCliSession(ctx)
 		if err != nil {
 			return err
 		}
@@ -127,63 +124,19 @@ var runCommand = &cli.Command{
 			setupErrs = append(setupErrs, err)
 		}()
 
-		var pipe pipelines.Pipeline
-
-		// is the model a full path to a model
-		ok, err := util.FileSystem.Exists(ctx.Context, modelPath)
+		modelPath, err = resolveModelPath(ctx.Context, session, modelPath)
 		if err != nil {
 			return err
 		}
-		if !ok {
-			// is the model the name of a model previously downloaded
-			downloadedModelName := strings.Replace(modelPath, "/", "_", -1)
-			ok, err = util.FileSystem.Exists(ctx.Context, util.PathJoinSafe(modelsDir, downloadedModelName))
-			if err != nil {
-				return err
-			}
-			if ok {
-				modelPath = util.PathJoinSafe(modelsDir, downloadedModelName)
-			} else {
-				// is the model the name of a model to download
-				if strings.Contains(modelPath, ":") {
-					return fmt.Errorf("filters with : are currently not supported")
-				}
-				err = util.FileSystem.Create(context.Background(), modelsDir, os.ModePerm, true)
-				if err != nil {
-					return err
-				}
-				modelPath, err = session.DownloadModel(modelPath, modelsDir, hugot.NewDownloadOptions())
-				if err != nil {
-					return err
-				}
-			}
-		}
 
-		switch pipelineType {
-		case "tokenClassification":
-			config := hugot.TokenClassificationConfig{
-				ModelPath: modelPath,
-				Name:      "cliPipeline",
-			}
-			pipe, err = hugot.NewPipeline(session, config)
-			setupErrs = append(setupErrs, err)
-		case "textClassification":
-			config := hugot.TextClassificationConfig{
-				ModelPath: modelPath,
-				Name:      "cliPipeline",
-			}
-			pipe, err = hugot.NewPipeline(session, config)
-			setupErrs = append(setupErrs, err)
-		case "featureExtraction":
-			config := hugot.FeatureExtractionConfig{
-				ModelPath: modelPath,
-				Name:      "cliPipeline",
-			}
-			pipe, err = hugot.NewPipeline(session, config)
-			setupErrs = append(setupErrs, err)
-		default:
-			setupErrs = append(setupErrs, fmt.Errorf("pipeline type %s not implemented", pipelineType))
-		}
+		pipe, err := newCliPipeline(session, pipelineConfig{
+			Name:              "cliPipeline",
+			Type:              pipelineType,
+			Model:             modelPath,
+			Labels:            labels.Value(),
+			MaxTokensPerBatch: maxTokensPerBatch,
+		})
+		setupErrs = append(setupErrs, err)
 		if e := errors.Join(setupErrs...); e != nil {
 			return e
 		}
@@ -284,11 +237,128 @@ var runCommand = &cli.Command{
 	},
 }
 
+// newCliSession creates the hugot session, loading onnxruntime from the path given on the command line or
+// from $HOME/lib/hugot/onnxruntime.so.
+func newCliSession(ctx *cli.Context) (*hugot.Session, error) {
+	var opts []hugot.WithOption
+
+	if modelsDir == "" {
+		userDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		modelsDir = util.PathJoinSafe(userDir, "hugot", "models")
+	}
+
+	if sharedLibraryPath != "" {
+		opts = append(opts, hugot.WithOnnxLibraryPath(sharedLibraryPath))
+	} else {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			if exists, err := util.FileSystem.Exists(ctx.Context, path.Join(homeDir, "lib", "hugot", "onnxruntime.so")); err != nil && exists {
+				opts = append(opts, hugot.WithOnnxLibraryPath(path.Join(homeDir, "lib", "hugot", "onnxruntime.so")))
+			}
+		}
+	}
+
+	return hugot.NewSession(opts...)
+}
+
+// pipelineConfig describes a pipeline created by the cli, from the flags of the run command or from the config
+// file of the serve command.
+type pipelineConfig struct {
+	Name              string   `json:"name"`
+	Type              string   `json:"type"`
+	Model             string   `json:"model"`
+	Labels            []string `json:"labels,omitempty"`
+	MaxTokensPerBatch int      `json:"maxTokensPerBatch,omitempty"`
+}
+
+// newCliPipeline creates a pipeline of the given type in the session. The model must already be resolved to a
+// folder, see resolveModelPath.
+func newCliPipeline(session *hugot.Session, config pipelineConfig) (pipelines.Pipeline, error) {
+	switch config.Type {
+	case "tokenClassification":
+		return hugot.NewPipeline(session, hugot.TokenClassificationConfig{
+			ModelPath:         config.Model,
+			Name:              config.Name,
+			MaxTokensPerBatch: config.MaxTokensPerBatch,
+		})
+	case "textClassification":
+		return hugot.NewPipeline(session, hugot.TextClassificationConfig{
+			ModelPath:         config.Model,
+			Name:              config.Name,
+			MaxTokensPerBatch: config.MaxTokensPerBatch,
+		})
+	case "featureExtraction":
+		return hugot.NewPipeline(session, hugot.FeatureExtractionConfig{
+			ModelPath:         config.Model,
+			Name:              config.Name,
+			MaxTokensPerBatch: config.MaxTokensPerBatch,
+		})
+	case "zeroShotClassification":
+		return hugot.NewPipeline(session, hugot.ZeroShotClassificationConfig{
+			ModelPath:         config.Model,
+			Name:              config.Name,
+			MaxTokensPerBatch: config.MaxTokensPerBatch,
+			Options: []hugot.ZeroShotClassificationOption{
+				pipelines.WithLabels(config.Labels),
+			},
+		})
+	case "fillMask":
+		return hugot.NewPipeline(session, hugot.FillMaskConfig{
+			ModelPath:         config.Model,
+			Name:              config.Name,
+			MaxTokensPerBatch: config.MaxTokensPerBatch,
+		})
+	case "text2textGeneration":
+		return hugot.NewPipeline(session, hugot.Text2TextGenerationConfig{
+			ModelPath: config.Model,
+			Name:      config.Name,
+		})
+	default:
+		return nil, fmt.Errorf("pipeline type %s not implemented", config.Type)
+	}
+}
+
+// resolveModelPath returns the folder of the model: the model path itself if it exists, then a previously
+// downloaded model with this name in the models folder, and finally the model downloaded from Huggingface.
+func resolveModelPath(ctx context.Context, session *hugot.Session, modelPath string) (string, error) {
+	// is the model a full path to a model
+	ok, err := util.FileSystem.Exists(ctx, modelPath)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return modelPath, nil
+	}
+
+	// is the model the name of a model previously downloaded
+	downloadedModelName := strings.Replace(modelPath, "/", "_", -1)
+	ok, err = util.FileSystem.Exists(ctx, util.PathJoinSafe(modelsDir, downloadedModelName))
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return util.PathJoinSafe(modelsDir, downloadedModelName), nil
+	}
+
+	// is the model the name of a model to download
+	if strings.Contains(modelPath, ":") {
+		return "", fmt.Errorf("filters with : are currently not supported")
+	}
+	err = util.FileSystem.Create(ctx, modelsDir, os.ModePerm, true)
+	if err != nil {
+		return "", err
+	}
+	return session.DownloadModelContext(ctx, modelPath, modelsDir, hugot.NewDownloadOptions())
+}
+
 func main() {
 	app := &cli.App{
 		Name:     "hugot",
 		Usage:    "Huggingface transformers from the command line - alpha",
-		Commands: []*cli.Command{runCommand},
+		Commands: []*cli.Command{runCommand, generateCommand, serveCommand},
 	}
 	if err := app.Run(os.Args); err != nil {
 		panic(err)
@@ -328,30 +398,60 @@ func writeOutputs(wg *sync.WaitGroup, processedChannel chan []byte, errorChannel
 
 func processWithPipeline(wg *sync.WaitGroup, inputChannel chan []input, processedChannel chan []byte, errorsChannel chan error, p pipelines.Pipeline) {
 	for inputBatch := range inputChannel {
-		inputStrings := make([]string, len(inputBatch))
-		for i := 0; i < len(inputBatch); i++ {
-			inputStrings[i] = inputBatch[i].Input
+		// lines with a pair are run through the pair encoding of the pipeline, the others as single strings
+		var singleInputs, pairInputs []input
+		for _, in := range inputBatch {
+			if in.Pair != nil {
+				pairInputs = append(pairInputs, in)
+			} else {
+				singleInputs = append(singleInputs, in)
+			}
 		}
-		output, err := p.Run(inputStrings)
-		if err != nil {
-			errorsChannel <- err
-		} else {
-			batchOutputs := output.GetOutput()
-			for i, batchOutput := range batchOutputs {
-				out := inputBatch[i]
-				out.Output = batchOutput
-				outputBytes, marshallErr := json.Marshal(out)
-				if marshallErr != nil {
-					errorsChannel <- marshallErr
-				} else {
-					processedChannel <- outputBytes
-				}
+
+		if len(singleInputs) > 0 {
+			inputStrings := make([]string, len(singleInputs))
+			for i := 0; i < len(singleInputs); i++ {
+				inputStrings[i] = singleInputs[i].Input
+			}
+			output, err := p.Run(inputStrings)
+			sendOutputs(singleInputs, output, err, processedChannel, errorsChannel)
+		}
+
+		if len(pairInputs) > 0 {
+			pairPipeline, ok := p.(pipelines.PairPipeline)
+			if !ok {
+				errorsChannel <- fmt.Errorf("pipeline type %s does not support text pair inputs", pipelineType)
+				continue
 			}
+			inputPairs := make([][2]string, len(pairInputs))
+			for i := 0; i < len(pairInputs); i++ {
+				inputPairs[i] = [2]string{pairInputs[i].Input, *pairInputs[i].Pair}
+			}
+			output, err := pairPipeline.RunPairs(inputPairs)
+			sendOutputs(pairInputs, output, err, processedChannel, errorsChannel)
 		}
 	}
 	wg.Done()
 }
 
+func sendOutputs(inputBatch []input, output pipelines.PipelineBatchOutput, err error, processedChannel chan []byte, errorsChannel chan error) {
+	if err != nil {
+		errorsChannel <- err
+		return
+	}
+	batchOutputs := output.GetOutput()
+	for i, batchOutput := range batchOutputs {
+		out := inputBatch[i]
+		out.Output = batchOutput
+		outputBytes, marshallErr := json.Marshal(out)
+		if marshallErr != nil {
+			errorsChannel <- marshallErr
+		} else {
+			processedChannel <- outputBytes
+		}
+	}
+}
+
 func readInputs(inputSource io.Reader, inputChannel chan []input) error {
 	inputBatch := make([]input, 0, 20)
 
@@ -376,6 +476,7 @@ func readInputs(inputSource io.Reader, inputChannel chan []input) error {
 }
 
 type input struct {
-	Input  string `json:"input"`
-	Output any    `json:"output"`
+	Input  string  `json:"input"`
+	Pair   *string `json:"pair,omitempty"`
+	Output any     `json:"output"`
 }
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/urfave/cli/v2"
 
+	"github.com/knights-analytics/hugot"
 	util "github.com/knights-analytics/hugot/utils"
 )
 
@@ -19,6 +28,9 @@ var textClassificationData []byte
 //go:embed testData/tokenClassification.jsonl
 var tokenClassificationData []byte
 
+//go:embed testData/textPairClassification.jsonl
+var textPairClassificationData []byte
+
 func TestTextClassificationCli(t *testing.T) {
 	app := &cli.App{
 		Name:     "hugot",
@@ -109,6 +121,232 @@ func TestFeatureExtractionCli(t *testing.T) {
 	fmt.Println(string(result))
 }
 
+func TestMaxTokensPerBatchCli(t *testing.T) {
+	app := &cli.App{
+		Name:     "hugot",
+		Usage:    "Huggingface transformers from the command line - alpha",
+		Commands: []*cli.Command{runCommand},
+	}
+	baseArgs := os.Args[0:1]
+
+	testModel := path.Join("../models", "KnightsAnalytics_all-MiniLM-L6-v2")
+
+	testDataDir := path.Join(os.TempDir(), "hugoTestData")
+	err := os.MkdirAll(testDataDir, os.ModePerm)
+	check(t, err)
+	err = os.WriteFile(path.Join(testDataDir, "test-max-tokens.jsonl"), tokenClassificationData, os.ModePerm)
+	check(t, err)
+	defer func() {
+		err := os.RemoveAll(testDataDir)
+		check(t, err)
+	}()
+
+	args := append(baseArgs, "run", fmt.Sprintf("--input=%s", path.Join(testDataDir, "test-max-tokens.jsonl")),
+		fmt.Sprintf("--model=%s", testModel), "--type=featureExtraction", "--maxTokensPerBatch=16", fmt.Sprintf("--output=%s", testDataDir))
+	if err := app.Run(args); err != nil {
+		check(t, err)
+	}
+	result, err := os.ReadFile(path.Join(testDataDir, "result-0.jsonl"))
+	check(t, err)
+	nonEmptyLines := func(data []byte) int {
+		n := 0
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, nonEmptyLines(tokenClassificationData), nonEmptyLines(result))
+}
+
+func TestZeroShotClassificationCli(t *testing.T) {
+	app := &cli.App{
+		Name:     "hugot",
+		Usage:    "Huggingface transformers from the command line - alpha",
+		Commands: []*cli.Command{runCommand},
+	}
+	baseArgs := os.Args[0:1]
+
+	testModel := path.Join("../models", "protectai_deberta-v3-base-zeroshot-v1-onnx")
+
+	testDataDir := path.Join(os.TempDir(), "hugoTestData")
+	err := os.MkdirAll(testDataDir, os.ModePerm)
+	check(t, err)
+	err = os.WriteFile(path.Join(testDataDir, "test-zero-shot-classification.jsonl"), textClassificationData, os.ModePerm)
+	check(t, err)
+	defer func() {
+		err := os.RemoveAll(testDataDir)
+		check(t, err)
+	}()
+
+	args := append(baseArgs, "run", fmt.Sprintf("--input=%s", path.Join(testDataDir, "test-zero-shot-classification.jsonl")),
+		fmt.Sprintf("--model=%s", testModel), "--type=zeroShotClassification", "--labels=positive,negative", fmt.Sprintf("--output=%s", testDataDir))
+	if err := app.Run(args); err != nil {
+		check(t, err)
+	}
+	result, err := os.ReadFile(path.Join(testDataDir, "result-0.jsonl"))
+	check(t, err)
+	fmt.Println(string(result))
+}
+
+func TestTextPairClassificationCli(t *testing.T) {
+	app := &cli.App{
+		Name:     "hugot",
+		Usage:    "Huggingface transformers from the command line - alpha",
+		Commands: []*cli.Command{runCommand},
+	}
+	baseArgs := os.Args[0:1]
+
+	testModel := path.Join("../models", "protectai_deberta-v3-base-zeroshot-v1-onnx")
+
+	testDataDir := path.Join(os.TempDir(), "hugoTestData")
+	err := os.MkdirAll(testDataDir, os.ModePerm)
+	check(t, err)
+	err = os.WriteFile(path.Join(testDataDir, "test-text-pair-classification.jsonl"), textPairClassificationData, os.ModePerm)
+	check(t, err)
+	defer func() {
+		err := os.RemoveAll(testDataDir)
+		check(t, err)
+	}()
+
+	args := append(baseArgs, "run", fmt.Sprintf("--input=%s", path.Join(testDataDir, "test-text-pair-classification.jsonl")),
+		fmt.Sprintf("--model=%s", testModel), "--type=textClassification", fmt.Sprintf("--output=%s", testDataDir))
+	if err := app.Run(args); err != nil {
+		check(t, err)
+	}
+	result, err := os.ReadFile(path.Join(testDataDir, "result-0.jsonl"))
+	check(t, err)
+	fmt.Println(string(result))
+}
+
+func TestGenerateCli(t *testing.T) {
+	app := &cli.App{
+		Name:     "hugot",
+		Usage:    "Huggingface transformers from the command line - alpha",
+		Commands: []*cli.Command{generateCommand},
+	}
+	baseArgs := os.Args[0:1]
+
+	testModel := path.Join("../models", "Xenova_gpt2")
+
+	args := append(baseArgs, "generate", fmt.Sprintf("--model=%s", testModel), "--prompt=Hello, my name is", "--maxNewTokens=10")
+	if err := app.Run(args); err != nil {
+		check(t, err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	session, err := hugot.NewSession()
+	check(t, err)
+	defer func(session *hugot.Session) {
+		check(t, session.Destroy())
+	}(session)
+
+	srv := newServer(session, 2)
+	testServer := httptest.NewServer(srv.handler())
+	defer testServer.Close()
+
+	post := func(path string, body string) (int, map[string]any) {
+		response, err := http.Post(testServer.URL+path, "application/json", strings.NewReader(body))
+		check(t, err)
+		defer func() {
+			check(t, response.Body.Close())
+		}()
+		decoded := map[string]any{}
+		check(t, json.NewDecoder(response.Body).Decode(&decoded))
+		return response.StatusCode, decoded
+	}
+	get := func(path string) int {
+		response, err := http.Get(testServer.URL + path)
+		check(t, err)
+		check(t, response.Body.Close())
+		return response.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+
+	check(t, srv.loadPipelines(context.Background(), []pipelineConfig{
+		{Name: "sentiment", Type: "textClassification", Model: path.Join("../models", "KnightsAnalytics_distilbert-base-uncased-finetuned-sst-2-english")},
+		{Name: "embeddings", Type: "featureExtraction", Model: path.Join("../models", "KnightsAnalytics_all-MiniLM-L6-v2")},
+	}))
+	srv.ready.Store(true)
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/v1/pipelines"))
+
+	// three inputs are run in two batches
+	status, body := post("/v1/pipelines/sentiment/run", `{"inputs": ["This movie is disgustingly good !", "The director tried too much", "I love it"]}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["outputs"], 3)
+	status, body = post("/v1/pipelines/embeddings/run", `{"inputs": ["Onnx runtime over http"]}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["outputs"], 1)
+
+	status, body = post("/v1/embeddings", `{"input": "Onnx runtime over http", "model": "embeddings"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["data"], 1)
+	status, _ = post("/v1/embeddings", `{"input": "Onnx runtime over http", "model": "sentiment"}`)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = post("/v1/pipelines/unknown/run", `{"inputs": ["test"]}`)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = post("/v1/pipelines/sentiment/run", `{"inputs": "test"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = post("/v1/pipelines/sentiment/run", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = post("/v1/pipelines/embeddings/run", `{"pairs": [["first", "second"]]}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, http.StatusMethodNotAllowed, get("/v1/pipelines/sentiment/run"))
+
+	// the metrics of the runs above are exported for prometheus
+	response, err := http.Get(testServer.URL + "/metrics")
+	check(t, err)
+	metrics, err := io.ReadAll(response.Body)
+	check(t, err)
+	check(t, response.Body.Close())
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, string(metrics), `hugot_pipeline_inputs_total{pipeline="sentiment",type="TextClassificationPipeline"} 3`)
+	assert.Contains(t, string(metrics), `hugot_pipeline_stage_duration_seconds_count{pipeline="embeddings",stage="forward",type="FeatureExtractionPipeline"} 2`)
+}
+
+func TestServeConfig(t *testing.T) {
+	configFile := path.Join(t.TempDir(), "config.json")
+	check(t, os.WriteFile(configFile, []byte(`{"pipelines": [{"name": "sentiment", "type": "textClassification", "model": "model"}]}`), os.ModePerm))
+	config, err := loadServeConfig(configFile)
+	check(t, err)
+	assert.Equal(t, ":8080", config.Address)
+	assert.Equal(t, 32, config.BatchSize)
+	assert.Equal(t, []pipelineConfig{{Name: "sentiment", Type: "textClassification", Model: "model"}}, config.Pipelines)
+
+	check(t, os.WriteFile(configFile, []byte(`{"pipelines": []}`), os.ModePerm))
+	_, err = loadServeConfig(configFile)
+	assert.Error(t, err)
+	check(t, os.WriteFile(configFile, []byte(`{"pipelines": [{"name": "a/b", "type": "textClassification", "model": "model"}]}`), os.ModePerm))
+	_, err = loadServeConfig(configFile)
+	assert.Error(t, err)
+}
+
+func TestServeAddressInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	check(t, err)
+	defer func() {
+		check(t, listener.Close())
+	}()
+
+	// the error of the server is returned rather than serving the pipelines without a listener
+	configFile := path.Join(t.TempDir(), "config.json")
+	config := fmt.Sprintf(`{"address": %q, "pipelines": [{"name": "sentiment", "type": "textClassification", "model": %q}]}`,
+		listener.Addr().String(), path.Join("../models", "KnightsAnalytics_distilbert-base-uncased-finetuned-sst-2-english"))
+	check(t, os.WriteFile(configFile, []byte(config), os.ModePerm))
+	app := &cli.App{
+		Name:     "hugot",
+		Commands: []*cli.Command{serveCommand},
+	}
+	err = app.Run(append(os.Args[0:1], "serve", fmt.Sprintf("--config=%s", configFile)))
+	assert.Error(t, err)
+}
+
 func TestModelChain(t *testing.T) {
 	app := &cli.App{
 		Name:     "hugot",
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/knights-analytics/hugot/grpcserver"
+	"github.com/knights-analytics/hugot/openai"
+	"github.com/knights-analytics/hugot/pipelines"
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+var configPath string
+var address string
+var grpcAddress string
+var shutdownTimeout time.Duration
+
+// maximum size of the body of a request
+const maxRequestBytes = 32 << 20
+
+var serveCommand = &cli.Command{
+	Name:  "serve",
+	Usage: "Serve huggingface pipelines over http",
+	Description: `Serve loads the pipelines of a json config file and exposes them as json endpoints:
+				POST /v1/pipelines/{name}/run with a body {"inputs": ["input string", ...]} or {"pairs": [["first string", "second string"], ...]} returns {"outputs": [...]}, with one output per input.
+				POST /v1/embeddings serves the featureExtraction pipelines with the OpenAI embeddings API, the model of the request being the name of the pipeline.
+				GET /v1/pipelines lists the loaded pipelines, GET /healthz reports that the server is up and GET /readyz that the pipelines are loaded.
+				The config file has the format {"address": ":8080", "batchSize": 32, "pipelines": [{"name": "sentiment", "type": "textClassification", "model": "KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english"}]}.
+				`,
+	ArgsUsage: `
+				--config: path to the json config file.
+				--address: address to listen on, overriding the address of the config file. Defaults to :8080.
+				--shutdownTimeout: time given to the requests in progress to finish on shutdown.
+				--onnxruntimeSharedLibrary: path to the onnxruntime.so library.
+				--modelFolder: folder where to store downloaded models.
+				`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "config",
+			Usage:       "Path to the config file",
+			Aliases:     []string{"c"},
+			Destination: &configPath,
+			Required:    true,
+		},
+		&cli.StringFlag{
+			Name:        "address",
+			Usage:       "Address to listen on",
+			Aliases:     []string{"a"},
+			Destination: &address,
+		},
+		&cli.StringFlag{
+			Name:        "grpcAddress",
+			Usage:       "Address to serve grpc on",
+			Destination: &grpcAddress,
+		},
+		&cli.DurationFlag{
+			Name:        "shutdownTimeout",
+			Usage:       "Time given to the requests in progress to finish on shutdown",
+			Destination: &shutdownTimeout,
+			Value:       30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:        "onnxruntimeSharedLibrary",
+			Usage:       "Path to onnxruntime.so",
+			Aliases:     []string{"s"},
+			Destination: &sharedLibraryPath,
+		},
+		&cli.StringFlag{
+			Name:        "modelFolder",
+			Usage:       "Folder where to store downloaded models. Falls back to $HOME/hugot/models if not specified",
+			Aliases:     []string{"f"},
+			Destination: &modelsDir,
+		},
+	},
+	Action: func(ctx *cli.Context) (err error) {
+		config, err := loadServeConfig(configPath)
+		if err != nil {
+			return err
+		}
+		if address != "" {
+			config.Address = address
+		}
+		if grpcAddress != "" {
+			config.GrpcAddress = grpcAddress
+		}
+
+		session, err := newCliSession(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errors.Join(err, session.Destroy())
+		}()
+
+		signalCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// the server listens while the pipelines are loaded, and is ready once they are
+		srv := newServer(session, config.BatchSize)
+		httpServer := &http.Server{
+			Addr:              config.Address,
+			Handler:           srv.handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		serveErr := make(chan error, 2)
+		go func() {
+			serveErr <- httpServer.ListenAndServe()
+		}()
+		fmt.Fprintf(os.Stderr, "listening on %s\n", config.Address)
+
+		var runErr error
+		var grpcServer *grpc.Server
+		if config.GrpcAddress != "" {
+			listener, err := net.Listen("tcp", config.GrpcAddress)
+			if err != nil {
+				runErr = err
+			} else {
+				grpcServer = grpc.NewServer()
+				inferenceServer := grpcserver.NewServer(session)
+				inferenceServer.BatchSize = config.BatchSize
+				inferenceServer.Register(grpcServer)
+				go func() {
+					serveErr <- grpcServer.Serve(listener)
+				}()
+				fmt.Fprintf(os.Stderr, "serving grpc on %s\n", config.GrpcAddress)
+			}
+		}
+
+		// the servers can fail while the pipelines are loaded, e.g. if the address is in use
+		loadCtx, cancelLoad := context.WithCancel(signalCtx)
+		defer cancelLoad()
+		if runErr == nil {
+			loaded := make(chan error, 1)
+			go func() {
+				loaded <- srv.loadPipelines(loadCtx, config.Pipelines)
+			}()
+			select {
+			case runErr = <-loaded:
+				if runErr == nil {
+					srv.ready.Store(true)
+					fmt.Fprintf(os.Stderr, "loaded %d pipelines\n", len(config.Pipelines))
+					select {
+					case <-signalCtx.Done():
+					case runErr = <-serveErr:
+					}
+				}
+			case runErr = <-serveErr:
+				// wait for the loading to stop, as the session is destroyed on return
+				cancelLoad()
+				<-loaded
+			}
+		}
+
+		// graceful shutdown: stop accepting requests and wait for the requests in progress
+		srv.ready.Store(false)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if grpcServer != nil {
+			go func() {
+				<-shutdownCtx.Done()
+				grpcServer.Stop()
+			}()
+			grpcServer.GracefulStop()
+		}
+		return errors.Join(runErr, httpServer.Shutdown(shutdownCtx))
+	},
+}
+
+// serveConfig is the config file of the serve command.
+type serveConfig struct {
+	Address     string           `json:"address"`
+	GrpcAddress string           `json:"grpcAddress"`
+	BatchSize   int              `json:"batchSize"`
+	Pipelines   []pipelineConfig `json:"pipelines"`
+}
+
+func loadServeConfig(path string) (serveConfig, error) {
+	config := serveConfig{
+		Address:   ":8080",
+		BatchSize: 32,
+	}
+	configBytes, err := util.ReadFileBytes(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if len(config.Pipelines) == 0 {
+		return config, fmt.Errorf("the config file %s has no pipelines", path)
+	}
+	if config.BatchSize <= 0 {
+		return config, fmt.Errorf("batchSize must be positive, got %d", config.BatchSize)
+	}
+	for _, p := range config.Pipelines {
+		if p.Name == "" || strings.Contains(p.Name, "/") {
+			return config, fmt.Errorf("invalid pipeline name %q", p.Name)
+		}
+	}
+	return config, nil
+}
+
+// server serves the pipelines of a hugot session over http.
+type server struct {
+	session   *hugot.Session
+	batchSize int
+	ready     atomic.Bool
+}
+
+type runRequest struct {
+	Inputs []string    `json:"inputs,omitempty"`
+	Pairs  [][2]string `json:"pairs,omitempty"`
+}
+
+type runResponse struct {
+	Outputs []any `json:"outputs"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func newServer(session *hugot.Session, batchSize int) *server {
+	return &server{
+		session:   session,
+		batchSize: batchSize,
+	}
+}
+
+// loadPipelines resolves the models and creates the pipelines of the config in the session of the server. The
+// context is checked before each pipeline.
+func (s *server) loadPipelines(ctx context.Context, configs []pipelineConfig) error {
+	for _, config := range configs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		modelPath, err := resolveModelPath(ctx, s.session, config.Model)
+		if err != nil {
+			return fmt.Errorf("pipeline %s: %w", config.Name, err)
+		}
+		config.Model = modelPath
+		if _, err := newCliPipeline(s.session, config); err != nil {
+			return fmt.Errorf("pipeline %s: %w", config.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !s.ready.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.HandleFunc("/v1/pipelines", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.session.ListPipelines())
+	})
+	mux.HandleFunc("/v1/pipelines/", s.handleRun)
+	embeddingsHandler := openai.NewEmbeddingsHandler(func(model string) (*pipelines.FeatureExtractionPipeline, error) {
+		return hugot.GetPipeline[*pipelines.FeatureExtractionPipeline](s.session, model)
+	})
+	embeddingsHandler.BatchSize = s.batchSize
+	mux.Handle("/v1/embeddings", embeddingsHandler)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		hugot.NewMetricsCollector(s.session),
+	)
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// handleRun runs the pipeline named in the path /v1/pipelines/{name}/run on the inputs of the request, in batches
+// of at most batchSize inputs. The request is cancelled when the client goes away.
+func (s *server) handleRun(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/pipelines/"), "/run")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("path %s not found", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	pipe, err := hugot.GetPipeline[pipelines.Pipeline](s.session, name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var request runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if (len(request.Inputs) == 0) == (len(request.Pairs) == 0) {
+		writeError(w, http.StatusBadRequest, errors.New("the request must have either inputs or pairs"))
+		return
+	}
+	var pairPipeline pipelines.PairPipeline
+	if len(request.Pairs) > 0 {
+		if pairPipeline, ok = pipe.(pipelines.PairPipeline); !ok {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("pipeline %s does not support text pair inputs", name))
+			return
+		}
+	}
+
+	response := runResponse{Outputs: make([]any, 0, len(request.Inputs)+len(request.Pairs))}
+	for start := 0; start < len(request.Inputs)+len(request.Pairs); start += s.batchSize {
+		var output pipelines.PipelineBatchOutput
+		if pairPipeline != nil {
+			output, err = pairPipeline.RunPairsContext(r.Context(), request.Pairs[start:minInt(start+s.batchSize, len(request.Pairs))])
+		} else {
+			output, err = pipe.RunContext(r.Context(), request.Inputs[start:minInt(start+s.batchSize, len(request.Inputs))])
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				status = http.StatusServiceUnavailable
+			}
+			writeError(w, status, err)
+			return
+		}
+		response.Outputs = append(response.Outputs, output.GetOutput()...)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing the response: %s\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -14,7 +14,12 @@ import (
 	"time"
 
 	hfd "github.com/bodaay/HuggingFaceModelDownloader/hfdownloader"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/knights-analytics/hugot/pipelines"
+	"github.com/knights-analytics/hugot/tokenizer"
 	util "github.com/knights-analytics/hugot/utils"
 )
 
@@ -41,10 +46,33 @@ func NewDownloadOptions() DownloadOptions {
 }
 
 // DownloadModel can be used to download a model directly from huggingface. Before the model is downloaded,
-// validation occurs to ensure there is an .onnx and tokenizers.json file. Hugot only works with onnx models.
+// validation occurs to ensure there is an .onnx file and a tokenizer at the root of the repository: a tokenizer.json,
+// or the vocab.txt, vocab.json and merges.txt or sentencepiece model of a slow tokenizer. Hugot only works with onnx
+// models.
 func (s *Session) DownloadModel(modelName string, destination string, options DownloadOptions) (string, error) {
+	return s.DownloadModelContext(context.Background(), modelName, destination, options)
+}
+
+// DownloadModelContext is DownloadModel with a context. The context is used for the validation requests and checked
+// between download attempts, and cancelling it interrupts the wait before a retry. The download is traced with a
+// span, a child of the span of the context.
+func (s *Session) DownloadModelContext(ctx context.Context, modelName string, destination string, options DownloadOptions) (modelPath string, err error) {
+	ctx, span := s.tracerProvider.Tracer(pipelines.TracerName).Start(ctx, "hugot.DownloadModel", trace.WithAttributes(
+		attribute.String("hugot.model.name", modelName),
+		attribute.String("hugot.model.destination", destination),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(pipelines.AttributeModelPath.String(modelPath))
+		}
+		span.End()
+	}()
+
 	// make sure it's an onnx model with tokenizer
-	err := validateDownloadHfModel(modelName, options.Branch, options.AuthToken)
+	err = validateDownloadHfModel(ctx, modelName, options.Branch, options.AuthToken)
 	if err != nil {
 		return "", err
 	}
@@ -54,12 +82,21 @@ func (s *Session) DownloadModel(modelName string, destination string, options Do
 	if strings.Contains(modelP, ":") {
 		modelP = strings.Split(modelName, ":")[0]
 	}
-	modelPath := path.Join(destination, strings.Replace(modelP, "/", "_", -1))
+	modelPath = path.Join(destination, strings.Replace(modelP, "/", "_", -1))
 
 	for i := 0; i < options.MaxRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
 		if err := hfd.DownloadModel(modelName, false, options.SkipSha, false, destination, options.Branch, options.ConcurrentConnections, options.AuthToken, !options.Verbose); err != nil {
 			fmt.Printf("Warning: attempt %d / %d failed, error: %s\n", i+1, options.MaxRetries, err)
-			time.Sleep(time.Duration(options.RetryInterval) * time.Second)
+			timer := time.NewTimer(time.Duration(options.RetryInterval) * time.Second)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return "", ctx.Err()
+			case <-timer.C:
+			}
 			continue
 		}
 		fmt.Printf("\nDownload of %s completed successfully\n", modelName)
@@ -74,14 +111,14 @@ type hfFile struct {
 	IsDirectory bool
 }
 
-func validateDownloadHfModel(modelPath string, branch string, authToken string) error {
+func validateDownloadHfModel(ctx context.Context, modelPath string, branch string, authToken string) error {
 	if strings.Contains(modelPath, ":") {
 		return errors.New("model filters are not supported")
 	}
 
 	client := &http.Client{}
 
-	hasTokenizer, hasOnxx, err := checkURL(client, fmt.Sprintf("https://huggingface.co/api/models/%s/tree/%s", modelPath, branch), authToken)
+	hasTokenizer, hasOnxx, err := checkURL(ctx, client, fmt.Sprintf("https://huggingface.co/api/models/%s/tree/%s", modelPath, branch), authToken, true)
 	if err != nil {
 		return err
 	}
@@ -91,15 +128,19 @@ func validateDownloadHfModel(modelPath string, branch string, authToken string)
 		errs = append(errs, fmt.Errorf("model does not have a model.onnx file, Hugot only works with onnx models"))
 	}
 	if !hasTokenizer {
-		errs = append(errs, fmt.Errorf("model does not have a tokenizer.json file"))
+		errs = append(errs, fmt.Errorf("model does not have a tokenizer.json, vocab.txt, vocab.json and merges.txt or sentencepiece model file"))
 	}
 	return errors.Join(errs...)
 }
 
-func checkURL(client *http.Client, url string, authToken string) (bool, bool, error) {
+// checkURL reports whether the folder of the repository at the url has the files of a tokenizer and an .onnx file.
+// The tokenizer is loaded from the root of the model folder, so its files are only looked for at the root of the
+// repository, while the .onnx files are looked for in the subfolders as well.
+func checkURL(ctx context.Context, client *http.Client, url string, authToken string, root bool) (bool, bool, error) {
 	var tokenizerFound bool
 	var onnxFound bool
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	var vocabFound, mergesFound bool
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return false, false, err
 	}
@@ -120,21 +161,29 @@ func checkURL(client *http.Client, url string, authToken string) (bool, bool, er
 		return false, false, e
 	}
 	for _, f := range filesList {
-		if f.Path == "tokenizer.json" {
-			tokenizerFound = true
+		if root {
+			switch f.Path {
+			case "vocab.json":
+				vocabFound = true
+			case "merges.txt":
+				mergesFound = true
+			default:
+				tokenizerFound = tokenizerFound || tokenizer.IsTokenizerFile(f.Path)
+			}
+			// byte level BPE tokenizers need both their vocabulary and merges
+			tokenizerFound = tokenizerFound || (vocabFound && mergesFound)
 		}
 		if filepath.Ext(f.Path) == ".onnx" {
 			onnxFound = true
 		}
-		if f.Type == "directory" && !(tokenizerFound && onnxFound) {
-			tokenizerFoundRec, onnxFoundRec, err := checkURL(client, url+"/"+f.Path, authToken)
+		if f.Type == "directory" && !onnxFound {
+			_, onnxFoundRec, err := checkURL(ctx, client, url+"/"+f.Path, authToken, false)
 			if err != nil {
 				return false, false, err
 			}
-			tokenizerFound = tokenizerFound || tokenizerFoundRec
-			onnxFound = onnxFound || onnxFoundRec
+			onnxFound = onnxFoundRec
 		}
-		if onnxFound && tokenizerFound {
+		if onnxFound && (tokenizerFound || !root) {
 			break
 		}
 	}
@@ -0,0 +1,100 @@
+package hugot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// The onnxruntime environment is a process-wide resource: the shared library can only be loaded once, and all the
+// onnxruntime sessions of the process are created in the same environment. Hugot sessions therefore share the
+// environment, which is initialised by the first session and destroyed when the last session is destroyed. Each
+// session keeps its own session options (threads, execution providers) and pipelines.
+var environment struct {
+	mutex       sync.Mutex
+	references  int
+	libraryPath string
+	telemetry   bool
+}
+
+// acquireEnvironment initialises the onnxruntime environment if no other session is active, and registers the
+// session as one of its users. The library path and telemetry setting are those of the first session, so a later
+// session asking for a different library returns an error.
+func acquireEnvironment(o *ortOptions) error {
+	environment.mutex.Lock()
+	defer environment.mutex.Unlock()
+
+	if environment.references > 0 {
+		if o.libraryPath != "" && o.libraryPath != environment.libraryPath {
+			return fmt.Errorf("the onnxruntime environment is already active with the library %s, cannot load %s", environment.libraryPath, o.libraryPath)
+		}
+		if o.telemetry != environment.telemetry {
+			return fmt.Errorf("the onnxruntime environment is already active with telemetry set to %t", environment.telemetry)
+		}
+		environment.references++
+		return nil
+	}
+
+	if ort.IsInitialized() {
+		return fmt.Errorf("the onnxruntime environment was initialised outside of hugot")
+	}
+
+	if o.libraryPath != "" {
+		ortPathExists, err := util.FileSystem.Exists(context.Background(), o.libraryPath)
+		if err != nil {
+			return err
+		}
+		if !ortPathExists {
+			return fmt.Errorf("cannot find the ort library at: %s", o.libraryPath)
+		}
+		ort.SetSharedLibraryPath(o.libraryPath)
+	}
+
+	if err := ort.InitializeEnvironment(); err != nil {
+		return err
+	}
+	var err error
+	if o.telemetry {
+		err = ort.EnableTelemetry()
+	} else {
+		err = ort.DisableTelemetry()
+	}
+	if err != nil {
+		return errors.Join(err, ort.DestroyEnvironment())
+	}
+
+	environment.libraryPath = o.libraryPath
+	environment.telemetry = o.telemetry
+	environment.references = 1
+	return nil
+}
+
+// releaseEnvironment unregisters a session from the onnxruntime environment, and destroys the environment if it
+// was the last active session.
+func releaseEnvironment() error {
+	environment.mutex.Lock()
+	defer environment.mutex.Unlock()
+
+	if environment.references == 0 {
+		return nil
+	}
+	environment.references--
+	if environment.references > 0 {
+		return nil
+	}
+	environment.libraryPath = ""
+	environment.telemetry = false
+	return ort.DestroyEnvironment()
+}
+
+// activeSessions returns the number of sessions using the onnxruntime environment.
+func activeSessions() int {
+	environment.mutex.Lock()
+	defer environment.mutex.Unlock()
+	return environment.references
+}
@@ -0,0 +1,80 @@
+// Package grpcclient runs the pipelines of a hugot gRPC server, see the grpcserver package, and returns their
+// outputs as the outputs of the pipelines package.
+package grpcclient
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/knights-analytics/hugot/hugotpb"
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+// Client is a client of the Inference service of a hugot server.
+type Client struct {
+	conn   *grpc.ClientConn
+	client hugotpb.InferenceClient
+}
+
+// Dial connects to the hugot server at target. The options set e.g. the transport credentials of the connection.
+func Dial(target string, options ...grpc.DialOption) (*Client, error) {
+	conn, err := grpc.Dial(target, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, client: hugotpb.NewInferenceClient(conn)}, nil
+}
+
+// NewClient creates a client using an existing connection, which is not closed by Close.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{client: hugotpb.NewInferenceClient(conn)}
+}
+
+// Close closes the connection opened by Dial.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Embeddings runs the feature extraction pipeline with the name on the inputs.
+func (c *Client) Embeddings(ctx context.Context, pipeline string, inputs []string, options ...grpc.CallOption) (*pipelines.FeatureExtractionOutput, error) {
+	response, err := c.client.Embeddings(ctx, &hugotpb.EmbeddingsRequest{Pipeline: pipeline, Inputs: inputs}, options...)
+	if err != nil {
+		return nil, err
+	}
+	return response.Output(), nil
+}
+
+// ClassifyText runs the text classification pipeline with the name on the inputs.
+func (c *Client) ClassifyText(ctx context.Context, pipeline string, inputs []string, options ...grpc.CallOption) (*pipelines.TextClassificationOutput, error) {
+	response, err := c.client.ClassifyText(ctx, &hugotpb.TextClassificationRequest{Pipeline: pipeline, Inputs: inputs}, options...)
+	if err != nil {
+		return nil, err
+	}
+	return response.Output(), nil
+}
+
+// ClassifyTextPairs runs the text classification pipeline with the name on the text pairs.
+func (c *Client) ClassifyTextPairs(ctx context.Context, pipeline string, pairs [][2]string, options ...grpc.CallOption) (*pipelines.TextClassificationOutput, error) {
+	request := &hugotpb.TextClassificationRequest{Pipeline: pipeline, Pairs: make([]*hugotpb.TextPair, len(pairs))}
+	for i, pair := range pairs {
+		request.Pairs[i] = &hugotpb.TextPair{First: pair[0], Second: pair[1]}
+	}
+	response, err := c.client.ClassifyText(ctx, request, options...)
+	if err != nil {
+		return nil, err
+	}
+	return response.Output(), nil
+}
+
+// ClassifyTokens runs the token classification pipeline with the name on the inputs.
+func (c *Client) ClassifyTokens(ctx context.Context, pipeline string, inputs []string, options ...grpc.CallOption) (*pipelines.TokenClassificationOutput, error) {
+	response, err := c.client.ClassifyTokens(ctx, &hugotpb.TokenClassificationRequest{Pipeline: pipeline, Inputs: inputs}, options...)
+	if err != nil {
+		return nil, err
+	}
+	return response.Output(), nil
+}
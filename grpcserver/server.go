@@ -0,0 +1,155 @@
+// Package grpcserver serves the pipelines of a hugot session with the gRPC interface of the hugotpb package.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/knights-analytics/hugot/hugotpb"
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+// Server implements the Inference service with the pipelines of a hugot session, which are looked up by the
+// pipeline name of each request.
+type Server struct {
+	hugotpb.UnimplementedInferenceServer
+	session *hugot.Session
+	// BatchSize is the maximum number of inputs run in one call of the pipeline. If zero, all the inputs of a
+	// request are run in a single batch.
+	BatchSize int
+}
+
+// NewServer creates a server running the pipelines of the session.
+func NewServer(session *hugot.Session) *Server {
+	return &Server{session: session}
+}
+
+// Register registers the server as the Inference service of a grpc server.
+func (s *Server) Register(registrar grpc.ServiceRegistrar) {
+	hugotpb.RegisterInferenceServer(registrar, s)
+}
+
+// Embeddings runs the feature extraction pipeline of the request.
+func (s *Server) Embeddings(ctx context.Context, request *hugotpb.EmbeddingsRequest) (*hugotpb.EmbeddingsResponse, error) {
+	pipeline, err := getPipeline[*pipelines.FeatureExtractionPipeline](s.session, request.GetPipeline())
+	if err != nil {
+		return nil, err
+	}
+	if len(request.GetInputs()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "inputs must not be empty")
+	}
+	output := &pipelines.FeatureExtractionOutput{}
+	err = runBatches(len(request.GetInputs()), s.BatchSize, func(start, end int) error {
+		batchOutput, err := pipeline.RunPipelineContext(ctx, request.GetInputs()[start:end])
+		if err != nil {
+			return err
+		}
+		if batchOutput.Embeddings != nil {
+			output.Embeddings = append(output.Embeddings, batchOutput.Embeddings...)
+		}
+		if batchOutput.TokenEmbeddings != nil {
+			output.TokenEmbeddings = append(output.TokenEmbeddings, batchOutput.TokenEmbeddings...)
+		}
+		output.TokenCounts = append(output.TokenCounts, batchOutput.TokenCounts...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hugotpb.NewEmbeddingsResponse(output), nil
+}
+
+// ClassifyText runs the text classification pipeline of the request, on either its inputs or its text pairs.
+func (s *Server) ClassifyText(ctx context.Context, request *hugotpb.TextClassificationRequest) (*hugotpb.TextClassificationResponse, error) {
+	pipeline, err := getPipeline[*pipelines.TextClassificationPipeline](s.session, request.GetPipeline())
+	if err != nil {
+		return nil, err
+	}
+	inputs, pairs := request.GetInputs(), request.GetPairs()
+	if (len(inputs) == 0) == (len(pairs) == 0) {
+		return nil, status.Error(codes.InvalidArgument, "the request must have either inputs or pairs")
+	}
+	output := &pipelines.TextClassificationOutput{}
+	err = runBatches(len(inputs)+len(pairs), s.BatchSize, func(start, end int) error {
+		var batchOutput *pipelines.TextClassificationOutput
+		var err error
+		if len(pairs) > 0 {
+			batchPairs := make([][2]string, 0, end-start)
+			for _, pair := range pairs[start:end] {
+				batchPairs = append(batchPairs, [2]string{pair.GetFirst(), pair.GetSecond()})
+			}
+			batchOutput, err = pipeline.RunPairsPipelineContext(ctx, batchPairs)
+		} else {
+			batchOutput, err = pipeline.RunPipelineContext(ctx, inputs[start:end])
+		}
+		if err != nil {
+			return err
+		}
+		output.ClassificationOutputs = append(output.ClassificationOutputs, batchOutput.ClassificationOutputs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hugotpb.NewTextClassificationResponse(output), nil
+}
+
+// ClassifyTokens runs the token classification pipeline of the request.
+func (s *Server) ClassifyTokens(ctx context.Context, request *hugotpb.TokenClassificationRequest) (*hugotpb.TokenClassificationResponse, error) {
+	pipeline, err := getPipeline[*pipelines.TokenClassificationPipeline](s.session, request.GetPipeline())
+	if err != nil {
+		return nil, err
+	}
+	if len(request.GetInputs()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "inputs must not be empty")
+	}
+	output := &pipelines.TokenClassificationOutput{}
+	err = runBatches(len(request.GetInputs()), s.BatchSize, func(start, end int) error {
+		batchOutput, err := pipeline.RunPipelineContext(ctx, request.GetInputs()[start:end])
+		if err != nil {
+			return err
+		}
+		output.Entities = append(output.Entities, batchOutput.Entities...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hugotpb.NewTokenClassificationResponse(output), nil
+}
+
+// getPipeline returns the pipeline of the session with the name and type T, or a NotFound status.
+func getPipeline[T pipelines.Pipeline](session *hugot.Session, name string) (T, error) {
+	pipeline, err := hugot.GetPipeline[T](session, name)
+	if err != nil {
+		return pipeline, status.Error(codes.NotFound, err.Error())
+	}
+	return pipeline, nil
+}
+
+// runBatches calls run on consecutive batches of at most batchSize of the n inputs, and converts its errors to
+// grpc statuses.
+func runBatches(n int, batchSize int, run func(start, end int) error) error {
+	if batchSize <= 0 {
+		batchSize = n
+	}
+	for start := 0; start < n; start += batchSize {
+		end := start + batchSize
+		if end > n {
+			end = n
+		}
+		if err := run(start, end); err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return status.FromContextError(err).Err()
+			}
+			return status.Error(codes.Internal, fmt.Sprintf("running the pipeline: %s", err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package grpcserver_test
+
+import (
+	"context"
+	"net"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/knights-analytics/hugot/grpcclient"
+	"github.com/knights-analytics/hugot/grpcserver"
+	"github.com/knights-analytics/hugot/hugotpb"
+)
+
+func TestServer(t *testing.T) {
+	session, err := hugot.NewSession(hugot.WithOnnxLibraryPath("/usr/lib64/onnxruntime.so"))
+	check(t, err)
+	defer func(session *hugot.Session) {
+		check(t, session.Destroy())
+	}(session)
+	features, err := hugot.NewPipeline(session, hugot.FeatureExtractionConfig{
+		ModelPath: path.Join("../models", "KnightsAnalytics_all-MiniLM-L6-v2"),
+		Name:      "embeddings",
+	})
+	check(t, err)
+	sentiment, err := hugot.NewPipeline(session, hugot.TextClassificationConfig{
+		ModelPath: path.Join("../models", "KnightsAnalytics_distilbert-base-uncased-finetuned-sst-2-english"),
+		Name:      "sentiment",
+	})
+	check(t, err)
+	ner, err := hugot.NewPipeline(session, hugot.TokenClassificationConfig{
+		ModelPath: path.Join("../models", "KnightsAnalytics_distilbert-NER"),
+		Name:      "ner",
+	})
+	check(t, err)
+
+	// serve in-process over an in-memory connection
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	server := grpcserver.NewServer(session)
+	server.BatchSize = 2
+	server.Register(grpcServer)
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	client, err := grpcclient.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	check(t, err)
+	defer func(client *grpcclient.Client) {
+		check(t, client.Close())
+	}(client)
+	ctx := context.Background()
+	inputs := []string{"This movie is disgustingly good !", "The director tried too much", "My name is Wolfgang and I live in Berlin"}
+
+	// the outputs are those of running the pipelines directly, run in batches of two by the server
+	embeddings, err := client.Embeddings(ctx, "embeddings", inputs)
+	check(t, err)
+	expectedEmbeddings, err := features.RunPipeline(inputs)
+	check(t, err)
+	assert.Equal(t, expectedEmbeddings, embeddings)
+
+	classification, err := client.ClassifyText(ctx, "sentiment", inputs)
+	check(t, err)
+	expectedClassification, err := sentiment.RunPipeline(inputs)
+	check(t, err)
+	assert.Equal(t, expectedClassification, classification)
+
+	pairs := [][2]string{{"The director tried too much", "The movie is bad"}}
+	pairsClassification, err := client.ClassifyTextPairs(ctx, "sentiment", pairs)
+	check(t, err)
+	expectedPairsClassification, err := sentiment.RunPairsPipeline(pairs)
+	check(t, err)
+	assert.Equal(t, expectedPairsClassification, pairsClassification)
+
+	entities, err := client.ClassifyTokens(ctx, "ner", inputs)
+	check(t, err)
+	expectedEntities, err := ner.RunPipeline(inputs)
+	check(t, err)
+	assert.Len(t, entities.Entities, len(inputs))
+	assert.Equal(t, "Berlin", entities.Entities[2][len(entities.Entities[2])-1].Word)
+	// the internal fields of the entities are not part of the message
+	assert.Equal(t, hugotpb.NewTokenClassificationResponse(expectedEntities).Output(), entities)
+
+	// errors
+	_, err = client.Embeddings(ctx, "missing", inputs)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Embeddings(ctx, "sentiment", inputs)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.ClassifyTokens(ctx, "ner", nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.ClassifyText(cancelledCtx, "sentiment", inputs)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
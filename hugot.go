@@ -1,41 +1,41 @@
 package hugot
 
 import (
-	"context"
 	"errors"
 	"fmt"
-
-	util "github.com/knights-analytics/hugot/utils"
+	"sort"
+	"sync"
 
 	ort "github.com/yalue/onnxruntime_go"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 
 	"github.com/knights-analytics/hugot/pipelines"
 )
 
 // Session allows for the creation of new pipelines and holds the pipeline already created.
 type Session struct {
-	featureExtractionPipelines   pipelineMap[*pipelines.FeatureExtractionPipeline]
-	tokenClassificationPipelines pipelineMap[*pipelines.TokenClassificationPipeline]
-	textClassificationPipelines  pipelineMap[*pipelines.TextClassificationPipeline]
-	ortOptions                   *ort.SessionOptions
+	pipelines           map[string]*sessionPipeline
+	pipelinesMutex      sync.RWMutex
+	ortOptions          *ort.SessionOptions
+	environmentAcquired bool
+	tracerProvider      trace.TracerProvider
 }
 
-type pipelineMap[T pipelines.Pipeline] map[string]T
-
-func (m pipelineMap[T]) Destroy() error {
-	var err error
-	for _, p := range m {
-		err = p.Destroy()
-	}
-	return err
+// sessionPipeline is a pipeline of the session, with what is needed to reload it.
+type sessionPipeline struct {
+	pipeline     pipelines.Pipeline
+	pipelineType string
+	modelPath    string
+	create       func() (pipelines.Pipeline, error)
 }
 
-func (m pipelineMap[T]) GetStats() []string {
-	var stats []string
-	for _, p := range m {
-		stats = append(stats, p.GetStats()...)
-	}
-	return stats
+// PipelineInfo describes a pipeline of the session, see ListPipelines.
+type PipelineInfo struct {
+	Name      string
+	Type      string
+	ModelPath string
+	OutputDim int
 }
 
 // TokenClassificationConfig is the configuration for a token classification pipeline
@@ -47,6 +47,18 @@ type TextClassificationConfig = pipelines.PipelineConfig[*pipelines.TextClassifi
 // FeatureExtractionConfig is the configuration for a feature extraction pipeline
 type FeatureExtractionConfig = pipelines.PipelineConfig[*pipelines.FeatureExtractionPipeline]
 
+// ZeroShotClassificationConfig is the configuration for a zero shot classification pipeline
+type ZeroShotClassificationConfig = pipelines.PipelineConfig[*pipelines.ZeroShotClassificationPipeline]
+
+// QuestionAnsweringConfig is the configuration for a question answering pipeline
+type QuestionAnsweringConfig = pipelines.PipelineConfig[*pipelines.QuestionAnsweringPipeline]
+
+// FillMaskConfig is the configuration for a fill mask pipeline
+type FillMaskConfig = pipelines.PipelineConfig[*pipelines.FillMaskPipeline]
+
+// RerankConfig is the configuration for a rerank pipeline
+type RerankConfig = pipelines.PipelineConfig[*pipelines.RerankPipeline]
+
 // TokenClassificationOption is an option for a token classification pipeline
 type TokenClassificationOption = pipelines.PipelineOption[*pipelines.TokenClassificationPipeline]
 
@@ -56,22 +68,41 @@ type TextClassificationOption = pipelines.PipelineOption[*pipelines.TextClassifi
 // FeatureExtractionOption is an option for a text classification pipeline
 type FeatureExtractionOption = pipelines.PipelineOption[*pipelines.FeatureExtractionPipeline]
 
+// ZeroShotClassificationOption is an option for a zero shot classification pipeline
+type ZeroShotClassificationOption = pipelines.PipelineOption[*pipelines.ZeroShotClassificationPipeline]
+
+// QuestionAnsweringOption is an option for a question answering pipeline
+type QuestionAnsweringOption = pipelines.PipelineOption[*pipelines.QuestionAnsweringPipeline]
+
+// FillMaskOption is an option for a fill mask pipeline
+type FillMaskOption = pipelines.PipelineOption[*pipelines.FillMaskPipeline]
+
+// RerankOption is an option for a rerank pipeline
+type RerankOption = pipelines.PipelineOption[*pipelines.RerankPipeline]
+
+// Text2TextGenerationConfig is the configuration for a text2text generation pipeline
+type Text2TextGenerationConfig = pipelines.PipelineConfig[*pipelines.Text2TextGenerationPipeline]
+
+// Text2TextGenerationOption is an option for a text2text generation pipeline
+type Text2TextGenerationOption = pipelines.PipelineOption[*pipelines.Text2TextGenerationPipeline]
+
+// TextGenerationConfig is the configuration for a text generation pipeline
+type TextGenerationConfig = pipelines.PipelineConfig[*pipelines.TextGenerationPipeline]
+
+// TextGenerationOption is an option for a text generation pipeline
+type TextGenerationOption = pipelines.PipelineOption[*pipelines.TextGenerationPipeline]
+
 // NewSession is the main entrypoint to hugot and is used to create a new hugot session object.
 // ortLibraryPath should be the path to onnxruntime.so. If it's the empty string, hugot will try
 // to load the library from the default location (/usr/lib/onnxruntime.so).
 // A new session must be destroyed when it's not needed anymore to avoid memory leaks. See the Destroy method.
-// Note moreover that there can be at most one hugot session active (i.e., the Session object is a singleton),
-// otherwise NewSession will return an error.
+// Several sessions can be active at the same time, each with its own options and pipelines. They share the
+// onnxruntime environment, which is created by the first session and destroyed with the last one, so the library
+// path and telemetry setting of the later sessions must match those of the first.
 func NewSession(options ...WithOption) (*Session, error) {
-
-	if ort.IsInitialized() {
-		return nil, errors.New("another session is currently active, and only one session can be active at one time")
-	}
-
 	session := &Session{
-		featureExtractionPipelines:   map[string]*pipelines.FeatureExtractionPipeline{},
-		tokenClassificationPipelines: map[string]*pipelines.TokenClassificationPipeline{},
-		textClassificationPipelines:  map[string]*pipelines.TextClassificationPipeline{},
+		pipelines:      map[string]*sessionPipeline{},
+		tracerProvider: noop.NewTracerProvider(),
 	}
 
 	// set session options and initialise
@@ -93,33 +124,15 @@ func (s *Session) initialiseORT(options ...WithOption) (bool, error) {
 	for _, option := range options {
 		option(o)
 	}
-
-	// Set pre-initialisation options
-	if o.libraryPath != "" {
-		ortPathExists, err := util.FileSystem.Exists(context.Background(), o.libraryPath)
-		if err != nil {
-			return false, err
-		}
-		if !ortPathExists {
-			return false, fmt.Errorf("cannot find the ort library at: %s", o.libraryPath)
-		}
-		ort.SetSharedLibraryPath(o.libraryPath)
+	if o.tracerProvider != nil {
+		s.tracerProvider = o.tracerProvider
 	}
 
-	// Start OnnxRuntime
-	if err := ort.InitializeEnvironment(); err != nil {
+	// Start OnnxRuntime, or join the environment of the active sessions
+	if err := acquireEnvironment(o); err != nil {
 		return false, err
 	}
-
-	if o.telemetry {
-		if err := ort.EnableTelemetry(); err != nil {
-			return true, err
-		}
-	} else {
-		if err := ort.DisableTelemetry(); err != nil {
-			return true, err
-		}
-	}
+	s.environmentAcquired = true
 
 	// Create session options for use in all pipelines
 	sessionOptions, optionsError := ort.NewSessionOptions()
@@ -207,105 +220,241 @@ func (e *pipelineNotFoundError) Error() string {
 
 // NewPipeline can be used to create a new pipeline of type T. The initialised pipeline will be returned and it
 // will also be stored in the session object so that all created pipelines can be destroyed with session.Destroy()
-// at once.
+// at once. T must be a registered pipeline type (see RegisterPipeline).
+//
+// Pipeline names are unique within a session, across all pipeline types: a feature extraction and a text
+// classification pipeline can no longer share a name, as the session, its metrics and ClosePipeline and
+// ReloadPipeline identify pipelines by name only.
 func NewPipeline[T pipelines.Pipeline](s *Session, pipelineConfig pipelines.PipelineConfig[T]) (T, error) {
 	var pipeline T
-	var err error
 	if pipelineConfig.Name == "" {
 		return pipeline, errors.New("a name for the pipeline is required")
 	}
 
-	_, getError := GetPipeline[T](s, pipelineConfig.Name)
-	var notFoundError *pipelineNotFoundError
-	if getError == nil {
+	if _, err := s.getPipeline(pipelineConfig.Name); err == nil {
 		return pipeline, fmt.Errorf("pipeline %s has already been initialised", pipelineConfig.Name)
-	} else if !errors.As(getError, &notFoundError) {
-		return pipeline, getError
 	}
 
-	switch any(pipeline).(type) {
-	case *pipelines.TokenClassificationPipeline:
-		config := any(pipelineConfig).(pipelines.PipelineConfig[*pipelines.TokenClassificationPipeline])
-		pipelineInitialised, err := pipelines.NewTokenClassificationPipeline(config, s.ortOptions)
-		if err != nil {
-			return pipeline, err
-		}
-		s.tokenClassificationPipelines[config.Name] = pipelineInitialised
-		pipeline = any(pipelineInitialised).(T)
-	case *pipelines.TextClassificationPipeline:
-		config := any(pipelineConfig).(pipelines.PipelineConfig[*pipelines.TextClassificationPipeline])
-		pipelineInitialised, err := pipelines.NewTextClassificationPipeline(config, s.ortOptions)
-		if err != nil {
-			return pipeline, err
-		}
-		s.textClassificationPipelines[config.Name] = pipelineInitialised
-		pipeline = any(pipelineInitialised).(T)
-	case *pipelines.FeatureExtractionPipeline:
-		config := any(pipelineConfig).(pipelines.PipelineConfig[*pipelines.FeatureExtractionPipeline])
-		pipelineInitialised, err := pipelines.NewFeatureExtractionPipeline(config, s.ortOptions)
-		if err != nil {
-			return pipeline, err
-		}
-		s.featureExtractionPipelines[config.Name] = pipelineInitialised
-		pipeline = any(pipelineInitialised).(T)
-	default:
-		return pipeline, fmt.Errorf("not implemented")
+	constructor, err := getConstructor[T]()
+	if err != nil {
+		return pipeline, err
+	}
+	entry := &sessionPipeline{
+		pipelineType: pipelineTypeName(pipelineType[T]()),
+		modelPath:    pipelineConfig.ModelPath,
+		create: func() (pipelines.Pipeline, error) {
+			created, err := constructor(pipelineConfig, s.ortOptions)
+			if err != nil {
+				return nil, err
+			}
+			s.setTracerProvider(created)
+			return created, nil
+		},
+	}
+	pipelineInitialised, err := constructor(pipelineConfig, s.ortOptions)
+	if err != nil {
+		return pipeline, err
+	}
+	s.setTracerProvider(pipelineInitialised)
+	entry.pipeline = pipelineInitialised
+
+	// the model is loaded without holding the lock, so the name is checked again
+	s.pipelinesMutex.Lock()
+	defer s.pipelinesMutex.Unlock()
+	if _, ok := s.pipelines[pipelineConfig.Name]; ok {
+		return pipeline, errors.Join(fmt.Errorf("pipeline %s has already been initialised", pipelineConfig.Name), pipelineInitialised.Destroy())
 	}
-	return pipeline, err
+	s.pipelines[pipelineConfig.Name] = entry
+	return pipelineInitialised, nil
 }
 
-// GetPipeline can be used to retrieve a pipeline of type T with the given name from the session
+// setTracerProvider sets the tracer provider of the session on the pipeline, if it is traced as the pipelines
+// embedding pipelines.BasePipeline are.
+func (s *Session) setTracerProvider(pipeline pipelines.Pipeline) {
+	if traced, ok := pipeline.(interface {
+		SetTracerProvider(trace.TracerProvider)
+	}); ok {
+		traced.SetTracerProvider(s.tracerProvider)
+	}
+}
+
+// GetPipeline can be used to retrieve a pipeline of type T with the given name from the session. A pipeline of
+// another type with that name is not found.
 func GetPipeline[T pipelines.Pipeline](s *Session, name string) (T, error) {
 	var pipeline T
-	switch any(pipeline).(type) {
-	case *pipelines.TokenClassificationPipeline:
-		p, ok := s.tokenClassificationPipelines[name]
-		if !ok {
-			return pipeline, &pipelineNotFoundError{pipelineName: name}
-		}
-		return any(p).(T), nil
-	case *pipelines.TextClassificationPipeline:
-		p, ok := s.textClassificationPipelines[name]
-		if !ok {
-			return pipeline, &pipelineNotFoundError{pipelineName: name}
-		}
-		return any(p).(T), nil
-	case *pipelines.FeatureExtractionPipeline:
-		p, ok := s.featureExtractionPipelines[name]
-		if !ok {
-			return pipeline, &pipelineNotFoundError{pipelineName: name}
-		}
-		return any(p).(T), nil
-	default:
-		return pipeline, errors.New("pipeline type not supported")
+	p, err := s.getPipeline(name)
+	if err != nil {
+		return pipeline, err
+	}
+	pipeline, ok := p.(T)
+	if !ok {
+		return pipeline, &pipelineNotFoundError{pipelineName: name}
+	}
+	return pipeline, nil
+}
+
+func (s *Session) getPipeline(name string) (pipelines.Pipeline, error) {
+	s.pipelinesMutex.RLock()
+	defer s.pipelinesMutex.RUnlock()
+	entry, ok := s.pipelines[name]
+	if !ok {
+		return nil, &pipelineNotFoundError{pipelineName: name}
+	}
+	return entry.pipeline, nil
+}
+
+// ListPipelines returns the name, type, model path and output dimension of the pipelines of the session, sorted
+// by name.
+func (s *Session) ListPipelines() []PipelineInfo {
+	s.pipelinesMutex.RLock()
+	defer s.pipelinesMutex.RUnlock()
+	infos := make([]PipelineInfo, 0, len(s.pipelines))
+	for name, entry := range s.pipelines {
+		infos = append(infos, PipelineInfo{
+			Name:      name,
+			Type:      entry.pipelineType,
+			ModelPath: entry.modelPath,
+			OutputDim: entry.pipeline.GetOutputDim(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// ClosePipeline removes the pipeline from the session and destroys it, freeing its onnxruntime session and
+// tokenizer. The runs in progress on the pipeline finish first, while new runs return an error.
+func (s *Session) ClosePipeline(name string) error {
+	s.pipelinesMutex.Lock()
+	entry, ok := s.pipelines[name]
+	delete(s.pipelines, name)
+	s.pipelinesMutex.Unlock()
+	if !ok {
+		return &pipelineNotFoundError{pipelineName: name}
 	}
+	return entry.pipeline.Destroy()
 }
 
-// Destroy deletes the hugot session and onnxruntime environment and all initialized pipelines, freeing memory.
+// ReloadPipeline loads the model of the pipeline again with the same configuration, e.g. after a new version of the
+// model was written to its model path, and swaps it in atomically: GetPipeline returns the new pipeline from then
+// on, while the runs in progress finish on the old pipeline, which is then destroyed. If the new model cannot be
+// loaded, the old pipeline is kept and the error is returned.
+func (s *Session) ReloadPipeline(name string) error {
+	s.pipelinesMutex.RLock()
+	entry, ok := s.pipelines[name]
+	s.pipelinesMutex.RUnlock()
+	if !ok {
+		return &pipelineNotFoundError{pipelineName: name}
+	}
+
+	reloaded, err := entry.create()
+	if err != nil {
+		return fmt.Errorf("reloading pipeline %s: %w", name, err)
+	}
+
+	s.pipelinesMutex.Lock()
+	if s.pipelines[name] != entry {
+		s.pipelinesMutex.Unlock()
+		return errors.Join(fmt.Errorf("pipeline %s was closed or reloaded while reloading", name), reloaded.Destroy())
+	}
+	s.pipelines[name] = &sessionPipeline{
+		pipeline:     reloaded,
+		pipelineType: entry.pipelineType,
+		modelPath:    entry.modelPath,
+		create:       entry.create,
+	}
+	s.pipelinesMutex.Unlock()
+	return entry.pipeline.Destroy()
+}
+
+// removePipelines removes all the pipelines from the session and returns them sorted by name.
+func (s *Session) removePipelines() []pipelines.Pipeline {
+	s.pipelinesMutex.Lock()
+	defer s.pipelinesMutex.Unlock()
+	removed := s.sortedPipelines()
+	s.pipelines = map[string]*sessionPipeline{}
+	return removed
+}
+
+// sortedPipelines returns the pipelines of the session sorted by name. The caller must hold the lock.
+func (s *Session) sortedPipelines() []pipelines.Pipeline {
+	names := make([]string, 0, len(s.pipelines))
+	for name := range s.pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sorted := make([]pipelines.Pipeline, len(names))
+	for i, name := range names {
+		sorted[i] = s.pipelines[name].pipeline
+	}
+	return sorted
+}
+
+// Destroy deletes the hugot session and all initialized pipelines, freeing memory. The onnxruntime environment is
+// destroyed with the last active session.
 // A hugot session should be destroyed when not neeeded anymore, preferably with a defer() call.
 func (s *Session) Destroy() error {
-	return errors.Join(
-		s.featureExtractionPipelines.Destroy(),
-		s.tokenClassificationPipelines.Destroy(),
-		s.textClassificationPipelines.Destroy(),
-		s.ortOptions.Destroy(),
-		ort.DestroyEnvironment(),
-	)
+	var errs []error
+	for _, p := range s.removePipelines() {
+		errs = append(errs, p.Destroy())
+	}
+	if s.ortOptions != nil {
+		errs = append(errs, s.ortOptions.Destroy())
+		s.ortOptions = nil
+	}
+	if s.environmentAcquired {
+		errs = append(errs, releaseEnvironment())
+		s.environmentAcquired = false
+	}
+	return errors.Join(errs...)
 }
 
-// GetStats returns runtime statistics for all initialized pipelines for profiling purposes. We currently record for each pipeline:
-// the total runtime of the tokenization step
-// the number of batch calls to the tokenization step
-// the average time per tokenization batch call
-// the total runtime of the inference (i.e. onnxruntime) step
-// the number of batch calls to the onnxruntime inference
-// the average time per onnxruntime inference batch call
+// GetStats returns runtime statistics for all initialized pipelines for profiling purposes, formatted for logging.
+// We currently record for each pipeline:
+// the total runtime, number of calls, average and p50/p95/p99 latencies of the tokenization step
+// the same for the inference (i.e. onnxruntime) step and the postprocessing step
+// the number of inputs and tokens processed and the share of padding in the inference inputs
+// See Stats for the statistics as values.
 func (s *Session) GetStats() []string {
-	// slices.Concat() is not implemented in experimental x/exp/slices package
-	return append(append(s.tokenClassificationPipelines.GetStats(),
-		s.textClassificationPipelines.GetStats()...),
-		s.featureExtractionPipelines.GetStats()...,
-	)
+	s.pipelinesMutex.RLock()
+	defer s.pipelinesMutex.RUnlock()
+	var stats []string
+	for _, p := range s.sortedPipelines() {
+		stats = append(stats, p.GetStats()...)
+	}
+	return stats
+}
+
+// statsPipeline is a pipeline that keeps statistics of its runs, as the pipelines embedding pipelines.BasePipeline do.
+type statsPipeline interface {
+	Stats() pipelines.PipelineStats
+	ResetStats()
+}
+
+// Stats returns the statistics of the runs of the pipelines of the session, by pipeline name. See
+// pipelines.PipelineStats.
+func (s *Session) Stats() map[string]pipelines.PipelineStats {
+	s.pipelinesMutex.RLock()
+	defer s.pipelinesMutex.RUnlock()
+	stats := make(map[string]pipelines.PipelineStats, len(s.pipelines))
+	for name, entry := range s.pipelines {
+		if p, ok := entry.pipeline.(statsPipeline); ok {
+			stats[name] = p.Stats()
+		}
+	}
+	return stats
+}
+
+// ResetStats resets the statistics of the pipelines of the session, e.g. after a warm-up.
+func (s *Session) ResetStats() {
+	s.pipelinesMutex.RLock()
+	defer s.pipelinesMutex.RUnlock()
+	for _, entry := range s.pipelines {
+		if p, ok := entry.pipeline.(statsPipeline); ok {
+			p.ResetStats()
+		}
+	}
 }
 
 // deprecated methods
@@ -0,0 +1,75 @@
+//go:build !norust
+
+package hugot
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/knights-analytics/tokenizers"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/knights-analytics/hugot/tokenizer"
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// TestGoTokenizer compares the pure Go tokenizer with the rust tokenizers. It needs libtokenizers.a, so it is left out
+// of builds with the norust tag.
+func TestGoTokenizer(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	inputs := []string{
+		"My name is Wolfgang and I live in Berlin.",
+		"  Leading and trailing   spaces, tabs\tand new\nlines  ",
+		"Ünïcödé, accents: café, naïve, Straße; CJK: 東京タワー; emoji: 👋🏽 and digits 1234567",
+		"Contractions aren't split the same way everywhere, e.g. I'm, you'll, they've.",
+		"[CLS] special tokens <s> </s> <|endoftext|> [MASK] <mask> in the text",
+		"",
+	}
+
+	// the pure Go tokenizer gives the same encodings as the rust tokenizers, for each kind of tokenizer of the test
+	// models: WordPiece, byte level BPE and Unigram
+	for _, modelName := range []string{
+		"KnightsAnalytics/all-MiniLM-L6-v2",
+		"KnightsAnalytics/distilbert-NER",
+		"SamLowe/roberta-base-go_emotions-onnx",
+		"Xenova/gpt2",
+		"protectai/deberta-v3-base-zeroshot-v1-onnx",
+		"Xenova/t5-small",
+	} {
+		t.Run(modelName, func(t *testing.T) {
+			modelPath := downloadModelIfNotExists(session, modelName, "./models")
+			tokenizerBytes, err := util.ReadFileBytes(filepath.Join(modelPath, "tokenizer.json"))
+			check(t, err)
+			rustTokenizer, err := tokenizers.FromBytes(tokenizerBytes)
+			check(t, err)
+			defer func() {
+				check(t, rustTokenizer.Close())
+			}()
+			goTokenizer, err := tokenizer.FromBytes(tokenizerBytes)
+			check(t, err)
+
+			for _, input := range inputs {
+				expected := rustTokenizer.EncodeWithOptions(input, true, tokenizers.WithReturnAllAttributes())
+				encoding := goTokenizer.Encode(input, true)
+				assert.Equal(t, expected.IDs, encoding.IDs, input)
+				assert.Equal(t, expected.Tokens, encoding.Tokens, input)
+				assert.Equal(t, expected.TypeIDs, encoding.TypeIDs, input)
+				assert.Equal(t, expected.SpecialTokensMask, encoding.SpecialTokensMask, input)
+				assert.Equal(t, expected.AttentionMask, encoding.AttentionMask, input)
+				assert.Equal(t, len(expected.Offsets), len(encoding.Offsets), input)
+				for i := range expected.Offsets {
+					if i < len(encoding.Offsets) {
+						assert.Equal(t, [2]uint(expected.Offsets[i]), [2]uint(encoding.Offsets[i]), input)
+					}
+				}
+				assert.Equal(t, rustTokenizer.Decode(expected.IDs, true), goTokenizer.Decode(encoding.IDs, true), input)
+			}
+		})
+	}
+}
@@ -6,13 +6,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	ort "github.com/yalue/onnxruntime_go"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/protobuf/encoding/protowire"
 
 	"github.com/knights-analytics/hugot/pipelines"
+	"github.com/knights-analytics/hugot/tokenizer"
 	util "github.com/knights-analytics/hugot/utils"
 )
 
@@ -28,13 +41,63 @@ const onnxRuntimeSharedLibrary = "/usr/lib64/onnxruntime.so"
 // test download validation
 
 func TestDownloadValidation(t *testing.T) {
-	err := validateDownloadHfModel("KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english", "main", "")
+	err := validateDownloadHfModel(context.Background(), "KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english", "main", "")
 	assert.NoError(t, err)
 	// a model without tokenizer.json or .onnx model should error
-	err = validateDownloadHfModel("ByteDance/SDXL-Lightning", "main", "")
+	err = validateDownloadHfModel(context.Background(), "ByteDance/SDXL-Lightning", "main", "")
 	assert.Error(t, err)
 }
 
+func TestDownloadValidationTokenizerFiles(t *testing.T) {
+	// listings of the tree api of the hub, by folder of the repository
+	var listings map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(listings[strings.TrimPrefix(r.URL.Path, "/tree/main")]))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		listings     map[string]string
+		hasOnnx      bool
+		hasTokenizer bool
+	}{
+		{
+			name: "slow tokenizer at the root",
+			listings: map[string]string{
+				"":      `[{"type": "file", "path": "vocab.txt"}, {"type": "directory", "path": "onnx"}]`,
+				"/onnx": `[{"type": "file", "path": "onnx/model.onnx"}]`,
+			},
+			hasOnnx:      true,
+			hasTokenizer: true,
+		},
+		{
+			name: "byte level BPE needs its merges",
+			listings: map[string]string{
+				"": `[{"type": "file", "path": "vocab.json"}, {"type": "file", "path": "model.onnx"}]`,
+			},
+			hasOnnx: true,
+		},
+		{
+			name: "tokenizer in a subfolder",
+			listings: map[string]string{
+				"":      `[{"type": "file", "path": "config.json"}, {"type": "directory", "path": "onnx"}]`,
+				"/onnx": `[{"type": "file", "path": "onnx/model.onnx"}, {"type": "file", "path": "onnx/tokenizer.json"}]`,
+			},
+			hasOnnx: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listings = tt.listings
+			hasTokenizer, hasOnnx, err := checkURL(context.Background(), server.Client(), server.URL+"/tree/main", "", true)
+			check(t, err)
+			assert.Equal(t, tt.hasTokenizer, hasTokenizer)
+			assert.Equal(t, tt.hasOnnx, hasOnnx)
+		})
+	}
+}
+
 // Text classification
 
 func TestTextClassificationPipeline(t *testing.T) {
@@ -241,6 +304,44 @@ func TestTextClassificationPipeline(t *testing.T) {
 	session.GetStats()
 }
 
+func TestTextPairClassificationPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	// an NLI model classifies (premise, hypothesis) pairs into entailment and not_entailment
+	modelPath := downloadModelIfNotExists(session, "protectai/deberta-v3-base-zeroshot-v1-onnx", "./models")
+	config := TextClassificationConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelinePairs",
+		OnnxFilename: "model.onnx",
+		Options: []TextClassificationOption{
+			pipelines.WithSoftmax(),
+		},
+	}
+	pipeline, err := NewPipeline(session, config)
+	check(t, err)
+
+	inputs := [][2]string{
+		{"A man is playing a guitar on stage.", "A person is performing music."},
+		{"A man is playing a guitar on stage.", "Nobody is playing an instrument."},
+	}
+	batchResult, err := pipeline.RunPairsPipeline(inputs)
+	check(t, err)
+	assert.Equal(t, len(inputs), len(batchResult.ClassificationOutputs))
+	assert.Equal(t, "entailment", batchResult.ClassificationOutputs[0][0].Label)
+	assert.Equal(t, "not_entailment", batchResult.ClassificationOutputs[1][0].Label)
+
+	// the pair must be encoded with the separator layout of the tokenizer, not concatenated
+	batch := pipeline.PreprocessPairs(inputs[:1])
+	single := pipeline.Preprocess([]string{inputs[0][0] + " " + inputs[0][1]})
+	assert.NotEqual(t, single.Input[0].TokenIds, batch.Input[0].TokenIds)
+	assert.Contains(t, batch.Input[0].SequenceIds, 1)
+}
+
 func TestTextClassificationPipelineValidation(t *testing.T) {
 	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
 	check(t, err)
@@ -307,6 +408,18 @@ func TestTokenClassificationPipeline(t *testing.T) {
 	pipelineNone, err3 := NewPipeline(session, configNone)
 	check(t, err3)
 
+	configGo := TokenClassificationConfig{
+		ModelPath:   modelPath,
+		Name:        "testPipelineGoTokenizer",
+		GoTokenizer: true,
+		Options: []TokenClassificationOption{
+			pipelines.WithSimpleAggregation(),
+			pipelines.WithIgnoreLabels([]string{"O"}),
+		},
+	}
+	pipelineGo, errGo := NewPipeline(session, configGo)
+	check(t, errGo)
+
 	var expectedResults map[int]pipelines.TokenClassificationOutput
 	err4 := json.Unmarshal(tokenExpectedByte, &expectedResults)
 	check(t, err4)
@@ -335,6 +448,12 @@ func TestTokenClassificationPipeline(t *testing.T) {
 			strings:  []string{"Microsoft incorporated.", "Yesterday I went to Berlin and met with Jack Brown."},
 			expected: expectedResults[2],
 		},
+		{
+			pipeline: pipelineGo,
+			name:     "Pure Go tokenizer",
+			strings:  []string{"Microsoft incorporated.", "Yesterday I went to Berlin and met with Jack Brown."},
+			expected: expectedResults[2],
+		},
 	}
 
 	for _, tt := range tests {
@@ -352,6 +471,83 @@ func TestTokenClassificationPipeline(t *testing.T) {
 			}
 		})
 	}
+
+	wordInputs := []string{"I went shopping in Knightsbridge with Wolfgang Schmidt.", "My name is Wolfgang and I live in Berlin."}
+	for _, strategy := range []string{"FIRST", "AVERAGE", "MAX"} {
+		configWord := TokenClassificationConfig{
+			ModelPath: modelPath,
+			Name:      "testPipeline" + strategy,
+			Options: []TokenClassificationOption{
+				pipelines.WithAggregationStrategy(strategy),
+			},
+		}
+		pipelineWord, errWord := NewPipeline(session, configWord)
+		check(t, errWord)
+		t.Run(pipelineWord.AggregationStrategy+" aggregation", func(t *testing.T) {
+			batchResult, err := pipelineWord.RunPipeline(wordInputs)
+			check(t, err)
+			printTokenEntities(batchResult)
+			for i, predictedEntities := range batchResult.Entities {
+				assert.NotEmpty(t, predictedEntities)
+				for _, entity := range predictedEntities {
+					// entities cover whole words
+					assert.False(t, strings.HasPrefix(entity.Word, "##"))
+					if entity.Start > 0 {
+						assert.False(t, unicode.IsLetter(rune(wordInputs[i][entity.Start-1])))
+					}
+					if int(entity.End) < len(wordInputs[i]) {
+						assert.False(t, unicode.IsLetter(rune(wordInputs[i][entity.End])))
+					}
+				}
+			}
+			assert.Equal(t, "Berlin", batchResult.Entities[1][len(batchResult.Entities[1])-1].Word)
+		})
+	}
+
+	t.Run("CJK words", func(t *testing.T) {
+		// the BERT normalizer makes each CJK character a word, so none of them is a subword of the previous one
+		batch := pipelineNone.Preprocess([]string{"我爱北京"})
+		input := batch.Input[0]
+		preEntities := pipelineNone.GatherPreEntities(input, make([][]float32, len(input.TokenIds)))
+		assert.Equal(t, 4, len(preEntities))
+		for _, preEntity := range preEntities {
+			assert.False(t, preEntity.IsSubword, preEntity.Word)
+		}
+	})
+}
+
+func TestTokenClassificationPipelineSentencePiece(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	// the sentencepiece tokenizer of deberta-v3 with a model that has a single label
+	tokenizerPath := downloadModelIfNotExists(session, "protectai/deberta-v3-base-zeroshot-v1-onnx", "./models")
+	modelPath := extraOutputsModelPath(t, tokenizerPath)
+	check(t, os.WriteFile(filepath.Join(modelPath, "config.json"), []byte(`{"id2label": {"0": "O"}}`), 0o644))
+	pipeline, err := NewPipeline(session, TokenClassificationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineSentencePiece",
+		Options:   []TokenClassificationOption{pipelines.WithoutAggregation()},
+	})
+	check(t, err)
+
+	// the offsets of the tokens that start a word follow the previous token directly, as the space is part of the
+	// token, so the words are told apart by the whitespace in the input
+	batch := pipeline.Preprocess([]string{"Hugot tokenizes Knights Analytics pipelines"})
+	input := batch.Input[0]
+	preEntities := pipeline.GatherPreEntities(input, make([][]float32, len(input.TokenIds)))
+	subwords := 0
+	for _, preEntity := range preEntities {
+		assert.Equal(t, !strings.HasPrefix(preEntity.Word, "▁"), preEntity.IsSubword, preEntity.Word)
+		if preEntity.IsSubword {
+			subwords++
+		}
+	}
+	assert.Greater(t, subwords, 0)
 }
 
 func TestTokenClassificationPipelineValidation(t *testing.T) {
@@ -388,6 +584,13 @@ func TestTokenClassificationPipelineValidation(t *testing.T) {
 		errInt := err.(interface{ Unwrap() []error })
 		assert.Equal(t, 2, len(errInt.Unwrap()))
 	}
+	pipelineSimple.AggregationStrategy = "LONGEST"
+	err = pipelineSimple.Validate()
+	assert.Error(t, err)
+	if err != nil {
+		errInt := err.(interface{ Unwrap() []error })
+		assert.Equal(t, 3, len(errInt.Unwrap()))
+	}
 }
 
 func TestNoSameNamePipeline(t *testing.T) {
@@ -415,6 +618,233 @@ func TestNoSameNamePipeline(t *testing.T) {
 	assert.Error(t, err3)
 }
 
+// a pipeline defined outside of the pipelines package, returning the embeddings together with their norm
+type normPipeline struct {
+	*pipelines.FeatureExtractionPipeline
+}
+
+type normOutput struct {
+	Norms []float32
+}
+
+func (o *normOutput) GetOutput() []any {
+	out := make([]any, len(o.Norms))
+	for i, norm := range o.Norms {
+		out[i] = any(norm)
+	}
+	return out
+}
+
+func (p *normPipeline) Run(inputs []string) (pipelines.PipelineBatchOutput, error) {
+	return p.RunContext(context.Background(), inputs)
+}
+
+func (p *normPipeline) RunContext(ctx context.Context, inputs []string) (pipelines.PipelineBatchOutput, error) {
+	result, err := p.RunPipelineContext(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+	output := normOutput{Norms: make([]float32, len(result.Embeddings))}
+	for i, embedding := range result.Embeddings {
+		var sum float64
+		for _, value := range embedding {
+			sum += float64(value * value)
+		}
+		output.Norms[i] = float32(math.Sqrt(sum))
+	}
+	return &output, nil
+}
+
+func newNormPipeline(config pipelines.PipelineConfig[*normPipeline], ortOptions *ort.SessionOptions) (*normPipeline, error) {
+	featurePipeline, err := pipelines.NewFeatureExtractionPipeline(FeatureExtractionConfig{
+		ModelPath: config.ModelPath,
+		Name:      config.Name,
+	}, ortOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &normPipeline{FeatureExtractionPipeline: featurePipeline}, nil
+}
+
+func TestRegisterPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+	config := pipelines.PipelineConfig[*normPipeline]{
+		ModelPath: modelPath,
+		Name:      "testPipelineNorm",
+	}
+	// the pipeline type must be registered first
+	_, err = NewPipeline(session, config)
+	assert.Error(t, err)
+
+	check(t, RegisterPipeline(newNormPipeline))
+	assert.Error(t, RegisterPipeline(newNormPipeline))
+	assert.Error(t, RegisterPipeline(pipelines.NewFeatureExtractionPipeline))
+
+	pipeline, err := NewPipeline(session, config)
+	check(t, err)
+	result, err := pipeline.Run([]string{"a custom pipeline"})
+	check(t, err)
+	assert.Len(t, result.GetOutput(), 1)
+	assert.Greater(t, result.GetOutput()[0], float32(0))
+
+	retrieved, err := GetPipeline[*normPipeline](session, "testPipelineNorm")
+	check(t, err)
+	assert.Same(t, pipeline, retrieved)
+	// a pipeline of another type cannot be retrieved or created with the same name
+	_, err = GetPipeline[*pipelines.FeatureExtractionPipeline](session, "testPipelineNorm")
+	var notFound *pipelineNotFoundError
+	assert.ErrorAs(t, err, &notFound)
+	_, err = NewPipeline(session, FeatureExtractionConfig{ModelPath: modelPath, Name: "testPipelineNorm"})
+	assert.Error(t, err)
+	assert.Len(t, session.GetStats(), len(pipeline.GetStats()))
+}
+
+func TestPipelineLifecycle(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	featuresPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+	features, err := NewPipeline(session, FeatureExtractionConfig{
+		ModelPath: featuresPath,
+		Name:      "testPipelineLifecycleFeatures",
+	})
+	check(t, err)
+	generationPath := downloadModelIfNotExists(session, "Xenova/gpt2", "./models")
+	generation, err := NewPipeline(session, TextGenerationConfig{
+		ModelPath: generationPath,
+		Name:      "testPipelineLifecycleGeneration",
+		Options: []TextGenerationOption{
+			pipelines.WithMaxTokens(5),
+		},
+	})
+	check(t, err)
+
+	assert.Equal(t, []PipelineInfo{
+		{Name: "testPipelineLifecycleFeatures", Type: "FeatureExtractionPipeline", ModelPath: featuresPath, OutputDim: features.GetOutputDim()},
+		{Name: "testPipelineLifecycleGeneration", Type: "TextGenerationPipeline", ModelPath: generationPath, OutputDim: generation.GetOutputDim()},
+	}, session.ListPipelines())
+
+	t.Run("Reload", func(t *testing.T) {
+		inputs := []string{"the model is reloaded"}
+		expected, err := features.RunPipeline(inputs)
+		check(t, err)
+		check(t, session.ReloadPipeline("testPipelineLifecycleFeatures"))
+		reloaded, err := GetPipeline[*pipelines.FeatureExtractionPipeline](session, "testPipelineLifecycleFeatures")
+		check(t, err)
+		assert.NotSame(t, features, reloaded)
+		result, err := reloaded.RunPipeline(inputs)
+		check(t, err)
+		check(t, floatsEqual(expected.Embeddings[0], result.Embeddings[0]))
+		// the old pipeline has been destroyed
+		_, err = features.RunPipeline(inputs)
+		assert.Error(t, err)
+	})
+
+	t.Run("Reload with a run in progress", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		streamErr := make(chan error, 1)
+		go func() {
+			first := true
+			_, _, err := generation.Stream("Once upon a time", func(pipelines.GeneratedToken) error {
+				if first {
+					first = false
+					close(started)
+					<-release
+				}
+				return nil
+			})
+			streamErr <- err
+		}()
+		<-started
+
+		reloadErr := make(chan error, 1)
+		go func() {
+			reloadErr <- session.ReloadPipeline("testPipelineLifecycleGeneration")
+		}()
+		// the new pipeline is swapped in while the old one is still running
+		var reloaded *pipelines.TextGenerationPipeline
+		for reloaded == nil || reloaded == generation {
+			reloaded, err = GetPipeline[*pipelines.TextGenerationPipeline](session, "testPipelineLifecycleGeneration")
+			check(t, err)
+			time.Sleep(10 * time.Millisecond)
+		}
+		select {
+		case <-reloadErr:
+			t.Fatal("the old pipeline was destroyed during a run")
+		default:
+		}
+		close(release)
+		check(t, <-streamErr)
+		check(t, <-reloadErr)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		pipeline, err := GetPipeline[*pipelines.TextGenerationPipeline](session, "testPipelineLifecycleGeneration")
+		check(t, err)
+		check(t, session.ClosePipeline("testPipelineLifecycleGeneration"))
+		_, err = pipeline.RunPipeline([]string{"Once upon a time"})
+		assert.Error(t, err)
+		_, err = GetPipeline[*pipelines.TextGenerationPipeline](session, "testPipelineLifecycleGeneration")
+		assert.Error(t, err)
+		assert.Error(t, session.ClosePipeline("testPipelineLifecycleGeneration"))
+		assert.Error(t, session.ReloadPipeline("testPipelineLifecycleGeneration"))
+		assert.Len(t, session.ListPipelines(), 1)
+	})
+}
+
+// sessions
+
+func TestMultipleSessions(t *testing.T) {
+	first, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary), WithIntraOpNumThreads(1))
+	check(t, err)
+	second, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary), WithIntraOpNumThreads(2))
+	check(t, err)
+	assert.Equal(t, 2, activeSessions())
+
+	// a session cannot load a different onnxruntime library in the shared environment
+	_, err = NewSession(WithOnnxLibraryPath("./hugot_test.go"))
+	assert.Error(t, err)
+	assert.Equal(t, 2, activeSessions())
+
+	modelPath := downloadModelIfNotExists(first, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+	// the pipelines of each session are independent, so they can have the same name
+	config := FeatureExtractionConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineSessions",
+	}
+	firstPipeline, err := NewPipeline(first, config)
+	check(t, err)
+	secondPipeline, err := NewPipeline(second, config)
+	check(t, err)
+
+	inputs := []string{"Onnx runtime is shared between sessions"}
+	expected, err := firstPipeline.RunPipeline(inputs)
+	check(t, err)
+
+	// destroying a session keeps the environment for the other active sessions
+	check(t, first.Destroy())
+	assert.Equal(t, 1, activeSessions())
+	result, err := secondPipeline.RunPipeline(inputs)
+	check(t, err)
+	check(t, floatsEqual(expected.Embeddings[0], result.Embeddings[0]))
+
+	check(t, second.Destroy())
+	assert.Equal(t, 0, activeSessions())
+	assert.False(t, ort.IsInitialized())
+}
+
 // feature extraction
 
 func TestFeatureExtractionPipeline(t *testing.T) {
@@ -517,6 +947,143 @@ func TestFeatureExtractionPipeline(t *testing.T) {
 	}
 }
 
+func TestFeatureExtractionPipelinePooling(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+	newPipeline := func(name string, options ...FeatureExtractionOption) *pipelines.FeatureExtractionPipeline {
+		pipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath: modelPath,
+			Name:      name,
+			Options:   options,
+		})
+		check(t, err)
+		return pipeline
+	}
+
+	inputs := []string{"robert smith junior", "francis ford coppola was here"}
+	tokenResult, err := newPipeline("testPipelineNoPooling", pipelines.WithoutPooling()).RunPipeline(inputs)
+	check(t, err)
+	assert.Nil(t, tokenResult.Embeddings)
+	assert.Equal(t, len(inputs), len(tokenResult.TokenEmbeddings))
+	firstWord := tokenResult.TokenEmbeddings[0][1]
+	assert.Equal(t, "robert", inputs[0][firstWord.Start:firstWord.End])
+	for i, tokens := range tokenResult.TokenEmbeddings {
+		assert.Equal(t, "[CLS]", tokens[0].Token)
+		assert.Equal(t, "[SEP]", tokens[len(tokens)-1].Token)
+		for _, token := range tokens {
+			assert.Equal(t, 384, len(token.Embedding))
+		}
+		if i == 0 {
+			// the shorter input is padded in the batch, padding tokens are not returned
+			assert.Less(t, len(tokens), len(tokenResult.TokenEmbeddings[1]))
+		}
+	}
+
+	expected := func(pool func(tokens []pipelines.TokenEmbedding) []float32) [][]float32 {
+		out := make([][]float32, len(tokenResult.TokenEmbeddings))
+		for i, tokens := range tokenResult.TokenEmbeddings {
+			out[i] = pool(tokens)
+		}
+		return out
+	}
+	tests := []struct {
+		name     string
+		option   FeatureExtractionOption
+		expected [][]float32
+	}{
+		{
+			name:   "CLS",
+			option: pipelines.WithCLSPooling(),
+			expected: expected(func(tokens []pipelines.TokenEmbedding) []float32 {
+				return tokens[0].Embedding
+			}),
+		},
+		{
+			name:   "Max",
+			option: pipelines.WithMaxPooling(),
+			expected: expected(func(tokens []pipelines.TokenEmbedding) []float32 {
+				vector := append([]float32{}, tokens[0].Embedding...)
+				for _, token := range tokens[1:] {
+					for k, value := range token.Embedding {
+						vector[k] = float32(math.Max(float64(vector[k]), float64(value)))
+					}
+				}
+				return vector
+			}),
+		},
+		{
+			name:   "Last token",
+			option: pipelines.WithLastNPooling(1),
+			expected: expected(func(tokens []pipelines.TokenEmbedding) []float32 {
+				return tokens[len(tokens)-1].Embedding
+			}),
+		},
+		{
+			name:   "Weighted mean",
+			option: pipelines.WithWeightedMeanPooling(),
+			expected: expected(func(tokens []pipelines.TokenEmbedding) []float32 {
+				vector := make([]float32, len(tokens[0].Embedding))
+				var totalWeight float32
+				for j, token := range tokens {
+					for k, value := range token.Embedding {
+						vector[k] += float32(j+1) * value
+					}
+					totalWeight += float32(j + 1)
+				}
+				for k := range vector {
+					vector[k] /= totalWeight
+				}
+				return vector
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batchResult, err := newPipeline("testPipeline"+tt.name, tt.option).RunPipeline(inputs)
+			check(t, err)
+			for i, embedding := range batchResult.Embeddings {
+				check(t, floatsEqual(embedding, tt.expected[i]))
+			}
+		})
+	}
+
+	// the pooling strategy is read from the sentence-transformers configuration of the model
+	t.Run("Pooling configuration", func(t *testing.T) {
+		configuredPath := t.TempDir()
+		entries, err := os.ReadDir(modelPath)
+		check(t, err)
+		for _, entry := range entries {
+			if entry.Name() == "1_Pooling" {
+				continue
+			}
+			absolutePath, err := filepath.Abs(filepath.Join(modelPath, entry.Name()))
+			check(t, err)
+			check(t, os.Symlink(absolutePath, filepath.Join(configuredPath, entry.Name())))
+		}
+		check(t, os.Mkdir(filepath.Join(configuredPath, "1_Pooling"), 0o755))
+		check(t, os.WriteFile(filepath.Join(configuredPath, "1_Pooling", "config.json"),
+			[]byte(`{"word_embedding_dimension": 384, "pooling_mode_cls_token": true, "pooling_mode_mean_tokens": false}`), 0o644))
+
+		pipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath: configuredPath,
+			Name:      "testPipelinePoolingConfiguration",
+		})
+		check(t, err)
+		assert.Equal(t, "CLS", pipeline.PoolingStrategy)
+		batchResult, err := pipeline.RunPipeline(inputs)
+		check(t, err)
+		for i, embedding := range batchResult.Embeddings {
+			check(t, floatsEqual(embedding, tokenResult.TokenEmbeddings[i][0].Embedding))
+		}
+	})
+}
+
 func TestFeatureExtractionPipelineValidation(t *testing.T) {
 	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
 	check(t, err)
@@ -538,6 +1105,1324 @@ func TestFeatureExtractionPipelineValidation(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestFeatureExtractionPipelineOutputSelection(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+	pipeline, err := NewPipeline(session, FeatureExtractionConfig{
+		ModelPath: modelPath,
+		Name:      "testPipeline",
+	})
+	check(t, err)
+
+	// selecting the output by name gives the same embeddings
+	namedPipeline, err := NewPipeline(session, FeatureExtractionConfig{
+		ModelPath:  modelPath,
+		Name:       "testPipelineNamedOutput",
+		OutputName: pipeline.OutputsMeta[0].Name,
+	})
+	check(t, err)
+	inputs := []string{"robert smith junior", "francis ford coppola"}
+	batchResult, err := pipeline.RunPipeline(inputs)
+	check(t, err)
+	namedBatchResult, err := namedPipeline.RunPipeline(inputs)
+	check(t, err)
+	for i := range inputs {
+		check(t, floatsEqual(batchResult.Embeddings[i], namedBatchResult.Embeddings[i]))
+	}
+
+	_, err = NewPipeline(session, FeatureExtractionConfig{
+		ModelPath:  modelPath,
+		Name:       "testPipelineMissingOutput",
+		OutputName: "missing_output",
+	})
+	assert.Error(t, err)
+}
+
+// extraOutputsModel encodes an onnx model whose first output, last_hidden_state, is the input ids as float32 token
+// embeddings of dimension 1, followed by an int64 output, an output with four dynamic dimensions, and the sum of the
+// ids as a sentence embedding whose dimension is dynamic.
+func extraOutputsModel() []byte {
+	message := func(fields ...[]byte) []byte {
+		var b []byte
+		for _, field := range fields {
+			b = append(b, field...)
+		}
+		return b
+	}
+	bytesField := func(number protowire.Number, value []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(nil, number, protowire.BytesType), value)
+	}
+	stringField := func(number protowire.Number, value string) []byte {
+		return bytesField(number, []byte(value))
+	}
+	intField := func(number protowire.Number, value int64) []byte {
+		return protowire.AppendVarint(protowire.AppendTag(nil, number, protowire.VarintType), uint64(value))
+	}
+	valueInfo := func(name string, elemType int64, dims ...string) []byte {
+		var shape []byte
+		for _, dim := range dims {
+			if dim == "1" {
+				shape = append(shape, bytesField(1, intField(1, 1))...)
+			} else {
+				shape = append(shape, bytesField(1, stringField(2, dim))...)
+			}
+		}
+		tensorType := message(intField(1, elemType), bytesField(2, shape))
+		return message(stringField(1, name), bytesField(2, bytesField(1, tensorType)))
+	}
+	node := func(opType string, input string, output string, attributes ...[]byte) []byte {
+		b := message(stringField(1, input), stringField(2, output), stringField(3, output), stringField(4, opType))
+		for _, attribute := range attributes {
+			b = append(b, bytesField(5, attribute)...)
+		}
+		return b
+	}
+	const float, int64Type, attributeInt, attributeInts = 1, 7, 2, 7
+
+	graph := message(
+		bytesField(1, node("Unsqueeze", "input_ids", "unsqueezed", message(stringField(1, "axes"), intField(8, 2), intField(20, attributeInts)))),
+		bytesField(1, node("Cast", "unsqueezed", "last_hidden_state", message(stringField(1, "to"), intField(3, float), intField(20, attributeInt)))),
+		bytesField(1, node("Identity", "input_ids", "ids")),
+		bytesField(1, node("Unsqueeze", "last_hidden_state", "attentions", message(stringField(1, "axes"), intField(8, 1), intField(20, attributeInts)))),
+		bytesField(1, node("ReduceSum", "last_hidden_state", "sentence_embedding",
+			message(stringField(1, "axes"), intField(8, 1), intField(20, attributeInts)),
+			message(stringField(1, "keepdims"), intField(3, 0), intField(20, attributeInt)))),
+		stringField(2, "extra_outputs"),
+		bytesField(11, valueInfo("input_ids", int64Type, "batch", "sequence")),
+		bytesField(12, valueInfo("last_hidden_state", float, "batch", "sequence", "1")),
+		bytesField(12, valueInfo("ids", int64Type, "batch", "sequence")),
+		bytesField(12, valueInfo("attentions", float, "batch", "heads", "queries", "keys")),
+		bytesField(12, valueInfo("sentence_embedding", float, "batch", "sentence_embedding_dim_1")),
+	)
+	return message(
+		intField(1, 7),
+		bytesField(8, message(stringField(1, ""), intField(2, 11))),
+		bytesField(7, graph),
+	)
+}
+
+// extraOutputsModelPath writes the extra outputs model with the files of the tokenizer in the path, and with the
+// config.json of the path as well if it is listed, to a temporary directory.
+func extraOutputsModelPath(t *testing.T, tokenizerPath string, files ...string) string {
+	t.Helper()
+	modelPath := t.TempDir()
+	files = append([]string{"tokenizer.json", "tokenizer_config.json", "special_tokens_map.json"}, files...)
+	for _, name := range files {
+		content, err := os.ReadFile(filepath.Join(tokenizerPath, name))
+		if err != nil {
+			continue
+		}
+		check(t, os.WriteFile(filepath.Join(modelPath, name), content, 0o644))
+	}
+	check(t, os.WriteFile(filepath.Join(modelPath, "model.onnx"), extraOutputsModel(), 0o644))
+	return modelPath
+}
+
+func TestFeatureExtractionPipelineExtraOutputs(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	// the tokenizer of all-MiniLM-L6-v2 with a model that has outputs of other types and shapes than the selected one
+	tokenizerPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+	modelPath := extraOutputsModelPath(t, tokenizerPath, "config.json")
+
+	pipeline, err := NewPipeline(session, FeatureExtractionConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineExtraOutputs",
+		Options:   []FeatureExtractionOption{pipelines.WithMeanPooling()},
+	})
+	check(t, err)
+
+	inputs := []string{"robert smith junior", "francis ford coppola"}
+	batch, err := pipeline.Forward(pipeline.Preprocess(inputs))
+	check(t, err)
+	// the int64 output is converted to float32, the other outputs are allocated by onnxruntime
+	for i, id := range batch.IdsTensor {
+		assert.Equal(t, float32(id), batch.OutputTensors[1][i])
+	}
+	assert.Equal(t, len(batch.IdsTensor), len(batch.OutputTensors[2]))
+
+	batchResult, err := pipeline.RunPipeline(inputs)
+	check(t, err)
+	for i, input := range pipeline.Preprocess(inputs).Input {
+		var sum float32
+		for _, id := range input.TokenIds {
+			sum += float32(id)
+		}
+		assert.InDelta(t, sum/float32(len(input.TokenIds)), batchResult.Embeddings[i][0], 1e-2)
+	}
+
+	// with a token budget, the output with more dynamic dimensions is left out of the reassembled batch
+	bucketedPipeline, err := NewPipeline(session, FeatureExtractionConfig{
+		ModelPath:         modelPath,
+		Name:              "testPipelineExtraOutputsBuckets",
+		MaxTokensPerBatch: 8,
+		Options:           []FeatureExtractionOption{pipelines.WithMeanPooling()},
+	})
+	check(t, err)
+	bucketedResult, err := bucketedPipeline.RunPipeline([]string{"robert", "francis ford coppola was born in detroit"})
+	check(t, err)
+	expectedResult, err := pipeline.RunPipeline([]string{"robert", "francis ford coppola was born in detroit"})
+	check(t, err)
+	for i := range expectedResult.Embeddings {
+		check(t, floatsEqual(expectedResult.Embeddings[i], bucketedResult.Embeddings[i]))
+	}
+
+	// the dynamic dimension of the sentence embedding is not a sequence dimension
+	inputs = []string{"robert", "francis ford coppola was born in detroit"}
+	bucketedBatch, err := bucketedPipeline.Forward(bucketedPipeline.Preprocess(inputs))
+	check(t, err)
+	expectedBatch, err := pipeline.Forward(pipeline.Preprocess(inputs))
+	check(t, err)
+	assert.Equal(t, len(inputs), len(bucketedBatch.OutputTensors[3]))
+	check(t, floatsEqual(expectedBatch.OutputTensors[3], bucketedBatch.OutputTensors[3]))
+
+	// the selected output is an error rather than missing if its rows can not be reassembled
+	bucketedPipeline.OutputsMeta[0].Dimensions[2] = -1
+	_, err = bucketedPipeline.Forward(bucketedPipeline.Preprocess(inputs))
+	assert.Error(t, err)
+}
+
+// zero shot classification
+
+func TestZeroShotClassificationPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "protectai/deberta-v3-base-zeroshot-v1-onnx", "./models")
+	labels := []string{"travel", "cooking", "dancing"}
+	config := ZeroShotClassificationConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineZeroShot",
+		OnnxFilename: "model.onnx",
+		Options: []ZeroShotClassificationOption{
+			pipelines.WithLabels(labels),
+			pipelines.WithHypothesisTemplate("This example is about {}."),
+		},
+	}
+	pipelineSingle, err := NewPipeline(session, config)
+	check(t, err)
+
+	configMulti := ZeroShotClassificationConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineZeroShotMulti",
+		OnnxFilename: "model.onnx",
+		Options: []ZeroShotClassificationOption{
+			pipelines.WithLabels(labels),
+			pipelines.WithMultilabel(true),
+		},
+	}
+	pipelineMulti, err := NewPipeline(session, configMulti)
+	check(t, err)
+
+	inputs := []string{"One day I will see the world", "I love making pasta from scratch"}
+	expectedTopLabels := []string{"travel", "cooking"}
+
+	t.Run("Single label", func(t *testing.T) {
+		batchResult, err := pipelineSingle.RunPipeline(inputs)
+		check(t, err)
+		assert.Equal(t, len(inputs), len(batchResult.ZeroShotOutputs))
+		for i, output := range batchResult.ZeroShotOutputs {
+			assert.Equal(t, inputs[i], output.Sequence)
+			assert.Equal(t, len(labels), len(output.ClassificationOutputs))
+			assert.Equal(t, expectedTopLabels[i], output.ClassificationOutputs[0].Label)
+			sum := float32(0)
+			for j, classificationOutput := range output.ClassificationOutputs {
+				sum += classificationOutput.Score
+				if j > 0 {
+					assert.GreaterOrEqual(t, output.ClassificationOutputs[j-1].Score, classificationOutput.Score)
+				}
+			}
+			assert.True(t, almostEqual(float64(sum), 1))
+		}
+	})
+
+	t.Run("Multi label", func(t *testing.T) {
+		batchResult, err := pipelineMulti.RunPipeline(inputs)
+		check(t, err)
+		for i, output := range batchResult.ZeroShotOutputs {
+			assert.Equal(t, expectedTopLabels[i], output.ClassificationOutputs[0].Label)
+			for _, classificationOutput := range output.ClassificationOutputs {
+				assert.Greater(t, classificationOutput.Score, float32(0))
+				assert.Less(t, classificationOutput.Score, float32(1))
+			}
+		}
+	})
+}
+
+func TestZeroShotClassificationPipelineValidation(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "protectai/deberta-v3-base-zeroshot-v1-onnx", "./models")
+	config := ZeroShotClassificationConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineZeroShot",
+		OnnxFilename: "model.onnx",
+		Options: []ZeroShotClassificationOption{
+			pipelines.WithLabels([]string{"travel"}),
+		},
+	}
+	pipeline, err := NewPipeline(session, config)
+	check(t, err)
+
+	pipeline.Labels = nil
+	pipeline.HypothesisTemplate = "This example is about"
+	err = pipeline.Validate()
+	assert.Error(t, err)
+	if err != nil {
+		errInt := err.(interface{ Unwrap() []error })
+		assert.Equal(t, 2, len(errInt.Unwrap()))
+	}
+}
+
+// question answering
+
+func TestQuestionAnsweringPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "Xenova/distilbert-base-cased-distilled-squad", "./models")
+	config := QuestionAnsweringConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineQuestionAnswering",
+		OnnxFilename: "model.onnx",
+	}
+	pipeline, err := NewPipeline(session, config)
+	check(t, err)
+	// one start and one end logit per token
+	assert.Equal(t, 1, pipeline.GetOutputDim())
+
+	// a small window forces the context to be split in several overlapping features
+	configStride := QuestionAnsweringConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineQuestionAnsweringStride",
+		OnnxFilename: "model.onnx",
+		Options: []QuestionAnsweringOption{
+			pipelines.WithMaxSequenceLength(24),
+			pipelines.WithDocStride(8),
+			pipelines.WithTopKAnswers(2),
+		},
+	}
+	pipelineStride, err := NewPipeline(session, configStride)
+	check(t, err)
+
+	longContext := "Hugot is a library to run transformer pipelines in go. It was written by Knights Analytics. " +
+		"It uses onnxruntime for inference and the rust tokenizers for tokenization. My name is Clara and I live in Berkeley."
+
+	tests := []struct {
+		pipeline *pipelines.QuestionAnsweringPipeline
+		name     string
+		inputs   [][2]string
+		expected []string
+	}{
+		{
+			pipeline: pipeline,
+			name:     "Basic tests",
+			inputs: [][2]string{
+				{"What is my name?", "My name is Clara and I live in Berkeley."},
+				{"Where do I live?", "My name is Clara and I live in Berkeley."},
+			},
+			expected: []string{"Clara", "Berkeley"},
+		},
+		{
+			pipeline: pipelineStride,
+			name:     "Long context",
+			inputs:   [][2]string{{"Where do I live?", longContext}},
+			expected: []string{"Berkeley"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batchResult, err := tt.pipeline.RunPipeline(tt.inputs)
+			check(t, err)
+			assert.Equal(t, len(tt.inputs), len(batchResult.Answers))
+			for i, answers := range batchResult.Answers {
+				assert.LessOrEqual(t, len(answers), tt.pipeline.TopK)
+				assert.Equal(t, tt.expected[i], answers[0].Answer)
+				assert.Equal(t, tt.expected[i], tt.inputs[i][1][answers[0].Start:answers[0].End])
+			}
+		})
+	}
+
+	_, err = pipeline.Run([]string{"What is my name?"})
+	assert.Error(t, err)
+}
+
+func TestFillMaskPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "Xenova/distilbert-base-uncased", "./models")
+	config := FillMaskConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineFillMask",
+		OnnxFilename: "model.onnx",
+	}
+	pipeline, err := NewPipeline(session, config)
+	check(t, err)
+
+	configTargets := FillMaskConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineFillMaskTargets",
+		OnnxFilename: "model.onnx",
+		Options: []FillMaskOption{
+			pipelines.WithTargets([]string{"city", "capital"}),
+		},
+	}
+	pipelineTargets, err := NewPipeline(session, configTargets)
+	check(t, err)
+
+	assert.Equal(t, "[MASK]", pipeline.MaskToken)
+
+	batchResult, err := pipeline.RunPipeline([]string{"Paris is the [MASK] of France.", "The [MASK] sat on the [MASK]."})
+	check(t, err)
+	assert.Equal(t, 2, len(batchResult.Masks))
+	assert.Equal(t, 1, len(batchResult.Masks[0]))
+	assert.Equal(t, 2, len(batchResult.Masks[1]))
+	predictions := batchResult.Masks[0][0].Predictions
+	assert.Equal(t, 5, len(predictions))
+	assert.Equal(t, "capital", predictions[0].TokenStr)
+	assert.Equal(t, "paris is the capital of france.", predictions[0].Sequence)
+	for i := 1; i < len(predictions); i++ {
+		assert.GreaterOrEqual(t, predictions[i-1].Score, predictions[i].Score)
+	}
+
+	batchResult, err = pipelineTargets.RunPipeline([]string{"Paris is the [MASK] of France."})
+	check(t, err)
+	predictions = batchResult.Masks[0][0].Predictions
+	assert.Equal(t, 2, len(predictions))
+	assert.Equal(t, "capital", predictions[0].TokenStr)
+	assert.Equal(t, "city", predictions[1].TokenStr)
+
+	// targets are looked up in the vocabulary before they are tokenized, and each token is predicted once
+	pipelineDuplicateTargets, err := NewPipeline(session, FillMaskConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineFillMaskDuplicateTargets",
+		OnnxFilename: "model.onnx",
+		Options: []FillMaskOption{
+			pipelines.WithTargets([]string{"city", "capital", "city", "##s"}),
+		},
+	})
+	check(t, err)
+	batchResult, err = pipelineDuplicateTargets.RunPipeline([]string{"Paris is the [MASK] of France."})
+	check(t, err)
+	predictions = batchResult.Masks[0][0].Predictions
+	assert.Equal(t, 3, len(predictions))
+	assert.Equal(t, "capital", predictions[0].TokenStr)
+
+	_, err = pipeline.RunPipeline([]string{"There is no mask here."})
+	assert.Error(t, err)
+
+	// an output that is not the logits over the vocabulary is a validation error
+	_, err = NewPipeline(session, FillMaskConfig{
+		ModelPath:  extraOutputsModelPath(t, modelPath),
+		Name:       "testPipelineFillMaskOutputRank",
+		OutputName: "ids",
+	})
+	assert.Error(t, err)
+}
+
+func TestRerankPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "cross-encoder/ms-marco-MiniLM-L-6-v2", "./models")
+	config := RerankConfig{
+		ModelPath:    modelPath,
+		Name:         "testPipelineRerank",
+		OnnxFilename: "model.onnx",
+		Options: []RerankOption{
+			pipelines.WithSigmoidScores(),
+			pipelines.WithRerankBatchSize(2),
+		},
+	}
+	pipeline, err := NewPipeline(session, config)
+	check(t, err)
+
+	documents := []string{
+		"The weather in Paris is sunny today.",
+		"Berlin is the capital and largest city of Germany.",
+		"Bananas are a good source of potassium.",
+	}
+	result, err := pipeline.RunPipeline("What is the capital of Germany?", documents)
+	check(t, err)
+	assert.Equal(t, len(documents), len(result.Results))
+	assert.Equal(t, 1, result.Results[0].Index)
+	assert.Equal(t, documents[1], result.Results[0].Document)
+	for i, r := range result.Results {
+		assert.Equal(t, documents[r.Index], r.Document)
+		assert.GreaterOrEqual(t, r.Score, float32(0))
+		assert.LessOrEqual(t, r.Score, float32(1))
+		if i > 0 {
+			assert.GreaterOrEqual(t, result.Results[i-1].Score, r.Score)
+		}
+	}
+
+	_, err = pipeline.Run(documents)
+	assert.Error(t, err)
+}
+
+func TestText2TextGenerationPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "Xenova/t5-small", "./models")
+	config := Text2TextGenerationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineText2TextGreedy",
+		Options: []Text2TextGenerationOption{
+			pipelines.WithMaxNewTokens(30),
+		},
+	}
+	pipelineGreedy, err := NewPipeline(session, config)
+	check(t, err)
+
+	configBeam := Text2TextGenerationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineText2TextBeam",
+		Options: []Text2TextGenerationOption{
+			pipelines.WithMaxNewTokens(30),
+			pipelines.WithNumBeams(3),
+			pipelines.WithLengthPenalty(1.2),
+			pipelines.WithNoRepeatNgramSize(2),
+		},
+	}
+	pipelineBeam, err := NewPipeline(session, configBeam)
+	check(t, err)
+
+	inputs := []string{
+		"translate English to French: The house is wonderful.",
+		"translate English to German: I like to read books in the evening.",
+	}
+
+	for _, pipeline := range []*pipelines.Text2TextGenerationPipeline{pipelineGreedy, pipelineBeam} {
+		t.Run(pipeline.PipelineName, func(t *testing.T) {
+			batchResult, err := pipeline.RunPipeline(inputs)
+			check(t, err)
+			assert.Equal(t, len(inputs), len(batchResult.GeneratedTexts))
+			assert.Contains(t, batchResult.GeneratedTexts[0], "maison")
+			assert.Contains(t, batchResult.GeneratedTexts[1], "Bücher")
+			for _, tokens := range batchResult.GeneratedTokens {
+				assert.LessOrEqual(t, len(tokens), pipeline.MaxNewTokens)
+			}
+		})
+	}
+
+	// with no repeated bigrams, each bigram of the output is unique
+	batchResult, err := pipelineBeam.RunPipeline(inputs)
+	check(t, err)
+	for _, tokens := range batchResult.GeneratedTokens {
+		seen := map[[2]uint32]bool{}
+		for i := 1; i < len(tokens); i++ {
+			bigram := [2]uint32{tokens[i-1], tokens[i]}
+			assert.False(t, seen[bigram])
+			seen[bigram] = true
+		}
+	}
+}
+
+func TestTextGenerationPipeline(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	modelPath := downloadModelIfNotExists(session, "Xenova/gpt2", "./models")
+	config := TextGenerationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineTextGenerationGreedy",
+		Options: []TextGenerationOption{
+			pipelines.WithMaxTokens(10),
+		},
+	}
+	pipelineGreedy, err := NewPipeline(session, config)
+	check(t, err)
+
+	configSampling := TextGenerationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineTextGenerationSampling",
+		Options: []TextGenerationOption{
+			pipelines.WithMaxTokens(10),
+			pipelines.WithTemperature(0.8),
+			pipelines.WithTopK(40),
+			pipelines.WithTopP(0.9),
+			pipelines.WithRepetitionPenalty(1.2),
+			pipelines.WithSeed(42),
+		},
+	}
+	pipelineSampling, err := NewPipeline(session, configSampling)
+	check(t, err)
+
+	prompt := "Hello, my name is"
+
+	t.Run("Greedy", func(t *testing.T) {
+		batchResult, err := pipelineGreedy.RunPipeline([]string{prompt, prompt})
+		check(t, err)
+		assert.Equal(t, 2, len(batchResult.GeneratedTexts))
+		assert.NotEmpty(t, batchResult.GeneratedTexts[0])
+		assert.LessOrEqual(t, len(batchResult.GeneratedTokens[0]), 10)
+		assert.Equal(t, batchResult.GeneratedTexts[0], batchResult.GeneratedTexts[1])
+	})
+
+	t.Run("Seeded sampling", func(t *testing.T) {
+		first, err := pipelineSampling.RunPipeline([]string{prompt})
+		check(t, err)
+		second, err := pipelineSampling.RunPipeline([]string{prompt})
+		check(t, err)
+		assert.Equal(t, first.GeneratedTexts[0], second.GeneratedTexts[0])
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		streamed := ""
+		text, _, err := pipelineGreedy.Stream(prompt, func(token pipelines.GeneratedToken) error {
+			streamed += token.Text
+			return nil
+		})
+		check(t, err)
+		assert.Equal(t, text, streamed)
+
+		tokens, errs := pipelineSampling.StreamChannel(context.Background(), prompt)
+		streamed = ""
+		for token := range tokens {
+			streamed += token.Text
+		}
+		check(t, <-errs)
+		batchResult, err := pipelineSampling.RunPipeline([]string{prompt})
+		check(t, err)
+		assert.Equal(t, batchResult.GeneratedTexts[0], streamed)
+
+		// a consumer that stops reading cancels the context, which ends the generation
+		ctx, cancel := context.WithCancel(context.Background())
+		tokens, errs = pipelineSampling.StreamChannel(ctx, prompt)
+		<-tokens
+		cancel()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+	})
+
+	t.Run("Stop sequences", func(t *testing.T) {
+		batchResult, err := pipelineGreedy.RunPipeline([]string{prompt})
+		check(t, err)
+		words := strings.Fields(batchResult.GeneratedTexts[0])
+		assert.Greater(t, len(words), 1)
+
+		configStop := TextGenerationConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineTextGenerationStop",
+			Options: []TextGenerationOption{
+				pipelines.WithMaxTokens(10),
+				pipelines.WithStopSequences([]string{words[1]}),
+			},
+		}
+		pipelineStop, err := NewPipeline(session, configStop)
+		check(t, err)
+		streamed := ""
+		text, _, err := pipelineStop.Stream(prompt, func(token pipelines.GeneratedToken) error {
+			streamed += token.Text
+			return nil
+		})
+		check(t, err)
+		assert.NotContains(t, text, words[1])
+		assert.Equal(t, text, streamed)
+		assert.True(t, strings.HasPrefix(batchResult.GeneratedTexts[0], text))
+	})
+}
+
+// long inputs
+
+func TestLongInputWindows(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	longInput := "My name is Wolfgang and I live in Berlin. I work for Microsoft as an engineer, and on weekends " +
+		"I travel to Paris with my friend Jack Brown to visit museums and eat croissants by the river."
+	inputs := []string{"robert smith", longInput}
+
+	t.Run("Feature extraction", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+		fullPipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineFullTokens",
+			Options:   []FeatureExtractionOption{pipelines.WithoutPooling()},
+		})
+		check(t, err)
+		fullResult, err := fullPipeline.RunPipeline(inputs)
+		check(t, err)
+
+		truncatedPipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineTruncatedTokens",
+			MaxLength: 16,
+			Options:   []FeatureExtractionOption{pipelines.WithoutPooling()},
+		})
+		check(t, err)
+		truncatedResult, err := truncatedPipeline.RunPipeline(inputs)
+		check(t, err)
+		assert.Equal(t, len(fullResult.TokenEmbeddings[0]), len(truncatedResult.TokenEmbeddings[0]))
+		assert.Equal(t, 16, len(truncatedResult.TokenEmbeddings[1]))
+		assert.Equal(t, "[SEP]", truncatedResult.TokenEmbeddings[1][15].Token)
+
+		// the windows are merged back into the tokens of the full input
+		windowPipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineWindowTokens",
+			MaxLength: 16,
+			Stride:    4,
+			Options:   []FeatureExtractionOption{pipelines.WithoutPooling()},
+		})
+		check(t, err)
+		windowResult, err := windowPipeline.RunPipeline(inputs)
+		check(t, err)
+		assert.Equal(t, len(inputs), len(windowResult.TokenEmbeddings))
+		assert.Equal(t, len(fullResult.TokenEmbeddings[1]), len(windowResult.TokenEmbeddings[1]))
+		for j, token := range windowResult.TokenEmbeddings[1] {
+			assert.Equal(t, fullResult.TokenEmbeddings[1][j].Token, token.Token)
+			assert.Equal(t, fullResult.TokenEmbeddings[1][j].Start, token.Start)
+			assert.Equal(t, fullResult.TokenEmbeddings[1][j].End, token.End)
+		}
+
+		pooledPipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineWindowPooled",
+			MaxLength: 16,
+			Stride:    4,
+		})
+		check(t, err)
+		pooledResult, err := pooledPipeline.RunPipeline(inputs)
+		check(t, err)
+		assert.Equal(t, len(inputs), len(pooledResult.Embeddings))
+		shortResult, err := pooledPipeline.RunPipeline(inputs[:1])
+		check(t, err)
+		check(t, floatsEqual(shortResult.Embeddings[0], pooledResult.Embeddings[0]))
+	})
+
+	t.Run("Token classification", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/distilbert-NER", "./models")
+		fullPipeline, err := NewPipeline(session, TokenClassificationConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineFullEntities",
+		})
+		check(t, err)
+		fullResult, err := fullPipeline.RunPipeline([]string{longInput})
+		check(t, err)
+
+		windowPipeline, err := NewPipeline(session, TokenClassificationConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineWindowEntities",
+			MaxLength: 16,
+			Stride:    6,
+		})
+		check(t, err)
+		windowResult, err := windowPipeline.RunPipeline([]string{longInput})
+		check(t, err)
+		printTokenEntities(windowResult)
+		assert.Equal(t, len(fullResult.Entities[0]), len(windowResult.Entities[0]))
+		for j, entity := range windowResult.Entities[0] {
+			assert.Equal(t, fullResult.Entities[0][j].Entity, entity.Entity)
+			assert.Equal(t, fullResult.Entities[0][j].Word, entity.Word)
+			// offsets refer to the original text
+			assert.Equal(t, entity.Word, longInput[entity.Start:entity.End])
+		}
+	})
+
+	t.Run("Text classification", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english", "./models")
+		windowOptions := map[string]TextClassificationOption{
+			"Mean": pipelines.WithMeanWindowScores(),
+			"Max":  pipelines.WithMaxWindowScores(),
+		}
+		for name, option := range windowOptions {
+			pipeline, err := NewPipeline(session, TextClassificationConfig{
+				ModelPath: modelPath,
+				Name:      "testPipelineWindowClassification" + name,
+				MaxLength: 16,
+				Stride:    4,
+				Options:   []TextClassificationOption{pipelines.WithSoftmax(), pipelines.WithMultiLabel(), option},
+			})
+			check(t, err)
+			result, err := pipeline.RunPipeline(inputs)
+			check(t, err)
+			assert.Equal(t, len(inputs), len(result.ClassificationOutputs))
+			scores := float32(0)
+			for _, output := range result.ClassificationOutputs[1] {
+				scores += output.Score
+			}
+			if pipeline.WindowAggregation == "MEAN" {
+				// the mean of probability distributions is a probability distribution
+				assert.InDelta(t, 1, scores, 1e-5)
+			} else {
+				assert.GreaterOrEqual(t, scores, float32(1-1e-5))
+			}
+		}
+	})
+
+	t.Run("Text pair truncation", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "protectai/deberta-v3-base-zeroshot-v1-onnx", "./models")
+		for _, truncation := range []string{"longest_first", "only_first", "only_second"} {
+			pipeline, err := NewPipeline(session, TextClassificationConfig{
+				ModelPath:  modelPath,
+				Name:       "testPipelinePairTruncation" + truncation,
+				MaxLength:  24,
+				Truncation: truncation,
+				Options:    []TextClassificationOption{pipelines.WithSoftmax()},
+			})
+			check(t, err)
+			result, err := pipeline.RunPairsPipeline([][2]string{{longInput, longInput}})
+			check(t, err)
+			assert.Equal(t, 1, len(result.ClassificationOutputs))
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "Xenova/distilbert-base-uncased", "./models")
+		_, err := NewPipeline(session, FillMaskConfig{
+			ModelPath:    modelPath,
+			Name:         "testPipelineFillMaskStride",
+			OnnxFilename: "model.onnx",
+			MaxLength:    16,
+			Stride:       4,
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestDynamicBatching(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	inputs := []string{
+		"My name is Wolfgang and I live in Berlin. I work for Microsoft as an engineer, and on weekends I travel to Paris.",
+		"robert smith",
+		"Yesterday I went to Berlin and met with Jack Brown.",
+		"yo",
+	}
+
+	t.Run("Feature extraction", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+		pipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineFeatures",
+		})
+		check(t, err)
+		bucketedPipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath:         modelPath,
+			Name:              "testPipelineFeaturesBucketed",
+			MaxTokensPerBatch: 24,
+		})
+		check(t, err)
+
+		batchResult, err := pipeline.RunPipeline(inputs)
+		check(t, err)
+		bucketedResult, err := bucketedPipeline.RunPipeline(inputs)
+		check(t, err)
+		for i := range inputs {
+			check(t, floatsEqual(batchResult.Embeddings[i], bucketedResult.Embeddings[i]))
+		}
+		// the batch was run in several sub-batches
+		assert.Greater(t, bucketedPipeline.PipelineTimings.NumCalls, uint64(1))
+	})
+
+	t.Run("Token classification", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/distilbert-NER", "./models")
+		pipeline, err := NewPipeline(session, TokenClassificationConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineEntities",
+		})
+		check(t, err)
+		bucketedPipeline, err := NewPipeline(session, TokenClassificationConfig{
+			ModelPath:         modelPath,
+			Name:              "testPipelineEntitiesBucketed",
+			MaxTokensPerBatch: 24,
+		})
+		check(t, err)
+
+		batchResult, err := pipeline.RunPipeline(inputs)
+		check(t, err)
+		bucketedResult, err := bucketedPipeline.RunPipeline(inputs)
+		check(t, err)
+		for i := range inputs {
+			assert.Equal(t, len(batchResult.Entities[i]), len(bucketedResult.Entities[i]))
+			for j, entity := range bucketedResult.Entities[i] {
+				assert.Equal(t, batchResult.Entities[i][j].Entity, entity.Entity)
+				assert.Equal(t, batchResult.Entities[i][j].Word, entity.Word)
+				assert.Equal(t, batchResult.Entities[i][j].Start, entity.Start)
+			}
+		}
+	})
+}
+
+// Tokenizers
+
+func TestLegacyTokenizerFiles(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	inputs := []string{
+		"My name is Wolfgang and I live in Berlin.",
+		"Ünïcödé, accents: café, naïve, Straße; digits 1234567",
+		"",
+	}
+
+	// without tokenizer.json, the tokenizer is converted from the files of the slow tokenizer of the model and gives
+	// the same encodings
+	for _, modelName := range []string{
+		"KnightsAnalytics/all-MiniLM-L6-v2",
+		"Xenova/gpt2",
+		"protectai/deberta-v3-base-zeroshot-v1-onnx",
+	} {
+		t.Run(modelName, func(t *testing.T) {
+			modelPath := downloadModelIfNotExists(session, modelName, "./models")
+			legacyPath := t.TempDir()
+			var found bool
+			for _, name := range []string{"vocab.txt", "vocab.json", "merges.txt", "spm.model", "spiece.model", "tokenizer_config.json", "special_tokens_map.json", "added_tokens.json"} {
+				content, err := os.ReadFile(filepath.Join(modelPath, name))
+				if err != nil {
+					continue
+				}
+				found = found || tokenizer.IsTokenizerFile(name) || name == "vocab.json"
+				check(t, os.WriteFile(filepath.Join(legacyPath, name), content, 0o644))
+			}
+			if !found {
+				t.Skipf("%s has no slow tokenizer files", modelName)
+			}
+
+			tokenizerBytes, err := util.ReadFileBytes(filepath.Join(modelPath, "tokenizer.json"))
+			check(t, err)
+			expectedTokenizer, err := tokenizer.FromBytes(tokenizerBytes)
+			check(t, err)
+			legacyBytes, err := tokenizer.ReadJSON(legacyPath)
+			check(t, err)
+			legacyTokenizer, err := tokenizer.FromBytes(legacyBytes)
+			check(t, err)
+
+			for _, input := range inputs {
+				expected := expectedTokenizer.Encode(input, true)
+				encoding := legacyTokenizer.Encode(input, true)
+				assert.Equal(t, expected.IDs, encoding.IDs, input)
+				assert.Equal(t, expected.SpecialTokensMask, encoding.SpecialTokensMask, input)
+			}
+		})
+	}
+}
+
+// Metrics
+
+func TestMetrics(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+	modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english", "./models")
+	pipeline, err := NewPipeline(session, TextClassificationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineMetrics",
+	})
+	check(t, err)
+	registry := prometheus.NewRegistry()
+	check(t, session.RegisterMetrics(registry))
+
+	_, err = pipeline.RunPipeline([]string{"This movie is disgustingly good !", "The director tried too much"})
+	check(t, err)
+	_, err = pipeline.RunPipeline([]string{"I love it"})
+	check(t, err)
+
+	metrics := pipeline.GetMetrics()
+	assert.Equal(t, uint64(3), metrics.Inputs())
+	for _, stage := range pipelines.Stages {
+		assert.Equal(t, uint64(2), metrics.Durations[stage].Snapshot().Count)
+		assert.Equal(t, uint64(0), metrics.Errors(stage))
+	}
+	batchSizes := metrics.BatchSize.Snapshot()
+	assert.Equal(t, float64(3), batchSizes.Sum)
+	assert.Equal(t, []uint64{1, 2}, batchSizes.Counts[:2]) // one batch of one input and one of two
+
+	families, err := registry.Gather()
+	check(t, err)
+	exported := map[string]int{}
+	for _, family := range families {
+		exported[family.GetName()] = len(family.GetMetric())
+		for _, metric := range family.GetMetric() {
+			assert.Equal(t, "testPipelineMetrics", metric.GetLabel()[0].GetValue())
+		}
+		if family.GetName() == "hugot_pipeline_inputs_total" {
+			assert.Equal(t, float64(3), family.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"hugot_pipeline_stage_duration_seconds": 3,
+		"hugot_pipeline_errors_total":           3,
+		"hugot_pipeline_batch_size":             1,
+		"hugot_pipeline_sequence_length":        1,
+		"hugot_pipeline_inputs_total":           1,
+	}, exported)
+}
+
+func TestStats(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+	modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english", "./models")
+	pipeline, err := NewPipeline(session, TextClassificationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineStats",
+	})
+	check(t, err)
+
+	inputs := []string{"This movie is disgustingly good !", "The director tried too much", "I love it"}
+	_, err = pipeline.RunPipeline(inputs)
+	check(t, err)
+
+	stats := session.Stats()
+	assert.Len(t, stats, 1)
+	pipelineStats := stats["testPipelineStats"]
+	assert.Equal(t, pipeline.Stats(), pipelineStats)
+	for _, stage := range []pipelines.StageStats{pipelineStats.Tokenization, pipelineStats.Forward, pipelineStats.Postprocess} {
+		assert.Equal(t, uint64(1), stage.Calls)
+		assert.Greater(t, stage.Total, time.Duration(0))
+		assert.Equal(t, stage.Total, stage.Average)
+		assert.LessOrEqual(t, stage.P50, stage.P95)
+		assert.LessOrEqual(t, stage.P95, stage.P99)
+	}
+	assert.Equal(t, uint64(3), pipelineStats.Inputs)
+	batch := pipeline.Preprocess(inputs)
+	tokens := 0
+	for _, input := range batch.Input {
+		tokens += len(input.TokenIds)
+	}
+	assert.Equal(t, uint64(tokens), pipelineStats.Tokens)
+	assert.InDelta(t, float64(len(inputs)*batch.MaxSequence-tokens)/float64(len(inputs)*batch.MaxSequence), pipelineStats.PaddingRatio, 1e-9)
+	assert.Greater(t, pipelineStats.PaddingRatio, 0.0)
+	assert.Len(t, session.GetStats(), 5)
+
+	session.ResetStats()
+	assert.Equal(t, pipelines.PipelineStats{}, session.Stats()["testPipelineStats"])
+	assert.Equal(t, uint64(0), pipeline.PipelineTimings.NumCalls)
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	histogram := pipelines.NewHistogram([]float64{1, 2, 4})
+	assert.Equal(t, float64(0), histogram.Snapshot().Quantile(0.5))
+	for _, value := range []float64{0.5, 1.5, 1.5, 3} {
+		histogram.Observe(value)
+	}
+	snapshot := histogram.Snapshot()
+	assert.Equal(t, float64(1), snapshot.Quantile(0.25))
+	assert.Equal(t, float64(2), snapshot.Quantile(0.75))
+	assert.Equal(t, 1.5, snapshot.Quantile(0.5))
+	assert.Equal(t, float64(4), snapshot.Quantile(1))
+	histogram.Observe(100)
+	assert.Equal(t, float64(4), histogram.Snapshot().Quantile(1))
+	histogram.Reset()
+	assert.Equal(t, uint64(0), histogram.Snapshot().Count)
+}
+
+// Tracing
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary), WithTracerProvider(provider))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+	modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english", "./models")
+	pipeline, err := NewPipeline(session, TextClassificationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineTracing",
+	})
+	check(t, err)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	_, err = pipeline.RunPipelineContext(ctx, []string{"This movie is disgustingly good !", "The director tried too much"})
+	check(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+		if span.Name() == "request" {
+			continue
+		}
+		// the stages are children of the span of the context
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		attributes := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attributes[kv.Key] = kv.Value
+		}
+		assert.Equal(t, "testPipelineTracing", attributes[pipelines.AttributePipelineName].AsString())
+		assert.Equal(t, modelPath, attributes[pipelines.AttributeModelPath].AsString())
+		assert.Equal(t, int64(2), attributes[pipelines.AttributeBatchSize].AsInt64())
+		assert.Greater(t, attributes[pipelines.AttributeMaxSequenceLength].AsInt64(), int64(0))
+	}
+	assert.Equal(t, []string{"hugot.Preprocess", "hugot.Forward", "hugot.Postprocess", "request"}, names)
+
+	// without a tracer provider the spans are not recorded
+	untraced, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(untraced)
+	untracedPipeline, err := NewPipeline(untraced, TextClassificationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineNoTracing",
+	})
+	check(t, err)
+	_, err = untracedPipeline.RunPipelineContext(ctx, []string{"I love it"})
+	check(t, err)
+	assert.Len(t, recorder.Ended(), len(spans))
+}
+
+// Micro-batching of concurrent inputs
+
+func TestBatcher(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+	modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english", "./models")
+	pipeline, err := NewPipeline(session, TextClassificationConfig{
+		ModelPath: modelPath,
+		Name:      "testPipelineBatcher",
+	})
+	check(t, err)
+
+	inputs := []string{"This movie is disgustingly good !", "The director tried too much", "The film was excellent", "I hated it"}
+	expected, err := pipeline.RunPipeline(inputs)
+	check(t, err)
+	callsBefore := pipeline.PipelineTimings.NumCalls
+
+	batcher, err := pipelines.NewBatcher(pipeline, pipelines.WithMaxBatchSize(len(inputs)), pipelines.WithMaxWait(time.Second))
+	check(t, err)
+	outputs := make([]any, len(inputs))
+	errs := make([]error, len(inputs))
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(i int, input string) {
+			defer wg.Done()
+			outputs[i], errs[i] = batcher.Submit(context.Background(), input)
+		}(i, input)
+	}
+	wg.Wait()
+	check(t, batcher.Close())
+	for i := range inputs {
+		check(t, errs[i])
+		assert.Equal(t, expected.ClassificationOutputs[i], outputs[i])
+	}
+	// the concurrent inputs were run in a single batch, as the batch was full before the wait was over
+	assert.Equal(t, callsBefore+1, pipeline.PipelineTimings.NumCalls)
+
+	_, err = batcher.Submit(context.Background(), inputs[0])
+	assert.ErrorIs(t, err, pipelines.ErrBatcherClosed)
+
+	_, err = pipelines.NewBatcher(pipeline, pipelines.WithMaxBatchSize(0), pipelines.WithMaxQueueSize(0))
+	assert.Error(t, err)
+	// a negative queue size is a validation error rather than a panic
+	assert.NotPanics(t, func() {
+		_, err = pipelines.NewBatcher(pipeline, pipelines.WithMaxQueueSize(-1))
+	})
+	assert.Error(t, err)
+}
+
+// a pipeline blocking its runs until released, to test the queue of the batcher
+type blockingPipeline struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingPipeline) Destroy() error     { return nil }
+func (p *blockingPipeline) GetStats() []string { return nil }
+func (p *blockingPipeline) GetOutputDim() int  { return 1 }
+func (p *blockingPipeline) Validate() error    { return nil }
+func (p *blockingPipeline) Run(inputs []string) (pipelines.PipelineBatchOutput, error) {
+	return p.RunContext(context.Background(), inputs)
+}
+
+func (p *blockingPipeline) RunContext(_ context.Context, inputs []string) (pipelines.PipelineBatchOutput, error) {
+	p.started <- struct{}{}
+	<-p.release
+	output := normOutput{Norms: make([]float32, len(inputs))}
+	for i, input := range inputs {
+		output.Norms[i] = float32(len(input))
+	}
+	return &output, nil
+}
+
+func TestBatcherBackpressure(t *testing.T) {
+	pipeline := &blockingPipeline{started: make(chan struct{}), release: make(chan struct{})}
+	batcher, err := pipelines.NewBatcher(pipeline, pipelines.WithMaxBatchSize(1), pipelines.WithMaxWait(0), pipelines.WithMaxQueueSize(1))
+	check(t, err)
+
+	results := make(chan any, 2)
+	submit := func(input string) {
+		output, err := batcher.Submit(context.Background(), input)
+		assert.NoError(t, err)
+		results <- output
+	}
+	// the first input is running, and the second one fills the queue
+	go submit("one")
+	<-pipeline.started
+	go submit("three")
+	for batcher.QueueLength() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = batcher.Submit(context.Background(), "rejected")
+	assert.ErrorIs(t, err, pipelines.ErrBatcherQueueFull)
+
+	// a caller giving up gets the error of its context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = batcher.Submit(ctx, "cancelled")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	pipeline.release <- struct{}{}
+	<-pipeline.started
+	pipeline.release <- struct{}{}
+	assert.ElementsMatch(t, []any{float32(3), float32(5)}, []any{<-results, <-results})
+	check(t, batcher.Close())
+}
+
+// Context: cancellation and deadlines
+
+func TestRunContext(t *testing.T) {
+	session, err := NewSession(WithOnnxLibraryPath(onnxRuntimeSharedLibrary))
+	check(t, err)
+	defer func(session *Session) {
+		err := session.Destroy()
+		check(t, err)
+	}(session)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("Feature extraction", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "KnightsAnalytics/all-MiniLM-L6-v2", "./models")
+		pipeline, err := NewPipeline(session, FeatureExtractionConfig{
+			ModelPath:         modelPath,
+			Name:              "testPipelineFeaturesContext",
+			MaxTokensPerBatch: 24,
+		})
+		check(t, err)
+
+		inputs := []string{"Onnx runtime is fast", "hugot runs onnx models from go"}
+		result, err := pipeline.RunContext(context.Background(), inputs)
+		check(t, err)
+		expected, err := pipeline.Run(inputs)
+		check(t, err)
+		assert.Equal(t, expected, result)
+
+		calls := pipeline.PipelineTimings.NumCalls
+		_, err = pipeline.RunContext(cancelled, inputs)
+		assert.ErrorIs(t, err, context.Canceled)
+		// the forward pass was not run
+		assert.Equal(t, calls, pipeline.PipelineTimings.NumCalls)
+	})
+
+	t.Run("Text pair classification", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "protectai/deberta-v3-base-zeroshot-v1-onnx", "./models")
+		pipeline, err := NewPipeline(session, TextClassificationConfig{
+			ModelPath:    modelPath,
+			Name:         "testPipelinePairsContext",
+			OnnxFilename: "model.onnx",
+		})
+		check(t, err)
+		_, err = pipeline.RunPairsContext(cancelled, [][2]string{{"The cat sleeps.", "An animal is resting."}})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Text generation", func(t *testing.T) {
+		modelPath := downloadModelIfNotExists(session, "Xenova/gpt2", "./models")
+		pipeline, err := NewPipeline(session, TextGenerationConfig{
+			ModelPath: modelPath,
+			Name:      "testPipelineTextGenerationContext",
+			Options: []TextGenerationOption{
+				pipelines.WithMaxTokens(10),
+			},
+		})
+		check(t, err)
+
+		expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancelExpired()
+		_, err = pipeline.RunContext(expired, []string{"Once upon a time"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// cancelling from the callback stops the generation before the next token
+		ctx, cancelStream := context.WithCancel(context.Background())
+		defer cancelStream()
+		_, tokens, err := pipeline.StreamContext(ctx, "Once upon a time", func(pipelines.GeneratedToken) error {
+			cancelStream()
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, len(tokens), 10)
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		_, err := session.DownloadModelContext(cancelled, "KnightsAnalytics/all-MiniLM-L6-v2", t.TempDir(), NewDownloadOptions())
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
 // README: test the readme examples
 
 func TestReadmeExample(t *testing.T) {
@@ -0,0 +1,145 @@
+package hugotpb
+
+import (
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+// NewEmbeddingsResponse converts the output of a feature extraction pipeline to its message.
+func NewEmbeddingsResponse(output *pipelines.FeatureExtractionOutput) *EmbeddingsResponse {
+	response := &EmbeddingsResponse{}
+	if output.Embeddings != nil {
+		response.Embeddings = make([]*Embedding, len(output.Embeddings))
+		for i, embedding := range output.Embeddings {
+			response.Embeddings[i] = &Embedding{Values: embedding}
+		}
+	}
+	if output.TokenEmbeddings != nil {
+		response.TokenEmbeddings = make([]*TokenEmbeddings, len(output.TokenEmbeddings))
+		for i, tokenEmbeddings := range output.TokenEmbeddings {
+			tokens := make([]*TokenEmbedding, len(tokenEmbeddings))
+			for j, token := range tokenEmbeddings {
+				tokens[j] = &TokenEmbedding{
+					Token:     token.Token,
+					TokenId:   token.TokenId,
+					Start:     uint64(token.Start),
+					End:       uint64(token.End),
+					Embedding: token.Embedding,
+				}
+			}
+			response.TokenEmbeddings[i] = &TokenEmbeddings{Tokens: tokens}
+		}
+	}
+	if output.TokenCounts != nil {
+		response.TokenCounts = make([]int64, len(output.TokenCounts))
+		for i, count := range output.TokenCounts {
+			response.TokenCounts[i] = int64(count)
+		}
+	}
+	return response
+}
+
+// Output converts the message back to the output of a feature extraction pipeline.
+func (x *EmbeddingsResponse) Output() *pipelines.FeatureExtractionOutput {
+	output := &pipelines.FeatureExtractionOutput{}
+	if x.GetEmbeddings() != nil {
+		output.Embeddings = make([][]float32, len(x.GetEmbeddings()))
+		for i, embedding := range x.GetEmbeddings() {
+			output.Embeddings[i] = embedding.GetValues()
+		}
+	}
+	if x.GetTokenEmbeddings() != nil {
+		output.TokenEmbeddings = make([][]pipelines.TokenEmbedding, len(x.GetTokenEmbeddings()))
+		for i, tokenEmbeddings := range x.GetTokenEmbeddings() {
+			tokens := make([]pipelines.TokenEmbedding, len(tokenEmbeddings.GetTokens()))
+			for j, token := range tokenEmbeddings.GetTokens() {
+				tokens[j] = pipelines.TokenEmbedding{
+					Token:     token.GetToken(),
+					TokenId:   token.GetTokenId(),
+					Start:     uint(token.GetStart()),
+					End:       uint(token.GetEnd()),
+					Embedding: token.GetEmbedding(),
+				}
+			}
+			output.TokenEmbeddings[i] = tokens
+		}
+	}
+	if x.GetTokenCounts() != nil {
+		output.TokenCounts = make([]int, len(x.GetTokenCounts()))
+		for i, count := range x.GetTokenCounts() {
+			output.TokenCounts[i] = int(count)
+		}
+	}
+	return output
+}
+
+// NewTextClassificationResponse converts the output of a text classification pipeline to its message.
+func NewTextClassificationResponse(output *pipelines.TextClassificationOutput) *TextClassificationResponse {
+	response := &TextClassificationResponse{ClassificationOutputs: make([]*ClassificationOutputs, len(output.ClassificationOutputs))}
+	for i, classificationOutputs := range output.ClassificationOutputs {
+		outputs := make([]*ClassificationOutput, len(classificationOutputs))
+		for j, classificationOutput := range classificationOutputs {
+			outputs[j] = &ClassificationOutput{Label: classificationOutput.Label, Score: classificationOutput.Score}
+		}
+		response.ClassificationOutputs[i] = &ClassificationOutputs{Outputs: outputs}
+	}
+	return response
+}
+
+// Output converts the message back to the output of a text classification pipeline.
+func (x *TextClassificationResponse) Output() *pipelines.TextClassificationOutput {
+	output := &pipelines.TextClassificationOutput{ClassificationOutputs: make([][]pipelines.ClassificationOutput, len(x.GetClassificationOutputs()))}
+	for i, classificationOutputs := range x.GetClassificationOutputs() {
+		outputs := make([]pipelines.ClassificationOutput, len(classificationOutputs.GetOutputs()))
+		for j, classificationOutput := range classificationOutputs.GetOutputs() {
+			outputs[j] = pipelines.ClassificationOutput{Label: classificationOutput.GetLabel(), Score: classificationOutput.GetScore()}
+		}
+		output.ClassificationOutputs[i] = outputs
+	}
+	return output
+}
+
+// NewTokenClassificationResponse converts the output of a token classification pipeline to its message.
+func NewTokenClassificationResponse(output *pipelines.TokenClassificationOutput) *TokenClassificationResponse {
+	response := &TokenClassificationResponse{Entities: make([]*Entities, len(output.Entities))}
+	for i, entities := range output.Entities {
+		messages := make([]*Entity, len(entities))
+		for j, entity := range entities {
+			messages[j] = &Entity{
+				Entity:    entity.Entity,
+				Score:     entity.Score,
+				Scores:    entity.Scores,
+				Index:     int64(entity.Index),
+				Word:      entity.Word,
+				TokenId:   entity.TokenId,
+				Start:     uint64(entity.Start),
+				End:       uint64(entity.End),
+				IsSubword: entity.IsSubword,
+			}
+		}
+		response.Entities[i] = &Entities{Entities: messages}
+	}
+	return response
+}
+
+// Output converts the message back to the output of a token classification pipeline.
+func (x *TokenClassificationResponse) Output() *pipelines.TokenClassificationOutput {
+	output := &pipelines.TokenClassificationOutput{Entities: make([][]pipelines.Entity, len(x.GetEntities()))}
+	for i, entities := range x.GetEntities() {
+		outputEntities := make([]pipelines.Entity, len(entities.GetEntities()))
+		for j, entity := range entities.GetEntities() {
+			outputEntities[j] = pipelines.Entity{
+				Entity:    entity.GetEntity(),
+				Score:     entity.GetScore(),
+				Scores:    entity.GetScores(),
+				Index:     int(entity.GetIndex()),
+				Word:      entity.GetWord(),
+				TokenId:   entity.GetTokenId(),
+				Start:     uint(entity.GetStart()),
+				End:       uint(entity.GetEnd()),
+				IsSubword: entity.GetIsSubword(),
+			}
+		}
+		output.Entities[i] = outputEntities
+	}
+	return output
+}
@@ -0,0 +1,196 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: hugot.proto
+
+// The gRPC interface of hugot, to run the pipelines of a hugot session from other services. The messages map
+// one-to-one onto the outputs of the pipelines package: FeatureExtractionOutput, TextClassificationOutput and
+// TokenClassificationOutput.
+//
+// The go code is generated with protoc-gen-go and protoc-gen-go-grpc, from this folder:
+//   protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative hugot.proto
+
+package hugotpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Inference_Embeddings_FullMethodName     = "/hugot.v1.Inference/Embeddings"
+	Inference_ClassifyText_FullMethodName   = "/hugot.v1.Inference/ClassifyText"
+	Inference_ClassifyTokens_FullMethodName = "/hugot.v1.Inference/ClassifyTokens"
+)
+
+// InferenceClient is the client API for Inference service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InferenceClient interface {
+	// Embeddings runs a feature extraction pipeline on the inputs.
+	Embeddings(ctx context.Context, in *EmbeddingsRequest, opts ...grpc.CallOption) (*EmbeddingsResponse, error)
+	// ClassifyText runs a text classification pipeline on the inputs or on the text pairs.
+	ClassifyText(ctx context.Context, in *TextClassificationRequest, opts ...grpc.CallOption) (*TextClassificationResponse, error)
+	// ClassifyTokens runs a token classification pipeline on the inputs.
+	ClassifyTokens(ctx context.Context, in *TokenClassificationRequest, opts ...grpc.CallOption) (*TokenClassificationResponse, error)
+}
+
+type inferenceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInferenceClient(cc grpc.ClientConnInterface) InferenceClient {
+	return &inferenceClient{cc}
+}
+
+func (c *inferenceClient) Embeddings(ctx context.Context, in *EmbeddingsRequest, opts ...grpc.CallOption) (*EmbeddingsResponse, error) {
+	out := new(EmbeddingsResponse)
+	err := c.cc.Invoke(ctx, Inference_Embeddings_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inferenceClient) ClassifyText(ctx context.Context, in *TextClassificationRequest, opts ...grpc.CallOption) (*TextClassificationResponse, error) {
+	out := new(TextClassificationResponse)
+	err := c.cc.Invoke(ctx, Inference_ClassifyText_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inferenceClient) ClassifyTokens(ctx context.Context, in *TokenClassificationRequest, opts ...grpc.CallOption) (*TokenClassificationResponse, error) {
+	out := new(TokenClassificationResponse)
+	err := c.cc.Invoke(ctx, Inference_ClassifyTokens_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InferenceServer is the server API for Inference service.
+// All implementations must embed UnimplementedInferenceServer
+// for forward compatibility
+type InferenceServer interface {
+	// Embeddings runs a feature extraction pipeline on the inputs.
+	Embeddings(context.Context, *EmbeddingsRequest) (*EmbeddingsResponse, error)
+	// ClassifyText runs a text classification pipeline on the inputs or on the text pairs.
+	ClassifyText(context.Context, *TextClassificationRequest) (*TextClassificationResponse, error)
+	// ClassifyTokens runs a token classification pipeline on the inputs.
+	ClassifyTokens(context.Context, *TokenClassificationRequest) (*TokenClassificationResponse, error)
+	mustEmbedUnimplementedInferenceServer()
+}
+
+// UnimplementedInferenceServer must be embedded to have forward compatible implementations.
+type UnimplementedInferenceServer struct {
+}
+
+func (UnimplementedInferenceServer) Embeddings(context.Context, *EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Embeddings not implemented")
+}
+func (UnimplementedInferenceServer) ClassifyText(context.Context, *TextClassificationRequest) (*TextClassificationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClassifyText not implemented")
+}
+func (UnimplementedInferenceServer) ClassifyTokens(context.Context, *TokenClassificationRequest) (*TokenClassificationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClassifyTokens not implemented")
+}
+func (UnimplementedInferenceServer) mustEmbedUnimplementedInferenceServer() {}
+
+// UnsafeInferenceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InferenceServer will
+// result in compilation errors.
+type UnsafeInferenceServer interface {
+	mustEmbedUnimplementedInferenceServer()
+}
+
+func RegisterInferenceServer(s grpc.ServiceRegistrar, srv InferenceServer) {
+	s.RegisterService(&Inference_ServiceDesc, srv)
+}
+
+func _Inference_Embeddings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmbeddingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InferenceServer).Embeddings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Inference_Embeddings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InferenceServer).Embeddings(ctx, req.(*EmbeddingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Inference_ClassifyText_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TextClassificationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InferenceServer).ClassifyText(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Inference_ClassifyText_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InferenceServer).ClassifyText(ctx, req.(*TextClassificationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Inference_ClassifyTokens_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenClassificationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InferenceServer).ClassifyTokens(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Inference_ClassifyTokens_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InferenceServer).ClassifyTokens(ctx, req.(*TokenClassificationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Inference_ServiceDesc is the grpc.ServiceDesc for Inference service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Inference_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hugot.v1.Inference",
+	HandlerType: (*InferenceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Embeddings",
+			Handler:    _Inference_Embeddings_Handler,
+		},
+		{
+			MethodName: "ClassifyText",
+			Handler:    _Inference_ClassifyText_Handler,
+		},
+		{
+			MethodName: "ClassifyTokens",
+			Handler:    _Inference_ClassifyTokens_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "hugot.proto",
+}
@@ -0,0 +1,94 @@
+package hugot
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+// MetricsCollector is a prometheus collector exporting the metrics of the pipelines of a session: latency
+// histograms of the tokenization, forward and postprocess stages, the distributions of the batch sizes and
+// sequence lengths of the forward passes, and counters of the inputs and errors. The metrics are labelled with the
+// name and type of the pipeline, and are read from the pipelines when prometheus scrapes them, so pipelines
+// created, closed or reloaded after the collector is registered are exported as well. A reloaded pipeline starts
+// again from zero, which prometheus handles as a counter reset.
+type MetricsCollector struct {
+	session        *Session
+	stageDuration  *prometheus.Desc
+	batchSize      *prometheus.Desc
+	sequenceLength *prometheus.Desc
+	inputs         *prometheus.Desc
+	errors         *prometheus.Desc
+}
+
+// metricsPipeline is a pipeline that keeps metrics, as the pipelines embedding pipelines.BasePipeline do.
+type metricsPipeline interface {
+	GetMetrics() *pipelines.PipelineMetrics
+}
+
+// NewMetricsCollector creates a prometheus collector of the metrics of the pipelines of the session, to be
+// registered with a prometheus registry. See also Session.RegisterMetrics.
+func NewMetricsCollector(session *Session) *MetricsCollector {
+	labels := []string{"pipeline", "type"}
+	stageLabels := []string{"pipeline", "type", "stage"}
+	return &MetricsCollector{
+		session: session,
+		stageDuration: prometheus.NewDesc("hugot_pipeline_stage_duration_seconds",
+			"Duration of the tokenization, forward and postprocess stages of the pipeline runs.", stageLabels, nil),
+		batchSize: prometheus.NewDesc("hugot_pipeline_batch_size",
+			"Number of inputs of the forward passes of the pipeline.", labels, nil),
+		sequenceLength: prometheus.NewDesc("hugot_pipeline_sequence_length",
+			"Padded sequence length in tokens of the forward passes of the pipeline.", labels, nil),
+		inputs: prometheus.NewDesc("hugot_pipeline_inputs_total",
+			"Number of inputs tokenized by the pipeline.", labels, nil),
+		errors: prometheus.NewDesc("hugot_pipeline_errors_total",
+			"Number of errors of the stages of the pipeline runs.", stageLabels, nil),
+	}
+}
+
+// RegisterMetrics registers a MetricsCollector of the session with the prometheus registerer, e.g.
+// prometheus.DefaultRegisterer.
+func (s *Session) RegisterMetrics(registerer prometheus.Registerer) error {
+	return registerer.Register(NewMetricsCollector(s))
+}
+
+// Describe implements prometheus.Collector.
+func (c *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.stageDuration
+	ch <- c.batchSize
+	ch <- c.sequenceLength
+	ch <- c.inputs
+	ch <- c.errors
+}
+
+// Collect implements prometheus.Collector.
+func (c *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, info := range c.session.ListPipelines() {
+		pipeline, err := c.session.getPipeline(info.Name)
+		if err != nil {
+			// closed since it was listed
+			continue
+		}
+		withMetrics, ok := pipeline.(metricsPipeline)
+		if !ok || withMetrics.GetMetrics() == nil {
+			continue
+		}
+		metrics := withMetrics.GetMetrics()
+		for _, stage := range pipelines.Stages {
+			ch <- constHistogram(c.stageDuration, metrics.Durations[stage], info.Name, info.Type, string(stage))
+			ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(metrics.Errors(stage)), info.Name, info.Type, string(stage))
+		}
+		ch <- constHistogram(c.batchSize, metrics.BatchSize, info.Name, info.Type)
+		ch <- constHistogram(c.sequenceLength, metrics.SequenceLength, info.Name, info.Type)
+		ch <- prometheus.MustNewConstMetric(c.inputs, prometheus.CounterValue, float64(metrics.Inputs()), info.Name, info.Type)
+	}
+}
+
+func constHistogram(desc *prometheus.Desc, histogram *pipelines.Histogram, labelValues ...string) prometheus.Metric {
+	snapshot := histogram.Snapshot()
+	buckets := make(map[float64]uint64, len(snapshot.Bounds))
+	for i, bound := range snapshot.Bounds {
+		buckets[bound] = snapshot.Counts[i]
+	}
+	return prometheus.MustNewConstHistogram(desc, snapshot.Count, snapshot.Sum, buckets, labelValues...)
+}
@@ -0,0 +1,228 @@
+// Package openai serves hugot pipelines with the OpenAI API, so that clients of that API can use them as a
+// drop-in replacement.
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+// maximum size of the body of a request
+const maxRequestBytes = 32 << 20
+
+// PipelineLookup returns the feature extraction pipeline serving the model named in a request.
+type PipelineLookup func(model string) (*pipelines.FeatureExtractionPipeline, error)
+
+// EmbeddingsHandler serves the OpenAI embeddings API (POST /v1/embeddings) with feature extraction pipelines.
+type EmbeddingsHandler struct {
+	lookup PipelineLookup
+	// BatchSize is the maximum number of inputs run in one call of the pipeline. If zero, all the inputs of a
+	// request are run in a single batch.
+	BatchSize int
+}
+
+// EmbeddingsInput is the input of an embeddings request, which is either a string or an array of strings.
+type EmbeddingsInput []string
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (i *EmbeddingsInput) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*i = EmbeddingsInput{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return errors.New("input must be a string or an array of strings")
+	}
+	*i = multiple
+	return nil
+}
+
+// EmbeddingsRequest is the body of an embeddings request.
+type EmbeddingsRequest struct {
+	Input          EmbeddingsInput `json:"input"`
+	Model          string          `json:"model"`
+	EncodingFormat string          `json:"encoding_format,omitempty"` // float (default) or base64
+	Dimensions     int             `json:"dimensions,omitempty"`      // if set, the embeddings are truncated to this size
+	User           string          `json:"user,omitempty"`
+}
+
+// Embedding is the embedding of one input. The embedding is a []float32, or a string with the base64 encoding
+// of the little-endian float32 values with the base64 encoding format.
+type Embedding struct {
+	Object    string `json:"object"`
+	Index     int    `json:"index"`
+	Embedding any    `json:"embedding"`
+}
+
+// Usage reports the number of tokens of the inputs.
+type Usage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// EmbeddingsResponse is the body of the response to an embeddings request.
+type EmbeddingsResponse struct {
+	Object string      `json:"object"`
+	Data   []Embedding `json:"data"`
+	Model  string      `json:"model"`
+	Usage  Usage       `json:"usage"`
+}
+
+// Error is an error of the API, with the http status of the response.
+type Error struct {
+	Status  int    `json:"-"`
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Param   any    `json:"param"`
+	Code    any    `json:"code"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type errorResponse struct {
+	Error *Error `json:"error"`
+}
+
+// NewEmbeddingsHandler creates an embeddings handler serving the pipelines returned by lookup.
+func NewEmbeddingsHandler(lookup PipelineLookup) *EmbeddingsHandler {
+	return &EmbeddingsHandler{lookup: lookup}
+}
+
+func (h *EmbeddingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, &Error{Status: http.StatusMethodNotAllowed, Message: fmt.Sprintf("method %s not allowed", r.Method), Type: "invalid_request_error"})
+		return
+	}
+	var request EmbeddingsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&request); err != nil {
+		writeError(w, invalidRequest(fmt.Sprintf("invalid request body: %s", err), nil))
+		return
+	}
+	response, err := h.Embeddings(r.Context(), request)
+	if err != nil {
+		var apiError *Error
+		if !errors.As(err, &apiError) {
+			apiError = &Error{Status: http.StatusInternalServerError, Message: err.Error(), Type: "server_error"}
+		}
+		writeError(w, apiError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Embeddings computes the response to an embeddings request. Invalid requests return an *Error.
+func (h *EmbeddingsHandler) Embeddings(ctx context.Context, request EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	if len(request.Input) == 0 {
+		return nil, invalidRequest("input must not be empty", "input")
+	}
+	for _, input := range request.Input {
+		if input == "" {
+			return nil, invalidRequest("input must not contain empty strings", "input")
+		}
+	}
+	if request.EncodingFormat != "" && request.EncodingFormat != "float" && request.EncodingFormat != "base64" {
+		return nil, invalidRequest(fmt.Sprintf("encoding_format %s is not supported, use float or base64", request.EncodingFormat), "encoding_format")
+	}
+	if request.Dimensions < 0 {
+		return nil, invalidRequest("dimensions must be positive", "dimensions")
+	}
+	pipeline, err := h.lookup(request.Model)
+	if err != nil {
+		return nil, &Error{Status: http.StatusNotFound, Message: err.Error(), Type: "invalid_request_error", Param: "model", Code: "model_not_found"}
+	}
+
+	batchSize := h.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(request.Input)
+	}
+	response := EmbeddingsResponse{
+		Object: "list",
+		Data:   make([]Embedding, 0, len(request.Input)),
+		Model:  request.Model,
+	}
+	for start := 0; start < len(request.Input); start += batchSize {
+		end := start + batchSize
+		if end > len(request.Input) {
+			end = len(request.Input)
+		}
+		output, err := pipeline.RunPipelineContext(ctx, request.Input[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if output.Embeddings == nil {
+			return nil, invalidRequest(fmt.Sprintf("model %s returns token embeddings rather than one embedding per input", request.Model), "model")
+		}
+		for i, embedding := range output.Embeddings {
+			if request.Dimensions > 0 {
+				if request.Dimensions > len(embedding) {
+					return nil, invalidRequest(fmt.Sprintf("dimensions must be at most %d for model %s", len(embedding), request.Model), "dimensions")
+				}
+				embedding = truncate(embedding, request.Dimensions)
+			}
+			var value any = embedding
+			if request.EncodingFormat == "base64" {
+				value = encodeBase64(embedding)
+			}
+			response.Data = append(response.Data, Embedding{Object: "embedding", Index: start + i, Embedding: value})
+			response.Usage.PromptTokens += output.TokenCounts[i]
+		}
+	}
+	response.Usage.TotalTokens = response.Usage.PromptTokens
+	return &response, nil
+}
+
+// truncate keeps the first dimensions of the embedding and normalizes it again, as done by the OpenAI API for
+// models trained with Matryoshka representation learning.
+func truncate(embedding []float32, dimensions int) []float32 {
+	truncated := make([]float32, dimensions)
+	copy(truncated, embedding)
+	var norm float64
+	for _, value := range truncated {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return truncated
+	}
+	norm = math.Sqrt(norm)
+	for i := range truncated {
+		truncated[i] = float32(float64(truncated[i]) / norm)
+	}
+	return truncated
+}
+
+// encodeBase64 encodes the embedding as the base64 encoding of its little-endian float32 values.
+func encodeBase64(embedding []float32) string {
+	var buffer bytes.Buffer
+	buffer.Grow(4 * len(embedding))
+	// writing to a bytes.Buffer does not fail
+	_ = binary.Write(&buffer, binary.LittleEndian, embedding)
+	return base64.StdEncoding.EncodeToString(buffer.Bytes())
+}
+
+func invalidRequest(message string, param any) *Error {
+	return &Error{Status: http.StatusBadRequest, Message: message, Type: "invalid_request_error", Param: param}
+}
+
+func writeError(w http.ResponseWriter, apiError *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiError.Status)
+	if err := json.NewEncoder(w).Encode(errorResponse{Error: apiError}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
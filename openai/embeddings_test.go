@@ -0,0 +1,102 @@
+package openai
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/knights-analytics/hugot"
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+func TestEmbeddingsInput(t *testing.T) {
+	var request EmbeddingsRequest
+	check(t, json.Unmarshal([]byte(`{"input": "one", "model": "m"}`), &request))
+	assert.Equal(t, EmbeddingsInput{"one"}, request.Input)
+	check(t, json.Unmarshal([]byte(`{"input": ["one", "two"], "model": "m"}`), &request))
+	assert.Equal(t, EmbeddingsInput{"one", "two"}, request.Input)
+	assert.Error(t, json.Unmarshal([]byte(`{"input": [1, 2], "model": "m"}`), &request))
+}
+
+func TestTruncateAndEncode(t *testing.T) {
+	truncated := truncate([]float32{3, 4, 12}, 2)
+	assert.InDeltaSlice(t, []float32{0.6, 0.8}, truncated, 1e-6)
+
+	decoded, err := base64.StdEncoding.DecodeString(encodeBase64([]float32{1.5, -2}))
+	check(t, err)
+	assert.Equal(t, float32(1.5), math.Float32frombits(binary.LittleEndian.Uint32(decoded[0:4])))
+	assert.Equal(t, float32(-2), math.Float32frombits(binary.LittleEndian.Uint32(decoded[4:8])))
+}
+
+func TestEmbeddingsHandler(t *testing.T) {
+	session, err := hugot.NewSession(hugot.WithOnnxLibraryPath("/usr/lib64/onnxruntime.so"))
+	check(t, err)
+	defer func(session *hugot.Session) {
+		check(t, session.Destroy())
+	}(session)
+	_, err = hugot.NewPipeline(session, hugot.FeatureExtractionConfig{
+		ModelPath: path.Join("../models", "KnightsAnalytics_all-MiniLM-L6-v2"),
+		Name:      "all-MiniLM-L6-v2",
+	})
+	check(t, err)
+
+	handler := NewEmbeddingsHandler(func(model string) (*pipelines.FeatureExtractionPipeline, error) {
+		return hugot.GetPipeline[*pipelines.FeatureExtractionPipeline](session, model)
+	})
+	handler.BatchSize = 1
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	post := func(body string) (int, EmbeddingsResponse) {
+		response, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+		check(t, err)
+		defer func() {
+			check(t, response.Body.Close())
+		}()
+		var decoded EmbeddingsResponse
+		if response.StatusCode == http.StatusOK {
+			check(t, json.NewDecoder(response.Body).Decode(&decoded))
+		}
+		return response.StatusCode, decoded
+	}
+
+	status, response := post(`{"input": ["The quick brown fox", "jumps over the lazy dog"], "model": "all-MiniLM-L6-v2"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "list", response.Object)
+	assert.Len(t, response.Data, 2)
+	assert.Equal(t, 1, response.Data[1].Index)
+	assert.Len(t, response.Data[0].Embedding, 384)
+	// [CLS] the quick brown fox [SEP] and [CLS] jumps over the lazy dog [SEP]
+	assert.Equal(t, 13, response.Usage.PromptTokens)
+	assert.Equal(t, response.Usage.PromptTokens, response.Usage.TotalTokens)
+
+	status, response = post(`{"input": "The quick brown fox", "model": "all-MiniLM-L6-v2", "encoding_format": "base64", "dimensions": 64}`)
+	assert.Equal(t, http.StatusOK, status)
+	decoded, err := base64.StdEncoding.DecodeString(response.Data[0].Embedding.(string))
+	check(t, err)
+	assert.Len(t, decoded, 64*4)
+
+	status, _ = post(`{"input": "The quick brown fox", "model": "unknown"}`)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = post(`{"input": [], "model": "all-MiniLM-L6-v2"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = post(`{"input": "The quick brown fox", "model": "all-MiniLM-L6-v2", "dimensions": 1000}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = post(`{"input": "The quick brown fox", "model": "all-MiniLM-L6-v2", "encoding_format": "int8"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+}
@@ -1,5 +1,9 @@
 package hugot
 
+import (
+	"go.opentelemetry.io/otel/trace"
+)
+
 type ortOptions struct {
 	libraryPath        string
 	telemetry          bool
@@ -19,6 +23,7 @@ type ortOptions struct {
 	openVINOOptionsSet bool
 	tensorRTOptions    map[string]string
 	tensorRTOptionsSet bool
+	tracerProvider     trace.TracerProvider
 }
 
 // WithOption is the interface for all option functions
@@ -133,3 +138,12 @@ func WithTensorRT(options map[string]string) WithOption {
 		o.tensorRTOptionsSet = true
 	}
 }
+
+// WithTracerProvider sets the OpenTelemetry tracer provider of the session. The Preprocess, Forward and Postprocess
+// stages of the runs of its pipelines, and DownloadModel, are then traced with spans, children of the span of the
+// context of the run (see the RunContext methods). By default the spans are not recorded.
+func WithTracerProvider(provider trace.TracerProvider) WithOption {
+	return func(o *ortOptions) {
+		o.tracerProvider = provider
+	}
+}
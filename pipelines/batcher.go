@@ -0,0 +1,212 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// In a server, requests typically arrive one input at a time, while the throughput of onnxruntime depends on
+// running inputs in batches. The Batcher collects the inputs submitted by concurrent callers into batches, runs each
+// batch with a single call of the pipeline, and returns to each caller its own output, taken from the batch output
+// by the index of its input with GetOutput.
+
+// ErrBatcherQueueFull is returned by Batcher.Submit when the queue of inputs waiting to be batched is full. Servers
+// typically map it to a "too many requests" response so that clients back off.
+var ErrBatcherQueueFull = errors.New("the batcher queue is full")
+
+// ErrBatcherClosed is returned by Batcher.Submit once the batcher has been closed.
+var ErrBatcherClosed = errors.New("the batcher is closed")
+
+// Batcher runs the inputs submitted concurrently to a pipeline in batches.
+type Batcher struct {
+	pipeline     Pipeline
+	maxBatchSize int
+	maxWait      time.Duration
+	maxQueueSize int
+	queue        chan *batcherRequest
+	mutex        sync.RWMutex
+	closed       bool
+	done         chan struct{}
+}
+
+type batcherRequest struct {
+	ctx    context.Context
+	input  string
+	result chan batcherResult
+}
+
+type batcherResult struct {
+	output any
+	err    error
+}
+
+// BatcherOption is an option of NewBatcher.
+type BatcherOption func(b *Batcher)
+
+// WithMaxBatchSize sets the maximum number of inputs run in one batch. Defaults to 32.
+func WithMaxBatchSize(size int) BatcherOption {
+	return func(b *Batcher) {
+		b.maxBatchSize = size
+	}
+}
+
+// WithMaxWait sets how long the first input of a batch waits for other inputs before the batch is run, if the
+// batch is not full. Defaults to 5ms.
+func WithMaxWait(wait time.Duration) BatcherOption {
+	return func(b *Batcher) {
+		b.maxWait = wait
+	}
+}
+
+// WithMaxQueueSize sets the maximum number of inputs waiting to be batched. Submit returns ErrBatcherQueueFull
+// rather than waiting when the queue is full. Defaults to 1024.
+func WithMaxQueueSize(size int) BatcherOption {
+	return func(b *Batcher) {
+		b.maxQueueSize = size
+	}
+}
+
+// NewBatcher creates a batcher running the inputs submitted to it in batches with the pipeline. The batcher must
+// be closed before the pipeline is destroyed.
+func NewBatcher(pipeline Pipeline, options ...BatcherOption) (*Batcher, error) {
+	b := &Batcher{
+		pipeline:     pipeline,
+		maxBatchSize: 32,
+		maxWait:      5 * time.Millisecond,
+		maxQueueSize: 1024,
+		done:         make(chan struct{}),
+	}
+	for _, option := range options {
+		option(b)
+	}
+
+	var err error
+	if pipeline == nil {
+		err = errors.Join(err, errors.New("the pipeline of the batcher is nil"))
+	}
+	if b.maxBatchSize <= 0 {
+		err = errors.Join(err, fmt.Errorf("max batch size must be positive, got %d", b.maxBatchSize))
+	}
+	if b.maxWait < 0 {
+		err = errors.Join(err, fmt.Errorf("max wait must not be negative, got %s", b.maxWait))
+	}
+	if b.maxQueueSize <= 0 {
+		err = errors.Join(err, fmt.Errorf("max queue size must be positive, got %d", b.maxQueueSize))
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.queue = make(chan *batcherRequest, b.maxQueueSize)
+
+	go b.loop()
+	return b, nil
+}
+
+// Submit queues the input to be run in the next batch and waits for its output, which is the element of the
+// GetOutput of the batch output for this input, e.g. a []ClassificationOutput for a text classification pipeline.
+// It returns ErrBatcherQueueFull without waiting if the queue is full, and ctx.Err() if the context is done before
+// the output is ready.
+func (b *Batcher) Submit(ctx context.Context, input string) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	request := &batcherRequest{ctx: ctx, input: input, result: make(chan batcherResult, 1)}
+
+	b.mutex.RLock()
+	if b.closed {
+		b.mutex.RUnlock()
+		return nil, ErrBatcherClosed
+	}
+	select {
+	case b.queue <- request:
+		b.mutex.RUnlock()
+	default:
+		b.mutex.RUnlock()
+		return nil, ErrBatcherQueueFull
+	}
+
+	select {
+	case result := <-request.result:
+		return result.output, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// QueueLength returns the number of inputs waiting to be batched.
+func (b *Batcher) QueueLength() int {
+	return len(b.queue)
+}
+
+// Close stops accepting inputs, runs the inputs already queued, and waits for their batches to finish.
+func (b *Batcher) Close() error {
+	b.mutex.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mutex.Unlock()
+	<-b.done
+	return nil
+}
+
+// loop collects the queued inputs into batches and runs them, until the queue is closed and empty.
+func (b *Batcher) loop() {
+	defer close(b.done)
+	for first := range b.queue {
+		batch := []*batcherRequest{first}
+		timer := time.NewTimer(b.maxWait)
+	collect:
+		for len(batch) < b.maxBatchSize {
+			select {
+			case request, ok := <-b.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, request)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		b.run(batch)
+	}
+}
+
+// run runs the batch with the pipeline and sends each caller the output of its input. The inputs of callers that
+// have given up are left out of the batch.
+func (b *Batcher) run(batch []*batcherRequest) {
+	requests := make([]*batcherRequest, 0, len(batch))
+	inputs := make([]string, 0, len(batch))
+	for _, request := range batch {
+		if err := request.ctx.Err(); err != nil {
+			request.result <- batcherResult{err: err}
+			continue
+		}
+		requests = append(requests, request)
+		inputs = append(inputs, request.input)
+	}
+	if len(inputs) == 0 {
+		return
+	}
+
+	// the batch is shared by several callers, so it is not cancelled with the context of any one of them
+	output, err := b.pipeline.Run(inputs)
+	var outputs []any
+	if err == nil {
+		outputs = output.GetOutput()
+		if len(outputs) != len(inputs) {
+			err = fmt.Errorf("the pipeline returned %d outputs for a batch of %d inputs", len(outputs), len(inputs))
+		}
+	}
+	for i, request := range requests {
+		if err != nil {
+			request.result <- batcherResult{err: err}
+			continue
+		}
+		request.result <- batcherResult{output: outputs[i]}
+	}
+}
@@ -0,0 +1,135 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Inputs of very different lengths waste most of the compute of a batch on padding, as every input is padded to the
+// longest one. With a token budget, the inputs are sorted by length and split into sub-batches whose padded size stays
+// under the budget. The outputs of the sub-batches are then copied back into the layout of the full batch, so that
+// the results are in the original order and postprocessing is unchanged.
+
+// lengthBuckets groups the rows of the batch by increasing length, so that each group padded to its longest row has
+// at most maxTokens tokens. A row longer than the budget is placed in a group of its own.
+func lengthBuckets(inputs []TokenizedInput, maxTokens int) [][]int {
+	rows := make([]int, len(inputs))
+	for i := range rows {
+		rows[i] = i
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return inputs[rows[i]].MaxAttentionIndex < inputs[rows[j]].MaxAttentionIndex
+	})
+
+	var buckets [][]int
+	var bucket []int
+	for _, row := range rows {
+		// rows are sorted, so the new row is the longest of the bucket
+		length := inputs[row].MaxAttentionIndex + 1
+		if len(bucket) > 0 && (len(bucket)+1)*length > maxTokens {
+			buckets = append(buckets, bucket)
+			bucket = nil
+		}
+		bucket = append(bucket, row)
+	}
+	if len(bucket) > 0 {
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+// forwardBuckets runs the forward pass on length bucketed sub-batches of the batch and reassembles their outputs.
+// The context is checked before each sub-batch.
+func (p *BasePipeline) forwardBuckets(ctx context.Context, batch PipelineBatch) (PipelineBatch, error) {
+	batchSize := len(batch.Input)
+	for _, bucket := range lengthBuckets(batch.Input, p.MaxTokensPerBatch) {
+		if err := ctx.Err(); err != nil {
+			return batch, err
+		}
+		inputs := make([]TokenizedInput, len(bucket))
+		maxSequence := 0
+		for i, row := range bucket {
+			inputs[i] = batch.Input[row]
+			if inputs[i].MaxAttentionIndex > maxSequence {
+				maxSequence = inputs[i].MaxAttentionIndex
+			}
+		}
+		subBatch, err := p.forward(p.convertInputToTensors(inputs, maxSequence+1))
+		if err != nil {
+			return batch, err
+		}
+
+		if batch.OutputTensors == nil {
+			batch.OutputTensors = make([][]float32, len(subBatch.OutputTensors))
+			for i, output := range subBatch.OutputTensors {
+				if rowSize, ok := p.fullRowSize(i, output, len(bucket), subBatch.MaxSequence, batch.MaxSequence); ok {
+					batch.OutputTensors[i] = make([]float32, rowSize*batchSize)
+				}
+			}
+		}
+		for i, output := range subBatch.OutputTensors {
+			if batch.OutputTensors[i] == nil {
+				continue
+			}
+			rowSize, ok := p.fullRowSize(i, output, len(bucket), subBatch.MaxSequence, batch.MaxSequence)
+			if !ok || rowSize*batchSize != len(batch.OutputTensors[i]) {
+				// the rows of this sub-batch do not have the layout of the previous ones
+				batch.OutputTensors[i] = nil
+				continue
+			}
+			scatterRows(batch.OutputTensors[i], output, bucket, rowSize)
+		}
+	}
+	batch.OutputTensor = batch.OutputTensors[p.outputIndex]
+	if batch.OutputTensor == nil {
+		return batch, fmt.Errorf("output %s can not be reassembled from the sub-batches of the token budget, as it does not have a row of fixed or sequence length per input",
+			p.OutputsMeta[p.outputIndex].Name)
+	}
+	return batch, nil
+}
+
+// fullRowSize returns the size of a row of the output in the full batch, given the output of a sub-batch. Whether
+// the second dimension of the output is the sequence length of the batch, which differs between the sub-batches and
+// the full batch, is decided from the shape of the output, as a dynamic dimension is not necessarily the sequence,
+// e.g. (batch, sentence_embedding_dim). It returns false if the output does not have a row layout.
+func (p *BasePipeline) fullRowSize(outputIndex int, output []float32, rows int, subSequence int, fullSequence int) (int, bool) {
+	if output == nil || len(output)%rows != 0 || !p.hasRowLayout(outputIndex) {
+		return 0, false
+	}
+	rowSize := len(output) / rows
+	dimensions := p.OutputsMeta[outputIndex].Dimensions
+	if len(dimensions) < 2 || dimensions[1] > 0 {
+		return rowSize, true
+	}
+	tokenSize := 1
+	for _, dimension := range dimensions[2:] {
+		tokenSize *= int(dimension)
+	}
+	if rowSize == subSequence*tokenSize {
+		return fullSequence * tokenSize, true
+	}
+	return rowSize, true
+}
+
+// hasRowLayout reports whether the rows of the output of sub-batches can be reassembled, i.e. only its first two
+// dimensions can be dynamic. Other outputs, such as attentions of shape (batch, heads, sequence, sequence), are left
+// out of the output of the full batch.
+func (p *BasePipeline) hasRowLayout(outputIndex int) bool {
+	for j, dimension := range p.OutputsMeta[outputIndex].Dimensions {
+		if j > 1 && dimension <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// scatterRows copies the rows of the output of a sub-batch to their rows in the output of the full batch. With a
+// sequence dimension, the full batch rows are longer: the tokens of a sub-batch row fill the start of the row, and the
+// remaining padding positions stay at zero.
+func scatterRows(full []float32, sub []float32, rows []int, fullRowSize int) {
+	subRowSize := len(sub) / len(rows)
+	for i, row := range rows {
+		copy(full[row*fullRowSize:row*fullRowSize+subRowSize], sub[i*subRowSize:(i+1)*subRowSize])
+	}
+}
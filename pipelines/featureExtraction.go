@@ -1,12 +1,15 @@
 package pipelines
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	jsoniter "github.com/json-iterator/go"
 	ort "github.com/yalue/onnxruntime_go"
 
 	util "github.com/knights-analytics/hugot/utils"
-	"github.com/knights-analytics/tokenizers"
 )
 
 // FeatureExtractionPipeline A feature extraction pipeline is a go version of
@@ -16,18 +19,48 @@ import (
 
 type FeatureExtractionPipeline struct {
 	BasePipeline
-	Normalization bool
+	Normalization   bool
+	PoolingStrategy string
+	PoolingLastN    int
+	pooledOutput    bool // the selected output of the model is already pooled, e.g. sentence_embedding
 }
 
 type FeatureExtractionPipelineConfig struct {
 	IdLabelMap map[int]string `json:"id2label"`
 }
 
+// PoolingConfig is the pooling configuration of sentence-transformers models, stored in 1_Pooling/config.json.
+type PoolingConfig struct {
+	ClsToken           bool `json:"pooling_mode_cls_token"`
+	MeanTokens         bool `json:"pooling_mode_mean_tokens"`
+	MaxTokens          bool `json:"pooling_mode_max_tokens"`
+	WeightedMeanTokens bool `json:"pooling_mode_weightedmean_tokens"`
+	LastToken          bool `json:"pooling_mode_lasttoken"`
+}
+
+// TokenEmbedding is the embedding of a single token, returned when pooling is disabled.
+type TokenEmbedding struct {
+	Token     string
+	TokenId   uint32
+	Start     uint
+	End       uint
+	Embedding []float32
+}
+
 type FeatureExtractionOutput struct {
-	Embeddings [][]float32
+	Embeddings      [][]float32
+	TokenEmbeddings [][]TokenEmbedding // only set when pooling is disabled
+	TokenCounts     []int              // number of tokens of each input run through the model, special tokens included
 }
 
 func (t *FeatureExtractionOutput) GetOutput() []any {
+	if t.TokenEmbeddings != nil {
+		out := make([]any, len(t.TokenEmbeddings))
+		for i, tokenEmbeddings := range t.TokenEmbeddings {
+			out[i] = any(tokenEmbeddings)
+		}
+		return out
+	}
 	out := make([]any, len(t.Embeddings))
 	for i, embedding := range t.Embeddings {
 		out[i] = any(embedding)
@@ -43,6 +76,53 @@ func WithNormalization() PipelineOption[*FeatureExtractionPipeline] {
 	}
 }
 
+// WithMeanPooling averages the embeddings of the tokens of the input. This is the default if the model does not
+// have a sentence-transformers pooling configuration.
+func WithMeanPooling() PipelineOption[*FeatureExtractionPipeline] {
+	return func(pipeline *FeatureExtractionPipeline) {
+		pipeline.PoolingStrategy = "MEAN"
+	}
+}
+
+// WithCLSPooling uses the embedding of the first (CLS) token, as expected by BGE and E5 style models.
+func WithCLSPooling() PipelineOption[*FeatureExtractionPipeline] {
+	return func(pipeline *FeatureExtractionPipeline) {
+		pipeline.PoolingStrategy = "CLS"
+	}
+}
+
+// WithMaxPooling takes the maximum of each dimension over the tokens of the input.
+func WithMaxPooling() PipelineOption[*FeatureExtractionPipeline] {
+	return func(pipeline *FeatureExtractionPipeline) {
+		pipeline.PoolingStrategy = "MAX"
+	}
+}
+
+// WithLastNPooling averages the embeddings of the last n tokens of the input. With n = 1 this is the last token
+// pooling of decoder based embedding models.
+func WithLastNPooling(n int) PipelineOption[*FeatureExtractionPipeline] {
+	return func(pipeline *FeatureExtractionPipeline) {
+		pipeline.PoolingStrategy = "LAST_N"
+		pipeline.PoolingLastN = n
+	}
+}
+
+// WithWeightedMeanPooling averages the embeddings of the tokens weighted by their position, so that later tokens
+// weigh more, as in the weightedmean pooling of sentence-transformers.
+func WithWeightedMeanPooling() PipelineOption[*FeatureExtractionPipeline] {
+	return func(pipeline *FeatureExtractionPipeline) {
+		pipeline.PoolingStrategy = "WEIGHTED_MEAN"
+	}
+}
+
+// WithoutPooling returns the embedding of each token of the input, together with the token and its offsets,
+// in the TokenEmbeddings field of the output.
+func WithoutPooling() PipelineOption[*FeatureExtractionPipeline] {
+	return func(pipeline *FeatureExtractionPipeline) {
+		pipeline.PoolingStrategy = "NONE"
+	}
+}
+
 // NewFeatureExtractionPipeline Initialize a feature extraction pipeline
 func NewFeatureExtractionPipeline(config PipelineConfig[*FeatureExtractionPipeline], ortOptions *ort.SessionOptions) (*FeatureExtractionPipeline, error) {
 	pipeline := &FeatureExtractionPipeline{}
@@ -50,16 +130,31 @@ func NewFeatureExtractionPipeline(config PipelineConfig[*FeatureExtractionPipeli
 	pipeline.PipelineName = config.Name
 	pipeline.OrtOptions = ortOptions
 	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.OutputName = config.OutputName
+	pipeline.MaxLength = config.MaxLength
+	pipeline.Truncation = config.Truncation
+	pipeline.Stride = config.Stride
+	pipeline.MaxTokensPerBatch = config.MaxTokensPerBatch
+	pipeline.GoTokenizer = config.GoTokenizer
 
 	for _, o := range config.Options {
 		o(pipeline)
 	}
 
-	// tokenizer
-	pipeline.TokenizerOptions = []tokenizers.EncodeOption{tokenizers.WithReturnTypeIDs(), tokenizers.WithReturnAttentionMask()}
+	// defaults
+	explicitPooling := pipeline.PoolingStrategy != ""
+	if !explicitPooling {
+		strategy, lastN, err := loadPoolingStrategy(pipeline.ModelPath)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.PoolingStrategy = strategy
+		pipeline.PoolingLastN = lastN
+	}
 
 	pipeline.PipelineTimings = &Timings{}
 	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
 
 	// load onnx model
 	err := pipeline.loadModel()
@@ -67,8 +162,21 @@ func NewFeatureExtractionPipeline(config PipelineConfig[*FeatureExtractionPipeli
 		return nil, err
 	}
 
-	// the dimension of the output is taken from the output meta. For the moment we assume that there is only one output
-	pipeline.OutputDim = int(pipeline.OutputsMeta[0].Dimensions[2])
+	// sentence-transformers exports can have a sentence_embedding output that is already pooled, which is used unless
+	// another output or pooling strategy was requested
+	if pipeline.OutputName == "" && !explicitPooling {
+		for i, output := range pipeline.OutputsMeta {
+			if output.Name == "sentence_embedding" {
+				pipeline.outputIndex = i
+			}
+		}
+	}
+
+	// the dimension of the output is taken from the output meta: token embeddings have shape
+	// (batch, sequence, dimension) and pooled embeddings (batch, dimension)
+	outputDimensions := pipeline.outputMeta().Dimensions
+	pipeline.pooledOutput = len(outputDimensions) == 2
+	pipeline.OutputDim = int(outputDimensions[len(outputDimensions)-1])
 
 	err = pipeline.Validate()
 	if err != nil {
@@ -84,16 +192,93 @@ func (p *FeatureExtractionPipeline) Validate() error {
 	if p.OutputDim <= 0 {
 		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: outputDim parameter must be greater than zero"))
 	}
+	if p.pooledOutput && p.PoolingStrategy == "NONE" {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: output %s is already pooled, token embeddings cannot be returned", p.outputMeta().Name))
+	}
+	switch p.PoolingStrategy {
+	case "MEAN", "CLS", "MAX", "WEIGHTED_MEAN", "NONE":
+	case "LAST_N":
+		if p.PoolingLastN <= 0 {
+			validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: number of pooled last tokens must be greater than zero"))
+		}
+	default:
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: pooling strategy %s is not supported", p.PoolingStrategy))
+	}
+	validationErrors = append(validationErrors, p.validateTruncation(true)...)
 	return errors.Join(validationErrors...)
 }
 
-// Postprocess Parse the results of the forward pass into the output. Token embeddings are mean pooled.
-func (p *FeatureExtractionPipeline) Postprocess(batch PipelineBatch) (*FeatureExtractionOutput, error) {
+// loadPoolingStrategy reads the pooling strategy from the 1_Pooling/config.json file of sentence-transformers models.
+// Models without the file are mean pooled.
+func loadPoolingStrategy(modelPath string) (string, int, error) {
+	configPath := util.PathJoinSafe(modelPath, "1_Pooling", "config.json")
+	exists, err := util.FileSystem.Exists(context.Background(), configPath)
+	if err != nil || !exists {
+		return "MEAN", 0, err
+	}
+	configBytes, err := util.ReadFileBytes(configPath)
+	if err != nil {
+		return "", 0, err
+	}
+	config := PoolingConfig{}
+	if err = jsoniter.Unmarshal(configBytes, &config); err != nil {
+		return "", 0, err
+	}
+
+	var strategies []string
+	lastN := 0
+	if config.ClsToken {
+		strategies = append(strategies, "CLS")
+	}
+	if config.MeanTokens {
+		strategies = append(strategies, "MEAN")
+	}
+	if config.MaxTokens {
+		strategies = append(strategies, "MAX")
+	}
+	if config.WeightedMeanTokens {
+		strategies = append(strategies, "WEIGHTED_MEAN")
+	}
+	if config.LastToken {
+		strategies = append(strategies, "LAST_N")
+		lastN = 1
+	}
+	switch len(strategies) {
+	case 0:
+		return "MEAN", 0, nil
+	case 1:
+		return strategies[0], lastN, nil
+	default:
+		return "", 0, fmt.Errorf("the pooling configuration %s combines the pooling modes %v, which is not supported", configPath, strategies)
+	}
+}
+
+// Postprocess Parse the results of the forward pass into the output. Token embeddings are pooled following the
+// pooling strategy of the pipeline, or returned per token if pooling is disabled. If the output of the model is already
+// pooled, the embeddings are returned as they are. The embeddings of the windows of long inputs are averaged.
+func (p *FeatureExtractionPipeline) Postprocess(batch PipelineBatch) (_ *FeatureExtractionOutput, err error) {
+	defer p.observePostprocess(time.Now(), &err)
+	rows := batch.inputRows()
+	if p.pooledOutput {
+		outputs := make([][]float32, len(batch.Input))
+		for i := range outputs {
+			outputs[i] = batch.OutputTensor[i*p.OutputDim : (i+1)*p.OutputDim]
+		}
+		outputs = aggregateWindows(outputs, rows, "MEAN")
+		if p.Normalization {
+			for i, output := range outputs {
+				outputs[i] = util.Normalize(output, 2)
+			}
+		}
+		return &FeatureExtractionOutput{Embeddings: outputs, TokenCounts: batch.tokenCounts(rows)}, nil
+	}
+
 	maxSequence := batch.MaxSequence
 	vectorCounter := 0
 	tokenCounter := 0
 	inputCounter := 0
 	outputs := make([][]float32, len(batch.Input))
+	rowTokens := make([][][]float32, len(batch.Input))
 	tokens := make([][]float32, maxSequence)
 	vectors := make([]float32, p.OutputDim)
 
@@ -104,7 +289,21 @@ func (p *FeatureExtractionPipeline) Postprocess(batch PipelineBatch) (*FeatureEx
 			vectorCounter = 0
 			vectors = make([]float32, p.OutputDim)
 			if tokenCounter == maxSequence-1 {
-				outputs[inputCounter] = meanPooling(tokens, batch.Input[inputCounter], maxSequence, p.OutputDim)
+				input := batch.Input[inputCounter]
+				switch p.PoolingStrategy {
+				case "CLS":
+					outputs[inputCounter] = tokens[0]
+				case "MAX":
+					outputs[inputCounter] = maxPooling(tokens, input, p.OutputDim)
+				case "LAST_N":
+					outputs[inputCounter] = lastNPooling(tokens, input, p.PoolingLastN, p.OutputDim)
+				case "WEIGHTED_MEAN":
+					outputs[inputCounter] = weightedMeanPooling(tokens, input, p.OutputDim)
+				case "NONE":
+					rowTokens[inputCounter] = tokens
+				default:
+					outputs[inputCounter] = meanPooling(tokens, input, maxSequence, p.OutputDim)
+				}
 				tokenCounter = 0
 				tokens = make([][]float32, maxSequence)
 				inputCounter++
@@ -116,14 +315,37 @@ func (p *FeatureExtractionPipeline) Postprocess(batch PipelineBatch) (*FeatureEx
 		}
 	}
 
+	var tokenOutputs [][]TokenEmbedding
+	if p.PoolingStrategy == "NONE" {
+		tokenOutputs = make([][]TokenEmbedding, len(rows))
+		for i, inputRows := range rows {
+			if len(inputRows) == 1 {
+				tokenOutputs[i] = tokenEmbeddings(rowTokens[inputRows[0]], batch.Input[inputRows[0]])
+			} else {
+				tokenOutputs[i] = mergeTokenEmbeddings(batch, inputRows, rowTokens)
+			}
+		}
+		outputs = nil
+	} else {
+		outputs = aggregateWindows(outputs, rows, "MEAN")
+	}
+
 	// Normalize embeddings (if asked), like in https://huggingface.co/sentence-transformers/all-mpnet-base-v2
 	if p.Normalization {
 		for i, output := range outputs {
 			outputs[i] = util.Normalize(output, 2)
 		}
+		for _, tokenOutput := range tokenOutputs {
+			for j, token := range tokenOutput {
+				tokenOutput[j].Embedding = util.Normalize(token.Embedding, 2)
+			}
+		}
 	}
 
-	return &FeatureExtractionOutput{Embeddings: outputs}, nil
+	if p.PoolingStrategy == "NONE" {
+		return &FeatureExtractionOutput{TokenEmbeddings: tokenOutputs, TokenCounts: batch.tokenCounts(rows)}, nil
+	}
+	return &FeatureExtractionOutput{Embeddings: outputs, TokenCounts: batch.tokenCounts(rows)}, nil
 }
 
 func meanPooling(tokens [][]float32, input TokenizedInput, maxSequence int, dimensions int) []float32 {
@@ -146,16 +368,140 @@ func meanPooling(tokens [][]float32, input TokenizedInput, maxSequence int, dime
 	return vector
 }
 
+func maxPooling(tokens [][]float32, input TokenizedInput, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+	first := true
+	for j, mask := range input.AttentionMask {
+		if mask == 0 {
+			continue
+		}
+		for k, vectorValue := range tokens[j] {
+			if first || vectorValue > vector[k] {
+				vector[k] = vectorValue
+			}
+		}
+		first = false
+	}
+	return vector
+}
+
+func lastNPooling(tokens [][]float32, input TokenizedInput, n int, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+	count := 0
+	for j := input.MaxAttentionIndex; j >= 0 && count < n; j-- {
+		if input.AttentionMask[j] == 0 {
+			continue
+		}
+		for k, vectorValue := range tokens[j] {
+			vector[k] += vectorValue
+		}
+		count++
+	}
+	for k := range vector {
+		vector[k] /= float32(count)
+	}
+	return vector
+}
+
+// weightedMeanPooling weights each token by its position in the input, starting from 1.
+func weightedMeanPooling(tokens [][]float32, input TokenizedInput, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+	var totalWeight float32
+	for j, mask := range input.AttentionMask {
+		if mask == 0 {
+			continue
+		}
+		weight := float32(j + 1)
+		for k, vectorValue := range tokens[j] {
+			vector[k] += weight * vectorValue
+		}
+		totalWeight += weight
+	}
+	for k := range vector {
+		vector[k] /= totalWeight
+	}
+	return vector
+}
+
+// tokenEmbeddings returns the embedding of each token of the input, excluding padding.
+func tokenEmbeddings(tokens [][]float32, input TokenizedInput) []TokenEmbedding {
+	embeddings := make([]TokenEmbedding, 0, len(input.TokenIds))
+	for j, mask := range input.AttentionMask {
+		if mask == 0 {
+			continue
+		}
+		embedding := TokenEmbedding{
+			TokenId:   input.TokenIds[j],
+			Embedding: tokens[j],
+		}
+		if j < len(input.Tokens) {
+			embedding.Token = input.Tokens[j]
+		}
+		if j < len(input.Offsets) {
+			embedding.Start = input.Offsets[j][0]
+			embedding.End = input.Offsets[j][1]
+		}
+		embeddings = append(embeddings, embedding)
+	}
+	return embeddings
+}
+
+// mergeTokenEmbeddings returns the token embeddings of an input split into windows, averaging the embeddings of the
+// tokens that appear in several windows.
+func mergeTokenEmbeddings(batch PipelineBatch, inputRows []int, rowTokens [][][]float32) []TokenEmbedding {
+	merged, positions := mergeWindows(batch, inputRows)
+	embeddings := make([]TokenEmbedding, len(merged.TokenIds))
+	for j, tokenPositions := range positions {
+		embedding := make([]float32, len(rowTokens[tokenPositions[0][0]][tokenPositions[0][1]]))
+		for _, position := range tokenPositions {
+			for k, value := range rowTokens[position[0]][position[1]] {
+				embedding[k] += value / float32(len(tokenPositions))
+			}
+		}
+		embeddings[j] = TokenEmbedding{
+			Token:     merged.Tokens[j],
+			TokenId:   merged.TokenIds[j],
+			Start:     merged.Offsets[j][0],
+			End:       merged.Offsets[j][1],
+			Embedding: embedding,
+		}
+	}
+	return embeddings
+}
+
 // Run the pipeline on a string batch
 func (p *FeatureExtractionPipeline) Run(inputs []string) (PipelineBatchOutput, error) {
 	return p.RunPipeline(inputs)
 }
 
+// RunContext runs the pipeline on a string batch, returning the error of the context if it is done.
+func (p *FeatureExtractionPipeline) RunContext(ctx context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
 func (p *FeatureExtractionPipeline) RunPipeline(inputs []string) (*FeatureExtractionOutput, error) {
-	batch := p.Preprocess(inputs)
-	batch, forwardError := p.Forward(batch)
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context between the tokenization, forward pass and postprocessing.
+func (p *FeatureExtractionPipeline) RunPipelineContext(ctx context.Context, inputs []string) (*FeatureExtractionOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batch := p.PreprocessContext(ctx, inputs)
+	batch, forwardError := p.ForwardContext(ctx, batch)
 	if forwardError != nil {
 		return nil, forwardError
 	}
-	return p.Postprocess(batch)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span := p.startSpan(ctx, spanPostprocess, batch.NumInputs)
+	output, err := p.Postprocess(batch)
+	endSpan(span, batch.MaxSequence, err)
+	return output, err
 }
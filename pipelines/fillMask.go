@@ -0,0 +1,352 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	ort "github.com/yalue/onnxruntime_go"
+
+	"github.com/knights-analytics/hugot/tokenizer"
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// FillMaskPipeline A fill mask pipeline is a go version of
+// https://github.com/huggingface/transformers/blob/main/src/transformers/pipelines/fill_mask.py
+// It predicts the most likely tokens for each mask token of the input.
+
+// types
+
+type FillMaskPipeline struct {
+	BasePipeline
+	TopK        int
+	Targets     []string
+	MaskToken   string
+	MaskTokenId uint32
+	targetIds   []uint32
+}
+
+type FillMaskTokenizerConfig struct {
+	AddedTokens []struct {
+		Id      uint32 `json:"id"`
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+}
+
+type MaskPrediction struct {
+	Score    float32
+	Token    uint32
+	TokenStr string
+	Sequence string
+}
+
+type MaskOutput struct {
+	Index       int
+	Predictions []MaskPrediction
+}
+
+type FillMaskOutput struct {
+	Masks [][]MaskOutput
+}
+
+func (t *FillMaskOutput) GetOutput() []any {
+	out := make([]any, len(t.Masks))
+	for i, masks := range t.Masks {
+		out[i] = any(masks)
+	}
+	return out
+}
+
+// options
+
+// WithTopKTokens sets the number of predictions returned for each mask token. Default is 5.
+func WithTopKTokens(topK int) PipelineOption[*FillMaskPipeline] {
+	return func(pipeline *FillMaskPipeline) {
+		pipeline.TopK = topK
+	}
+}
+
+// WithTargets restricts the predictions to the given words. Words that are not a token of the vocabulary are
+// tokenized, and their first token is used instead. Targets that map to the same token are predicted once.
+func WithTargets(targets []string) PipelineOption[*FillMaskPipeline] {
+	return func(pipeline *FillMaskPipeline) {
+		pipeline.Targets = targets
+	}
+}
+
+// NewFillMaskPipeline initializes a new fill mask pipeline
+func NewFillMaskPipeline(config PipelineConfig[*FillMaskPipeline], ortOptions *ort.SessionOptions) (*FillMaskPipeline, error) {
+	pipeline := &FillMaskPipeline{}
+	pipeline.ModelPath = config.ModelPath
+	pipeline.PipelineName = config.Name
+	pipeline.OrtOptions = ortOptions
+	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.OutputName = config.OutputName
+	pipeline.MaxLength = config.MaxLength
+	pipeline.Truncation = config.Truncation
+	pipeline.Stride = config.Stride
+	pipeline.MaxTokensPerBatch = config.MaxTokensPerBatch
+	pipeline.GoTokenizer = config.GoTokenizer
+
+	for _, o := range config.Options {
+		o(pipeline)
+	}
+
+	if pipeline.TopK == 0 {
+		pipeline.TopK = 5
+	}
+
+	pipeline.PipelineTimings = &Timings{}
+	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
+
+	// load onnx model
+	err := pipeline.loadModel()
+	if err != nil {
+		return nil, err
+	}
+
+	// the output of the model are the logits over the vocabulary for each token, [batch, sequence, vocabulary]
+	if dimensions := pipeline.outputMeta().Dimensions; len(dimensions) == 3 {
+		pipeline.OutputDim = int(dimensions[2])
+	}
+
+	err = pipeline.loadMaskToken()
+	if err != nil {
+		return nil, errors.Join(err, pipeline.Destroy())
+	}
+
+	err = pipeline.loadTargetIds()
+	if err != nil {
+		return nil, errors.Join(err, pipeline.Destroy())
+	}
+
+	err = pipeline.Validate()
+	if err != nil {
+		return nil, errors.Join(err, pipeline.Destroy())
+	}
+
+	return pipeline, nil
+}
+
+// loadMaskToken finds the mask token of the tokenizer. It is taken from special_tokens_map.json if present,
+// otherwise it is the special token of the tokenizer that contains "mask".
+func (p *FillMaskPipeline) loadMaskToken() error {
+	specialTokensPath := util.PathJoinSafe(p.ModelPath, "special_tokens_map.json")
+	exists, err := util.FileSystem.Exists(context.Background(), specialTokensPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		specialTokensBytes, err := util.ReadFileBytes(specialTokensPath)
+		if err != nil {
+			return err
+		}
+		specialTokens := map[string]any{}
+		if err := jsoniter.Unmarshal(specialTokensBytes, &specialTokens); err != nil {
+			return err
+		}
+		switch maskToken := specialTokens["mask_token"].(type) {
+		case string:
+			p.MaskToken = maskToken
+		case map[string]any:
+			p.MaskToken, _ = maskToken["content"].(string)
+		}
+	}
+
+	tokenizerBytes, err := tokenizer.ReadJSON(p.ModelPath)
+	if err != nil {
+		return err
+	}
+	tokenizerConfig := FillMaskTokenizerConfig{}
+	if err := jsoniter.Unmarshal(tokenizerBytes, &tokenizerConfig); err != nil {
+		return err
+	}
+	for _, token := range tokenizerConfig.AddedTokens {
+		if (p.MaskToken == "" && token.Special && strings.Contains(strings.ToLower(token.Content), "mask")) || (p.MaskToken != "" && token.Content == p.MaskToken) {
+			p.MaskToken = token.Content
+			p.MaskTokenId = token.Id
+			return nil
+		}
+	}
+	return fmt.Errorf("could not find the mask token of the tokenizer at %s", p.ModelPath)
+}
+
+type fillMaskVocabJSON struct {
+	AddedTokens []struct {
+		Id      uint32 `json:"id"`
+		Content string `json:"content"`
+	} `json:"added_tokens"`
+	Model struct {
+		Vocab jsoniter.RawMessage `json:"vocab"`
+	} `json:"model"`
+}
+
+// loadTargetIds finds the token of each target as the python pipeline does: a target that is in the vocabulary of
+// the tokenizer is its own token, other targets are tokenized and their first token is used. The ids are unique.
+func (p *FillMaskPipeline) loadTargetIds() error {
+	if len(p.Targets) == 0 {
+		return nil
+	}
+	tokenizerBytes, err := tokenizer.ReadJSON(p.ModelPath)
+	if err != nil {
+		return err
+	}
+	tokenizerConfig := fillMaskVocabJSON{}
+	if err := jsoniter.Unmarshal(tokenizerBytes, &tokenizerConfig); err != nil {
+		return err
+	}
+	vocab := map[string]uint32{}
+	if len(tokenizerConfig.Model.Vocab) > 0 {
+		if err := jsoniter.Unmarshal(tokenizerConfig.Model.Vocab, &vocab); err != nil {
+			// the vocabulary of unigram models is a list of [piece, score]
+			var pieces [][]any
+			if err := jsoniter.Unmarshal(tokenizerConfig.Model.Vocab, &pieces); err != nil {
+				return err
+			}
+			vocab = map[string]uint32{}
+			for id, piece := range pieces {
+				if len(piece) > 0 {
+					if content, ok := piece[0].(string); ok {
+						vocab[content] = uint32(id)
+					}
+				}
+			}
+		}
+	}
+	for _, token := range tokenizerConfig.AddedTokens {
+		vocab[token.Content] = token.Id
+	}
+
+	seen := map[uint32]bool{}
+	for _, target := range p.Targets {
+		id, ok := vocab[target]
+		if !ok {
+			ids := p.Tokenizer.Encode(target, false).IDs
+			if len(ids) == 0 {
+				return fmt.Errorf("target %s does not correspond to any token", target)
+			}
+			id = ids[0]
+		}
+		if !seen[id] {
+			seen[id] = true
+			p.targetIds = append(p.targetIds, id)
+		}
+	}
+	return nil
+}
+
+func (p *FillMaskPipeline) Validate() error {
+	var validationErrors []error
+
+	if len(p.outputMeta().Dimensions) != 3 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: the output of the model must have the dimensions batch, sequence and vocabulary"))
+	}
+	if p.OutputDim <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: outputDim parameter must be greater than zero"))
+	}
+	if p.TopK <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: topK must be greater than zero"))
+	}
+	if p.MaskToken == "" {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: the tokenizer has no mask token"))
+	}
+	validationErrors = append(validationErrors, p.validateTruncation(false)...)
+	return errors.Join(validationErrors...)
+}
+
+// Postprocess reads the vocabulary logits at the position of each mask token and returns the top k predictions.
+func (p *FillMaskPipeline) Postprocess(batch PipelineBatch) (_ *FillMaskOutput, err error) {
+	defer p.observePostprocess(time.Now(), &err)
+	output := FillMaskOutput{
+		Masks: make([][]MaskOutput, len(batch.Input)),
+	}
+
+	for i, input := range batch.Input {
+		var masks []MaskOutput
+		for j, tokenId := range input.TokenIds {
+			if tokenId != p.MaskTokenId {
+				continue
+			}
+			offset := (i*batch.MaxSequence + j) * p.OutputDim
+			scores := util.SoftMax(batch.OutputTensor[offset : offset+p.OutputDim])
+
+			var candidates []uint32
+			if len(p.targetIds) == 0 {
+				// only the top k of the vocabulary is kept, without sorting all of it
+				for _, candidate := range topTokens(scores, p.TopK) {
+					candidates = append(candidates, uint32(candidate))
+				}
+			} else {
+				candidates = append([]uint32{}, p.targetIds...)
+				sort.SliceStable(candidates, func(a, b int) bool {
+					return scores[candidates[a]] > scores[candidates[b]]
+				})
+				if len(candidates) > p.TopK {
+					candidates = candidates[:p.TopK]
+				}
+			}
+
+			predictions := make([]MaskPrediction, len(candidates))
+			for k, candidate := range candidates {
+				// the filled sequence is decoded with only the current mask replaced, like in python
+				filledIds := append([]uint32{}, input.TokenIds...)
+				filledIds[j] = candidate
+				predictions[k] = MaskPrediction{
+					Score:    scores[candidate],
+					Token:    candidate,
+					TokenStr: p.Tokenizer.Decode([]uint32{candidate}, false),
+					Sequence: p.Tokenizer.Decode(filledIds, true),
+				}
+			}
+			masks = append(masks, MaskOutput{Index: j, Predictions: predictions})
+		}
+		if len(masks) == 0 {
+			return nil, fmt.Errorf("no mask token %s found in input %s", p.MaskToken, input.Raw)
+		}
+		output.Masks[i] = masks
+	}
+	return &output, nil
+}
+
+// Run the pipeline on a string batch
+func (p *FillMaskPipeline) Run(inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipeline(inputs)
+}
+
+// RunContext runs the pipeline on a string batch, returning the error of the context if it is done.
+func (p *FillMaskPipeline) RunContext(ctx context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
+func (p *FillMaskPipeline) RunPipeline(inputs []string) (*FillMaskOutput, error) {
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context between the tokenization, forward pass and postprocessing.
+func (p *FillMaskPipeline) RunPipelineContext(ctx context.Context, inputs []string) (*FillMaskOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batch := p.PreprocessContext(ctx, inputs)
+	batch, err := p.ForwardContext(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span := p.startSpan(ctx, spanPostprocess, batch.NumInputs)
+	output, err := p.Postprocess(batch)
+	endSpan(span, batch.MaxSequence, err)
+	return output, err
+}
@@ -0,0 +1,223 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	ort "github.com/yalue/onnxruntime_go"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// Helpers shared by the generation pipelines: reading the special token ids of the model, running decoder graphs
+// step by step with a key/value cache, and processing the logits of each step.
+
+// generationConfig holds the generation settings of config.json, overridden by generation_config.json if present.
+type generationConfig struct {
+	DecoderStartTokenId *int64 `json:"decoder_start_token_id"`
+	BosTokenId          *int64 `json:"bos_token_id"`
+	EosTokenId          any    `json:"eos_token_id"`
+	PadTokenId          *int64 `json:"pad_token_id"`
+	ForcedBosTokenId    *int64 `json:"forced_bos_token_id"`
+}
+
+func loadGenerationConfig(modelPath string) (generationConfig, error) {
+	config := generationConfig{}
+	for _, filename := range []string{"config.json", "generation_config.json"} {
+		configPath := util.PathJoinSafe(modelPath, filename)
+		exists, err := util.FileSystem.Exists(context.Background(), configPath)
+		if err != nil {
+			return config, err
+		}
+		if !exists {
+			continue
+		}
+		configBytes, err := util.ReadFileBytes(configPath)
+		if err != nil {
+			return config, err
+		}
+		if err := jsoniter.Unmarshal(configBytes, &config); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// eosTokenIds returns the end of sequence token ids, which can be a single id or a list of ids in the config.
+func (c generationConfig) eosTokenIds() []int64 {
+	switch eos := c.EosTokenId.(type) {
+	case float64:
+		return []int64{int64(eos)}
+	case []any:
+		ids := make([]int64, 0, len(eos))
+		for _, id := range eos {
+			if value, ok := id.(float64); ok {
+				ids = append(ids, int64(value))
+			}
+		}
+		return ids
+	default:
+		return nil
+	}
+}
+
+// runGraph runs the graph on the named inputs, which must cover all the inputs of the graph. The outputs are
+// allocated with the shapes returned by outputShape and their data is returned by output name. Inputs and outputs
+// are destroyed before returning.
+func runGraph(graph *OnnxGraph, inputs map[string]ort.ArbitraryTensor, outputShape func(meta ort.InputOutputInfo) (ort.Shape, error)) (outputs map[string][]float32, err error) {
+	inputTensors := make([]ort.ArbitraryTensor, len(graph.InputsMeta))
+	outputTensors := make([]ort.ArbitraryTensor, 0, len(graph.OutputsMeta))
+
+	defer func() {
+		for _, tensor := range inputs {
+			err = errors.Join(err, tensor.Destroy())
+		}
+		for _, tensor := range outputTensors {
+			err = errors.Join(err, tensor.Destroy())
+		}
+	}()
+
+	for i, meta := range graph.InputsMeta {
+		tensor, ok := inputs[meta.Name]
+		if !ok {
+			return nil, fmt.Errorf("input %s of graph %s is not supported", meta.Name, graph.Filename)
+		}
+		inputTensors[i] = tensor
+	}
+
+	outputs = make(map[string][]float32, len(graph.OutputsMeta))
+	for _, meta := range graph.OutputsMeta {
+		shape, shapeErr := outputShape(meta)
+		if shapeErr != nil {
+			return nil, shapeErr
+		}
+		tensor, tensorErr := ort.NewEmptyTensor[float32](shape)
+		if tensorErr != nil {
+			return nil, tensorErr
+		}
+		outputTensors = append(outputTensors, tensor)
+		outputs[meta.Name] = tensor.GetData()
+	}
+
+	if errOnnx := graph.OrtSession.Run(inputTensors, outputTensors); errOnnx != nil {
+		return nil, errOnnx
+	}
+	return outputs, err
+}
+
+// resolveShape replaces the dynamic dimensions of the output with the given values, in order.
+func resolveShape(meta ort.InputOutputInfo, dynamicDimensions ...int64) (ort.Shape, error) {
+	shape := make(ort.Shape, len(meta.Dimensions))
+	next := 0
+	for i, dimension := range meta.Dimensions {
+		if dimension > 0 {
+			shape[i] = dimension
+			continue
+		}
+		if next >= len(dynamicDimensions) {
+			return nil, fmt.Errorf("cannot determine dimension %d of output %s", i, meta.Name)
+		}
+		shape[i] = dynamicDimensions[next]
+		next++
+	}
+	return shape, nil
+}
+
+// pastName returns the name of the past key/value input fed by a present output of a decoder graph,
+// e.g. present.0.decoder.key feeds past_key_values.0.decoder.key.
+func pastName(presentName string) (string, bool) {
+	if !strings.HasPrefix(presentName, "present") {
+		return "", false
+	}
+	return "past_key_values" + strings.TrimPrefix(presentName, "present"), true
+}
+
+// gatherRows reorders the rows (first dimension) of a flattened tensor following the source row indices.
+func gatherRows(data []float32, sourceRows []int) []float32 {
+	if len(sourceRows) == 0 {
+		return data
+	}
+	rowSize := len(data) / len(sourceRows)
+	gathered := make([]float32, len(data))
+	for row, source := range sourceRows {
+		copy(gathered[row*rowSize:(row+1)*rowSize], data[source*rowSize:(source+1)*rowSize])
+	}
+	return gathered
+}
+
+// logSoftMax returns the log probabilities of the logits.
+func logSoftMax(logits []float32) []float32 {
+	maxLogit := float32(math.Inf(-1))
+	for _, logit := range logits {
+		if logit > maxLogit {
+			maxLogit = logit
+		}
+	}
+	sum := 0.0
+	for _, logit := range logits {
+		sum += math.Exp(float64(logit - maxLogit))
+	}
+	logSum := float32(math.Log(sum)) + maxLogit
+	logProbs := make([]float32, len(logits))
+	for i, logit := range logits {
+		logProbs[i] = logit - logSum
+	}
+	return logProbs
+}
+
+// bannedNgramTokens returns the tokens that would repeat an ngram of size n already present in the tokens.
+func bannedNgramTokens(tokens []int64, n int) []int64 {
+	if n <= 0 || len(tokens) < n {
+		return nil
+	}
+	prefix := tokens[len(tokens)-n+1:]
+	var banned []int64
+	for i := 0; i+n <= len(tokens); i++ {
+		match := true
+		for j := range prefix {
+			if tokens[i+j] != prefix[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			banned = append(banned, tokens[i+n-1])
+		}
+	}
+	return banned
+}
+
+// topTokens returns the indices of the k highest scores, sorted by decreasing score.
+func topTokens(scores []float32, k int) []int {
+	if k > len(scores) {
+		k = len(scores)
+	}
+	top := make([]int, 0, k+1)
+	for i, score := range scores {
+		if len(top) == k && score <= scores[top[k-1]] {
+			continue
+		}
+		position := sort.Search(len(top), func(j int) bool { return scores[top[j]] < score })
+		top = append(top, 0)
+		copy(top[position+1:], top[position:])
+		top[position] = i
+		if len(top) > k {
+			top = top[:k]
+		}
+	}
+	return top
+}
+
+func containsToken(tokens []int64, token int64) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
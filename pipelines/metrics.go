@@ -0,0 +1,255 @@
+package pipelines
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The metrics of a pipeline are the distributions of its runs, kept as histograms with fixed buckets so that they
+// can be exported to monitoring systems such as prometheus (see hugot.NewMetricsCollector) without keeping every
+// observation.
+
+// Stage is a stage of a pipeline run.
+type Stage string
+
+const (
+	StageTokenization Stage = "tokenization"
+	StageForward      Stage = "forward"
+	StagePostprocess  Stage = "postprocess"
+)
+
+// Stages are the stages of a pipeline run, in order.
+var Stages = []Stage{StageTokenization, StageForward, StagePostprocess}
+
+var (
+	// upper bounds of the latency buckets in seconds, from 0.25ms to about 8s
+	latencyBuckets = exponentialBuckets(0.00025, 2, 16)
+	// upper bounds of the batch size buckets, from 1 to 1024 inputs
+	batchSizeBuckets = exponentialBuckets(1, 2, 11)
+	// upper bounds of the sequence length buckets, from 8 to 8192 tokens
+	sequenceLengthBuckets = exponentialBuckets(8, 2, 11)
+)
+
+// Histogram counts observations in buckets with fixed upper bounds. It is safe for concurrent use.
+type Histogram struct {
+	mutex  sync.Mutex
+	bounds []float64
+	counts []uint64 // counts[i] is the number of observations in (bounds[i-1], bounds[i]], the last one above all bounds
+	count  uint64
+	sum    float64
+}
+
+// HistogramSnapshot is the state of a histogram at a point in time.
+type HistogramSnapshot struct {
+	Bounds []float64 // the upper bounds of the buckets
+	Counts []uint64  // the cumulative count of the observations less than or equal to each bound
+	Count  uint64    // the number of observations
+	Sum    float64   // the sum of the observations
+}
+
+// NewHistogram creates a histogram with the increasing upper bounds of its buckets.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	bucket := len(h.bounds)
+	for i, bound := range h.bounds {
+		if value <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.counts[bucket]++
+	h.count++
+	h.sum += value
+}
+
+// Snapshot returns the state of the histogram.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	snapshot := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.bounds)),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+	var cumulative uint64
+	for i := range h.bounds {
+		cumulative += h.counts[i]
+		snapshot.Counts[i] = cumulative
+	}
+	return snapshot
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the observations from the buckets, interpolating linearly
+// within the bucket of the quantile as prometheus' histogram_quantile does. Observations above the last bound are
+// estimated at the last bound, and a snapshot without observations returns 0.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 || len(s.Bounds) == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	var lower float64
+	var below uint64
+	for i, bound := range s.Bounds {
+		if float64(s.Counts[i]) >= rank {
+			inBucket := s.Counts[i] - below
+			if inBucket == 0 {
+				return bound
+			}
+			return lower + (bound-lower)*(rank-float64(below))/float64(inBucket)
+		}
+		lower = bound
+		below = s.Counts[i]
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
+
+// Reset removes all the observations of the histogram.
+func (h *Histogram) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count = 0
+	h.sum = 0
+}
+
+// PipelineMetrics are the distributions of the runs of a pipeline.
+type PipelineMetrics struct {
+	Durations      map[Stage]*Histogram // duration in seconds of each call of the stage
+	BatchSize      *Histogram           // number of inputs of each forward pass
+	SequenceLength *Histogram           // padded sequence length of each forward pass
+	inputs         uint64
+	tokens         uint64
+	paddedTokens   uint64
+	errors         map[Stage]*uint64
+}
+
+// NewPipelineMetrics creates the metrics of a pipeline, with no observations.
+func NewPipelineMetrics() *PipelineMetrics {
+	m := &PipelineMetrics{
+		Durations:      map[Stage]*Histogram{},
+		BatchSize:      NewHistogram(batchSizeBuckets),
+		SequenceLength: NewHistogram(sequenceLengthBuckets),
+		errors:         map[Stage]*uint64{},
+	}
+	for _, stage := range Stages {
+		m.Durations[stage] = NewHistogram(latencyBuckets)
+		m.errors[stage] = new(uint64)
+	}
+	return m
+}
+
+// Inputs returns the number of inputs tokenized by the pipeline.
+func (m *PipelineMetrics) Inputs() uint64 {
+	return atomic.LoadUint64(&m.inputs)
+}
+
+// Tokens returns the number of tokens of the inputs of the forward passes of the pipeline, padding excluded.
+func (m *PipelineMetrics) Tokens() uint64 {
+	return atomic.LoadUint64(&m.tokens)
+}
+
+// PaddedTokens returns the number of tokens of the input tensors of the forward passes of the pipeline, padding
+// included.
+func (m *PipelineMetrics) PaddedTokens() uint64 {
+	return atomic.LoadUint64(&m.paddedTokens)
+}
+
+// Errors returns the number of calls of the stage that returned an error.
+func (m *PipelineMetrics) Errors(stage Stage) uint64 {
+	if counter, ok := m.errors[stage]; ok {
+		return atomic.LoadUint64(counter)
+	}
+	return 0
+}
+
+// Reset removes all the observations of the metrics.
+func (m *PipelineMetrics) Reset() {
+	for _, histogram := range m.Durations {
+		histogram.Reset()
+	}
+	m.BatchSize.Reset()
+	m.SequenceLength.Reset()
+	atomic.StoreUint64(&m.inputs, 0)
+	atomic.StoreUint64(&m.tokens, 0)
+	atomic.StoreUint64(&m.paddedTokens, 0)
+	for _, counter := range m.errors {
+		atomic.StoreUint64(counter, 0)
+	}
+}
+
+// GetMetrics returns the metrics of the pipeline, or nil for pipelines created without metrics.
+func (p *BasePipeline) GetMetrics() *PipelineMetrics {
+	return p.Metrics
+}
+
+// observeTokenization records the tokenization of inputs that started at start.
+func (p *BasePipeline) observeTokenization(start time.Time, inputs int) {
+	elapsed := time.Since(start)
+	atomic.AddUint64(&p.TokenizerTimings.NumCalls, 1)
+	atomic.AddUint64(&p.TokenizerTimings.TotalNS, uint64(elapsed))
+	if p.Metrics == nil {
+		return
+	}
+	atomic.AddUint64(&p.Metrics.inputs, uint64(inputs))
+	p.Metrics.Durations[StageTokenization].Observe(elapsed.Seconds())
+}
+
+// observeForward records a forward pass that started at start on a batch of batchSize inputs padded to
+// sequenceLength, with tokens tokens before padding, or its error.
+func (p *BasePipeline) observeForward(start time.Time, batchSize int, sequenceLength int, tokens int, err error) {
+	if err != nil {
+		p.observeError(StageForward)
+		return
+	}
+	elapsed := time.Since(start)
+	atomic.AddUint64(&p.PipelineTimings.NumCalls, 1)
+	atomic.AddUint64(&p.PipelineTimings.TotalNS, uint64(elapsed))
+	if p.Metrics == nil {
+		return
+	}
+	p.Metrics.Durations[StageForward].Observe(elapsed.Seconds())
+	p.Metrics.BatchSize.Observe(float64(batchSize))
+	p.Metrics.SequenceLength.Observe(float64(sequenceLength))
+	atomic.AddUint64(&p.Metrics.tokens, uint64(tokens))
+	atomic.AddUint64(&p.Metrics.paddedTokens, uint64(batchSize*sequenceLength))
+}
+
+// observePostprocess records a postprocessing that started at start, or the error it returned. It is deferred at
+// the start of Postprocess, with a pointer to its error result.
+func (p *BasePipeline) observePostprocess(start time.Time, err *error) {
+	if err != nil && *err != nil {
+		p.observeError(StagePostprocess)
+		return
+	}
+	if p.Metrics == nil {
+		return
+	}
+	p.Metrics.Durations[StagePostprocess].Observe(time.Since(start).Seconds())
+}
+
+// observeError records an error of the stage.
+func (p *BasePipeline) observeError(stage Stage) {
+	if p.Metrics == nil {
+		return
+	}
+	atomic.AddUint64(p.Metrics.errors[stage], 1)
+}
+
+func exponentialBuckets(start float64, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
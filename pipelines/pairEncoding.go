@@ -0,0 +1,230 @@
+package pipelines
+
+import (
+	"fmt"
+
+	"github.com/knights-analytics/hugot/tokenizer"
+)
+
+// The tokenizers of the pipelines only encode single sequences. To encode text pairs (e.g. premise/hypothesis
+// for NLI models) we encode each sequence without special tokens and then reassemble the pair following the
+// post_processor template declared in tokenizer.json, which is what the Huggingface tokenizers do internally. The single
+// sequence template is used in the same way to add the special tokens to truncated inputs and overflow windows.
+
+// templatePiece is either a sequence placeholder (A or B) or a special token to insert.
+type templatePiece struct {
+	sequence int // 0 for sequence A, 1 for sequence B, -1 for a special token
+	typeId   uint32
+	ids      []uint32
+	tokens   []string
+}
+
+type postProcessor struct {
+	single []templatePiece
+	pair   []templatePiece
+}
+
+type tokenizerJSON struct {
+	PostProcessor *postProcessorJSON `json:"post_processor"`
+	Truncation    *truncationJSON    `json:"truncation"`
+}
+
+type truncationJSON struct {
+	MaxLength int `json:"max_length"`
+}
+
+type postProcessorJSON struct {
+	Type          string                         `json:"type"`
+	Single        []map[string]templateEntryJSON `json:"single"`
+	Pair          []map[string]templateEntryJSON `json:"pair"`
+	SpecialTokens map[string]specialTokenJSON    `json:"special_tokens"`
+	Sep           []any                          `json:"sep"`
+	Cls           []any                          `json:"cls"`
+	Processors    []*postProcessorJSON           `json:"processors"`
+}
+
+type templateEntryJSON struct {
+	Id     string `json:"id"`
+	TypeId uint32 `json:"type_id"`
+}
+
+type specialTokenJSON struct {
+	Id     string   `json:"id"`
+	Ids    []uint32 `json:"ids"`
+	Tokens []string `json:"tokens"`
+}
+
+func newPostProcessor(config *postProcessorJSON) (*postProcessor, error) {
+	if config == nil {
+		// no post processor: sequences are simply concatenated
+		return &postProcessor{
+			single: []templatePiece{{sequence: 0}},
+			pair:   []templatePiece{{sequence: 0}, {sequence: 1}},
+		}, nil
+	}
+
+	switch config.Type {
+	case "TemplateProcessing":
+		single, err := templatePieces(config.Single, config.SpecialTokens)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := templatePieces(config.Pair, config.SpecialTokens)
+		if err != nil {
+			return nil, err
+		}
+		return &postProcessor{single: single, pair: pair}, nil
+	case "BertProcessing", "RobertaProcessing":
+		cls, err := specialTokenPiece(config.Cls)
+		if err != nil {
+			return nil, err
+		}
+		sep, err := specialTokenPiece(config.Sep)
+		if err != nil {
+			return nil, err
+		}
+		single := []templatePiece{cls, {sequence: 0}, sep}
+		if config.Type == "BertProcessing" {
+			// [CLS] A [SEP] B [SEP], with type id 1 for the second sequence
+			sepB := sep
+			sepB.typeId = 1
+			return &postProcessor{single: single, pair: []templatePiece{cls, {sequence: 0}, sep, {sequence: 1, typeId: 1}, sepB}}, nil
+		}
+		// <s> A </s></s> B </s>
+		return &postProcessor{single: single, pair: []templatePiece{cls, {sequence: 0}, sep, sep, {sequence: 1}, sep}}, nil
+	case "Sequence":
+		// the template defining processor is the one that adds special tokens, others (e.g. ByteLevel) only fix offsets
+		for _, processor := range config.Processors {
+			if processor != nil && processor.Type != "ByteLevel" {
+				return newPostProcessor(processor)
+			}
+		}
+		return newPostProcessor(nil)
+	case "ByteLevel":
+		return newPostProcessor(nil)
+	default:
+		return nil, fmt.Errorf("tokenizer post processor of type %s is not supported for text pairs", config.Type)
+	}
+}
+
+// templatePieces parses the single or pair template of a TemplateProcessing post processor.
+func templatePieces(entries []map[string]templateEntryJSON, specialTokens map[string]specialTokenJSON) ([]templatePiece, error) {
+	pieces := make([]templatePiece, 0, len(entries))
+	for _, entry := range entries {
+		if sequence, ok := entry["Sequence"]; ok {
+			sequenceIndex := 0
+			if sequence.Id == "B" {
+				sequenceIndex = 1
+			}
+			pieces = append(pieces, templatePiece{sequence: sequenceIndex, typeId: sequence.TypeId})
+		} else if special, ok := entry["SpecialToken"]; ok {
+			token, found := specialTokens[special.Id]
+			if !found {
+				return nil, fmt.Errorf("special token %s of the post processor template is not defined", special.Id)
+			}
+			pieces = append(pieces, templatePiece{sequence: -1, typeId: special.TypeId, ids: token.Ids, tokens: token.Tokens})
+		}
+	}
+	return pieces, nil
+}
+
+// specialTokenPiece parses a ["token", id] tuple of the Bert and Roberta post processors.
+func specialTokenPiece(tuple []any) (templatePiece, error) {
+	if len(tuple) != 2 {
+		return templatePiece{}, fmt.Errorf("malformed special token %v in tokenizer post processor", tuple)
+	}
+	token, okToken := tuple[0].(string)
+	id, okId := tuple[1].(float64)
+	if !okToken || !okId {
+		return templatePiece{}, fmt.Errorf("malformed special token %v in tokenizer post processor", tuple)
+	}
+	return templatePiece{sequence: -1, ids: []uint32{uint32(id)}, tokens: []string{token}}, nil
+}
+
+// numSpecialTokens returns the number of special tokens added by the post processor to a text pair.
+func (p *postProcessor) numSpecialTokens() int {
+	return countSpecialTokens(p.pair)
+}
+
+// numSingleSpecialTokens returns the number of special tokens added by the post processor to a single sequence.
+func (p *postProcessor) numSingleSpecialTokens() int {
+	return countSpecialTokens(p.single)
+}
+
+func countSpecialTokens(pieces []templatePiece) int {
+	n := 0
+	for _, piece := range pieces {
+		n += len(piece.ids)
+	}
+	return n
+}
+
+// mergePair assembles the encodings of two sequences, encoded without special tokens, into the encoding of the pair.
+// It also returns the sequence ids of the merged encoding, i.e. 0 or 1 for tokens of the first or second sequence,
+// and -1 for special tokens.
+func (p *postProcessor) mergePair(first tokenizer.Encoding, second tokenizer.Encoding) (tokenizer.Encoding, []int) {
+	return mergeTemplate(p.pair, [2]tokenizer.Encoding{first, second})
+}
+
+// mergeSingle adds the special tokens of the single sequence template to a sequence encoded without special tokens.
+func (p *postProcessor) mergeSingle(sequence tokenizer.Encoding) tokenizer.Encoding {
+	encoding, _ := mergeTemplate(p.single, [2]tokenizer.Encoding{sequence})
+	return encoding
+}
+
+func mergeTemplate(pieces []templatePiece, sequences [2]tokenizer.Encoding) (tokenizer.Encoding, []int) {
+	encoding := tokenizer.Encoding{}
+	var sequenceIds []int
+	for _, piece := range pieces {
+		if piece.sequence < 0 {
+			for i, id := range piece.ids {
+				encoding.IDs = append(encoding.IDs, id)
+				encoding.TypeIDs = append(encoding.TypeIDs, piece.typeId)
+				encoding.AttentionMask = append(encoding.AttentionMask, 1)
+				encoding.SpecialTokensMask = append(encoding.SpecialTokensMask, 1)
+				encoding.Offsets = append(encoding.Offsets, tokenizer.Offset{0, 0})
+				if i < len(piece.tokens) {
+					encoding.Tokens = append(encoding.Tokens, piece.tokens[i])
+				} else {
+					encoding.Tokens = append(encoding.Tokens, "")
+				}
+				sequenceIds = append(sequenceIds, -1)
+			}
+			continue
+		}
+		sequence := sequences[piece.sequence]
+		for i, id := range sequence.IDs {
+			encoding.IDs = append(encoding.IDs, id)
+			encoding.TypeIDs = append(encoding.TypeIDs, piece.typeId)
+			encoding.AttentionMask = append(encoding.AttentionMask, 1)
+			encoding.SpecialTokensMask = append(encoding.SpecialTokensMask, 0)
+			encoding.Offsets = append(encoding.Offsets, sequence.Offsets[i])
+			encoding.Tokens = append(encoding.Tokens, sequence.Tokens[i])
+			sequenceIds = append(sequenceIds, piece.sequence)
+		}
+	}
+	return encoding, sequenceIds
+}
+
+// encodePair encodes the two sequences of a text pair into a single encoding with the special tokens and
+// type ids required by the model.
+func (p *BasePipeline) encodePair(first string, second string) (tokenizer.Encoding, []int) {
+	firstEncoding := p.Tokenizer.Encode(first, false)
+	secondEncoding := p.Tokenizer.Encode(second, false)
+	if p.MaxLength > 0 {
+		firstEncoding, secondEncoding = truncatePair(firstEncoding, secondEncoding, p.MaxLength-p.postProcessor.numSpecialTokens(), p.Truncation)
+	}
+	return p.postProcessor.mergePair(firstEncoding, secondEncoding)
+}
+
+// sliceEncoding returns the tokens of the encoding between start (inclusive) and end (exclusive).
+func sliceEncoding(encoding tokenizer.Encoding, start int, end int) tokenizer.Encoding {
+	return tokenizer.Encoding{
+		IDs:               encoding.IDs[start:end],
+		TypeIDs:           encoding.TypeIDs[start:end],
+		SpecialTokensMask: encoding.SpecialTokensMask[start:end],
+		AttentionMask:     encoding.AttentionMask[start:end],
+		Tokens:            encoding.Tokens[start:end],
+		Offsets:           encoding.Offsets[start:end],
+	}
+}
@@ -5,34 +5,48 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"os"
+	"path/filepath"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
-	"github.com/knights-analytics/tokenizers"
+	jsoniter "github.com/json-iterator/go"
 	ort "github.com/yalue/onnxruntime_go"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/knights-analytics/hugot/tokenizer"
 	util "github.com/knights-analytics/hugot/utils"
 )
 
 // BasePipeline is a basic pipeline type used for struct composition in the other pipelines.
 type BasePipeline struct {
-	ModelPath        string
-	OnnxFilename     string
-	PipelineName     string
-	OrtSession       *ort.DynamicAdvancedSession
-	OrtOptions       *ort.SessionOptions
-	Tokenizer        *tokenizers.Tokenizer
-	TokenizerOptions []tokenizers.EncodeOption
-	InputsMeta       []ort.InputOutputInfo
-	OutputsMeta      []ort.InputOutputInfo
-	hasTokenTypeIds  bool
-	hasAttentionMask bool
-	OutputDim        int
-	TokenizerTimings *Timings
-	PipelineTimings  *Timings
+	ModelPath         string
+	OnnxFilename      string
+	PipelineName      string
+	OrtSession        *ort.DynamicAdvancedSession
+	OrtOptions        *ort.SessionOptions
+	Tokenizer         Tokenizer
+	GoTokenizer       bool
+	InputsMeta        []ort.InputOutputInfo
+	OutputsMeta       []ort.InputOutputInfo
+	OutputName        string
+	outputIndex       int
+	MaxLength         int
+	Truncation        string
+	Stride            int
+	MaxTokensPerBatch int
+	hasTokenTypeIds   bool
+	hasAttentionMask  bool
+	postProcessor     *postProcessor
+	OutputDim         int
+	TokenizerTimings  *Timings
+	PipelineTimings   *Timings
+	Metrics           *PipelineMetrics
+	tracer            trace.Tracer
+	runMutex          sync.Mutex
+	runs              sync.WaitGroup
+	closed            bool
 }
 
 type PipelineBatchOutput interface {
@@ -45,6 +59,14 @@ type Pipeline interface {
 	GetOutputDim() int
 	Validate() error
 	Run([]string) (PipelineBatchOutput, error)
+	RunContext(context.Context, []string) (PipelineBatchOutput, error)
+}
+
+// PairPipeline is a pipeline that can also be run on text pairs, such as premise/hypothesis or question/context.
+type PairPipeline interface {
+	Pipeline
+	RunPairs([][2]string) (PipelineBatchOutput, error)
+	RunPairsContext(context.Context, [][2]string) (PipelineBatchOutput, error)
 }
 
 type PipelineOption[T Pipeline] func(eo T)
@@ -53,7 +75,15 @@ type PipelineConfig[T Pipeline] struct {
 	ModelPath    string
 	Name         string
 	OnnxFilename string
-	Options      []PipelineOption[T]
+	OutputName   string // the output of the model used by the pipeline, if the model has several outputs
+	MaxLength    int    // maximum number of tokens of an input, defaults to the model_max_length of the tokenizer
+	Truncation   string // truncation strategy of text pairs: LONGEST_FIRST (default), ONLY_FIRST or ONLY_SECOND
+	Stride       int    // if set, inputs longer than MaxLength are split into windows overlapping by Stride tokens
+	// if set, inputs are sorted by length and run in sub-batches of at most MaxTokensPerBatch tokens, padding included
+	MaxTokensPerBatch int
+	// if set, the pipeline uses the pure Go tokenizer rather than the rust tokenizers library
+	GoTokenizer bool
+	Options     []PipelineOption[T]
 }
 
 type Timings struct {
@@ -63,13 +93,17 @@ type Timings struct {
 
 type TokenizedInput struct {
 	Raw               string
+	RawPair           string
 	Tokens            []string
 	TokenIds          []uint32
 	TypeIds           []uint32
 	AttentionMask     []uint32
 	SpecialTokensMask []uint32
+	SequenceIds       []int
 	MaxAttentionIndex int
-	Offsets           []tokenizers.Offset
+	Offsets           []tokenizer.Offset
+	InputIndex        int // index of the input in the batch, inputs split into windows have several rows
+	WindowStart       int // index of the first token of the window in the tokens of the input, without special tokens
 }
 
 type PipelineBatch struct {
@@ -78,7 +112,9 @@ type PipelineBatch struct {
 	TypeIdsTensor        []int64
 	AttentionMasksTensor []int64
 	MaxSequence          int
+	NumInputs            int
 	OutputTensor         []float32
+	OutputTensors        [][]float32
 }
 
 func (p *BasePipeline) GetOutputDim() int {
@@ -99,59 +135,62 @@ func getOnnxFiles(path string) ([][]string, error) {
 
 // Load the ort model supporting the pipeline.
 func (p *BasePipeline) loadModel() error {
-	tokenizerBytes, err := util.ReadFileBytes(util.PathJoinSafe(p.ModelPath, "tokenizer.json"))
+	tokenizerBytes, err := tokenizer.ReadJSON(p.ModelPath)
 	if err != nil {
 		return err
 	}
 
-	tk, err := tokenizers.FromBytes(tokenizerBytes)
-	if err != nil {
+	tokenizerConfig := tokenizerJSON{}
+	if err = jsoniter.Unmarshal(tokenizerBytes, &tokenizerConfig); err != nil {
 		return err
 	}
-
-	// we look for .onnx files.
-	var modelOnnxFile string
-	onnxFiles, err := getOnnxFiles(p.ModelPath)
+	processor, err := newPostProcessor(tokenizerConfig.PostProcessor)
 	if err != nil {
 		return err
 	}
-	if len(onnxFiles) == 0 {
-		return fmt.Errorf("no .onnx file detected at %s. There should be exactly .onnx file", p.ModelPath)
-	}
-	if len(onnxFiles) > 1 {
-		if p.OnnxFilename == "" {
-			return fmt.Errorf("multiple .onnx file detected at %s and no OnnxFilename specified", p.ModelPath)
+
+	// truncation is done by the pipeline rather than by the tokenizer, so that long inputs can be split into windows
+	if tokenizerConfig.Truncation != nil {
+		if p.MaxLength == 0 {
+			p.MaxLength = tokenizerConfig.Truncation.MaxLength
 		}
-		modelNameFound := false
-		for i := range onnxFiles {
-			if onnxFiles[i][1] == p.OnnxFilename {
-				modelNameFound = true
-				modelOnnxFile = util.PathJoinSafe(onnxFiles[i]...)
-			}
+		tokenizerBytes, err = withoutTruncation(tokenizerBytes)
+		if err != nil {
+			return err
 		}
-		if !modelNameFound {
-			return fmt.Errorf("file %s not found at %s", p.OnnxFilename, p.ModelPath)
+	}
+	if p.MaxLength == 0 {
+		p.MaxLength, err = loadModelMaxLength(p.ModelPath)
+		if err != nil {
+			return err
 		}
-	} else {
-		modelOnnxFile = util.PathJoinSafe(onnxFiles[0]...)
+	}
+	p.Truncation = strings.ToUpper(p.Truncation)
+	if p.Truncation == "" {
+		p.Truncation = "LONGEST_FIRST"
 	}
 
-	onnxBytes, err := util.ReadFileBytes(modelOnnxFile)
+	tk, err := p.newTokenizer(tokenizerBytes)
 	if err != nil {
 		return err
 	}
 
-	inputs, outputs, err := ort.GetInputOutputInfoWithONNXData(onnxBytes)
+	modelOnnxFile, err := p.findOnnxFile(p.OnnxFilename)
 	if err != nil {
 		return err
 	}
 
-	p.InputsMeta = inputs
-	p.OutputsMeta = outputs
+	graph, err := loadOnnxGraph(modelOnnxFile, p.OrtOptions)
+	if err != nil {
+		return err
+	}
 
-	inputNames := make([]string, len(inputs))
-	for i, meta := range inputs {
-		inputNames[i] = meta.Name
+	p.InputsMeta = graph.InputsMeta
+	p.OutputsMeta = graph.OutputsMeta
+	if err = p.selectOutput(p.OutputName); err != nil {
+		return errors.Join(err, graph.Destroy())
+	}
+	for _, meta := range graph.InputsMeta {
 		switch meta.Name {
 		case "token_type_ids":
 			p.hasTokenTypeIds = true
@@ -159,6 +198,93 @@ func (p *BasePipeline) loadModel() error {
 			p.hasAttentionMask = true
 		}
 	}
+
+	p.OrtSession = graph.OrtSession
+	p.Tokenizer = tk
+	p.postProcessor = processor
+	return nil
+}
+
+// selectOutput selects the output of the model used by the pipeline by name. Without a name, the first output of the
+// model is used.
+func (p *BasePipeline) selectOutput(outputName string) error {
+	if outputName == "" {
+		p.outputIndex = 0
+		return nil
+	}
+	outputNames := make([]string, len(p.OutputsMeta))
+	for i, meta := range p.OutputsMeta {
+		if meta.Name == outputName {
+			p.outputIndex = i
+			return nil
+		}
+		outputNames[i] = meta.Name
+	}
+	return fmt.Errorf("output %s not found in model %s, available outputs are %s", outputName, p.ModelPath, strings.Join(outputNames, ", "))
+}
+
+// outputMeta returns the metadata of the output used by the pipeline.
+func (p *BasePipeline) outputMeta() ort.InputOutputInfo {
+	return p.OutputsMeta[p.outputIndex]
+}
+
+// OnnxGraph is an onnx model file loaded in its own ort session. Pipelines that need several graphs, such as the
+// encoder and the decoders of text2text models, load one OnnxGraph per file of the model folder.
+type OnnxGraph struct {
+	Filename    string
+	OrtSession  *ort.DynamicAdvancedSession
+	InputsMeta  []ort.InputOutputInfo
+	OutputsMeta []ort.InputOutputInfo
+}
+
+func (g *OnnxGraph) Destroy() error {
+	return g.OrtSession.Destroy()
+}
+
+// findOnnxFile returns the path of the .onnx file to load from the model folder. If the folder contains several
+// .onnx files, onnxFilename selects the one to use.
+func (p *BasePipeline) findOnnxFile(onnxFilename string) (string, error) {
+	onnxFiles, err := getOnnxFiles(p.ModelPath)
+	if err != nil {
+		return "", err
+	}
+	if len(onnxFiles) == 0 {
+		return "", fmt.Errorf("no .onnx file detected at %s. There should be exactly .onnx file", p.ModelPath)
+	}
+	if len(onnxFiles) == 1 && onnxFilename == "" {
+		return util.PathJoinSafe(onnxFiles[0]...), nil
+	}
+	if onnxFilename == "" {
+		return "", fmt.Errorf("multiple .onnx file detected at %s and no OnnxFilename specified", p.ModelPath)
+	}
+	for i := range onnxFiles {
+		if onnxFiles[i][1] == onnxFilename {
+			return util.PathJoinSafe(onnxFiles[i]...), nil
+		}
+	}
+	if len(onnxFiles) == 1 {
+		// a single graph is used whatever its name
+		return util.PathJoinSafe(onnxFiles[0]...), nil
+	}
+	return "", fmt.Errorf("file %s not found at %s", onnxFilename, p.ModelPath)
+}
+
+// loadOnnxGraph creates an ort session for the onnx file, with all the inputs and outputs of the graph.
+func loadOnnxGraph(onnxFile string, options *ort.SessionOptions) (*OnnxGraph, error) {
+	onnxBytes, err := util.ReadFileBytes(onnxFile)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, outputs, err := ort.GetInputOutputInfoWithONNXData(onnxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	inputNames := make([]string, len(inputs))
+	for i, meta := range inputs {
+		inputNames[i] = meta.Name
+	}
 	outputNames := make([]string, len(outputs))
 	for i, meta := range outputs {
 		outputNames[i] = meta.Name
@@ -167,18 +293,59 @@ func (p *BasePipeline) loadModel() error {
 		onnxBytes,
 		inputNames,
 		outputNames,
-		p.OrtOptions,
+		options,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	p.OrtSession = session
-	p.Tokenizer = tk
+	return &OnnxGraph{
+		Filename:    onnxFile,
+		OrtSession:  session,
+		InputsMeta:  inputs,
+		OutputsMeta: outputs,
+	}, nil
+}
+
+// loadOnnxGraph loads an additional graph of the model folder, e.g. the decoder of an encoder-decoder model.
+func (p *BasePipeline) loadOnnxGraph(onnxFilename string) (*OnnxGraph, error) {
+	onnxFile, err := p.findOnnxFile(onnxFilename)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Base(onnxFile) != onnxFilename {
+		return nil, fmt.Errorf("file %s not found at %s", onnxFilename, p.ModelPath)
+	}
+	return loadOnnxGraph(onnxFile, p.OrtOptions)
+}
+
+// startRun registers a run of the pipeline, which Destroy waits for. It returns an error if the pipeline has been
+// destroyed. Every successful call must be followed by a call to endRun.
+func (p *BasePipeline) startRun() error {
+	p.runMutex.Lock()
+	defer p.runMutex.Unlock()
+	if p.closed {
+		return fmt.Errorf("pipeline %s has been destroyed", p.PipelineName)
+	}
+	p.runs.Add(1)
 	return nil
 }
 
+func (p *BasePipeline) endRun() {
+	p.runs.Done()
+}
+
+// closeRuns stops the pipeline from starting new runs and waits for the runs in progress to finish.
+func (p *BasePipeline) closeRuns() {
+	p.runMutex.Lock()
+	p.closed = true
+	p.runMutex.Unlock()
+	p.runs.Wait()
+}
+
+// Destroy frees the tokenizer and onnxruntime session of the pipeline, after the runs in progress have finished.
 func (p *BasePipeline) Destroy() error {
+	p.closeRuns()
 	var finalErr error
 	errTokenizer := p.Tokenizer.Close()
 	if errTokenizer != nil {
@@ -191,47 +358,96 @@ func (p *BasePipeline) Destroy() error {
 	return finalErr
 }
 
-// Preprocess the input strings in the batch
+// Preprocess the input strings in the batch. Inputs longer than the maximum length are truncated, or split into
+// windows if a stride is set.
 func (p *BasePipeline) Preprocess(inputs []string) PipelineBatch {
 	start := time.Now()
 
-	outputs := make([]TokenizedInput, len(inputs))
+	outputs := make([]TokenizedInput, 0, len(inputs))
 	maxSequence := 0
 	for i, input := range inputs {
 
-		output := p.Tokenizer.EncodeWithOptions(input,
-			true,
-			p.TokenizerOptions...,
-		)
-
-		maxAttentionIndex := 0
-		for j, attentionMaskValue := range output.AttentionMask {
-			if attentionMaskValue != 0 {
-				maxAttentionIndex = j
+		output := p.Tokenizer.Encode(input, true)
+		encoded := []TokenizedInput{newTokenizedInput(input, output)}
+		encoded[0].InputIndex = i
+		if p.MaxLength > 0 && len(output.IDs) > p.MaxLength {
+			encoded = p.splitInput(i, input)
+		}
+		for _, tokenizedInput := range encoded {
+			if tokenizedInput.MaxAttentionIndex > maxSequence {
+				maxSequence = tokenizedInput.MaxAttentionIndex
 			}
 		}
+		outputs = append(outputs, encoded...)
+	}
 
-		outputs[i] = TokenizedInput{
-			Raw:               input,
-			Tokens:            output.Tokens,
-			TokenIds:          output.IDs,
-			TypeIds:           output.TypeIDs,
-			AttentionMask:     output.AttentionMask,
-			MaxAttentionIndex: maxAttentionIndex,
-			SpecialTokensMask: output.SpecialTokensMask,
-			Offsets:           output.Offsets, // we need the offsets here for postprocessing later
-		}
-		if maxAttentionIndex > maxSequence {
-			maxSequence = maxAttentionIndex
+	p.observeTokenization(start, len(inputs))
+	batch := p.convertInputToTensors(outputs, maxSequence+1)
+	batch.NumInputs = len(inputs)
+	return batch
+}
+
+// PreprocessPairs preprocesses a batch of text pairs. Each pair is encoded as a single input, with the special
+// tokens and token type ids the model expects for two sequences. Pairs longer than the maximum length are truncated
+// following the truncation strategy of the pipeline.
+func (p *BasePipeline) PreprocessPairs(inputs [][2]string) PipelineBatch {
+	start := time.Now()
+
+	outputs := make([]TokenizedInput, len(inputs))
+	maxSequence := 0
+	for i, input := range inputs {
+		encoding, sequenceIds := p.encodePair(input[0], input[1])
+		outputs[i] = newTokenizedInput(input[0], encoding)
+		outputs[i].RawPair = input[1]
+		outputs[i].SequenceIds = sequenceIds
+		outputs[i].InputIndex = i
+		if outputs[i].MaxAttentionIndex > maxSequence {
+			maxSequence = outputs[i].MaxAttentionIndex
 		}
 	}
 
-	atomic.AddUint64(&p.TokenizerTimings.NumCalls, 1)
-	atomic.AddUint64(&p.TokenizerTimings.TotalNS, uint64(time.Since(start)))
+	p.observeTokenization(start, len(inputs))
 	batch := p.convertInputToTensors(outputs, maxSequence+1)
+	batch.NumInputs = len(inputs)
 	return batch
 }
 
+// PreprocessContext is Preprocess traced with a span, a child of the span of the context.
+func (p *BasePipeline) PreprocessContext(ctx context.Context, inputs []string) PipelineBatch {
+	_, span := p.startSpan(ctx, spanPreprocess, len(inputs))
+	batch := p.Preprocess(inputs)
+	endSpan(span, batch.MaxSequence, nil)
+	return batch
+}
+
+// PreprocessPairsContext is PreprocessPairs traced with a span, a child of the span of the context.
+func (p *BasePipeline) PreprocessPairsContext(ctx context.Context, inputs [][2]string) PipelineBatch {
+	_, span := p.startSpan(ctx, spanPreprocess, len(inputs))
+	batch := p.PreprocessPairs(inputs)
+	endSpan(span, batch.MaxSequence, nil)
+	return batch
+}
+
+func newTokenizedInput(input string, output tokenizer.Encoding) TokenizedInput {
+	maxAttentionIndex := 0
+	for j, attentionMaskValue := range output.AttentionMask {
+		if attentionMaskValue != 0 {
+			maxAttentionIndex = j
+		}
+	}
+
+	return TokenizedInput{
+		Raw:               input,
+		Tokens:            output.Tokens,
+		TokenIds:          output.IDs,
+		TypeIds:           output.TypeIDs,
+		AttentionMask:     output.AttentionMask,
+		MaxAttentionIndex: maxAttentionIndex,
+		SpecialTokensMask: output.SpecialTokensMask,
+		Offsets:           output.Offsets, // we need the offsets here for postprocessing later
+	}
+}
+
 func (p *BasePipeline) getInputTensors(batch PipelineBatch, actualBatchSize int64, maxSequence int64) ([]ort.ArbitraryTensor, error) {
 	inputTensors := make([]ort.ArbitraryTensor, len(p.InputsMeta))
 	var err error
@@ -254,40 +470,151 @@ func (p *BasePipeline) getInputTensors(batch PipelineBatch, actualBatchSize int6
 	return inputTensors, err
 }
 
-// Forward pass of the neural network on the tokenized input
+// getOutputTensors creates the tensor of the selected output of the model, with its declared element type. The other
+// outputs are left nil for onnxruntime to allocate, whatever their type and shape. Dynamic dimensions of the selected
+// output are resolved to the batch size for the first dimension and to the sequence length for the second, and if it
+// has other dynamic dimensions it is allocated by onnxruntime as well.
+func (p *BasePipeline) getOutputTensors(actualBatchSize int64, maxSequence int64) ([]ort.ArbitraryTensor, error) {
+	outputTensors := make([]ort.ArbitraryTensor, len(p.OutputsMeta))
+	output := p.outputMeta()
+	shape := make(ort.Shape, len(output.Dimensions))
+	for j, dimension := range output.Dimensions {
+		switch {
+		case dimension > 0:
+			shape[j] = dimension
+		case j == 0:
+			shape[j] = actualBatchSize
+		case j == 1:
+			shape[j] = maxSequence
+		default:
+			return outputTensors, nil
+		}
+	}
+
+	var tensor ort.ArbitraryTensor
+	var err error
+	switch output.DataType {
+	case ort.TensorElementDataTypeFloat:
+		tensor, err = newEmptyTensor[float32](shape)
+	case ort.TensorElementDataTypeDouble:
+		tensor, err = newEmptyTensor[float64](shape)
+	case ort.TensorElementDataTypeInt64:
+		tensor, err = newEmptyTensor[int64](shape)
+	case ort.TensorElementDataTypeInt32:
+		tensor, err = newEmptyTensor[int32](shape)
+	}
+	outputTensors[p.outputIndex] = tensor
+	return outputTensors, err
+}
+
+func newEmptyTensor[T ort.TensorData](shape ort.Shape) (ort.ArbitraryTensor, error) {
+	tensor, err := ort.NewEmptyTensor[T](shape)
+	if err != nil {
+		return nil, err
+	}
+	return tensor, nil
+}
+
+// tensorData copies the data of a numeric output tensor into a float32 slice. It returns nil for the other types.
+func tensorData(tensor ort.ArbitraryTensor) []float32 {
+	switch t := tensor.(type) {
+	case *ort.Tensor[float32]:
+		return append([]float32(nil), t.GetData()...)
+	case *ort.Tensor[float64]:
+		return convertTensorData(t.GetData())
+	case *ort.Tensor[int64]:
+		return convertTensorData(t.GetData())
+	case *ort.Tensor[int32]:
+		return convertTensorData(t.GetData())
+	}
+	return nil
+}
+
+func convertTensorData[T float64 | int64 | int32](data []T) []float32 {
+	converted := make([]float32, len(data))
+	for i, value := range data {
+		converted[i] = float32(value)
+	}
+	return converted
+}
+
+// Forward pass of the neural network on the tokenized input. The data of the selected output is set in OutputTensor,
+// and the data of all the numeric outputs in OutputTensors, converted to float32 (nil for outputs of other types). If the pipeline
+// has a token budget, the batch is run in length bucketed sub-batches.
 func (p *BasePipeline) Forward(batch PipelineBatch) (PipelineBatch, error) {
+	return p.ForwardContext(context.Background(), batch)
+}
+
+// ForwardContext is Forward with a context, which is checked before the forward pass and between sub-batches. The
+// forward pass is traced with a span, a child of the span of the context.
+func (p *BasePipeline) ForwardContext(ctx context.Context, batch PipelineBatch) (PipelineBatch, error) {
+	if err := ctx.Err(); err != nil {
+		return batch, err
+	}
+	ctx, span := p.startSpan(ctx, spanForward, len(batch.Input))
+	var err error
+	if p.MaxTokensPerBatch > 0 && len(batch.Input)*batch.MaxSequence > p.MaxTokensPerBatch {
+		batch, err = p.forwardBuckets(ctx, batch)
+	} else {
+		batch, err = p.forward(batch)
+	}
+	endSpan(span, batch.MaxSequence, err)
+	return batch, err
+}
+
+func (p *BasePipeline) forward(batch PipelineBatch) (PipelineBatch, error) {
 	start := time.Now()
+	tokens := 0
+	for _, input := range batch.Input {
+		tokens += input.MaxAttentionIndex + 1
+	}
 
 	actualBatchSize := int64(len(batch.Input))
 	maxSequence := int64(batch.MaxSequence)
 	inputTensors, err := p.getInputTensors(batch, actualBatchSize, maxSequence)
 	if err != nil {
+		p.observeForward(start, len(batch.Input), batch.MaxSequence, tokens, err)
 		return batch, err
 	}
 
-	outputTensor, err4 := ort.NewEmptyTensor[float32](ort.NewShape(actualBatchSize, maxSequence, int64(p.OutputDim)))
-	if err4 != nil {
-		return batch, err4
-	}
-
 	defer func(inputTensors []ort.ArbitraryTensor) {
 		for _, tensor := range inputTensors {
 			err = errors.Join(err, tensor.Destroy())
 		}
 	}(inputTensors)
 
+	outputTensors, errTensors := p.getOutputTensors(actualBatchSize, maxSequence)
+	// the outputs allocated by onnxruntime are set in the slice by Run
+	defer func(outputTensors []ort.ArbitraryTensor) {
+		for _, tensor := range outputTensors {
+			if tensor != nil {
+				err = errors.Join(err, tensor.Destroy())
+			}
+		}
+	}(outputTensors)
+	if errTensors != nil {
+		p.observeForward(start, len(batch.Input), batch.MaxSequence, tokens, errTensors)
+		return batch, errTensors
+	}
+
 	// Run Onnx model
-	errOnnx := p.OrtSession.Run(inputTensors, []ort.ArbitraryTensor{outputTensor})
+	errOnnx := p.OrtSession.Run(inputTensors, outputTensors)
 	if errOnnx != nil {
+		p.observeForward(start, len(batch.Input), batch.MaxSequence, tokens, errOnnx)
 		return batch, errOnnx
 	}
-	batch.OutputTensor = outputTensor.GetData()
-	defer func(outputTensor *ort.Tensor[float32]) {
-		err = errors.Join(err, outputTensor.Destroy())
-	}(outputTensor)
+	batch.OutputTensors = make([][]float32, len(outputTensors))
+	for i, outputTensor := range outputTensors {
+		batch.OutputTensors[i] = tensorData(outputTensor)
+	}
+	batch.OutputTensor = batch.OutputTensors[p.outputIndex]
+	if batch.OutputTensor == nil {
+		errType := fmt.Errorf("output %s has type %s, only numeric outputs are supported", p.outputMeta().Name, p.outputMeta().DataType)
+		p.observeForward(start, len(batch.Input), batch.MaxSequence, tokens, errType)
+		return batch, errType
+	}
 
-	atomic.AddUint64(&p.PipelineTimings.NumCalls, 1)
-	atomic.AddUint64(&p.PipelineTimings.TotalNS, uint64(time.Since(start)))
+	p.observeForward(start, len(batch.Input), batch.MaxSequence, tokens, nil)
 	return batch, err
 }
 
@@ -328,11 +655,3 @@ func (p *BasePipeline) convertInputToTensors(inputs []TokenizedInput, maxSequenc
 		MaxSequence:          maxSequence,
 	}
 }
-
-func (p *BasePipeline) GetStats() []string {
-	return []string{
-		fmt.Sprintf("Statistics for pipeline: %s", p.PipelineName),
-		fmt.Sprintf("Tokenizer: Total time=%s, Execution count=%d, Average query time=%s", time.Duration(p.TokenizerTimings.TotalNS), p.TokenizerTimings.NumCalls, time.Duration(float64(p.TokenizerTimings.TotalNS)/math.Max(1, float64(p.TokenizerTimings.NumCalls)))),
-		fmt.Sprintf("ONNX: Total time=%s, Execution count=%d, Average query time=%s", time.Duration(p.PipelineTimings.TotalNS), p.PipelineTimings.NumCalls, time.Duration(float64(p.PipelineTimings.TotalNS)/math.Max(1, float64(p.PipelineTimings.NumCalls)))),
-	}
-}
@@ -0,0 +1,399 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	ort "github.com/yalue/onnxruntime_go"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// QuestionAnsweringPipeline An extractive question answering pipeline is a go version of
+// https://github.com/huggingface/transformers/blob/main/src/transformers/pipelines/question_answering.py
+// Inputs are (question, context) pairs, and answers are spans of the context.
+
+// types
+
+type QuestionAnsweringPipeline struct {
+	BasePipeline
+	TopK                   int
+	MaxAnswerLength        int
+	MaxSequenceLength      int
+	DocStride              int
+	HandleImpossibleAnswer bool
+	startLogitsIndex       int
+	endLogitsIndex         int
+}
+
+type Answer struct {
+	Answer string
+	Score  float32
+	Start  uint
+	End    uint
+}
+
+type QuestionAnsweringOutput struct {
+	Answers [][]Answer
+}
+
+func (t *QuestionAnsweringOutput) GetOutput() []any {
+	out := make([]any, len(t.Answers))
+	for i, answers := range t.Answers {
+		out[i] = any(answers)
+	}
+	return out
+}
+
+// QuestionAnsweringFeature is a window of the context of an input, encoded together with the question.
+type QuestionAnsweringFeature struct {
+	InputIndex int
+	Encoding   TokenizedInput
+}
+
+// options
+
+// WithTopKAnswers sets the number of answers returned for each input. Default is 1.
+func WithTopKAnswers(topK int) PipelineOption[*QuestionAnsweringPipeline] {
+	return func(pipeline *QuestionAnsweringPipeline) {
+		pipeline.TopK = topK
+	}
+}
+
+// WithMaxAnswerLength sets the maximum length in tokens of the predicted answers. Default is 15.
+func WithMaxAnswerLength(maxAnswerLength int) PipelineOption[*QuestionAnsweringPipeline] {
+	return func(pipeline *QuestionAnsweringPipeline) {
+		pipeline.MaxAnswerLength = maxAnswerLength
+	}
+}
+
+// WithMaxSequenceLength sets the maximum length in tokens of the question and context window passed to the model.
+// Default is 384, or the model_max_length of the tokenizer if smaller.
+func WithMaxSequenceLength(maxSequenceLength int) PipelineOption[*QuestionAnsweringPipeline] {
+	return func(pipeline *QuestionAnsweringPipeline) {
+		pipeline.MaxSequenceLength = maxSequenceLength
+	}
+}
+
+// WithDocStride sets the number of overlapping tokens between the windows a long context is split into.
+// Default is 128, or half the maximum sequence length if smaller.
+func WithDocStride(docStride int) PipelineOption[*QuestionAnsweringPipeline] {
+	return func(pipeline *QuestionAnsweringPipeline) {
+		pipeline.DocStride = docStride
+	}
+}
+
+// WithHandleImpossibleAnswer allows the empty answer to be returned when the question cannot be answered
+// from the context.
+func WithHandleImpossibleAnswer() PipelineOption[*QuestionAnsweringPipeline] {
+	return func(pipeline *QuestionAnsweringPipeline) {
+		pipeline.HandleImpossibleAnswer = true
+	}
+}
+
+// NewQuestionAnsweringPipeline initializes a new question answering pipeline
+func NewQuestionAnsweringPipeline(config PipelineConfig[*QuestionAnsweringPipeline], ortOptions *ort.SessionOptions) (*QuestionAnsweringPipeline, error) {
+	pipeline := &QuestionAnsweringPipeline{}
+	pipeline.ModelPath = config.ModelPath
+	pipeline.PipelineName = config.Name
+	pipeline.OrtOptions = ortOptions
+	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.MaxTokensPerBatch = config.MaxTokensPerBatch
+	pipeline.GoTokenizer = config.GoTokenizer
+
+	for _, o := range config.Options {
+		o(pipeline)
+	}
+
+	// defaults, like in the python implementation
+	if pipeline.TopK == 0 {
+		pipeline.TopK = 1
+	}
+	if pipeline.MaxAnswerLength == 0 {
+		pipeline.MaxAnswerLength = 15
+	}
+
+	pipeline.PipelineTimings = &Timings{}
+	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
+
+	// load onnx model
+	loadErr := pipeline.loadModel()
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	// the window length defaults to the model maximum length if smaller than 384
+	if pipeline.MaxSequenceLength == 0 {
+		pipeline.MaxSequenceLength = 384
+		if pipeline.MaxLength > 0 && pipeline.MaxLength < pipeline.MaxSequenceLength {
+			pipeline.MaxSequenceLength = pipeline.MaxLength
+		}
+	}
+	if pipeline.DocStride == 0 {
+		pipeline.DocStride = 128
+		if pipeline.MaxSequenceLength/2 < pipeline.DocStride {
+			pipeline.DocStride = pipeline.MaxSequenceLength / 2
+		}
+	}
+
+	pipeline.startLogitsIndex = -1
+	pipeline.endLogitsIndex = -1
+	for i, output := range pipeline.OutputsMeta {
+		switch output.Name {
+		case "start_logits":
+			pipeline.startLogitsIndex = i
+		case "end_logits":
+			pipeline.endLogitsIndex = i
+		}
+	}
+	// the logits are [batch, sequence], one logit per token, or [batch, sequence, 1]
+	if pipeline.startLogitsIndex >= 0 {
+		dimensions := pipeline.OutputsMeta[pipeline.startLogitsIndex].Dimensions
+		switch len(dimensions) {
+		case 2:
+			pipeline.OutputDim = 1
+		case 3:
+			pipeline.OutputDim = int(dimensions[2])
+		}
+	}
+
+	// validate
+	validationErrors := pipeline.Validate()
+	if validationErrors != nil {
+		return nil, validationErrors
+	}
+
+	return pipeline, nil
+}
+
+func (p *QuestionAnsweringPipeline) Validate() error {
+	var validationErrors []error
+
+	if p.startLogitsIndex < 0 || p.endLogitsIndex < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: the model must have start_logits and end_logits outputs"))
+	} else if p.OutputDim != 1 {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: the start_logits output must have one logit per token, got outputDim %d", p.OutputDim))
+	}
+	if p.TopK <= 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: topK must be greater than zero"))
+	}
+	if p.MaxAnswerLength <= 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: maxAnswerLength must be greater than zero"))
+	}
+	if p.DocStride < 0 || p.DocStride >= p.MaxSequenceLength {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: docStride must be between zero and maxSequenceLength"))
+	}
+	return errors.Join(validationErrors...)
+}
+
+// Preprocess encodes the (question, context) pairs. Contexts that do not fit in the maximum sequence length
+// together with the question are split into overlapping windows, each of which becomes a feature in the batch.
+func (p *QuestionAnsweringPipeline) Preprocess(inputs [][2]string) (PipelineBatch, []QuestionAnsweringFeature, error) {
+	start := time.Now()
+
+	var features []QuestionAnsweringFeature
+	maxSequence := 0
+	for i, input := range inputs {
+		question := p.Tokenizer.Encode(input[0], false)
+		contextEncoding := p.Tokenizer.Encode(input[1], false)
+
+		windowLength := p.MaxSequenceLength - len(question.IDs) - p.postProcessor.numSpecialTokens()
+		if windowLength <= p.DocStride {
+			p.observeError(StageTokenization)
+			return PipelineBatch{}, nil, fmt.Errorf("question %s is too long to fit in the maximum sequence length %d with doc stride %d", input[0], p.MaxSequenceLength, p.DocStride)
+		}
+
+		for windowStart := 0; ; windowStart += windowLength - p.DocStride {
+			windowEnd := windowStart + windowLength
+			if windowEnd > len(contextEncoding.IDs) {
+				windowEnd = len(contextEncoding.IDs)
+			}
+			encoding, sequenceIds := p.postProcessor.mergePair(question, sliceEncoding(contextEncoding, windowStart, windowEnd))
+			feature := newTokenizedInput(input[0], encoding)
+			feature.RawPair = input[1]
+			feature.SequenceIds = sequenceIds
+			features = append(features, QuestionAnsweringFeature{InputIndex: i, Encoding: feature})
+			if feature.MaxAttentionIndex > maxSequence {
+				maxSequence = feature.MaxAttentionIndex
+			}
+			if windowEnd == len(contextEncoding.IDs) {
+				break
+			}
+		}
+	}
+
+	encodings := make([]TokenizedInput, len(features))
+	for i, feature := range features {
+		encodings[i] = feature.Encoding
+	}
+
+	p.observeTokenization(start, len(inputs))
+	return p.convertInputToTensors(encodings, maxSequence+1), features, nil
+}
+
+// Postprocess decodes the best answer spans of each feature from the start and end logits, and merges the
+// answers of the features of each input.
+func (p *QuestionAnsweringPipeline) Postprocess(batch PipelineBatch, features []QuestionAnsweringFeature, nInputs int) (_ *QuestionAnsweringOutput, err error) {
+	defer p.observePostprocess(time.Now(), &err)
+	candidates := make([][]Answer, nInputs)
+	minNullScores := make([]float32, nInputs)
+	for i := range minNullScores {
+		minNullScores[i] = 1
+	}
+
+	startLogits := batch.OutputTensors[p.startLogitsIndex]
+	endLogits := batch.OutputTensors[p.endLogitsIndex]
+	for i, feature := range features {
+		input := feature.Encoding
+		// tokens that cannot be part of an answer: question, special and padding tokens. The first token is kept
+		// for the softmax, as its score is the score of the impossible answer
+		undesired := make([]bool, batch.MaxSequence)
+		for j := 1; j < len(undesired); j++ {
+			undesired[j] = j >= len(input.SequenceIds) || input.SequenceIds[j] != 1 || input.AttentionMask[j] == 0
+		}
+
+		startScores := maskedSoftMax(startLogits[i*batch.MaxSequence:(i+1)*batch.MaxSequence], undesired)
+		endScores := maskedSoftMax(endLogits[i*batch.MaxSequence:(i+1)*batch.MaxSequence], undesired)
+
+		if p.HandleImpossibleAnswer {
+			nullScore := startScores[0] * endScores[0]
+			if nullScore < minNullScores[feature.InputIndex] {
+				minNullScores[feature.InputIndex] = nullScore
+			}
+		}
+		// the first token (usually CLS) is reserved for the impossible answer
+		startScores[0] = 0
+		endScores[0] = 0
+		undesired[0] = true
+
+		candidates[feature.InputIndex] = append(candidates[feature.InputIndex], p.decodeSpans(input, startScores, endScores, undesired)...)
+	}
+
+	output := QuestionAnsweringOutput{
+		Answers: make([][]Answer, nInputs),
+	}
+	for i, answers := range candidates {
+		if p.HandleImpossibleAnswer {
+			answers = append(answers, Answer{Score: minNullScores[i]})
+		}
+		sort.SliceStable(answers, func(a, b int) bool {
+			return answers[a].Score > answers[b].Score
+		})
+		if len(answers) > p.TopK {
+			answers = answers[:p.TopK]
+		}
+		output.Answers[i] = answers
+	}
+	return &output, nil
+}
+
+// decodeSpans finds the topK spans with the highest joint start and end probability, that end after they start
+// and are no longer than MaxAnswerLength tokens.
+func (p *QuestionAnsweringPipeline) decodeSpans(input TokenizedInput, startScores []float32, endScores []float32, undesired []bool) []Answer {
+	type span struct {
+		start int
+		end   int
+		score float32
+	}
+	var spans []span
+	for start := range startScores {
+		if undesired[start] {
+			continue
+		}
+		for end := start; end < len(endScores) && end < start+p.MaxAnswerLength; end++ {
+			if undesired[end] {
+				continue
+			}
+			spans = append(spans, span{start: start, end: end, score: startScores[start] * endScores[end]})
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].score > spans[j].score
+	})
+	if len(spans) > p.TopK {
+		spans = spans[:p.TopK]
+	}
+
+	answers := make([]Answer, len(spans))
+	for i, s := range spans {
+		startChar := input.Offsets[s.start][0]
+		endChar := input.Offsets[s.end][1]
+		answers[i] = Answer{
+			Answer: input.RawPair[startChar:endChar],
+			Score:  s.score,
+			Start:  startChar,
+			End:    endChar,
+		}
+	}
+	return answers
+}
+
+// maskedSoftMax computes the softmax of the logits, with the undesired positions set to a large negative value.
+func maskedSoftMax(logits []float32, undesired []bool) []float32 {
+	masked := make([]float32, len(logits))
+	for i, logit := range logits {
+		if undesired[i] {
+			masked[i] = -10000
+		} else {
+			masked[i] = logit
+		}
+	}
+	return util.SoftMax(masked)
+}
+
+// Run the pipeline on a string batch. Question answering needs (question, context) pairs, see RunPairs.
+func (p *QuestionAnsweringPipeline) Run(_ []string) (PipelineBatchOutput, error) {
+	return nil, errors.New("the question answering pipeline requires (question, context) pairs, use RunPairs")
+}
+
+// RunPairs runs the pipeline on a batch of (question, context) pairs.
+func (p *QuestionAnsweringPipeline) RunPairs(inputs [][2]string) (PipelineBatchOutput, error) {
+	return p.RunPipeline(inputs)
+}
+
+// RunContext is not supported as question answering needs (question, context) pairs, see RunPairsContext.
+func (p *QuestionAnsweringPipeline) RunContext(_ context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.Run(inputs)
+}
+
+// RunPairsContext runs the pipeline on a batch of (question, context) pairs, returning the error of the context if
+// it is done.
+func (p *QuestionAnsweringPipeline) RunPairsContext(ctx context.Context, inputs [][2]string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
+func (p *QuestionAnsweringPipeline) RunPipeline(inputs [][2]string) (*QuestionAnsweringOutput, error) {
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context between the tokenization, forward pass and postprocessing.
+func (p *QuestionAnsweringPipeline) RunPipelineContext(ctx context.Context, inputs [][2]string) (*QuestionAnsweringOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span := p.startSpan(ctx, spanPreprocess, len(inputs))
+	batch, features, err := p.Preprocess(inputs)
+	endSpan(span, batch.MaxSequence, err)
+	if err != nil {
+		return nil, err
+	}
+	batch, err = p.ForwardContext(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span = p.startSpan(ctx, spanPostprocess, len(inputs))
+	output, err := p.Postprocess(batch, features, len(inputs))
+	endSpan(span, batch.MaxSequence, err)
+	return output, err
+}
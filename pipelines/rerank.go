@@ -0,0 +1,188 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	ort "github.com/yalue/onnxruntime_go"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// RerankPipeline scores (query, document) pairs with a cross-encoder model, such as the ms-marco MiniLM models,
+// and returns the documents sorted by relevance to the query.
+
+// types
+
+type RerankPipeline struct {
+	BasePipeline
+	BatchSize int
+	Sigmoid   bool
+}
+
+type RerankResult struct {
+	Index    int
+	Document string
+	Score    float32
+}
+
+type RerankOutput struct {
+	Results []RerankResult
+}
+
+func (t *RerankOutput) GetOutput() []any {
+	out := make([]any, len(t.Results))
+	for i, result := range t.Results {
+		out[i] = any(result)
+	}
+	return out
+}
+
+// options
+
+// WithRerankBatchSize sets the number of (query, document) pairs sent to the model at once. Default is 32.
+func WithRerankBatchSize(batchSize int) PipelineOption[*RerankPipeline] {
+	return func(pipeline *RerankPipeline) {
+		pipeline.BatchSize = batchSize
+	}
+}
+
+// WithSigmoidScores applies a sigmoid to the logits of the model so that the scores are between 0 and 1.
+func WithSigmoidScores() PipelineOption[*RerankPipeline] {
+	return func(pipeline *RerankPipeline) {
+		pipeline.Sigmoid = true
+	}
+}
+
+// NewRerankPipeline initializes a new rerank pipeline
+func NewRerankPipeline(config PipelineConfig[*RerankPipeline], ortOptions *ort.SessionOptions) (*RerankPipeline, error) {
+	pipeline := &RerankPipeline{}
+	pipeline.ModelPath = config.ModelPath
+	pipeline.PipelineName = config.Name
+	pipeline.OrtOptions = ortOptions
+	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.OutputName = config.OutputName
+	pipeline.MaxLength = config.MaxLength
+	pipeline.Truncation = config.Truncation
+	pipeline.Stride = config.Stride
+	pipeline.MaxTokensPerBatch = config.MaxTokensPerBatch
+	pipeline.GoTokenizer = config.GoTokenizer
+
+	for _, o := range config.Options {
+		o(pipeline)
+	}
+
+	if pipeline.BatchSize == 0 {
+		pipeline.BatchSize = 32
+	}
+
+	pipeline.PipelineTimings = &Timings{}
+	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
+
+	// load onnx model
+	err := pipeline.loadModel()
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline.OutputDim = int(pipeline.outputMeta().Dimensions[1])
+
+	err = pipeline.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return pipeline, nil
+}
+
+func (p *RerankPipeline) Validate() error {
+	var validationErrors []error
+
+	if p.OutputDim <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: outputDim parameter must be greater than zero"))
+	}
+	if p.BatchSize <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: batch size must be greater than zero"))
+	}
+	validationErrors = append(validationErrors, p.validateTruncation(false)...)
+	return errors.Join(validationErrors...)
+}
+
+// Postprocess returns the relevance score of each pair of the batch. The score is the last logit of the model,
+// which for models with two labels is the logit of the relevant class.
+func (p *RerankPipeline) Postprocess(batch PipelineBatch) []float32 {
+	defer p.observePostprocess(time.Now(), nil)
+	scores := make([]float32, len(batch.Input))
+	for i := range batch.Input {
+		scores[i] = batch.OutputTensor[(i+1)*p.OutputDim-1]
+	}
+	if p.Sigmoid {
+		scores = util.Sigmoid(scores)
+	}
+	return scores
+}
+
+// Run is not supported as the rerank pipeline requires a query and a list of documents, use RunPipeline.
+func (p *RerankPipeline) Run(_ []string) (PipelineBatchOutput, error) {
+	return nil, errors.New("the rerank pipeline requires a query and a list of documents, use RunPipeline")
+}
+
+// RunContext is not supported as the rerank pipeline requires a query and a list of documents, use
+// RunPipelineContext.
+func (p *RerankPipeline) RunContext(_ context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.Run(inputs)
+}
+
+// RunPipeline scores each document against the query and returns the documents sorted by decreasing relevance,
+// together with their index in the input slice.
+func (p *RerankPipeline) RunPipeline(query string, documents []string) (*RerankOutput, error) {
+	return p.RunPipelineContext(context.Background(), query, documents)
+}
+
+// RunPipelineContext is RunPipeline with a context, which is checked between the batches of documents.
+func (p *RerankPipeline) RunPipelineContext(ctx context.Context, query string, documents []string) (*RerankOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	output := RerankOutput{Results: make([]RerankResult, 0, len(documents))}
+
+	for start := 0; start < len(documents); start += p.BatchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		end := start + p.BatchSize
+		if end > len(documents) {
+			end = len(documents)
+		}
+		pairs := make([][2]string, end-start)
+		for i, document := range documents[start:end] {
+			pairs[i] = [2]string{query, document}
+		}
+		batch, err := p.ForwardContext(ctx, p.PreprocessPairsContext(ctx, pairs))
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		_, span := p.startSpan(ctx, spanPostprocess, len(pairs))
+		scores := p.Postprocess(batch)
+		endSpan(span, batch.MaxSequence, nil)
+		for i, score := range scores {
+			output.Results = append(output.Results, RerankResult{
+				Index:    start + i,
+				Document: documents[start+i],
+				Score:    score,
+			})
+		}
+	}
+
+	sort.SliceStable(output.Results, func(i, j int) bool {
+		return output.Results[i].Score > output.Results[j].Score
+	})
+	return &output, nil
+}
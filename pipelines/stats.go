@@ -0,0 +1,108 @@
+package pipelines
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// StageStats are the statistics of the calls of a stage of the pipeline runs.
+type StageStats struct {
+	Calls   uint64        // the number of calls of the stage that succeeded
+	Errors  uint64        // the number of calls of the stage that returned an error
+	Total   time.Duration // the total duration of the calls
+	Average time.Duration // the average duration of a call
+	P50     time.Duration // the median duration of a call, estimated from the latency histogram
+	P95     time.Duration // the 95th percentile of the durations, estimated from the latency histogram
+	P99     time.Duration // the 99th percentile of the durations, estimated from the latency histogram
+}
+
+// PipelineStats are the statistics of the runs of a pipeline since it was created or its statistics were reset.
+type PipelineStats struct {
+	Tokenization StageStats
+	Forward      StageStats
+	Postprocess  StageStats
+	Inputs       uint64  // the number of inputs tokenized
+	Tokens       uint64  // the number of tokens of the forward passes, padding excluded
+	PaddingRatio float64 // the share of padding in the input tensors of the forward passes, between 0 and 1
+}
+
+// Stats returns the statistics of the runs of the pipeline. The calls and durations of the tokenization and forward
+// stages are those of the TokenizerTimings and PipelineTimings, the other statistics are only available for
+// pipelines created with metrics (see GetMetrics).
+func (p *BasePipeline) Stats() PipelineStats {
+	var stats PipelineStats
+	if p.TokenizerTimings != nil {
+		stats.Tokenization = newStageStats(atomic.LoadUint64(&p.TokenizerTimings.NumCalls), time.Duration(atomic.LoadUint64(&p.TokenizerTimings.TotalNS)))
+	}
+	if p.PipelineTimings != nil {
+		stats.Forward = newStageStats(atomic.LoadUint64(&p.PipelineTimings.NumCalls), time.Duration(atomic.LoadUint64(&p.PipelineTimings.TotalNS)))
+	}
+	if p.Metrics == nil {
+		return stats
+	}
+
+	postprocess := p.Metrics.Durations[StagePostprocess].Snapshot()
+	stats.Postprocess = newStageStats(postprocess.Count, time.Duration(postprocess.Sum*float64(time.Second)))
+	for stage, stageStats := range map[Stage]*StageStats{
+		StageTokenization: &stats.Tokenization,
+		StageForward:      &stats.Forward,
+		StagePostprocess:  &stats.Postprocess,
+	} {
+		snapshot := p.Metrics.Durations[stage].Snapshot()
+		stageStats.Errors = p.Metrics.Errors(stage)
+		stageStats.P50 = quantileDuration(snapshot, 0.5)
+		stageStats.P95 = quantileDuration(snapshot, 0.95)
+		stageStats.P99 = quantileDuration(snapshot, 0.99)
+	}
+
+	stats.Inputs = p.Metrics.Inputs()
+	stats.Tokens = p.Metrics.Tokens()
+	if padded := p.Metrics.PaddedTokens(); padded > stats.Tokens {
+		stats.PaddingRatio = float64(padded-stats.Tokens) / float64(padded)
+	}
+	return stats
+}
+
+// ResetStats resets the statistics of the pipeline: its timings and metrics.
+func (p *BasePipeline) ResetStats() {
+	for _, timings := range []*Timings{p.TokenizerTimings, p.PipelineTimings} {
+		if timings != nil {
+			atomic.StoreUint64(&timings.NumCalls, 0)
+			atomic.StoreUint64(&timings.TotalNS, 0)
+		}
+	}
+	if p.Metrics != nil {
+		p.Metrics.Reset()
+	}
+}
+
+// GetStats returns the statistics of the pipeline formatted for logging.
+func (p *BasePipeline) GetStats() []string {
+	stats := p.Stats()
+	return []string{
+		fmt.Sprintf("Statistics for pipeline: %s", p.PipelineName),
+		fmt.Sprintf("Tokenizer: %s", stats.Tokenization),
+		fmt.Sprintf("ONNX: %s", stats.Forward),
+		fmt.Sprintf("Postprocess: %s", stats.Postprocess),
+		fmt.Sprintf("Inputs: %d, Tokens: %d, Padding ratio: %.2f", stats.Inputs, stats.Tokens, stats.PaddingRatio),
+	}
+}
+
+// String formats the statistics of the stage.
+func (s StageStats) String() string {
+	return fmt.Sprintf("Total time=%s, Execution count=%d, Average query time=%s, p50=%s, p95=%s, p99=%s, Errors=%d",
+		s.Total, s.Calls, s.Average, s.P50, s.P95, s.P99, s.Errors)
+}
+
+func newStageStats(calls uint64, total time.Duration) StageStats {
+	stats := StageStats{Calls: calls, Total: total}
+	if calls > 0 {
+		stats.Average = total / time.Duration(calls)
+	}
+	return stats
+}
+
+func quantileDuration(snapshot HistogramSnapshot, q float64) time.Duration {
+	return time.Duration(snapshot.Quantile(q) * float64(time.Second))
+}
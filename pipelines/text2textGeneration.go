@@ -0,0 +1,548 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// Text2TextGenerationPipeline is a go version of
+// https://github.com/huggingface/transformers/blob/main/src/transformers/pipelines/text2text_generation.py
+// It runs encoder-decoder models such as T5, BART or Marian exported to onnx with optimum, where the encoder,
+// the decoder and the decoder with past key/values are separate graphs of the model folder. It is used for
+// summarization, translation and other text2text tasks.
+
+// types
+
+type Text2TextGenerationPipeline struct {
+	BasePipeline
+	DecoderFilename         string
+	DecoderWithPastFilename string
+	Decoder                 *OnnxGraph
+	DecoderWithPast         *OnnxGraph
+	MaxNewTokens            int
+	NumBeams                int
+	LengthPenalty           float32
+	NoRepeatNgramSize       int
+	DecoderStartTokenId     int64
+	EosTokenIds             []int64
+	ForcedBosTokenId        int64
+}
+
+type Text2TextGenerationOutput struct {
+	GeneratedTexts  []string
+	GeneratedTokens [][]uint32
+}
+
+func (t *Text2TextGenerationOutput) GetOutput() []any {
+	out := make([]any, len(t.GeneratedTexts))
+	for i, text := range t.GeneratedTexts {
+		out[i] = any(text)
+	}
+	return out
+}
+
+// beamHypothesis is a generated sequence, starting with the decoder start token, and its sum of log probabilities.
+type beamHypothesis struct {
+	tokens []int64
+	score  float32
+}
+
+// beamSearch is the state of the beam search for one input.
+type beamSearch struct {
+	beams    []beamHypothesis
+	finished []beamHypothesis
+	done     bool
+}
+
+// kvCacheEntry is a past key or value tensor of the decoder.
+type kvCacheEntry struct {
+	shape ort.Shape
+	data  []float32
+}
+
+// options
+
+// WithDecoderFilenames sets the names of the decoder and decoder with past onnx files of the model folder.
+// Defaults are decoder_model.onnx and decoder_with_past_model.onnx. The encoder file is set with the OnnxFilename
+// of the pipeline config, and defaults to encoder_model.onnx.
+func WithDecoderFilenames(decoder string, decoderWithPast string) PipelineOption[*Text2TextGenerationPipeline] {
+	return func(pipeline *Text2TextGenerationPipeline) {
+		pipeline.DecoderFilename = decoder
+		pipeline.DecoderWithPastFilename = decoderWithPast
+	}
+}
+
+// WithMaxNewTokens sets the maximum number of tokens to generate for each input. Default is 50.
+func WithMaxNewTokens(maxNewTokens int) PipelineOption[*Text2TextGenerationPipeline] {
+	return func(pipeline *Text2TextGenerationPipeline) {
+		pipeline.MaxNewTokens = maxNewTokens
+	}
+}
+
+// WithNumBeams sets the number of beams of the beam search. Default is 1, i.e. greedy decoding.
+func WithNumBeams(numBeams int) PipelineOption[*Text2TextGenerationPipeline] {
+	return func(pipeline *Text2TextGenerationPipeline) {
+		pipeline.NumBeams = numBeams
+	}
+}
+
+// WithLengthPenalty sets the exponent of the length normalization of the beam scores. Values above 1 favour
+// longer sequences, values below 1 shorter ones. Default is 1.
+func WithLengthPenalty(lengthPenalty float32) PipelineOption[*Text2TextGenerationPipeline] {
+	return func(pipeline *Text2TextGenerationPipeline) {
+		pipeline.LengthPenalty = lengthPenalty
+	}
+}
+
+// WithNoRepeatNgramSize prevents the generation of any ngram of this size twice. Default is 0 (disabled).
+func WithNoRepeatNgramSize(size int) PipelineOption[*Text2TextGenerationPipeline] {
+	return func(pipeline *Text2TextGenerationPipeline) {
+		pipeline.NoRepeatNgramSize = size
+	}
+}
+
+// NewText2TextGenerationPipeline initializes a new text2text generation pipeline
+func NewText2TextGenerationPipeline(config PipelineConfig[*Text2TextGenerationPipeline], ortOptions *ort.SessionOptions) (*Text2TextGenerationPipeline, error) {
+	pipeline := &Text2TextGenerationPipeline{}
+	pipeline.ModelPath = config.ModelPath
+	pipeline.PipelineName = config.Name
+	pipeline.OrtOptions = ortOptions
+	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.MaxLength = config.MaxLength
+	pipeline.Truncation = config.Truncation
+	pipeline.Stride = config.Stride
+	pipeline.GoTokenizer = config.GoTokenizer
+	pipeline.LengthPenalty = 1
+
+	for _, o := range config.Options {
+		o(pipeline)
+	}
+
+	if pipeline.OnnxFilename == "" {
+		pipeline.OnnxFilename = "encoder_model.onnx"
+	}
+	if pipeline.DecoderFilename == "" {
+		pipeline.DecoderFilename = "decoder_model.onnx"
+	}
+	if pipeline.DecoderWithPastFilename == "" {
+		pipeline.DecoderWithPastFilename = "decoder_with_past_model.onnx"
+	}
+	if pipeline.MaxNewTokens == 0 {
+		pipeline.MaxNewTokens = 50
+	}
+	if pipeline.NumBeams == 0 {
+		pipeline.NumBeams = 1
+	}
+
+	generationConfig, err := loadGenerationConfig(pipeline.ModelPath)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.EosTokenIds = generationConfig.eosTokenIds()
+	switch {
+	case generationConfig.DecoderStartTokenId != nil:
+		pipeline.DecoderStartTokenId = *generationConfig.DecoderStartTokenId
+	case generationConfig.PadTokenId != nil:
+		pipeline.DecoderStartTokenId = *generationConfig.PadTokenId
+	default:
+		return nil, fmt.Errorf("no decoder_start_token_id or pad_token_id found in the config of %s", pipeline.ModelPath)
+	}
+	pipeline.ForcedBosTokenId = -1
+	if generationConfig.ForcedBosTokenId != nil {
+		pipeline.ForcedBosTokenId = *generationConfig.ForcedBosTokenId
+	}
+
+	pipeline.PipelineTimings = &Timings{}
+	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
+
+	// load the encoder and the tokenizer
+	err = pipeline.loadModel()
+	if err != nil {
+		return nil, err
+	}
+	pipeline.OutputDim = int(pipeline.OutputsMeta[0].Dimensions[2])
+
+	// load the decoders
+	pipeline.Decoder, err = pipeline.loadOnnxGraph(pipeline.DecoderFilename)
+	if err != nil {
+		return nil, errors.Join(err, pipeline.Destroy())
+	}
+	pipeline.DecoderWithPast, err = pipeline.loadOnnxGraph(pipeline.DecoderWithPastFilename)
+	if err != nil {
+		return nil, errors.Join(err, pipeline.Destroy())
+	}
+
+	err = pipeline.Validate()
+	if err != nil {
+		return nil, errors.Join(err, pipeline.Destroy())
+	}
+
+	return pipeline, nil
+}
+
+func (p *Text2TextGenerationPipeline) Validate() error {
+	var validationErrors []error
+
+	if p.OutputDim <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: the hidden size of the encoder must be greater than zero"))
+	}
+	if len(p.EosTokenIds) == 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: no eos_token_id found in the model config"))
+	}
+	if p.MaxNewTokens <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: maxNewTokens must be greater than zero"))
+	}
+	if p.NumBeams <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: numBeams must be greater than zero"))
+	}
+	if p.NoRepeatNgramSize < 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: noRepeatNgramSize cannot be negative"))
+	}
+	validationErrors = append(validationErrors, p.validateTruncation(false)...)
+	return errors.Join(validationErrors...)
+}
+
+func (p *Text2TextGenerationPipeline) Destroy() error {
+	p.closeRuns()
+	var err error
+	if p.Decoder != nil {
+		err = errors.Join(err, p.Decoder.Destroy())
+	}
+	if p.DecoderWithPast != nil {
+		err = errors.Join(err, p.DecoderWithPast.Destroy())
+	}
+	return errors.Join(err, p.BasePipeline.Destroy())
+}
+
+// Generate decodes the output sequences from the encoder hidden states of the batch, computed by Forward.
+// Each input is expanded into NumBeams rows, and the key/value cache of the decoder is kept between steps and
+// reordered with the beams.
+func (p *Text2TextGenerationPipeline) Generate(batch PipelineBatch) (*Text2TextGenerationOutput, error) {
+	return p.GenerateContext(context.Background(), batch)
+}
+
+// GenerateContext is Generate with a context, which is checked before each decoding step.
+func (p *Text2TextGenerationPipeline) GenerateContext(ctx context.Context, batch PipelineBatch) (*Text2TextGenerationOutput, error) {
+	nInputs := len(batch.Input)
+	nBeams := p.NumBeams
+	rows := nInputs * nBeams
+	encoderLength := batch.MaxSequence
+	hiddenSize := p.OutputDim
+
+	// repeat the encoder outputs for each beam
+	encoderStates := make([]float32, rows*encoderLength*hiddenSize)
+	encoderMask := make([]int64, rows*encoderLength)
+	stateSize := encoderLength * hiddenSize
+	for i, input := range batch.Input {
+		for b := 0; b < nBeams; b++ {
+			row := i*nBeams + b
+			copy(encoderStates[row*stateSize:(row+1)*stateSize], batch.OutputTensor[i*stateSize:(i+1)*stateSize])
+			for j, mask := range input.AttentionMask {
+				encoderMask[row*encoderLength+j] = int64(mask)
+			}
+		}
+	}
+
+	searches := make([]*beamSearch, nInputs)
+	for i := range searches {
+		searches[i] = &beamSearch{beams: make([]beamHypothesis, nBeams)}
+		for b := range searches[i].beams {
+			searches[i].beams[b] = beamHypothesis{tokens: []int64{p.DecoderStartTokenId}}
+			if b > 0 {
+				// only the first beam is expanded at the first step, the others would be duplicates
+				searches[i].beams[b].score = -1e9
+			}
+		}
+	}
+
+	decoderCache := map[string]kvCacheEntry{}
+	encoderCache := map[string]kvCacheEntry{}
+
+	for step := 0; step < p.MaxNewTokens; step++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		inputIds := make([]int64, rows)
+		for i, search := range searches {
+			for b, beam := range search.beams {
+				inputIds[i*nBeams+b] = beam.tokens[len(beam.tokens)-1]
+			}
+		}
+
+		graph := p.Decoder
+		if step > 0 {
+			graph = p.DecoderWithPast
+		}
+		logits, err := p.decoderStep(graph, inputIds, encoderStates, encoderMask, encoderLength, hiddenSize, step, decoderCache, encoderCache)
+		if err != nil {
+			return nil, err
+		}
+
+		vocabSize := len(logits) / rows
+		sourceRows := make([]int, rows)
+		for i, search := range searches {
+			if search.done {
+				for b := range search.beams {
+					sourceRows[i*nBeams+b] = i*nBeams + b
+					search.beams[b].tokens = append(search.beams[b].tokens, p.DecoderStartTokenId)
+				}
+				continue
+			}
+			p.beamStep(search, logits[i*nBeams*vocabSize:(i+1)*nBeams*vocabSize], vocabSize, step, i*nBeams, sourceRows)
+		}
+
+		allDone := true
+		for _, search := range searches {
+			allDone = allDone && search.done
+		}
+		if allDone {
+			break
+		}
+
+		for name, entry := range decoderCache {
+			entry.data = gatherRows(entry.data, sourceRows)
+			decoderCache[name] = entry
+		}
+	}
+
+	output := Text2TextGenerationOutput{
+		GeneratedTexts:  make([]string, nInputs),
+		GeneratedTokens: make([][]uint32, nInputs),
+	}
+	for i, search := range searches {
+		if !search.done {
+			for _, beam := range search.beams {
+				p.addHypothesis(search, beam.tokens, beam.score, len(beam.tokens)-1)
+			}
+		}
+		best := search.finished[0]
+		tokens := make([]uint32, 0, len(best.tokens))
+		for _, token := range best.tokens[1:] {
+			if !containsToken(p.EosTokenIds, token) {
+				tokens = append(tokens, uint32(token))
+			}
+		}
+		output.GeneratedTokens[i] = tokens
+		output.GeneratedTexts[i] = strings.TrimSpace(p.Tokenizer.Decode(tokens, true))
+	}
+	return &output, nil
+}
+
+// decoderStep runs one step of the decoder graph and returns the logits of the last position for each row.
+// The present key/values are stored in the caches to be fed to the next step.
+func (p *Text2TextGenerationPipeline) decoderStep(graph *OnnxGraph, inputIds []int64, encoderStates []float32, encoderMask []int64,
+	encoderLength int, hiddenSize int, step int, decoderCache map[string]kvCacheEntry, encoderCache map[string]kvCacheEntry,
+) ([]float32, error) {
+	start := time.Now()
+	rows := int64(len(inputIds))
+
+	inputs := map[string]ort.ArbitraryTensor{}
+	var err error
+	addInput := func(name string, tensor ort.ArbitraryTensor, tensorErr error) {
+		if tensorErr != nil {
+			err = errors.Join(err, tensorErr)
+			return
+		}
+		inputs[name] = tensor
+	}
+	for _, meta := range graph.InputsMeta {
+		switch {
+		case meta.Name == "input_ids":
+			tensor, tensorErr := ort.NewTensor(ort.NewShape(rows, 1), inputIds)
+			addInput(meta.Name, tensor, tensorErr)
+		case meta.Name == "encoder_attention_mask":
+			tensor, tensorErr := ort.NewTensor(ort.NewShape(rows, int64(encoderLength)), encoderMask)
+			addInput(meta.Name, tensor, tensorErr)
+		case meta.Name == "encoder_hidden_states":
+			tensor, tensorErr := ort.NewTensor(ort.NewShape(rows, int64(encoderLength), int64(hiddenSize)), encoderStates)
+			addInput(meta.Name, tensor, tensorErr)
+		case strings.HasPrefix(meta.Name, "past_key_values"):
+			entry, ok := decoderCache[meta.Name]
+			if !ok {
+				entry, ok = encoderCache[meta.Name]
+			}
+			if !ok {
+				err = errors.Join(err, fmt.Errorf("no cached value for input %s of graph %s", meta.Name, graph.Filename))
+				continue
+			}
+			tensor, tensorErr := ort.NewTensor(entry.shape, entry.data)
+			addInput(meta.Name, tensor, tensorErr)
+		}
+	}
+	if err != nil {
+		for _, tensor := range inputs {
+			err = errors.Join(err, tensor.Destroy())
+		}
+		return nil, err
+	}
+
+	outputShape := func(meta ort.InputOutputInfo) (ort.Shape, error) {
+		switch {
+		case meta.Name == "logits":
+			return resolveShape(meta, rows, 1)
+		case strings.Contains(meta.Name, ".encoder."):
+			return resolveShape(meta, rows, int64(encoderLength))
+		case strings.HasPrefix(meta.Name, "present"):
+			return resolveShape(meta, rows, int64(step+1))
+		default:
+			return resolveShape(meta, rows, 1)
+		}
+	}
+
+	outputs, err := runGraph(graph, inputs, outputShape)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, meta := range graph.OutputsMeta {
+		name, ok := pastName(meta.Name)
+		if !ok {
+			continue
+		}
+		shape, _ := outputShape(meta)
+		entry := kvCacheEntry{shape: shape, data: outputs[meta.Name]}
+		if strings.Contains(meta.Name, ".encoder.") {
+			// the cross attention key/values only depend on the encoder and are computed at the first step
+			if _, cached := encoderCache[name]; !cached {
+				encoderCache[name] = entry
+			}
+		} else {
+			decoderCache[name] = entry
+		}
+	}
+
+	logits, ok := outputs["logits"]
+	if !ok {
+		return nil, fmt.Errorf("graph %s has no logits output", graph.Filename)
+	}
+
+	p.observeForward(start, int(rows), step+1, int(rows)*(step+1), nil)
+	return logits, nil
+}
+
+// beamStep extends the beams of one input with the logits of the step. The source row of each new beam is written
+// to sourceRows so that the key/value cache can be reordered.
+func (p *Text2TextGenerationPipeline) beamStep(search *beamSearch, logits []float32, vocabSize int, step int, firstRow int, sourceRows []int) {
+	nBeams := len(search.beams)
+
+	type candidate struct {
+		beam  int
+		token int64
+		score float32
+	}
+	var candidates []candidate
+
+	for b, beam := range search.beams {
+		scores := logSoftMax(logits[b*vocabSize : (b+1)*vocabSize])
+		if step == 0 && p.ForcedBosTokenId >= 0 {
+			for token := range scores {
+				if int64(token) != p.ForcedBosTokenId {
+					scores[token] = float32(math.Inf(-1))
+				}
+			}
+		}
+		for _, token := range bannedNgramTokens(beam.tokens, p.NoRepeatNgramSize) {
+			scores[token] = float32(math.Inf(-1))
+		}
+		for _, token := range topTokens(scores, 2*nBeams) {
+			candidates = append(candidates, candidate{beam: b, token: int64(token), score: beam.score + scores[token]})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	nextBeams := make([]beamHypothesis, 0, nBeams)
+	for rank, c := range candidates {
+		if len(nextBeams) == nBeams {
+			break
+		}
+		tokens := search.beams[c.beam].tokens
+		if containsToken(p.EosTokenIds, c.token) {
+			// the hypothesis is finished, unless the eos token is not among the best nBeams candidates
+			if rank < nBeams {
+				p.addHypothesis(search, tokens, c.score, len(tokens))
+			}
+			continue
+		}
+		newTokens := make([]int64, len(tokens), len(tokens)+1)
+		copy(newTokens, tokens)
+		sourceRows[firstRow+len(nextBeams)] = firstRow + c.beam
+		nextBeams = append(nextBeams, beamHypothesis{tokens: append(newTokens, c.token), score: c.score})
+	}
+	for len(nextBeams) < nBeams {
+		// not enough candidates to fill the beams, e.g. with a tiny vocabulary: pad with a beam that cannot win
+		sourceRows[firstRow+len(nextBeams)] = firstRow
+		nextBeams = append(nextBeams, beamHypothesis{tokens: append([]int64{}, search.beams[0].tokens...), score: -1e9})
+	}
+	search.beams = nextBeams
+
+	if len(search.finished) >= nBeams {
+		if nBeams == 1 {
+			search.done = true
+			return
+		}
+		// no running beam can improve on the worst finished hypothesis anymore
+		bestRunning := search.beams[0].score / float32(math.Pow(float64(step+1), float64(p.LengthPenalty)))
+		search.done = bestRunning <= search.finished[len(search.finished)-1].score
+	}
+}
+
+// addHypothesis adds a finished sequence with its length normalized score, keeping the NumBeams best sequences.
+func (p *Text2TextGenerationPipeline) addHypothesis(search *beamSearch, tokens []int64, score float32, generatedLength int) {
+	if generatedLength < 1 {
+		generatedLength = 1
+	}
+	normalized := score / float32(math.Pow(float64(generatedLength), float64(p.LengthPenalty)))
+	search.finished = append(search.finished, beamHypothesis{tokens: tokens, score: normalized})
+	sort.SliceStable(search.finished, func(i, j int) bool {
+		return search.finished[i].score > search.finished[j].score
+	})
+	if len(search.finished) > p.NumBeams {
+		search.finished = search.finished[:p.NumBeams]
+	}
+}
+
+// Run the pipeline on a string batch
+func (p *Text2TextGenerationPipeline) Run(inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipeline(inputs)
+}
+
+// RunContext runs the pipeline on a string batch, returning the error of the context if it is done.
+func (p *Text2TextGenerationPipeline) RunContext(ctx context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
+func (p *Text2TextGenerationPipeline) RunPipeline(inputs []string) (*Text2TextGenerationOutput, error) {
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context between the tokenization and the forward pass of the encoder, and before
+// each decoding step.
+func (p *Text2TextGenerationPipeline) RunPipelineContext(ctx context.Context, inputs []string) (*Text2TextGenerationOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batch := p.PreprocessContext(ctx, inputs)
+	batch, err := p.ForwardContext(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	// the decoding is traced as a second forward stage, as each step runs the decoder
+	ctx, span := p.startSpan(ctx, spanForward, len(inputs))
+	output, err := p.GenerateContext(ctx, batch)
+	endSpan(span, batch.MaxSequence, err)
+	return output, err
+}
@@ -1,15 +1,14 @@
 package pipelines
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"sync/atomic"
 	"time"
 
 	util "github.com/knights-analytics/hugot/utils"
 
 	jsoniter "github.com/json-iterator/go"
-	"github.com/knights-analytics/tokenizers"
 	ort "github.com/yalue/onnxruntime_go"
 )
 
@@ -20,6 +19,7 @@ type TextClassificationPipeline struct {
 	IdLabelMap              map[int]string
 	AggregationFunctionName string
 	ProblemType             string
+	WindowAggregation       string
 }
 
 type TextClassificationPipelineConfig struct {
@@ -71,6 +71,20 @@ func WithMultiLabel() PipelineOption[*TextClassificationPipeline] {
 	}
 }
 
+// WithMeanWindowScores averages the scores of the windows of inputs longer than the maximum length. This is the default.
+func WithMeanWindowScores() PipelineOption[*TextClassificationPipeline] {
+	return func(pipeline *TextClassificationPipeline) {
+		pipeline.WindowAggregation = "MEAN"
+	}
+}
+
+// WithMaxWindowScores takes the maximum score of each class over the windows of inputs longer than the maximum length.
+func WithMaxWindowScores() PipelineOption[*TextClassificationPipeline] {
+	return func(pipeline *TextClassificationPipeline) {
+		pipeline.WindowAggregation = "MAX"
+	}
+}
+
 // NewTextClassificationPipeline initializes a new text classification pipeline
 func NewTextClassificationPipeline(config PipelineConfig[*TextClassificationPipeline], ortOptions *ort.SessionOptions) (*TextClassificationPipeline, error) {
 	pipeline := &TextClassificationPipeline{}
@@ -78,6 +92,12 @@ func NewTextClassificationPipeline(config PipelineConfig[*TextClassificationPipe
 	pipeline.PipelineName = config.Name
 	pipeline.OrtOptions = ortOptions
 	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.OutputName = config.OutputName
+	pipeline.MaxLength = config.MaxLength
+	pipeline.Truncation = config.Truncation
+	pipeline.Stride = config.Stride
+	pipeline.MaxTokensPerBatch = config.MaxTokensPerBatch
+	pipeline.GoTokenizer = config.GoTokenizer
 
 	for _, o := range config.Options {
 		o(pipeline)
@@ -86,6 +106,9 @@ func NewTextClassificationPipeline(config PipelineConfig[*TextClassificationPipe
 	if pipeline.ProblemType == "" {
 		pipeline.ProblemType = "singleLabel"
 	}
+	if pipeline.WindowAggregation == "" {
+		pipeline.WindowAggregation = "MEAN"
+	}
 	if pipeline.AggregationFunctionName == "" {
 		if pipeline.PipelineName == "singleLabel" {
 			pipeline.AggregationFunctionName = "SOFTMAX"
@@ -94,10 +117,6 @@ func NewTextClassificationPipeline(config PipelineConfig[*TextClassificationPipe
 		}
 	}
 
-	pipeline.TokenizerOptions = []tokenizers.EncodeOption{
-		tokenizers.WithReturnAttentionMask(),
-	}
-
 	configPath := util.PathJoinSafe(pipeline.ModelPath, "config.json")
 	pipelineInputConfig := TextClassificationPipelineConfig{}
 	mapBytes, err := util.ReadFileBytes(configPath)
@@ -112,6 +131,7 @@ func NewTextClassificationPipeline(config PipelineConfig[*TextClassificationPipe
 	pipeline.IdLabelMap = pipelineInputConfig.IdLabelMap
 	pipeline.PipelineTimings = &Timings{}
 	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
 
 	// load onnx model
 	loadErr := pipeline.loadModel()
@@ -119,7 +139,7 @@ func NewTextClassificationPipeline(config PipelineConfig[*TextClassificationPipe
 		return nil, loadErr
 	}
 
-	pipeline.OutputDim = int(pipeline.OutputsMeta[0].Dimensions[1])
+	pipeline.OutputDim = int(pipeline.outputMeta().Dimensions[1])
 
 	// validate
 	validationErrors := pipeline.Validate()
@@ -145,47 +165,12 @@ func (p *TextClassificationPipeline) Validate() error {
 	if len(p.IdLabelMap) != p.OutputDim {
 		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: length of id2label map does not match model output dimension"))
 	}
+	validationErrors = append(validationErrors, p.validateTruncation(true)...)
 	return errors.Join(validationErrors...)
 }
 
-func (p *TextClassificationPipeline) Forward(batch PipelineBatch) (PipelineBatch, error) {
-	start := time.Now()
-
-	actualBatchSize := int64(len(batch.Input))
-	maxSequence := int64(batch.MaxSequence)
-	inputTensors, err := p.getInputTensors(batch, actualBatchSize, maxSequence)
-	if err != nil {
-		return batch, err
-	}
-
-	defer func(inputTensors []ort.ArbitraryTensor) {
-		for _, tensor := range inputTensors {
-			err = errors.Join(err, tensor.Destroy())
-		}
-	}(inputTensors)
-
-	outputTensor, errTensor := ort.NewEmptyTensor[float32](ort.NewShape(actualBatchSize, int64(p.OutputDim)))
-	if errTensor != nil {
-		return batch, errTensor
-	}
-
-	defer func(outputTensor *ort.Tensor[float32]) {
-		err = errors.Join(err, outputTensor.Destroy())
-	}(outputTensor)
-
-	// Run Onnx model
-	errOnnx := p.OrtSession.Run(inputTensors, []ort.ArbitraryTensor{outputTensor})
-	if errOnnx != nil {
-		return batch, errOnnx
-	}
-	batch.OutputTensor = outputTensor.GetData()
-
-	atomic.AddUint64(&p.PipelineTimings.NumCalls, 1)
-	atomic.AddUint64(&p.PipelineTimings.TotalNS, uint64(time.Since(start)))
-	return batch, err
-}
-
-func (p *TextClassificationPipeline) Postprocess(batch PipelineBatch) (*TextClassificationOutput, error) {
+func (p *TextClassificationPipeline) Postprocess(batch PipelineBatch) (_ *TextClassificationOutput, err error) {
+	defer p.observePostprocess(time.Now(), &err)
 	outputTensor := batch.OutputTensor
 	output := make([][]float32, len(batch.Input))
 	inputCounter := 0
@@ -214,13 +199,14 @@ func (p *TextClassificationPipeline) Postprocess(batch PipelineBatch) (*TextClas
 		}
 	}
 
+	// the scores of the windows of long inputs are aggregated into the scores of the input
+	output = aggregateWindows(output, batch.inputRows(), p.WindowAggregation)
+
 	batchClassificationOutputs := TextClassificationOutput{
-		ClassificationOutputs: make([][]ClassificationOutput, len(batch.Input)),
+		ClassificationOutputs: make([][]ClassificationOutput, len(output)),
 	}
 
-	var err error
-
-	for i := 0; i < len(batch.Input); i++ {
+	for i := 0; i < len(output); i++ {
 		switch p.ProblemType {
 		case "singleLabel":
 			inputClassificationOutputs := make([]ClassificationOutput, 1)
@@ -263,11 +249,72 @@ func (p *TextClassificationPipeline) Run(inputs []string) (PipelineBatchOutput,
 	return p.RunPipeline(inputs)
 }
 
+// RunContext runs the pipeline on a string batch, returning the error of the context if it is done.
+func (p *TextClassificationPipeline) RunContext(ctx context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
 func (p *TextClassificationPipeline) RunPipeline(inputs []string) (*TextClassificationOutput, error) {
-	batch := p.Preprocess(inputs)
-	batch, err := p.Forward(batch)
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context between the tokenization, forward pass and postprocessing.
+func (p *TextClassificationPipeline) RunPipelineContext(ctx context.Context, inputs []string) (*TextClassificationOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batch := p.PreprocessContext(ctx, inputs)
+	batch, err := p.ForwardContext(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span := p.startSpan(ctx, spanPostprocess, batch.NumInputs)
+	output, err := p.Postprocess(batch)
+	endSpan(span, batch.MaxSequence, err)
+	return output, err
+}
+
+// RunPairs runs the pipeline on a batch of text pairs, for models such as NLI or paraphrase classifiers that
+// expect the two sequences with the separator layout and token type ids of the tokenizer pair encoding.
+func (p *TextClassificationPipeline) RunPairs(inputs [][2]string) (PipelineBatchOutput, error) {
+	return p.RunPairsPipeline(inputs)
+}
+
+// RunPairsContext runs the pipeline on a batch of text pairs, returning the error of the context if it is done.
+func (p *TextClassificationPipeline) RunPairsContext(ctx context.Context, inputs [][2]string) (PipelineBatchOutput, error) {
+	return p.RunPairsPipelineContext(ctx, inputs)
+}
+
+func (p *TextClassificationPipeline) RunPairsPipeline(inputs [][2]string) (*TextClassificationOutput, error) {
+	return p.RunPairsPipelineContext(context.Background(), inputs)
+}
+
+// RunPairsPipelineContext checks the context between the tokenization, forward pass and postprocessing.
+func (p *TextClassificationPipeline) RunPairsPipelineContext(ctx context.Context, inputs [][2]string) (*TextClassificationOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batch := p.PreprocessPairsContext(ctx, inputs)
+	batch, err := p.ForwardContext(ctx, batch)
 	if err != nil {
 		return nil, err
 	}
-	return p.Postprocess(batch)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span := p.startSpan(ctx, spanPostprocess, batch.NumInputs)
+	output, err := p.Postprocess(batch)
+	endSpan(span, batch.MaxSequence, err)
+	return output, err
 }
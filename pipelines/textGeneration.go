@@ -0,0 +1,609 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// TextGenerationPipeline is a go version of
+// https://github.com/huggingface/transformers/blob/main/src/transformers/pipelines/text_generation.py
+// It runs decoder-only models such as GPT-2, Llama or Qwen exported to onnx. Three layouts of the model folder are
+// supported: a decoder_model.onnx and decoder_with_past_model.onnx pair, a single graph with past key/values inputs
+// (optionally merged with a use_cache_branch input), and a single graph without past key/values, in which case the
+// whole sequence is recomputed at each step.
+
+// types
+
+type TextGenerationPipeline struct {
+	BasePipeline
+	DecoderWithPast   *OnnxGraph
+	MaxNewTokens      int
+	Temperature       float32
+	TopK              int
+	TopP              float32
+	RepetitionPenalty float32
+	Seed              *int64
+	StopSequences     []string
+	EosTokenIds       []int64
+	decoder           *OnnxGraph
+	hasPast           bool
+	hasUseCacheBranch bool
+}
+
+// GeneratedToken is a token streamed during generation, with the text it adds to the output.
+type GeneratedToken struct {
+	Id   uint32
+	Text string
+}
+
+type TextGenerationOutput struct {
+	GeneratedTexts  []string
+	GeneratedTokens [][]uint32
+}
+
+func (t *TextGenerationOutput) GetOutput() []any {
+	out := make([]any, len(t.GeneratedTexts))
+	for i, text := range t.GeneratedTexts {
+		out[i] = any(text)
+	}
+	return out
+}
+
+// options
+
+// WithMaxTokens sets the maximum number of tokens generated after the prompt. Default is 50.
+func WithMaxTokens(maxTokens int) PipelineOption[*TextGenerationPipeline] {
+	return func(pipeline *TextGenerationPipeline) {
+		pipeline.MaxNewTokens = maxTokens
+	}
+}
+
+// WithTemperature enables sampling with the given temperature. Without any of WithTemperature, WithTopK and
+// WithTopP the pipeline decodes greedily.
+func WithTemperature(temperature float32) PipelineOption[*TextGenerationPipeline] {
+	return func(pipeline *TextGenerationPipeline) {
+		pipeline.Temperature = temperature
+	}
+}
+
+// WithTopK enables sampling among the k most likely tokens.
+func WithTopK(topK int) PipelineOption[*TextGenerationPipeline] {
+	return func(pipeline *TextGenerationPipeline) {
+		pipeline.TopK = topK
+	}
+}
+
+// WithTopP enables nucleus sampling among the most likely tokens whose cumulative probability reaches topP.
+func WithTopP(topP float32) PipelineOption[*TextGenerationPipeline] {
+	return func(pipeline *TextGenerationPipeline) {
+		pipeline.TopP = topP
+	}
+}
+
+// WithRepetitionPenalty penalizes the tokens already present in the sequence. Default is 1 (no penalty).
+func WithRepetitionPenalty(penalty float32) PipelineOption[*TextGenerationPipeline] {
+	return func(pipeline *TextGenerationPipeline) {
+		pipeline.RepetitionPenalty = penalty
+	}
+}
+
+// WithSeed makes sampling deterministic: each generation starts from a random generator with this seed.
+func WithSeed(seed int64) PipelineOption[*TextGenerationPipeline] {
+	return func(pipeline *TextGenerationPipeline) {
+		pipeline.Seed = &seed
+	}
+}
+
+// WithStopSequences stops the generation as soon as the generated text contains one of the sequences. The stop
+// sequence is not included in the output.
+func WithStopSequences(stopSequences []string) PipelineOption[*TextGenerationPipeline] {
+	return func(pipeline *TextGenerationPipeline) {
+		pipeline.StopSequences = stopSequences
+	}
+}
+
+// NewTextGenerationPipeline initializes a new text generation pipeline
+func NewTextGenerationPipeline(config PipelineConfig[*TextGenerationPipeline], ortOptions *ort.SessionOptions) (*TextGenerationPipeline, error) {
+	pipeline := &TextGenerationPipeline{}
+	pipeline.ModelPath = config.ModelPath
+	pipeline.PipelineName = config.Name
+	pipeline.OrtOptions = ortOptions
+	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.GoTokenizer = config.GoTokenizer
+	pipeline.RepetitionPenalty = 1
+
+	for _, o := range config.Options {
+		o(pipeline)
+	}
+
+	if pipeline.MaxNewTokens == 0 {
+		pipeline.MaxNewTokens = 50
+	}
+
+	generationConfig, err := loadGenerationConfig(pipeline.ModelPath)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.EosTokenIds = generationConfig.eosTokenIds()
+
+	// use the decoder and decoder with past pair if the folder has one and no file was chosen
+	withPast := false
+	if pipeline.OnnxFilename == "" {
+		onnxFiles, err := getOnnxFiles(pipeline.ModelPath)
+		if err != nil {
+			return nil, err
+		}
+		var hasDecoder, hasDecoderWithPast bool
+		for _, file := range onnxFiles {
+			hasDecoder = hasDecoder || file[1] == "decoder_model.onnx"
+			hasDecoderWithPast = hasDecoderWithPast || file[1] == "decoder_with_past_model.onnx"
+		}
+		if hasDecoder && hasDecoderWithPast {
+			pipeline.OnnxFilename = "decoder_model.onnx"
+			withPast = true
+		}
+	}
+
+	pipeline.PipelineTimings = &Timings{}
+	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
+
+	// load onnx model
+	err = pipeline.loadModel()
+	if err != nil {
+		return nil, err
+	}
+	pipeline.decoder = &OnnxGraph{
+		Filename:    pipeline.OnnxFilename,
+		OrtSession:  pipeline.OrtSession,
+		InputsMeta:  pipeline.InputsMeta,
+		OutputsMeta: pipeline.OutputsMeta,
+	}
+	if withPast {
+		pipeline.DecoderWithPast, err = pipeline.loadOnnxGraph("decoder_with_past_model.onnx")
+		if err != nil {
+			return nil, errors.Join(err, pipeline.Destroy())
+		}
+	}
+
+	for _, meta := range pipeline.InputsMeta {
+		pipeline.hasPast = pipeline.hasPast || strings.HasPrefix(meta.Name, "past_key_values")
+		pipeline.hasUseCacheBranch = pipeline.hasUseCacheBranch || meta.Name == "use_cache_branch"
+	}
+	for _, meta := range pipeline.OutputsMeta {
+		if meta.Name == "logits" {
+			pipeline.OutputDim = int(meta.Dimensions[len(meta.Dimensions)-1])
+		}
+	}
+
+	err = pipeline.Validate()
+	if err != nil {
+		return nil, errors.Join(err, pipeline.Destroy())
+	}
+
+	return pipeline, nil
+}
+
+func (p *TextGenerationPipeline) Validate() error {
+	var validationErrors []error
+
+	if p.OutputDim <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: the model must have a logits output with a fixed vocabulary size"))
+	}
+	if len(p.EosTokenIds) == 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: no eos_token_id found in the model config"))
+	}
+	if p.MaxNewTokens <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: maxTokens must be greater than zero"))
+	}
+	if p.Temperature < 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: temperature cannot be negative"))
+	}
+	if p.TopK < 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: topK cannot be negative"))
+	}
+	if p.TopP < 0 || p.TopP > 1 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: topP must be between 0 and 1"))
+	}
+	if p.RepetitionPenalty <= 0 {
+		validationErrors = append(validationErrors, errors.New("pipeline configuration invalid: repetitionPenalty must be greater than zero"))
+	}
+	return errors.Join(validationErrors...)
+}
+
+func (p *TextGenerationPipeline) Destroy() error {
+	p.closeRuns()
+	var err error
+	if p.DecoderWithPast != nil {
+		err = p.DecoderWithPast.Destroy()
+	}
+	return errors.Join(err, p.BasePipeline.Destroy())
+}
+
+// sampling reports whether tokens are sampled rather than chosen greedily.
+func (p *TextGenerationPipeline) sampling() bool {
+	return p.Temperature > 0 || p.TopK > 0 || p.TopP > 0
+}
+
+// Stream generates the continuation of the prompt and calls callback with each token as soon as its text is
+// decoded. Returning an error from the callback stops the generation. It returns the generated text and tokens.
+func (p *TextGenerationPipeline) Stream(prompt string, callback func(GeneratedToken) error) (string, []uint32, error) {
+	return p.StreamContext(context.Background(), prompt, callback)
+}
+
+// StreamContext is Stream with a context, which is checked before each generated token. When the context is done
+// the generation stops and the error of the context is returned with the text generated so far, as are the errors of
+// the callback and of the decoder.
+func (p *TextGenerationPipeline) StreamContext(ctx context.Context, prompt string, callback func(GeneratedToken) error) (string, []uint32, error) {
+	if err := p.startRun(); err != nil {
+		return "", nil, err
+	}
+	defer p.endRun()
+	_, span := p.startSpan(ctx, spanPreprocess, 1)
+	start := time.Now()
+	encoding := p.Tokenizer.Encode(prompt, true)
+	p.observeTokenization(start, 1)
+	endSpan(span, len(encoding.IDs), nil)
+
+	if len(encoding.IDs) == 0 {
+		return "", nil, errors.New("the prompt cannot be empty")
+	}
+
+	sequence := make([]int64, len(encoding.IDs))
+	for i, id := range encoding.IDs {
+		sequence[i] = int64(id)
+	}
+
+	// the generation loop is traced as the forward stage, as each step runs the decoder
+	ctx, span = p.startSpan(ctx, spanForward, 1)
+	text, generated, err := p.generate(ctx, sequence, callback)
+	endSpan(span, len(sequence)+len(generated), err)
+	return text, generated, err
+}
+
+// generate runs the decoder on the sequence of the prompt until the end of the generation.
+func (p *TextGenerationPipeline) generate(ctx context.Context, sequence []int64, callback func(GeneratedToken) error) (string, []uint32, error) {
+	var rng *rand.Rand
+	if p.Seed != nil {
+		rng = rand.New(rand.NewSource(*p.Seed))
+	} else {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	promptLength := len(sequence)
+	var generated []uint32
+	emitted := 0
+	text := ""
+	cache := map[string]kvCacheEntry{}
+	// a masked slot of the cache when the graph needs past key/values at the first step
+	pastOffset := 0
+
+	for step := 0; step < p.MaxNewTokens; step++ {
+		if err := ctx.Err(); err != nil {
+			return text, generated, err
+		}
+		logits, offset, err := p.decoderStep(sequence, step, promptLength, cache, pastOffset)
+		if err != nil {
+			return text, generated, err
+		}
+		pastOffset = offset
+
+		token := p.nextToken(logits, sequence, rng)
+		if containsToken(p.EosTokenIds, token) {
+			break
+		}
+		sequence = append(sequence, token)
+		generated = append(generated, uint32(token))
+
+		text = p.Tokenizer.Decode(generated, true)
+		stopIndex, safeLength := p.findStop(text)
+		if stopIndex >= 0 {
+			text = text[:stopIndex]
+			safeLength = stopIndex
+		}
+		if safeLength > emitted && !strings.HasSuffix(text[:safeLength], "�") {
+			if err := callback(GeneratedToken{Id: uint32(token), Text: text[emitted:safeLength]}); err != nil {
+				return text, generated, err
+			}
+			emitted = safeLength
+		}
+		if stopIndex >= 0 {
+			return text, generated, nil
+		}
+	}
+
+	// flush the text held back for a possible stop sequence
+	if len(text) > emitted && len(generated) > 0 {
+		if err := callback(GeneratedToken{Id: generated[len(generated)-1], Text: text[emitted:]}); err != nil {
+			return text, generated, err
+		}
+	}
+	return text, generated, nil
+}
+
+// StreamChannel runs StreamContext in a goroutine and sends the tokens on the returned channel, which is closed at
+// the end of the generation. The error channel receives the result of the generation (nil on success). The caller
+// must either drain the token channel or cancel the context: until then the generation holds the pipeline, and
+// closing the pipeline or the session waits for it.
+func (p *TextGenerationPipeline) StreamChannel(ctx context.Context, prompt string) (<-chan GeneratedToken, <-chan error) {
+	tokens := make(chan GeneratedToken)
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := p.StreamContext(ctx, prompt, func(token GeneratedToken) error {
+			select {
+			case tokens <- token:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(tokens)
+		errs <- err
+		close(errs)
+	}()
+	return tokens, errs
+}
+
+// findStop returns the index of the first stop sequence in the text (-1 if none), and the length of the text that
+// can safely be emitted, i.e. excluding a suffix that could be the beginning of a stop sequence.
+func (p *TextGenerationPipeline) findStop(text string) (int, int) {
+	stopIndex := -1
+	for _, stop := range p.StopSequences {
+		if stop == "" {
+			continue
+		}
+		if index := strings.Index(text, stop); index >= 0 && (stopIndex < 0 || index < stopIndex) {
+			stopIndex = index
+		}
+	}
+	safeLength := len(text)
+	for _, stop := range p.StopSequences {
+		for prefixLength := len(stop) - 1; prefixLength > 0; prefixLength-- {
+			if strings.HasSuffix(text, stop[:prefixLength]) {
+				if len(text)-prefixLength < safeLength {
+					safeLength = len(text) - prefixLength
+				}
+				break
+			}
+		}
+	}
+	return stopIndex, safeLength
+}
+
+// decoderStep runs the decoder on the new tokens of the sequence and returns the logits of the last position.
+// It returns the number of masked slots at the start of the cache.
+func (p *TextGenerationPipeline) decoderStep(sequence []int64, step int, promptLength int, cache map[string]kvCacheEntry, pastOffset int) ([]float32, int, error) {
+	start := time.Now()
+
+	graph := p.decoder
+	if step > 0 && p.DecoderWithPast != nil {
+		graph = p.DecoderWithPast
+	}
+	useCache := step > 0 && (p.hasPast || p.DecoderWithPast != nil)
+
+	// tokens that are not in the cache yet
+	newTokens := sequence
+	pastLength := 0
+	if useCache {
+		newTokens = sequence[len(sequence)-1:]
+		pastLength = pastOffset + len(sequence) - 1
+	} else {
+		pastOffset = 0
+	}
+	graphHasPast := false
+	for _, meta := range graph.InputsMeta {
+		graphHasPast = graphHasPast || strings.HasPrefix(meta.Name, "past_key_values")
+	}
+	if graphHasPast && !useCache && !p.hasUseCacheBranch {
+		// the graph requires past key/values at the first step: feed a single slot that is masked out
+		pastOffset = 1
+		pastLength = 1
+	}
+	currentLength := len(newTokens)
+	totalLength := pastLength + currentLength
+	realPast := len(sequence) - currentLength
+
+	inputs := map[string]ort.ArbitraryTensor{}
+	var err error
+	addInput := func(name string, tensor ort.ArbitraryTensor, tensorErr error) {
+		if tensorErr != nil {
+			err = errors.Join(err, tensorErr)
+			return
+		}
+		inputs[name] = tensor
+	}
+	for _, meta := range graph.InputsMeta {
+		switch {
+		case meta.Name == "input_ids":
+			tensor, tensorErr := ort.NewTensor(ort.NewShape(1, int64(currentLength)), append([]int64{}, newTokens...))
+			addInput(meta.Name, tensor, tensorErr)
+		case meta.Name == "attention_mask":
+			mask := make([]int64, totalLength)
+			for i := pastOffset; i < totalLength; i++ {
+				mask[i] = 1
+			}
+			tensor, tensorErr := ort.NewTensor(ort.NewShape(1, int64(len(mask))), mask)
+			addInput(meta.Name, tensor, tensorErr)
+		case meta.Name == "position_ids":
+			positions := make([]int64, currentLength)
+			for i := range positions {
+				positions[i] = int64(realPast + i)
+			}
+			tensor, tensorErr := ort.NewTensor(ort.NewShape(1, int64(currentLength)), positions)
+			addInput(meta.Name, tensor, tensorErr)
+		case meta.Name == "use_cache_branch":
+			value := byte(0)
+			if useCache {
+				value = 1
+			}
+			tensor, tensorErr := ort.NewCustomDataTensor(ort.NewShape(1), []byte{value}, ort.TensorElementDataTypeBool)
+			addInput(meta.Name, tensor, tensorErr)
+		case strings.HasPrefix(meta.Name, "past_key_values"):
+			entry, ok := cache[meta.Name]
+			if !ok || !useCache {
+				// first step: a single zero slot, masked out or ignored by the graph
+				shape, shapeErr := resolveShape(meta, 1, 1)
+				if shapeErr != nil {
+					err = errors.Join(err, shapeErr)
+					continue
+				}
+				entry = kvCacheEntry{shape: shape, data: make([]float32, shape.FlattenedSize())}
+			}
+			tensor, tensorErr := ort.NewTensor(entry.shape, entry.data)
+			addInput(meta.Name, tensor, tensorErr)
+		}
+	}
+	if err != nil {
+		for _, tensor := range inputs {
+			err = errors.Join(err, tensor.Destroy())
+		}
+		return nil, pastOffset, err
+	}
+
+	outputShape := func(meta ort.InputOutputInfo) (ort.Shape, error) {
+		if strings.HasPrefix(meta.Name, "present") {
+			return resolveShape(meta, 1, int64(totalLength))
+		}
+		return resolveShape(meta, 1, int64(currentLength))
+	}
+
+	outputs, err := runGraph(graph, inputs, outputShape)
+	if err != nil {
+		return nil, pastOffset, err
+	}
+	for _, meta := range graph.OutputsMeta {
+		if name, ok := pastName(meta.Name); ok {
+			shape, _ := outputShape(meta)
+			cache[name] = kvCacheEntry{shape: shape, data: outputs[meta.Name]}
+		}
+	}
+
+	logits, ok := outputs["logits"]
+	if !ok {
+		return nil, pastOffset, fmt.Errorf("graph %s has no logits output", graph.Filename)
+	}
+
+	p.observeForward(start, 1, totalLength, totalLength, nil)
+	return logits[(currentLength-1)*p.OutputDim : currentLength*p.OutputDim], pastOffset, nil
+}
+
+// nextToken applies the repetition penalty to the logits and picks the next token, greedily or by sampling.
+func (p *TextGenerationPipeline) nextToken(logits []float32, sequence []int64, rng *rand.Rand) int64 {
+	scores := make([]float32, len(logits))
+	copy(scores, logits)
+
+	if p.RepetitionPenalty != 1 {
+		penalized := map[int64]bool{}
+		for _, token := range sequence {
+			if penalized[token] || token < 0 || int(token) >= len(scores) {
+				continue
+			}
+			penalized[token] = true
+			if scores[token] < 0 {
+				scores[token] *= p.RepetitionPenalty
+			} else {
+				scores[token] /= p.RepetitionPenalty
+			}
+		}
+	}
+
+	if !p.sampling() {
+		return int64(topTokens(scores, 1)[0])
+	}
+
+	temperature := p.Temperature
+	if temperature == 0 {
+		temperature = 1
+	}
+
+	// candidate tokens sorted by decreasing score
+	var candidates []int
+	if p.TopK > 0 {
+		candidates = topTokens(scores, p.TopK)
+	} else {
+		candidates = make([]int, len(scores))
+		for i := range candidates {
+			candidates[i] = i
+		}
+		if p.TopP > 0 && p.TopP < 1 {
+			sort.Slice(candidates, func(i, j int) bool { return scores[candidates[i]] > scores[candidates[j]] })
+		}
+	}
+
+	maxScore := scores[candidates[0]]
+	for _, token := range candidates {
+		if scores[token] > maxScore {
+			maxScore = scores[token]
+		}
+	}
+	probabilities := make([]float64, len(candidates))
+	total := 0.0
+	for i, token := range candidates {
+		probabilities[i] = math.Exp(float64((scores[token] - maxScore) / temperature))
+		total += probabilities[i]
+	}
+
+	if p.TopP > 0 && p.TopP < 1 {
+		cumulative := 0.0
+		for i := range probabilities {
+			cumulative += probabilities[i] / total
+			if cumulative >= float64(p.TopP) {
+				probabilities = probabilities[:i+1]
+				break
+			}
+		}
+		total = 0
+		for _, probability := range probabilities {
+			total += probability
+		}
+	}
+
+	r := rng.Float64() * total
+	for i, probability := range probabilities {
+		r -= probability
+		if r <= 0 {
+			return int64(candidates[i])
+		}
+	}
+	return int64(candidates[len(probabilities)-1])
+}
+
+// Run the pipeline on a string batch. Each prompt is generated in turn and the output contains the generated
+// text without the prompt.
+func (p *TextGenerationPipeline) Run(inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipeline(inputs)
+}
+
+// RunContext runs the pipeline on a string batch, returning the error of the context if it is done.
+func (p *TextGenerationPipeline) RunContext(ctx context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
+func (p *TextGenerationPipeline) RunPipeline(inputs []string) (*TextGenerationOutput, error) {
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context before each generated token.
+func (p *TextGenerationPipeline) RunPipelineContext(ctx context.Context, inputs []string) (*TextGenerationOutput, error) {
+	output := TextGenerationOutput{
+		GeneratedTexts:  make([]string, len(inputs)),
+		GeneratedTokens: make([][]uint32, len(inputs)),
+	}
+	for i, input := range inputs {
+		text, tokens, err := p.StreamContext(ctx, input, func(GeneratedToken) error { return nil })
+		if err != nil {
+			return nil, err
+		}
+		output.GeneratedTexts[i] = text
+		output.GeneratedTokens[i] = tokens
+	}
+	return &output, nil
+}
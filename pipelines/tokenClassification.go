@@ -1,19 +1,23 @@
 package pipelines
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	// according to https://freshman.tech/snippets/go/check-if-slice-contains-element
 	"golang.org/x/exp/slices"
 
 	ort "github.com/yalue/onnxruntime_go"
 
+	"github.com/knights-analytics/hugot/tokenizer"
 	util "github.com/knights-analytics/hugot/utils"
 
 	jsoniter "github.com/json-iterator/go"
-	"github.com/knights-analytics/tokenizers"
 )
 
 // types
@@ -23,6 +27,9 @@ type TokenClassificationPipeline struct {
 	IdLabelMap          map[int]string
 	AggregationStrategy string
 	IgnoreLabels        []string
+	subwordPrefix       bool   // the model of the tokenizer marks the tokens continuing a word, e.g. ## in WordPiece
+	unkToken            string // the unknown token of the model of the tokenizer
+	splitChineseChars   bool   // the normalizer of the tokenizer makes each CJK character a word
 }
 
 type TokenClassificationPipelineConfig struct {
@@ -39,6 +46,7 @@ type Entity struct {
 	Start     uint
 	End       uint
 	IsSubword bool
+	tokenIds  []uint32 // the tokens of the word, for entities aggregated over words
 }
 
 type TokenClassificationOutput struct {
@@ -55,6 +63,15 @@ func (t *TokenClassificationOutput) GetOutput() []any {
 
 // options
 
+// WithAggregationStrategy sets the strategy used to aggregate the token predictions into entities. It can be
+// NONE, SIMPLE, FIRST, AVERAGE or MAX, as in the transformers token classification pipeline. FIRST, AVERAGE and MAX
+// first aggregate the tokens of each word, so that all the subwords of a word share the same entity.
+func WithAggregationStrategy(strategy string) PipelineOption[*TokenClassificationPipeline] {
+	return func(pipeline *TokenClassificationPipeline) {
+		pipeline.AggregationStrategy = strings.ToUpper(strategy)
+	}
+}
+
 func WithSimpleAggregation() PipelineOption[*TokenClassificationPipeline] {
 	return func(pipeline *TokenClassificationPipeline) {
 		pipeline.AggregationStrategy = "SIMPLE"
@@ -67,6 +84,27 @@ func WithoutAggregation() PipelineOption[*TokenClassificationPipeline] {
 	}
 }
 
+// WithFirstAggregation labels each word with the prediction of its first token.
+func WithFirstAggregation() PipelineOption[*TokenClassificationPipeline] {
+	return func(pipeline *TokenClassificationPipeline) {
+		pipeline.AggregationStrategy = "FIRST"
+	}
+}
+
+// WithAverageAggregation labels each word with the average of the scores of its tokens.
+func WithAverageAggregation() PipelineOption[*TokenClassificationPipeline] {
+	return func(pipeline *TokenClassificationPipeline) {
+		pipeline.AggregationStrategy = "AVERAGE"
+	}
+}
+
+// WithMaxAggregation labels each word with the prediction of its token with the highest score.
+func WithMaxAggregation() PipelineOption[*TokenClassificationPipeline] {
+	return func(pipeline *TokenClassificationPipeline) {
+		pipeline.AggregationStrategy = "MAX"
+	}
+}
+
 func WithIgnoreLabels(ignoreLabels []string) PipelineOption[*TokenClassificationPipeline] {
 	return func(pipeline *TokenClassificationPipeline) {
 		pipeline.IgnoreLabels = ignoreLabels
@@ -80,19 +118,16 @@ func NewTokenClassificationPipeline(config PipelineConfig[*TokenClassificationPi
 	pipeline.PipelineName = config.Name
 	pipeline.OrtOptions = ortOptions
 	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.OutputName = config.OutputName
+	pipeline.MaxLength = config.MaxLength
+	pipeline.Truncation = config.Truncation
+	pipeline.Stride = config.Stride
+	pipeline.MaxTokensPerBatch = config.MaxTokensPerBatch
+	pipeline.GoTokenizer = config.GoTokenizer
 	for _, o := range config.Options {
 		o(pipeline)
 	}
 
-	// inputs and encoding options
-	pipeline.TokenizerOptions = []tokenizers.EncodeOption{
-		tokenizers.WithReturnTokens(),
-		tokenizers.WithReturnTypeIDs(),
-		tokenizers.WithReturnAttentionMask(),
-		tokenizers.WithReturnSpecialTokensMask(),
-		tokenizers.WithReturnOffsets(),
-	}
-
 	// load json model config and set pipeline settings
 	configPath := util.PathJoinSafe(config.ModelPath, "config.json")
 	pipelineInputConfig := TokenClassificationPipelineConfig{}
@@ -109,6 +144,7 @@ func NewTokenClassificationPipeline(config PipelineConfig[*TokenClassificationPi
 
 	pipeline.PipelineTimings = &Timings{}
 	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
 
 	// defaults
 
@@ -126,7 +162,12 @@ func NewTokenClassificationPipeline(config PipelineConfig[*TokenClassificationPi
 	}
 
 	// the dimension of the output is taken from the output meta.
-	pipeline.OutputDim = int(pipeline.OutputsMeta[0].Dimensions[2])
+	pipeline.OutputDim = int(pipeline.outputMeta().Dimensions[2])
+
+	err = pipeline.loadWordBoundaries()
+	if err != nil {
+		return nil, err
+	}
 
 	err = pipeline.Validate()
 	if err != nil {
@@ -135,6 +176,73 @@ func NewTokenClassificationPipeline(config PipelineConfig[*TokenClassificationPi
 	return pipeline, nil
 }
 
+type wordNormalizerJSON struct {
+	Type               string                `json:"type"`
+	HandleChineseChars *bool                 `json:"handle_chinese_chars"`
+	Normalizers        []*wordNormalizerJSON `json:"normalizers"`
+}
+
+type wordModelJSON struct {
+	UnkToken                *string             `json:"unk_token"`
+	UnkID                   *int                `json:"unk_id"`
+	Vocab                   jsoniter.RawMessage `json:"vocab"`
+	ContinuingSubwordPrefix *string             `json:"continuing_subword_prefix"`
+}
+
+// loadWordBoundaries reads from the tokenizer what GatherPreEntities needs to tell the words apart: whether the
+// model has a continuing subword prefix, its unknown token, and whether the normalizer is a BERT normalizer that
+// handles Chinese characters, which puts spaces around the CJK characters so that each of them is a word.
+func (p *TokenClassificationPipeline) loadWordBoundaries() error {
+	tokenizerBytes, err := tokenizer.ReadJSON(p.ModelPath)
+	if err != nil {
+		return err
+	}
+	tokenizerConfig := struct {
+		Normalizer *wordNormalizerJSON `json:"normalizer"`
+		Model      *wordModelJSON      `json:"model"`
+	}{}
+	if err := jsoniter.Unmarshal(tokenizerBytes, &tokenizerConfig); err != nil {
+		return err
+	}
+
+	if model := tokenizerConfig.Model; model != nil {
+		p.subwordPrefix = model.ContinuingSubwordPrefix != nil && *model.ContinuingSubwordPrefix != ""
+		switch {
+		case model.UnkToken != nil:
+			p.unkToken = *model.UnkToken
+		case model.UnkID != nil:
+			// the vocabulary of unigram models is a list of [piece, score]
+			var vocab [][]any
+			if err := jsoniter.Unmarshal(model.Vocab, &vocab); err != nil {
+				return err
+			}
+			if *model.UnkID >= 0 && *model.UnkID < len(vocab) && len(vocab[*model.UnkID]) > 0 {
+				p.unkToken, _ = vocab[*model.UnkID][0].(string)
+			}
+		}
+	}
+
+	var splits func(normalizer *wordNormalizerJSON) bool
+	splits = func(normalizer *wordNormalizerJSON) bool {
+		if normalizer == nil {
+			return false
+		}
+		switch normalizer.Type {
+		case "BertNormalizer":
+			return normalizer.HandleChineseChars == nil || *normalizer.HandleChineseChars
+		case "Sequence":
+			for _, n := range normalizer.Normalizers {
+				if splits(n) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	p.splitChineseChars = splits(tokenizerConfig.Normalizer)
+	return nil
+}
+
 func (p *TokenClassificationPipeline) Validate() error {
 	var validationErrors []error
 
@@ -147,11 +255,16 @@ func (p *TokenClassificationPipeline) Validate() error {
 	if len(p.IdLabelMap) != p.OutputDim {
 		validationErrors = append(validationErrors, fmt.Errorf("p configuration invalid: length of id2label map does not match model output dimension"))
 	}
+	if !slices.Contains([]string{"NONE", "SIMPLE", "FIRST", "AVERAGE", "MAX"}, p.AggregationStrategy) {
+		validationErrors = append(validationErrors, fmt.Errorf("p configuration invalid: aggregation strategy %s is not supported", p.AggregationStrategy))
+	}
+	validationErrors = append(validationErrors, p.validateTruncation(true)...)
 	return errors.Join(validationErrors...)
 }
 
 // Postprocess function for a token classification pipeline
-func (p *TokenClassificationPipeline) Postprocess(batch PipelineBatch) (*TokenClassificationOutput, error) {
+func (p *TokenClassificationPipeline) Postprocess(batch PipelineBatch) (_ *TokenClassificationOutput, err error) {
+	defer p.observePostprocess(time.Now(), &err)
 
 	outputs := make([][][]float32, len(batch.Input))        // holds the final output
 	inputVectors := make([][]float32, 0, batch.MaxSequence) // holds the embeddings of each original token (no padding) for an input
@@ -192,13 +305,30 @@ func (p *TokenClassificationPipeline) Postprocess(batch PipelineBatch) (*TokenCl
 		}
 	}
 
-	// now convert the logits to the predictions of actual entities
+	// now convert the logits to the predictions of actual entities. The windows of inputs longer than the maximum
+	// length are merged first, averaging the predictions of the tokens in the overlap of two windows.
+	rows := batch.inputRows()
 	classificationOutput := TokenClassificationOutput{
-		Entities: make([][]Entity, len(batch.Input)),
+		Entities: make([][]Entity, len(rows)),
 	}
 
-	for i, input := range batch.Input {
-		preEntities := p.GatherPreEntities(input, outputs[i])
+	for i, inputRows := range rows {
+		input := batch.Input[inputRows[0]]
+		scores := outputs[inputRows[0]]
+		if len(inputRows) > 1 {
+			var positions [][][2]int
+			input, positions = mergeWindows(batch, inputRows)
+			scores = make([][]float32, len(positions))
+			for j, tokenPositions := range positions {
+				scores[j] = make([]float32, p.OutputDim)
+				for _, position := range tokenPositions {
+					for k, score := range outputs[position[0]][position[1]] {
+						scores[j][k] += score / float32(len(tokenPositions))
+					}
+				}
+			}
+		}
+		preEntities := p.GatherPreEntities(input, scores)
 		entities, errAggregate := p.Aggregate(input, preEntities)
 		if errAggregate != nil {
 			return nil, errAggregate
@@ -215,7 +345,11 @@ func (p *TokenClassificationPipeline) Postprocess(batch PipelineBatch) (*TokenCl
 	return &classificationOutput, nil
 }
 
-// GatherPreEntities from batch of logits to list of pre-aggregated outputs
+// GatherPreEntities from batch of logits to list of pre-aggregated outputs. The tokenizer does not expose the word
+// ids of the tokens, so subwords are found as in the python implementation: with a continuing subword prefix, e.g.
+// ## in WordPiece, a token is a subword when it differs in length from its text in the input. Otherwise it is a
+// subword when there is no whitespace around its start, so that the tokens of sentencepiece and byte level
+// tokenizers, whose offsets include the space before a word, start a new word. Unknown tokens are never subwords.
 func (p *TokenClassificationPipeline) GatherPreEntities(input TokenizedInput, output [][]float32) []Entity {
 
 	sentence := input.Raw
@@ -227,16 +361,20 @@ func (p *TokenClassificationPipeline) GatherPreEntities(input TokenizedInput, ou
 		if input.SpecialTokensMask[j] > 0.0 {
 			continue
 		}
-		// TODO: the python code uses id_to_token to get the token here which is a method on the rust tokenizer, check if it's better
 		word := input.Tokens[j]
 		tokenId := input.TokenIds[j]
-		// TODO: the determination of subword can probably be better done by exporting the words field from the tokenizer directly
 		startInd := input.Offsets[j][0]
 		endInd := input.Offsets[j][1]
 		wordRef := sentence[startInd:endInd]
-		isSubword := len(word) != len(wordRef)
-		// TODO: check for unknown token here, it's in the config and can be loaded and compared with the token
-		// in that case set the subword as in the python code
+		var isSubword bool
+		switch {
+		case p.unkToken != "" && word == p.unkToken:
+			word = wordRef
+		case p.subwordPrefix:
+			isSubword = utf8.RuneCountInString(word) != utf8.RuneCountInString(wordRef)
+		default:
+			isSubword = p.continuesWord(sentence, int(startInd))
+		}
 		preEntities = append(preEntities, Entity{
 			Word:      word,
 			TokenId:   tokenId,
@@ -250,9 +388,37 @@ func (p *TokenClassificationPipeline) GatherPreEntities(input TokenizedInput, ou
 	return preEntities
 }
 
+// continuesWord reports whether the token starting at the index continues the word before it, i.e. there is no
+// whitespace before or at its start. With a BERT normalizer, CJK characters are words of their own.
+func (p *TokenClassificationPipeline) continuesWord(sentence string, start int) bool {
+	if start == 0 {
+		return false
+	}
+	previous, _ := utf8.DecodeLastRuneInString(sentence[:start])
+	current, _ := utf8.DecodeRuneInString(sentence[start:])
+	if unicode.IsSpace(previous) || unicode.IsSpace(current) {
+		return false
+	}
+	return !p.splitChineseChars || (!isChineseChar(previous) && !isChineseChar(current))
+}
+
+// isChineseChar reports whether the rune is in the CJK Unicode blocks, as the BERT normalizer checks it.
+func isChineseChar(r rune) bool {
+	return (r >= 0x4E00 && r <= 0x9FFF) ||
+		(r >= 0x3400 && r <= 0x4DBF) ||
+		(r >= 0x20000 && r <= 0x2A6DF) ||
+		(r >= 0x2A700 && r <= 0x2B73F) ||
+		(r >= 0x2B740 && r <= 0x2B81F) ||
+		(r >= 0x2B920 && r <= 0x2CEAF) ||
+		(r >= 0xF900 && r <= 0xFAFF) ||
+		(r >= 0x2F800 && r <= 0x2FA1F)
+}
+
 func (p *TokenClassificationPipeline) Aggregate(input TokenizedInput, preEntities []Entity) ([]Entity, error) {
-	entities := make([]Entity, len(preEntities))
-	if p.AggregationStrategy == "SIMPLE" || p.AggregationStrategy == "NONE" {
+	var entities []Entity
+	switch p.AggregationStrategy {
+	case "SIMPLE", "NONE":
+		entities = make([]Entity, len(preEntities))
 		for i, preEntity := range preEntities {
 			entityIdx, score, argMaxErr := util.ArgMax(preEntity.Scores)
 			if argMaxErr != nil {
@@ -272,8 +438,14 @@ func (p *TokenClassificationPipeline) Aggregate(input TokenizedInput, preEntitie
 				End:     preEntity.End,
 			}
 		}
-	} else {
-		return nil, errors.New("aggregation strategies other than SIMPLE and NONE are not implemented")
+	case "FIRST", "AVERAGE", "MAX":
+		var errAggregate error
+		entities, errAggregate = p.aggregateWords(input, preEntities)
+		if errAggregate != nil {
+			return nil, errAggregate
+		}
+	default:
+		return nil, fmt.Errorf("aggregation strategy %s is not implemented", p.AggregationStrategy)
 	}
 	if p.AggregationStrategy == "NONE" {
 		return entities, nil
@@ -281,6 +453,86 @@ func (p *TokenClassificationPipeline) Aggregate(input TokenizedInput, preEntitie
 	return p.GroupEntities(entities)
 }
 
+// aggregateWords groups the tokens of each word and aggregates them into a single entity.
+func (p *TokenClassificationPipeline) aggregateWords(input TokenizedInput, preEntities []Entity) ([]Entity, error) {
+	var wordEntities []Entity
+	var wordGroup []Entity
+	for _, preEntity := range preEntities {
+		if len(wordGroup) > 0 && !preEntity.IsSubword {
+			wordEntity, err := p.aggregateWord(input, wordGroup)
+			if err != nil {
+				return nil, err
+			}
+			wordEntities = append(wordEntities, wordEntity)
+			wordGroup = nil
+		}
+		wordGroup = append(wordGroup, preEntity)
+	}
+	if len(wordGroup) > 0 {
+		wordEntity, err := p.aggregateWord(input, wordGroup)
+		if err != nil {
+			return nil, err
+		}
+		wordEntities = append(wordEntities, wordEntity)
+	}
+	return wordEntities, nil
+}
+
+// aggregateWord determines the entity of a word from the scores of its tokens following the aggregation strategy.
+func (p *TokenClassificationPipeline) aggregateWord(input TokenizedInput, entities []Entity) (Entity, error) {
+	var scores []float32
+	switch p.AggregationStrategy {
+	case "FIRST":
+		scores = entities[0].Scores
+	case "MAX":
+		maxScore := float32(-1)
+		for _, entity := range entities {
+			_, score, err := util.ArgMax(entity.Scores)
+			if err != nil {
+				return Entity{}, err
+			}
+			if score > maxScore {
+				maxScore = score
+				scores = entity.Scores
+			}
+		}
+	case "AVERAGE":
+		scores = make([]float32, len(entities[0].Scores))
+		for _, entity := range entities {
+			for i, score := range entity.Scores {
+				scores[i] += score
+			}
+		}
+		for i := range scores {
+			scores[i] /= float32(len(entities))
+		}
+	}
+
+	entityIdx, score, err := util.ArgMax(scores)
+	if err != nil {
+		return Entity{}, err
+	}
+	label, ok := p.IdLabelMap[entityIdx]
+	if !ok {
+		return Entity{}, fmt.Errorf("could not determine entity type for input %s, predicted entity index %d", input.Raw, entityIdx)
+	}
+	tokens := make([]uint32, len(entities))
+	for i, entity := range entities {
+		tokens[i] = entity.TokenId
+	}
+	return Entity{
+		Entity:   label,
+		Score:    score,
+		Scores:   scores,
+		Index:    entities[0].Index,
+		Word:     p.Tokenizer.Decode(tokens, false),
+		TokenId:  entities[0].TokenId,
+		Start:    entities[0].Start,
+		End:      entities[len(entities)-1].End,
+		tokenIds: tokens,
+	}, nil
+}
+
 func (p *TokenClassificationPipeline) getTag(entityName string) (string, string) {
 	var bi string
 	var tag string
@@ -307,10 +559,14 @@ func (p *TokenClassificationPipeline) groupSubEntities(entities []Entity) Entity
 		entityType = strings.Join(splits[1:], "-")
 	}
 	scores := make([]float32, len(entities))
-	tokens := make([]uint32, len(entities))
+	tokens := make([]uint32, 0, len(entities))
 	for i, s := range entities {
 		scores[i] = s.Score
-		tokens[i] = s.TokenId
+		if len(s.tokenIds) > 0 {
+			tokens = append(tokens, s.tokenIds...)
+		} else {
+			tokens = append(tokens, s.TokenId)
+		}
 	}
 	score := util.Mean(scores)
 	// note: here we directly appeal to the tokenizer decoder with the tokenIds
@@ -360,11 +616,34 @@ func (p *TokenClassificationPipeline) Run(inputs []string) (PipelineBatchOutput,
 	return p.RunPipeline(inputs)
 }
 
+// RunContext runs the pipeline on a string batch, returning the error of the context if it is done.
+func (p *TokenClassificationPipeline) RunContext(ctx context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
 func (p *TokenClassificationPipeline) RunPipeline(inputs []string) (*TokenClassificationOutput, error) {
-	batch := p.Preprocess(inputs)
-	batch, errForward := p.Forward(batch)
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context between the tokenization, forward pass and postprocessing.
+func (p *TokenClassificationPipeline) RunPipelineContext(ctx context.Context, inputs []string) (*TokenClassificationOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	batch := p.PreprocessContext(ctx, inputs)
+	batch, errForward := p.ForwardContext(ctx, batch)
 	if errForward != nil {
 		return nil, errForward
 	}
-	return p.Postprocess(batch)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, span := p.startSpan(ctx, spanPostprocess, batch.NumInputs)
+	output, err := p.Postprocess(batch)
+	endSpan(span, batch.MaxSequence, err)
+	return output, err
 }
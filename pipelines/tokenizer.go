@@ -0,0 +1,23 @@
+package pipelines
+
+import (
+	"github.com/knights-analytics/hugot/tokenizer"
+)
+
+// Tokenizer encodes the inputs of a pipeline into tokens, and decodes tokens back into text. It is implemented by the
+// rust tokenizers library, the default, and by the pure Go tokenizer of the tokenizer package.
+type Tokenizer interface {
+	// Encode encodes the input with all the attributes of the encoding: ids, type ids, masks, tokens and offsets.
+	Encode(input string, addSpecialTokens bool) tokenizer.Encoding
+	Decode(ids []uint32, skipSpecialTokens bool) string
+	Close() error
+}
+
+// newTokenizer loads the tokenizer of the pipeline from the content of its tokenizer.json. The pure Go tokenizer is
+// used if GoTokenizer is set, or if hugot is built without the rust tokenizers library (build tag norust).
+func (p *BasePipeline) newTokenizer(tokenizerBytes []byte) (Tokenizer, error) {
+	if p.GoTokenizer || !rustTokenizerAvailable {
+		return tokenizer.FromBytes(tokenizerBytes)
+	}
+	return newRustTokenizer(tokenizerBytes)
+}
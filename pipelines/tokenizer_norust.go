@@ -0,0 +1,14 @@
+//go:build norust
+
+package pipelines
+
+import (
+	"errors"
+)
+
+// hugot is built without the rust tokenizers library: the pipelines use the pure Go tokenizer.
+const rustTokenizerAvailable = false
+
+func newRustTokenizer(_ []byte) (Tokenizer, error) {
+	return nil, errors.New("hugot was built without the rust tokenizers library (build tag norust)")
+}
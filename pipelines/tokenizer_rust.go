@@ -0,0 +1,48 @@
+//go:build !norust
+
+package pipelines
+
+import (
+	"github.com/knights-analytics/tokenizers"
+
+	"github.com/knights-analytics/hugot/tokenizer"
+)
+
+const rustTokenizerAvailable = true
+
+// rustTokenizer is the tokenizer of the rust tokenizers library, linked statically through cgo.
+type rustTokenizer struct {
+	tokenizer *tokenizers.Tokenizer
+}
+
+func newRustTokenizer(tokenizerBytes []byte) (Tokenizer, error) {
+	tk, err := tokenizers.FromBytes(tokenizerBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &rustTokenizer{tokenizer: tk}, nil
+}
+
+func (t *rustTokenizer) Encode(input string, addSpecialTokens bool) tokenizer.Encoding {
+	encoding := t.tokenizer.EncodeWithOptions(input, addSpecialTokens, tokenizers.WithReturnAllAttributes())
+	offsets := make([]tokenizer.Offset, len(encoding.Offsets))
+	for i, offset := range encoding.Offsets {
+		offsets[i] = tokenizer.Offset(offset)
+	}
+	return tokenizer.Encoding{
+		IDs:               encoding.IDs,
+		TypeIDs:           encoding.TypeIDs,
+		SpecialTokensMask: encoding.SpecialTokensMask,
+		AttentionMask:     encoding.AttentionMask,
+		Tokens:            encoding.Tokens,
+		Offsets:           offsets,
+	}
+}
+
+func (t *rustTokenizer) Decode(ids []uint32, skipSpecialTokens bool) string {
+	return t.tokenizer.Decode(ids, skipSpecialTokens)
+}
+
+func (t *rustTokenizer) Close() error {
+	return t.tokenizer.Close()
+}
@@ -0,0 +1,65 @@
+package pipelines
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// The stages of the pipeline runs are traced with OpenTelemetry spans, children of the span of the context of the
+// run (see the RunContext methods). The spans are only recorded if a tracer provider is set, see
+// hugot.WithTracerProvider, and are otherwise no-ops.
+
+// TracerName is the name of the tracer of hugot, the instrumentation scope of its spans.
+const TracerName = "github.com/knights-analytics/hugot"
+
+// the span names of the stages
+const (
+	spanPreprocess  = "hugot.Preprocess"
+	spanForward     = "hugot.Forward"
+	spanPostprocess = "hugot.Postprocess"
+)
+
+// the attributes of the spans
+const (
+	AttributePipelineName      = attribute.Key("hugot.pipeline.name")
+	AttributeModelPath         = attribute.Key("hugot.model.path")
+	AttributeBatchSize         = attribute.Key("hugot.batch.size")
+	AttributeMaxSequenceLength = attribute.Key("hugot.batch.max_sequence_length")
+)
+
+var noopTracer = noop.NewTracerProvider().Tracer(TracerName)
+
+// SetTracerProvider sets the tracer provider of the spans of the stages of the pipeline. Hugot sessions set the
+// provider of their WithTracerProvider option on the pipelines they create.
+func (p *BasePipeline) SetTracerProvider(provider trace.TracerProvider) {
+	p.tracer = provider.Tracer(TracerName)
+}
+
+// startSpan starts the span of a stage of a run on a batch of batchSize inputs.
+func (p *BasePipeline) startSpan(ctx context.Context, name string, batchSize int) (context.Context, trace.Span) {
+	tracer := p.tracer
+	if tracer == nil {
+		tracer = noopTracer
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(
+		AttributePipelineName.String(p.PipelineName),
+		AttributeModelPath.String(p.ModelPath),
+		AttributeBatchSize.Int(batchSize),
+	))
+}
+
+// endSpan ends the span of a stage, with the padded sequence length of the batch and the error of the stage.
+func endSpan(span trace.Span, maxSequence int, err error) {
+	if maxSequence > 0 {
+		span.SetAttributes(AttributeMaxSequenceLength.Int(maxSequence))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
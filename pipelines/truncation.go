@@ -0,0 +1,242 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/knights-analytics/hugot/tokenizer"
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// Inputs longer than the maximum length of the pipeline are either truncated or, if a stride is set, split into
+// overlapping windows that are run as separate rows of the batch. The pipelines then aggregate the results of the
+// windows back into a single result per input. Since the windows are slices of the encoding of the full input,
+// the token offsets of every window refer to the original text.
+
+type tokenizerConfigJSON struct {
+	ModelMaxLength float64 `json:"model_max_length"`
+}
+
+// loadModelMaxLength reads the model_max_length of tokenizer_config.json. Tokenizers without a maximum length store
+// a very large sentinel value, in which case no maximum length is returned.
+func loadModelMaxLength(modelPath string) (int, error) {
+	configPath := util.PathJoinSafe(modelPath, "tokenizer_config.json")
+	exists, err := util.FileSystem.Exists(context.Background(), configPath)
+	if err != nil || !exists {
+		return 0, err
+	}
+	configBytes, err := util.ReadFileBytes(configPath)
+	if err != nil {
+		return 0, err
+	}
+	config := tokenizerConfigJSON{}
+	if err = jsoniter.Unmarshal(configBytes, &config); err != nil {
+		return 0, err
+	}
+	if config.ModelMaxLength <= 0 || config.ModelMaxLength > 1e6 {
+		return 0, nil
+	}
+	return int(config.ModelMaxLength), nil
+}
+
+// withoutTruncation removes the truncation settings of tokenizer.json, as truncation is done by the pipeline.
+func withoutTruncation(tokenizerBytes []byte) ([]byte, error) {
+	tokenizerMap := map[string]jsoniter.RawMessage{}
+	if err := jsoniter.Unmarshal(tokenizerBytes, &tokenizerMap); err != nil {
+		return nil, err
+	}
+	tokenizerMap["truncation"] = jsoniter.RawMessage("null")
+	return jsoniter.Marshal(tokenizerMap)
+}
+
+// validateTruncation checks the truncation settings. Pipelines that cannot aggregate the results of the windows of
+// an input do not allow a stride.
+func (p *BasePipeline) validateTruncation(allowStride bool) []error {
+	var validationErrors []error
+	if p.MaxLength < 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: maxLength must not be negative"))
+	}
+	switch p.Truncation {
+	case "LONGEST_FIRST", "ONLY_FIRST", "ONLY_SECOND":
+	default:
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: truncation strategy %s is not supported", p.Truncation))
+	}
+	if p.Stride != 0 {
+		switch {
+		case !allowStride:
+			validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: stride is not supported by this pipeline"))
+		case p.MaxLength == 0:
+			validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: stride requires a maxLength"))
+		case p.Stride < 0 || p.Stride >= p.MaxLength-p.postProcessor.numSingleSpecialTokens():
+			validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: stride must be between zero and maxLength minus the special tokens"))
+		}
+	}
+	return validationErrors
+}
+
+// splitInput truncates an input that does not fit in the maximum length or, if a stride is set, splits it into
+// windows of the maximum length overlapping by stride tokens.
+func (p *BasePipeline) splitInput(inputIndex int, input string) []TokenizedInput {
+	sequence := p.Tokenizer.Encode(input, false)
+	windowLength := p.MaxLength - p.postProcessor.numSingleSpecialTokens()
+	if windowLength <= 0 {
+		windowLength = 1
+	}
+
+	var windows []TokenizedInput
+	for windowStart := 0; ; windowStart += windowLength - p.Stride {
+		windowEnd := windowStart + windowLength
+		if windowEnd > len(sequence.IDs) {
+			windowEnd = len(sequence.IDs)
+		}
+		window := newTokenizedInput(input, p.postProcessor.mergeSingle(sliceEncoding(sequence, windowStart, windowEnd)))
+		window.InputIndex = inputIndex
+		window.WindowStart = windowStart
+		windows = append(windows, window)
+		if p.Stride == 0 || windowEnd == len(sequence.IDs) {
+			return windows
+		}
+	}
+}
+
+// truncatePair shortens the two sequences of a pair so that together they have at most maxTokens tokens.
+// LONGEST_FIRST removes tokens from the longest sequence one at a time, while ONLY_FIRST and ONLY_SECOND truncate a
+// single sequence. If that sequence cannot be shortened enough, the longest first strategy is used instead.
+func truncatePair(first tokenizer.Encoding, second tokenizer.Encoding, maxTokens int, strategy string) (tokenizer.Encoding, tokenizer.Encoding) {
+	firstLength, secondLength := len(first.IDs), len(second.IDs)
+	if maxTokens < 0 {
+		maxTokens = 0
+	}
+	switch {
+	case firstLength+secondLength <= maxTokens:
+		return first, second
+	case strategy == "ONLY_FIRST" && secondLength <= maxTokens:
+		firstLength = maxTokens - secondLength
+	case strategy == "ONLY_SECOND" && firstLength <= maxTokens:
+		secondLength = maxTokens - firstLength
+	default:
+		for firstLength+secondLength > maxTokens {
+			if firstLength > secondLength {
+				firstLength--
+			} else {
+				secondLength--
+			}
+		}
+	}
+	return sliceEncoding(first, 0, firstLength), sliceEncoding(second, 0, secondLength)
+}
+
+// inputRows returns the rows of the batch holding each input. Inputs split into windows have several rows.
+func (b PipelineBatch) inputRows() [][]int {
+	if b.NumInputs == 0 {
+		rows := make([][]int, len(b.Input))
+		for i := range rows {
+			rows[i] = []int{i}
+		}
+		return rows
+	}
+	rows := make([][]int, b.NumInputs)
+	for row, input := range b.Input {
+		rows[input.InputIndex] = append(rows[input.InputIndex], row)
+	}
+	return rows
+}
+
+// tokenCounts returns the number of tokens of each input run through the model, i.e. the tokens of the attention
+// masks of its rows. Tokens in the overlap of windows are counted once for each window.
+func (b PipelineBatch) tokenCounts(rows [][]int) []int {
+	counts := make([]int, len(rows))
+	for i, inputRows := range rows {
+		for _, row := range inputRows {
+			for _, mask := range b.Input[row].AttentionMask {
+				counts[i] += int(mask)
+			}
+		}
+	}
+	return counts
+}
+
+// aggregateWindows aggregates the vectors of the rows of each input, taking their mean or their element-wise maximum.
+func aggregateWindows(vectors [][]float32, rows [][]int, strategy string) [][]float32 {
+	aggregated := make([][]float32, len(rows))
+	for i, inputRows := range rows {
+		if len(inputRows) == 1 {
+			aggregated[i] = vectors[inputRows[0]]
+			continue
+		}
+		vector := make([]float32, len(vectors[inputRows[0]]))
+		copy(vector, vectors[inputRows[0]])
+		for _, row := range inputRows[1:] {
+			for k, value := range vectors[row] {
+				if strategy == "MAX" {
+					if value > vector[k] {
+						vector[k] = value
+					}
+				} else {
+					vector[k] += value
+				}
+			}
+		}
+		if strategy != "MAX" {
+			for k := range vector {
+				vector[k] /= float32(len(inputRows))
+			}
+		}
+		aggregated[i] = vector
+	}
+	return aggregated
+}
+
+// mergeWindows reassembles the tokens of the windows of an input into a single tokenized input, with the special
+// tokens of the start of the first window and of the end of the last window. For each token it also returns the
+// (row, position) pairs of the batch where the token appears, which are several where windows overlap.
+func mergeWindows(batch PipelineBatch, rows []int) (TokenizedInput, [][][2]int) {
+	merged := TokenizedInput{
+		Raw:        batch.Input[rows[0]].Raw,
+		RawPair:    batch.Input[rows[0]].RawPair,
+		InputIndex: batch.Input[rows[0]].InputIndex,
+	}
+	var positions [][][2]int
+	add := func(row int, j int) {
+		input := batch.Input[row]
+		merged.TokenIds = append(merged.TokenIds, input.TokenIds[j])
+		merged.Tokens = append(merged.Tokens, input.Tokens[j])
+		merged.TypeIds = append(merged.TypeIds, input.TypeIds[j])
+		merged.AttentionMask = append(merged.AttentionMask, input.AttentionMask[j])
+		merged.SpecialTokensMask = append(merged.SpecialTokensMask, input.SpecialTokensMask[j])
+		merged.Offsets = append(merged.Offsets, input.Offsets[j])
+		positions = append(positions, [][2]int{{row, j}})
+	}
+
+	first := batch.Input[rows[0]]
+	for j := 0; j < len(first.TokenIds) && first.SpecialTokensMask[j] != 0; j++ {
+		add(rows[0], j)
+	}
+	contentStart := len(merged.TokenIds)
+	lastContent := 0
+	for _, row := range rows {
+		input := batch.Input[row]
+		k := 0
+		for j := range input.TokenIds {
+			if input.SpecialTokensMask[j] != 0 {
+				continue
+			}
+			index := contentStart + input.WindowStart + k
+			if index < len(merged.TokenIds) {
+				positions[index] = append(positions[index], [2]int{row, j})
+			} else {
+				add(row, j)
+			}
+			lastContent = j
+			k++
+		}
+	}
+	last := rows[len(rows)-1]
+	for j := lastContent + 1; j < len(batch.Input[last].TokenIds); j++ {
+		add(last, j)
+	}
+	merged.MaxAttentionIndex = len(merged.TokenIds) - 1
+	return merged, positions
+}
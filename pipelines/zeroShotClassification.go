@@ -0,0 +1,258 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	ort "github.com/yalue/onnxruntime_go"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// ZeroShotClassificationPipeline A zero shot classification pipeline is a go version of
+// https://github.com/huggingface/transformers/blob/main/src/transformers/pipelines/zero_shot_classification.py
+// Each input is paired with a hypothesis built from each candidate label, and the pairs are scored by an NLI model.
+
+// types
+
+type ZeroShotClassificationPipeline struct {
+	BasePipeline
+	IdLabelMap         map[int]string
+	Labels             []string
+	HypothesisTemplate string
+	Multilabel         bool
+	entailmentId       int
+	contradictionId    int
+}
+
+type ZeroShotClassificationPipelineConfig struct {
+	IdLabelMap map[int]string `json:"id2label"`
+}
+
+type ZeroShotOutput struct {
+	Sequence              string
+	ClassificationOutputs []ClassificationOutput
+}
+
+type ZeroShotClassificationOutput struct {
+	ZeroShotOutputs []ZeroShotOutput
+}
+
+func (t *ZeroShotClassificationOutput) GetOutput() []any {
+	out := make([]any, len(t.ZeroShotOutputs))
+	for i, zeroShotOutput := range t.ZeroShotOutputs {
+		out[i] = any(zeroShotOutput)
+	}
+	return out
+}
+
+// options
+
+// WithLabels sets the candidate labels to classify the inputs against.
+func WithLabels(labels []string) PipelineOption[*ZeroShotClassificationPipeline] {
+	return func(pipeline *ZeroShotClassificationPipeline) {
+		pipeline.Labels = labels
+	}
+}
+
+// WithHypothesisTemplate sets the template used to turn each label into an NLI hypothesis. The template must contain
+// {}, which is replaced by the label. Default is "This example is {}.".
+func WithHypothesisTemplate(hypothesisTemplate string) PipelineOption[*ZeroShotClassificationPipeline] {
+	return func(pipeline *ZeroShotClassificationPipeline) {
+		pipeline.HypothesisTemplate = hypothesisTemplate
+	}
+}
+
+// WithMultilabel sets whether multiple labels can be true. If false, the scores are normalized such that the sum of
+// the label likelihoods for each input is 1. If true, the labels are considered independent.
+func WithMultilabel(multilabel bool) PipelineOption[*ZeroShotClassificationPipeline] {
+	return func(pipeline *ZeroShotClassificationPipeline) {
+		pipeline.Multilabel = multilabel
+	}
+}
+
+// NewZeroShotClassificationPipeline initializes a new zero shot classification pipeline
+func NewZeroShotClassificationPipeline(config PipelineConfig[*ZeroShotClassificationPipeline], ortOptions *ort.SessionOptions) (*ZeroShotClassificationPipeline, error) {
+	pipeline := &ZeroShotClassificationPipeline{}
+	pipeline.ModelPath = config.ModelPath
+	pipeline.PipelineName = config.Name
+	pipeline.OrtOptions = ortOptions
+	pipeline.OnnxFilename = config.OnnxFilename
+	pipeline.OutputName = config.OutputName
+	pipeline.MaxLength = config.MaxLength
+	pipeline.Truncation = config.Truncation
+	pipeline.Stride = config.Stride
+	pipeline.MaxTokensPerBatch = config.MaxTokensPerBatch
+	pipeline.GoTokenizer = config.GoTokenizer
+
+	for _, o := range config.Options {
+		o(pipeline)
+	}
+
+	if pipeline.HypothesisTemplate == "" {
+		pipeline.HypothesisTemplate = "This example is {}."
+	}
+
+	configPath := util.PathJoinSafe(pipeline.ModelPath, "config.json")
+	pipelineInputConfig := ZeroShotClassificationPipelineConfig{}
+	mapBytes, err := util.ReadFileBytes(configPath)
+	if err != nil {
+		return nil, err
+	}
+	err = jsoniter.Unmarshal(mapBytes, &pipelineInputConfig)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.IdLabelMap = pipelineInputConfig.IdLabelMap
+
+	pipeline.PipelineTimings = &Timings{}
+	pipeline.TokenizerTimings = &Timings{}
+	pipeline.Metrics = NewPipelineMetrics()
+
+	// load onnx model
+	loadErr := pipeline.loadModel()
+	if loadErr != nil {
+		return nil, loadErr
+	}
+
+	pipeline.OutputDim = int(pipeline.outputMeta().Dimensions[1])
+
+	// like in the python implementation, the entailment label is the first one starting with "entail". If there is
+	// none, the last label is used. The contradiction label is then the first one, or the last if entailment is first.
+	pipeline.entailmentId = pipeline.OutputDim - 1
+	for id := 0; id < pipeline.OutputDim; id++ {
+		if strings.HasPrefix(strings.ToLower(pipeline.IdLabelMap[id]), "entail") {
+			pipeline.entailmentId = id
+			break
+		}
+	}
+	pipeline.contradictionId = 0
+	if pipeline.entailmentId == 0 {
+		pipeline.contradictionId = pipeline.OutputDim - 1
+	}
+
+	// validate
+	validationErrors := pipeline.Validate()
+	if validationErrors != nil {
+		return nil, validationErrors
+	}
+
+	return pipeline, nil
+}
+
+func (p *ZeroShotClassificationPipeline) Validate() error {
+	var validationErrors []error
+
+	if len(p.Labels) == 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: at least one candidate label is required"))
+	}
+	if !strings.Contains(p.HypothesisTemplate, "{}") {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: hypothesis template %s does not contain {}", p.HypothesisTemplate))
+	}
+	if p.OutputDim <= 0 {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: outputDim parameter must be greater than zero"))
+	}
+	if len(p.IdLabelMap) != p.OutputDim {
+		validationErrors = append(validationErrors, fmt.Errorf("pipeline configuration invalid: length of id2label map does not match model output dimension"))
+	}
+	validationErrors = append(validationErrors, p.validateTruncation(false)...)
+	return errors.Join(validationErrors...)
+}
+
+// Postprocess converts the NLI logits of the (sequence, hypothesis) pairs of one sequence into label scores.
+// The batch is expected to hold one pair per candidate label, in the order of p.Labels.
+func (p *ZeroShotClassificationPipeline) Postprocess(batch PipelineBatch, sequence string) (_ ZeroShotOutput, err error) {
+	defer p.observePostprocess(time.Now(), &err)
+	nLabels := len(p.Labels)
+	if len(batch.OutputTensor) != nLabels*p.OutputDim {
+		return ZeroShotOutput{}, fmt.Errorf("expected %d logits for sequence %s, got %d", nLabels*p.OutputDim, sequence, len(batch.OutputTensor))
+	}
+
+	scores := make([]float32, nLabels)
+	// with a single label, python falls back to multi label scoring since a softmax over one value is always 1
+	if p.Multilabel || nLabels == 1 {
+		for i := 0; i < nLabels; i++ {
+			logits := batch.OutputTensor[i*p.OutputDim : (i+1)*p.OutputDim]
+			scores[i] = util.SoftMax([]float32{logits[p.contradictionId], logits[p.entailmentId]})[1]
+		}
+	} else {
+		entailmentLogits := make([]float32, nLabels)
+		for i := 0; i < nLabels; i++ {
+			entailmentLogits[i] = batch.OutputTensor[i*p.OutputDim+p.entailmentId]
+		}
+		scores = util.SoftMax(entailmentLogits)
+	}
+
+	classificationOutputs := make([]ClassificationOutput, nLabels)
+	for i, label := range p.Labels {
+		classificationOutputs[i] = ClassificationOutput{
+			Label: label,
+			Score: scores[i],
+		}
+	}
+	sort.SliceStable(classificationOutputs, func(i, j int) bool {
+		return classificationOutputs[i].Score > classificationOutputs[j].Score
+	})
+
+	return ZeroShotOutput{
+		Sequence:              sequence,
+		ClassificationOutputs: classificationOutputs,
+	}, nil
+}
+
+// Run the pipeline on a string batch
+func (p *ZeroShotClassificationPipeline) Run(inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipeline(inputs)
+}
+
+// RunContext runs the pipeline on a string batch, returning the error of the context if it is done.
+func (p *ZeroShotClassificationPipeline) RunContext(ctx context.Context, inputs []string) (PipelineBatchOutput, error) {
+	return p.RunPipelineContext(ctx, inputs)
+}
+
+func (p *ZeroShotClassificationPipeline) RunPipeline(inputs []string) (*ZeroShotClassificationOutput, error) {
+	return p.RunPipelineContext(context.Background(), inputs)
+}
+
+// RunPipelineContext checks the context between the tokenization, forward pass and postprocessing of each sequence.
+func (p *ZeroShotClassificationPipeline) RunPipelineContext(ctx context.Context, inputs []string) (*ZeroShotClassificationOutput, error) {
+	if err := p.startRun(); err != nil {
+		return nil, err
+	}
+	defer p.endRun()
+	outputs := ZeroShotClassificationOutput{
+		ZeroShotOutputs: make([]ZeroShotOutput, len(inputs)),
+	}
+
+	// each sequence is run as one batch of (sequence, hypothesis) pairs, one for each candidate label
+	for i, input := range inputs {
+		pairs := make([][2]string, len(p.Labels))
+		for j, label := range p.Labels {
+			pairs[j] = [2]string{input, strings.Replace(p.HypothesisTemplate, "{}", label, 1)}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		batch := p.PreprocessPairsContext(ctx, pairs)
+		batch, err := p.ForwardContext(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		_, span := p.startSpan(ctx, spanPostprocess, 1)
+		output, err := p.Postprocess(batch, input)
+		endSpan(span, batch.MaxSequence, err)
+		if err != nil {
+			return nil, err
+		}
+		outputs.ZeroShotOutputs[i] = output
+	}
+	return &outputs, nil
+}
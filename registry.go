@@ -0,0 +1,79 @@
+package hugot
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+
+	"github.com/knights-analytics/hugot/pipelines"
+)
+
+// PipelineConstructor creates a pipeline of type T from its configuration and the onnxruntime options of the
+// session. The constructors of the pipelines package, e.g. pipelines.NewFeatureExtractionPipeline, have this type.
+type PipelineConstructor[T pipelines.Pipeline] func(config pipelines.PipelineConfig[T], ortOptions *ort.SessionOptions) (T, error)
+
+// the constructors of the registered pipeline types, by type of pipeline
+var registry = struct {
+	mutex        sync.RWMutex
+	constructors map[reflect.Type]any
+}{constructors: map[reflect.Type]any{}}
+
+func init() {
+	mustRegisterPipeline(pipelines.NewTokenClassificationPipeline)
+	mustRegisterPipeline(pipelines.NewTextClassificationPipeline)
+	mustRegisterPipeline(pipelines.NewFeatureExtractionPipeline)
+	mustRegisterPipeline(pipelines.NewZeroShotClassificationPipeline)
+	mustRegisterPipeline(pipelines.NewQuestionAnsweringPipeline)
+	mustRegisterPipeline(pipelines.NewFillMaskPipeline)
+	mustRegisterPipeline(pipelines.NewRerankPipeline)
+	mustRegisterPipeline(pipelines.NewText2TextGenerationPipeline)
+	mustRegisterPipeline(pipelines.NewTextGenerationPipeline)
+}
+
+// RegisterPipeline registers the constructor of the pipeline type T, so that pipelines of that type can be created
+// with NewPipeline, retrieved with GetPipeline, and are destroyed and profiled with the session. This allows
+// packages outside of hugot to plug in their own pipelines, typically from an init function. Registering a type
+// twice returns an error.
+func RegisterPipeline[T pipelines.Pipeline](constructor PipelineConstructor[T]) error {
+	if constructor == nil {
+		return fmt.Errorf("the constructor of pipeline type %s is nil", pipelineType[T]())
+	}
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if _, ok := registry.constructors[pipelineType[T]()]; ok {
+		return fmt.Errorf("pipeline type %s is already registered", pipelineType[T]())
+	}
+	registry.constructors[pipelineType[T]()] = constructor
+	return nil
+}
+
+func mustRegisterPipeline[T pipelines.Pipeline](constructor PipelineConstructor[T]) {
+	if err := RegisterPipeline(constructor); err != nil {
+		panic(err)
+	}
+}
+
+// getConstructor returns the registered constructor of the pipeline type T.
+func getConstructor[T pipelines.Pipeline]() (PipelineConstructor[T], error) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	constructor, ok := registry.constructors[pipelineType[T]()]
+	if !ok {
+		return nil, fmt.Errorf("pipeline type %s is not registered, see RegisterPipeline", pipelineType[T]())
+	}
+	return constructor.(PipelineConstructor[T]), nil
+}
+
+func pipelineType[T pipelines.Pipeline]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// pipelineTypeName returns the name of the pipeline type, without the pointer, e.g. FeatureExtractionPipeline.
+func pipelineTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer && t.Elem().Name() != "" {
+		return t.Elem().Name()
+	}
+	return t.String()
+}
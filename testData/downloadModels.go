@@ -18,12 +18,12 @@ func main() {
 			if err != nil {
 				panic(err)
 			}
-			defer func(s hugot.Session) {
+			defer func(s *hugot.Session) {
 				err := s.Destroy()
 				if err != nil {
 					panic(err)
 				}
-			}(*session)
+			}(session)
 
 			err = os.MkdirAll("./models", os.ModePerm)
 			if err != nil {
@@ -34,7 +34,13 @@ func main() {
 				"KnightsAnalytics/all-MiniLM-L6-v2",
 				"KnightsAnalytics/distilbert-base-uncased-finetuned-sst-2-english",
 				"KnightsAnalytics/distilbert-NER",
-				"SamLowe/roberta-base-go_emotions-onnx"} {
+				"SamLowe/roberta-base-go_emotions-onnx",
+				"protectai/deberta-v3-base-zeroshot-v1-onnx",
+				"Xenova/distilbert-base-cased-distilled-squad",
+				"Xenova/distilbert-base-uncased",
+				"cross-encoder/ms-marco-MiniLM-L-6-v2",
+				"Xenova/t5-small",
+				"Xenova/gpt2"} {
 				_, err := session.DownloadModel(modelName, "./models", downloadOptions)
 				if err != nil {
 					panic(err)
@@ -0,0 +1,117 @@
+package tokenizer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// addedToken is a token added to the vocabulary of the model, such as the special tokens. Added tokens are found in
+// the input before it is split into words, and are never split by the model.
+type addedToken struct {
+	ID         uint32 `json:"id"`
+	Content    string `json:"content"`
+	SingleWord bool   `json:"single_word"`
+	LStrip     bool   `json:"lstrip"`
+	RStrip     bool   `json:"rstrip"`
+	Normalized bool   `json:"normalized"`
+	Special    bool   `json:"special"`
+}
+
+// addedTokenMatcher finds the added tokens in a text, the longest token first where several match.
+type addedTokenMatcher struct {
+	tokens []addedToken // sorted by decreasing length
+}
+
+func newAddedTokenMatcher(tokens []addedToken) addedTokenMatcher {
+	sorted := make([]addedToken, 0, len(tokens))
+	for _, token := range tokens {
+		if token.Content != "" {
+			sorted = append(sorted, token)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Content) > len(sorted[j].Content)
+	})
+	return addedTokenMatcher{tokens: sorted}
+}
+
+// addedTokenMatch is an added token found in a text, with its span including the whitespace it strips.
+type addedTokenMatch struct {
+	token      addedToken
+	start, end int
+}
+
+// find returns the added tokens found in the text, from left to right.
+func (m addedTokenMatcher) find(text string) []addedTokenMatch {
+	if len(m.tokens) == 0 {
+		return nil
+	}
+	var matches []addedTokenMatch
+	previousEnd := 0
+	for start := 0; start < len(text); {
+		matched := false
+		for _, token := range m.tokens {
+			if !strings.HasPrefix(text[start:], token.Content) {
+				continue
+			}
+			end := start + len(token.Content)
+			// single word tokens do not match inside words
+			if token.SingleWord && (wordCharBefore(text, start) || wordCharAt(text, end)) {
+				continue
+			}
+			match := addedTokenMatch{token: token, start: start, end: end}
+			if token.LStrip {
+				for match.start > previousEnd {
+					r, size := utf8.DecodeLastRuneInString(text[:match.start])
+					if !unicode.IsSpace(r) {
+						break
+					}
+					match.start -= size
+				}
+			}
+			if token.RStrip {
+				for match.end < len(text) {
+					r, size := utf8.DecodeRuneInString(text[match.end:])
+					if !unicode.IsSpace(r) {
+						break
+					}
+					match.end += size
+				}
+			}
+			matches = append(matches, match)
+			previousEnd = match.end
+			start = match.end
+			matched = true
+			break
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(text[start:])
+			start += size
+		}
+	}
+	return matches
+}
+
+// wordCharBefore reports whether the character before the position is part of a word.
+func wordCharBefore(text string, position int) bool {
+	if position == 0 {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(text[:position])
+	return isWordChar(r)
+}
+
+// wordCharAt reports whether the character at the position is part of a word.
+func wordCharAt(text string, position int) bool {
+	if position >= len(text) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(text[position:])
+	return isWordChar(r)
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
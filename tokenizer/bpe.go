@@ -0,0 +1,183 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// bpeCacheSize is the number of words whose tokens are cached by the BPE model.
+const bpeCacheSize = 10000
+
+// bpe is the byte pair encoding model of GPT-2, RoBERTa and most generative models: words start as a sequence of
+// characters, or of bytes for byte level models, and the pairs of tokens are merged following the ranked merges of
+// the model until no merge applies.
+type bpe struct {
+	vocabulary
+	merges       map[[2]uint32]bpeMerge
+	unkToken     string
+	unkID        uint32
+	hasUnk       bool
+	prefix       string
+	suffix       string
+	fuseUnk      bool
+	byteFallback bool
+	ignoreMerges bool
+	cacheMutex   sync.RWMutex
+	cache        map[string][]token
+}
+
+// bpeMerge is the merge of a pair of tokens: its rank, the lowest rank being merged first, and the merged token.
+type bpeMerge struct {
+	rank int
+	id   uint32
+}
+
+func newBPE(config *modelJSON, addedTokenIDs map[string]uint32) (*bpe, error) {
+	vocabulary, err := newVocabulary(config.Vocab)
+	if err != nil {
+		return nil, err
+	}
+	b := &bpe{
+		vocabulary:   vocabulary,
+		merges:       make(map[[2]uint32]bpeMerge, len(config.Merges)),
+		fuseUnk:      config.FuseUnk,
+		byteFallback: config.ByteFallback,
+		ignoreMerges: config.IgnoreMerges,
+		cache:        map[string][]token{},
+	}
+	if config.ContinuingSubwordPrefix != nil {
+		b.prefix = *config.ContinuingSubwordPrefix
+	}
+	if config.EndOfWordSuffix != nil {
+		b.suffix = *config.EndOfWordSuffix
+	}
+	if config.UnkToken != nil {
+		b.unkToken = *config.UnkToken
+		b.unkID, b.hasUnk = b.unknownTokenID(b.unkToken, addedTokenIDs)
+	}
+
+	for rank, data := range config.Merges {
+		// merges are either "a b" strings or ["a", "b"] pairs
+		var pair []string
+		var line string
+		if err := jsoniter.Unmarshal(data, &line); err == nil {
+			pair = strings.SplitN(line, " ", 2)
+		} else if err := jsoniter.Unmarshal(data, &pair); err != nil {
+			return nil, fmt.Errorf("reading merge %d of the BPE model: %w", rank, err)
+		}
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("merge %d of the BPE model is not a pair of tokens", rank)
+		}
+		first, okFirst := b.ids[pair[0]]
+		second, okSecond := b.ids[pair[1]]
+		if !okFirst || !okSecond {
+			return nil, fmt.Errorf("merge %d of the BPE model has a token that is not in its vocabulary", rank)
+		}
+		merged := pair[0] + strings.TrimPrefix(pair[1], b.prefix)
+		id, ok := b.ids[merged]
+		if !ok {
+			return nil, fmt.Errorf("the token %s merged by merge %d of the BPE model is not in its vocabulary", merged, rank)
+		}
+		b.merges[[2]uint32{first, second}] = bpeMerge{rank: rank, id: id}
+	}
+	return b, nil
+}
+
+func (b *bpe) tokenize(word string) []token {
+	if word == "" {
+		return nil
+	}
+	if b.ignoreMerges {
+		if id, ok := b.ids[word]; ok {
+			return []token{{id: id, value: word, start: 0, end: len(word)}}
+		}
+	}
+	b.cacheMutex.RLock()
+	tokens, ok := b.cache[word]
+	b.cacheMutex.RUnlock()
+	if ok {
+		return tokens
+	}
+
+	tokens = b.merge(b.symbols(word))
+	b.cacheMutex.Lock()
+	if len(b.cache) < bpeCacheSize {
+		b.cache[word] = tokens
+	}
+	b.cacheMutex.Unlock()
+	return tokens
+}
+
+// symbols returns the initial tokens of the word, one per character.
+func (b *bpe) symbols(word string) []token {
+	symbols := make([]token, 0, len(word))
+	for i := 0; i < len(word); {
+		_, size := utf8.DecodeRuneInString(word[i:])
+		char := word[i : i+size]
+		if i > 0 {
+			char = b.prefix + char
+		}
+		if i+size == len(word) {
+			char += b.suffix
+		}
+
+		if id, ok := b.ids[char]; ok {
+			symbols = append(symbols, token{id: id, start: i, end: i + size})
+		} else if bytes, ok := b.byteTokens(word[i : i+size]); ok {
+			for j, id := range bytes {
+				symbols = append(symbols, token{id: id, start: i + j, end: i + j + 1})
+			}
+		} else if b.hasUnk {
+			if last := len(symbols) - 1; b.fuseUnk && last >= 0 && symbols[last].id == b.unkID {
+				symbols[last].end = i + size
+			} else {
+				symbols = append(symbols, token{id: b.unkID, start: i, end: i + size})
+			}
+		}
+		i += size
+	}
+	return symbols
+}
+
+// byteTokens returns the <0xXX> tokens of the bytes of the character, if the model falls back to bytes.
+func (b *bpe) byteTokens(char string) ([]uint32, bool) {
+	if !b.byteFallback {
+		return nil, false
+	}
+	ids := make([]uint32, len(char))
+	for i := 0; i < len(char); i++ {
+		id, ok := b.ids[fmt.Sprintf("<0x%02X>", char[i])]
+		if !ok {
+			return nil, false
+		}
+		ids[i] = id
+	}
+	return ids, true
+}
+
+// merge applies the merges to the symbols, lowest rank first and from the left for merges of the same rank.
+func (b *bpe) merge(symbols []token) []token {
+	for {
+		best := -1
+		var bestMerge bpeMerge
+		for i := 0; i+1 < len(symbols); i++ {
+			merge, ok := b.merges[[2]uint32{symbols[i].id, symbols[i+1].id}]
+			if ok && (best < 0 || merge.rank < bestMerge.rank) {
+				best, bestMerge = i, merge
+			}
+		}
+		if best < 0 {
+			break
+		}
+		symbols[best] = token{id: bestMerge.id, start: symbols[best].start, end: symbols[best+1].end}
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+	}
+	for i := range symbols {
+		symbols[i].value = b.tokens[symbols[i].id]
+	}
+	return symbols
+}
@@ -0,0 +1,61 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The byte level BPE of GPT-2 works on bytes rather than characters, so that any input can be tokenized without
+// unknown tokens. The bytes are mapped to printable characters, e.g. the space to Ġ, so that the vocabulary and the
+// merges can be stored as text.
+
+var (
+	byteToChar [256]rune
+	charToByte = map[rune]byte{}
+)
+
+func init() {
+	next := rune(256)
+	for b := 0; b < 256; b++ {
+		printable := (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF)
+		if printable {
+			byteToChar[b] = rune(b)
+		} else {
+			byteToChar[b] = next
+			next++
+		}
+		charToByte[byteToChar[b]] = byte(b)
+	}
+}
+
+// bytesToChars maps the bytes of the text to their printable characters.
+func bytesToChars(text string) string {
+	var chars strings.Builder
+	for i := 0; i < len(text); i++ {
+		chars.WriteRune(byteToChar[text[i]])
+	}
+	return chars.String()
+}
+
+// charsToBytes maps the printable characters of the text back to their bytes. Characters that are not byte
+// characters are kept as they are.
+func charsToBytes(text string) string {
+	var bytes strings.Builder
+	for _, r := range text {
+		if b, ok := charToByte[r]; ok {
+			bytes.WriteByte(b)
+		} else {
+			bytes.WriteRune(r)
+		}
+	}
+	return bytes.String()
+}
+
+// toValidUTF8 replaces the invalid UTF-8 sequences of the text, e.g. a character cut by a token boundary, with the
+// replacement character.
+func toValidUTF8(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+	return strings.ToValidUTF8(text, "�")
+}
@@ -0,0 +1,238 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decoder turns tokens back into text, e.g. removing the ## of WordPiece tokens or mapping the characters of byte
+// level tokens back to bytes. Decoders are chained: each one transforms the tokens of the previous one.
+type decoder interface {
+	decodeChain(tokens []string) []string
+}
+
+type decoderJSON struct {
+	Type           string        `json:"type"`
+	Prefix         *string       `json:"prefix"`
+	Cleanup        *bool         `json:"cleanup"`
+	Replacement    string        `json:"replacement"`
+	PrependScheme  string        `json:"prepend_scheme"`
+	AddPrefixSpace *bool         `json:"add_prefix_space"`
+	Pattern        patternJSON   `json:"pattern"`
+	Content        string        `json:"content"`
+	Start          int           `json:"start"`
+	Stop           int           `json:"stop"`
+	Suffix         *string       `json:"suffix"`
+	Decoders       []decoderJSON `json:"decoders"`
+}
+
+func newDecoder(config *decoderJSON) (decoder, error) {
+	if config == nil {
+		return nil, nil
+	}
+	switch config.Type {
+	case "WordPiece":
+		wordPiece := wordPieceDecoder{prefix: "##", cleanup: true}
+		if config.Prefix != nil {
+			wordPiece.prefix = *config.Prefix
+		}
+		if config.Cleanup != nil {
+			wordPiece.cleanup = *config.Cleanup
+		}
+		return wordPiece, nil
+	case "ByteLevel":
+		return decoderFunc(decodeByteLevel), nil
+	case "Metaspace":
+		metaspace := newMetaspace(&preTokenizerJSON{Replacement: config.Replacement, PrependScheme: config.PrependScheme, AddPrefixSpace: config.AddPrefixSpace})
+		return metaspaceDecoder{replacement: metaspace.replacement, stripFirst: metaspace.prependScheme != "never"}, nil
+	case "Replace":
+		if config.Pattern.String == nil {
+			return nil, fmt.Errorf("the Replace decoder only supports string patterns")
+		}
+		return replaceDecoder{pattern: *config.Pattern.String, content: config.Content}, nil
+	case "Strip":
+		return stripDecoder{content: config.Content, start: config.Start, stop: config.Stop}, nil
+	case "Fuse":
+		return decoderFunc(func(tokens []string) []string {
+			return []string{strings.Join(tokens, "")}
+		}), nil
+	case "ByteFallback":
+		return decoderFunc(decodeByteFallback), nil
+	case "BPEDecoder":
+		suffix := "</w>"
+		if config.Suffix != nil {
+			suffix = *config.Suffix
+		}
+		return bpeDecoder{suffix: suffix}, nil
+	case "Sequence":
+		var sequence sequenceDecoder
+		for i := range config.Decoders {
+			d, err := newDecoder(&config.Decoders[i])
+			if err != nil {
+				return nil, err
+			}
+			if d != nil {
+				sequence = append(sequence, d)
+			}
+		}
+		return sequence, nil
+	default:
+		return nil, fmt.Errorf("decoder of type %s is not supported", config.Type)
+	}
+}
+
+type decoderFunc func(tokens []string) []string
+
+func (f decoderFunc) decodeChain(tokens []string) []string {
+	return f(tokens)
+}
+
+type sequenceDecoder []decoder
+
+func (s sequenceDecoder) decodeChain(tokens []string) []string {
+	for _, d := range s {
+		tokens = d.decodeChain(tokens)
+	}
+	return tokens
+}
+
+type wordPieceDecoder struct {
+	prefix  string
+	cleanup bool
+}
+
+func (w wordPieceDecoder) decodeChain(tokens []string) []string {
+	decoded := make([]string, len(tokens))
+	for i, token := range tokens {
+		if i > 0 {
+			if strings.HasPrefix(token, w.prefix) {
+				token = strings.TrimPrefix(token, w.prefix)
+			} else {
+				token = " " + token
+			}
+		}
+		if w.cleanup {
+			token = cleanupTokenization(token)
+		}
+		decoded[i] = token
+	}
+	return decoded
+}
+
+var cleanupReplacer = strings.NewReplacer(
+	" .", ".",
+	" ?", "?",
+	" !", "!",
+	" ,", ",",
+	" ' ", "'",
+	" n't", "n't",
+	" 'm", "'m",
+	" do not", " don't",
+	" 's", "'s",
+	" 've", "'ve",
+	" 're", "'re",
+)
+
+// cleanupTokenization removes the spaces that WordPiece decoding adds before punctuation and contractions.
+func cleanupTokenization(token string) string {
+	return cleanupReplacer.Replace(token)
+}
+
+func decodeByteLevel(tokens []string) []string {
+	return []string{toValidUTF8(charsToBytes(strings.Join(tokens, "")))}
+}
+
+type metaspaceDecoder struct {
+	replacement string
+	stripFirst  bool
+}
+
+func (m metaspaceDecoder) decodeChain(tokens []string) []string {
+	decoded := make([]string, len(tokens))
+	for i, token := range tokens {
+		if i == 0 && m.stripFirst {
+			// the replacements of the first token are the prepended space
+			decoded[i] = strings.ReplaceAll(token, m.replacement, "")
+			continue
+		}
+		decoded[i] = strings.ReplaceAll(token, m.replacement, " ")
+	}
+	return decoded
+}
+
+type replaceDecoder struct {
+	pattern string
+	content string
+}
+
+func (r replaceDecoder) decodeChain(tokens []string) []string {
+	decoded := make([]string, len(tokens))
+	for i, token := range tokens {
+		decoded[i] = strings.ReplaceAll(token, r.pattern, r.content)
+	}
+	return decoded
+}
+
+// stripDecoder removes up to start occurrences of the content character at the start of each token, and up to stop
+// at its end.
+type stripDecoder struct {
+	content string
+	start   int
+	stop    int
+}
+
+func (s stripDecoder) decodeChain(tokens []string) []string {
+	decoded := make([]string, len(tokens))
+	for i, token := range tokens {
+		for n := 0; n < s.start && s.content != "" && strings.HasPrefix(token, s.content); n++ {
+			token = strings.TrimPrefix(token, s.content)
+		}
+		for n := 0; n < s.stop && s.content != "" && strings.HasSuffix(token, s.content); n++ {
+			token = strings.TrimSuffix(token, s.content)
+		}
+		decoded[i] = token
+	}
+	return decoded
+}
+
+// decodeByteFallback turns the <0xXX> byte tokens back into text, consecutive bytes forming UTF-8 characters.
+func decodeByteFallback(tokens []string) []string {
+	var decoded []string
+	var pending []byte
+	flush := func() {
+		if len(pending) > 0 {
+			decoded = append(decoded, toValidUTF8(string(pending)))
+			pending = nil
+		}
+	}
+	for _, token := range tokens {
+		if len(token) == 6 && strings.HasPrefix(token, "<0x") && strings.HasSuffix(token, ">") {
+			if b, err := strconv.ParseUint(token[3:5], 16, 8); err == nil {
+				pending = append(pending, byte(b))
+				continue
+			}
+		}
+		flush()
+		decoded = append(decoded, token)
+	}
+	flush()
+	return decoded
+}
+
+// bpeDecoder replaces the end of word suffix of the tokens with a space.
+type bpeDecoder struct {
+	suffix string
+}
+
+func (b bpeDecoder) decodeChain(tokens []string) []string {
+	decoded := make([]string, len(tokens))
+	for i, token := range tokens {
+		replacement := " "
+		if i == len(tokens)-1 {
+			replacement = ""
+		}
+		decoded[i] = strings.ReplaceAll(token, b.suffix, replacement)
+	}
+	return decoded
+}
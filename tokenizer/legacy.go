@@ -0,0 +1,532 @@
+package tokenizer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// Models saved with the slow tokenizers of the transformers library have no tokenizer.json, only the files of their
+// vocabulary: vocab.txt for WordPiece, vocab.json and merges.txt for byte level BPE, or a sentencepiece model. ReadJSON
+// converts these files into the tokenizer.json of the equivalent fast tokenizer, as the transformers library does, so
+// that both the Go and the rust tokenizers can load them. The special tokens and the options of the slow tokenizer,
+// e.g. do_lower_case, are read from tokenizer_config.json and special_tokens_map.json.
+
+// sentencePieceFiles are the names under which the slow tokenizers save their sentencepiece model.
+var sentencePieceFiles = []string{"spiece.model", "sentencepiece.model", "spm.model", "tokenizer.model", "sentencepiece.bpe.model"}
+
+// IsTokenizerFile reports whether the file, given by its name, is one from which a tokenizer can be loaded on its own:
+// tokenizer.json, vocab.txt or a sentencepiece model. The vocab.json of byte level BPE tokenizers also needs merges.txt.
+func IsTokenizerFile(name string) bool {
+	if name == "tokenizer.json" || name == "vocab.txt" {
+		return true
+	}
+	for _, file := range sentencePieceFiles {
+		if name == file {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadJSON returns the content of the tokenizer.json of the model folder. If the folder has no tokenizer.json, it is
+// built from the files of the slow tokenizer of the model.
+func ReadJSON(modelPath string) ([]byte, error) {
+	tokenizerPath := util.PathJoinSafe(modelPath, "tokenizer.json")
+	exists, err := fileExists(tokenizerPath)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return util.ReadFileBytes(tokenizerPath)
+	}
+
+	config, err := readLegacyConfig(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	hasVocabJSON, err := fileExists(util.PathJoinSafe(modelPath, "vocab.json"))
+	if err != nil {
+		return nil, err
+	}
+	hasMerges, err := fileExists(util.PathJoinSafe(modelPath, "merges.txt"))
+	if err != nil {
+		return nil, err
+	}
+	if hasVocabJSON && hasMerges {
+		return convertByteLevelBPE(modelPath, config)
+	}
+	for _, file := range sentencePieceFiles {
+		exists, err = fileExists(util.PathJoinSafe(modelPath, file))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return convertSentencePiece(util.PathJoinSafe(modelPath, file), config)
+		}
+	}
+	exists, err = fileExists(util.PathJoinSafe(modelPath, "vocab.txt"))
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return convertWordPiece(modelPath, config)
+	}
+	return nil, fmt.Errorf("no tokenizer.json, vocab.txt, vocab.json and merges.txt or sentencepiece model found in %s", modelPath)
+}
+
+func fileExists(path string) (bool, error) {
+	return util.FileSystem.Exists(context.Background(), path)
+}
+
+// legacyToken is a special token of tokenizer_config.json or special_tokens_map.json: either its content, or the
+// added token with its options.
+type legacyToken struct {
+	addedToken
+}
+
+func (t *legacyToken) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return jsoniter.Unmarshal(data, &t.Content)
+	}
+	return jsoniter.Unmarshal(data, &t.addedToken)
+}
+
+// legacySpecialTokens are the special tokens of a slow tokenizer, by role.
+type legacySpecialTokens struct {
+	UnkToken                *legacyToken  `json:"unk_token"`
+	BosToken                *legacyToken  `json:"bos_token"`
+	EosToken                *legacyToken  `json:"eos_token"`
+	PadToken                *legacyToken  `json:"pad_token"`
+	ClsToken                *legacyToken  `json:"cls_token"`
+	SepToken                *legacyToken  `json:"sep_token"`
+	MaskToken               *legacyToken  `json:"mask_token"`
+	AdditionalSpecialTokens []legacyToken `json:"additional_special_tokens"`
+}
+
+// legacyConfig is the configuration of a slow tokenizer, from tokenizer_config.json and special_tokens_map.json.
+type legacyConfig struct {
+	legacySpecialTokens
+	TokenizerClass       string                 `json:"tokenizer_class"`
+	DoLowerCase          *bool                  `json:"do_lower_case"`
+	StripAccents         *bool                  `json:"strip_accents"`
+	KeepAccents          *bool                  `json:"keep_accents"`
+	TokenizeChineseChars *bool                  `json:"tokenize_chinese_chars"`
+	AddPrefixSpace       *bool                  `json:"add_prefix_space"`
+	AddBosToken          *bool                  `json:"add_bos_token"`
+	AddEosToken          *bool                  `json:"add_eos_token"`
+	ExtraIDs             int                    `json:"extra_ids"`
+	AddedTokensDecoder   map[string]legacyToken `json:"added_tokens_decoder"`
+	addedTokensFile      map[string]uint32      // added_tokens.json
+}
+
+func readLegacyConfig(modelPath string) (*legacyConfig, error) {
+	config := &legacyConfig{}
+	if err := readOptionalJSON(util.PathJoinSafe(modelPath, "tokenizer_config.json"), config); err != nil {
+		return nil, err
+	}
+	// the special tokens map overrides the special tokens of the tokenizer config
+	specialTokens := legacySpecialTokens{}
+	if err := readOptionalJSON(util.PathJoinSafe(modelPath, "special_tokens_map.json"), &specialTokens); err != nil {
+		return nil, err
+	}
+	for _, override := range []struct{ token, value **legacyToken }{
+		{&config.UnkToken, &specialTokens.UnkToken},
+		{&config.BosToken, &specialTokens.BosToken},
+		{&config.EosToken, &specialTokens.EosToken},
+		{&config.PadToken, &specialTokens.PadToken},
+		{&config.ClsToken, &specialTokens.ClsToken},
+		{&config.SepToken, &specialTokens.SepToken},
+		{&config.MaskToken, &specialTokens.MaskToken},
+	} {
+		if *override.value != nil {
+			*override.token = *override.value
+		}
+	}
+	if len(specialTokens.AdditionalSpecialTokens) > 0 {
+		config.AdditionalSpecialTokens = specialTokens.AdditionalSpecialTokens
+	}
+	if err := readOptionalJSON(util.PathJoinSafe(modelPath, "added_tokens.json"), &config.addedTokensFile); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func readOptionalJSON(path string, value any) error {
+	exists, err := fileExists(path)
+	if err != nil || !exists {
+		return err
+	}
+	data, err := util.ReadFileBytes(path)
+	if err != nil {
+		return err
+	}
+	if err = jsoniter.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// setDefault sets the special token of the role to the token if the slow tokenizer does not define it and the token is
+// in the vocabulary, as the slow tokenizers have default special tokens.
+func setDefault(token **legacyToken, content string, ids map[string]uint32) {
+	if *token != nil {
+		return
+	}
+	if _, ok := ids[content]; ok {
+		*token = &legacyToken{addedToken{Content: content}}
+	}
+}
+
+func content(token *legacyToken) string {
+	if token == nil {
+		return ""
+	}
+	return token.Content
+}
+
+// isFairseq reports whether the tokenizer maps the ids of its sentencepiece model to those of a fairseq dictionary,
+// which the conversion does not support.
+func (c *legacyConfig) isFairseq() bool {
+	for _, class := range []string{"XLMRoberta", "Camembert", "MBart", "Nllb", "M2M100", "Barthez"} {
+		if strings.Contains(c.TokenizerClass, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// pairsWithDoubleSeparator reports whether text pairs are encoded as <s> A </s></s> B </s>, as in RoBERTa, rather
+// than as [CLS] A [SEP] B [SEP], as in BERT.
+func (c *legacyConfig) pairsWithDoubleSeparator(byteLevel bool) bool {
+	if c.TokenizerClass == "" {
+		return byteLevel
+	}
+	for _, class := range []string{"Roberta", "Bart", "Longformer", "MPNet", "Blenderbot"} {
+		if strings.Contains(c.TokenizerClass, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// addedTokens returns the added tokens of the tokenizer, whose ids are those of added_tokens_decoder in
+// tokenizer_config.json if present. Otherwise they are the special tokens and the tokens of added_tokens.json, the
+// tokens missing from the vocabulary being added after it.
+func (c *legacyConfig) addedTokens(ids map[string]uint32, vocabSize int, extraTokens []addedToken) ([]addedToken, error) {
+	var tokens []addedToken
+	if len(c.AddedTokensDecoder) > 0 {
+		for key, token := range c.AddedTokensDecoder {
+			id, err := strconv.ParseUint(key, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("malformed id %s of added_tokens_decoder: %w", key, err)
+			}
+			token.ID = uint32(id)
+			tokens = append(tokens, token.addedToken)
+		}
+	} else {
+		nextID := uint32(vocabSize + c.ExtraIDs)
+		seen := map[string]bool{}
+		add := func(token addedToken) {
+			if token.Content == "" || seen[token.Content] {
+				return
+			}
+			seen[token.Content] = true
+			if id, ok := c.addedTokensFile[token.Content]; ok {
+				token.ID = id
+			} else if id, ok := ids[token.Content]; ok {
+				token.ID = id
+			} else if n, ok := extraID(token.Content); ok && n < c.ExtraIDs {
+				// the sentinel tokens of T5 are numbered down from the end of the vocabulary
+				token.ID = uint32(vocabSize + c.ExtraIDs - 1 - n)
+			} else {
+				token.ID = nextID
+				nextID++
+			}
+			tokens = append(tokens, token)
+		}
+		for _, token := range []*legacyToken{c.UnkToken, c.BosToken, c.EosToken, c.PadToken, c.ClsToken, c.SepToken, c.MaskToken} {
+			if token != nil {
+				special := token.addedToken
+				special.Special = true
+				add(special)
+			}
+		}
+		for _, token := range c.AdditionalSpecialTokens {
+			special := token.addedToken
+			special.Special = true
+			add(special)
+		}
+		for _, token := range extraTokens {
+			add(token)
+		}
+		addedTokensFile := make([]string, 0, len(c.addedTokensFile))
+		for token := range c.addedTokensFile {
+			addedTokensFile = append(addedTokensFile, token)
+		}
+		sort.Slice(addedTokensFile, func(i, j int) bool {
+			return c.addedTokensFile[addedTokensFile[i]] < c.addedTokensFile[addedTokensFile[j]]
+		})
+		for _, token := range addedTokensFile {
+			add(addedToken{Content: token, Normalized: true})
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+// extraID returns the number of a T5 sentinel token <extra_id_N>.
+func extraID(token string) (int, bool) {
+	if !strings.HasPrefix(token, "<extra_id_") || !strings.HasSuffix(token, ">") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(token, "<extra_id_"), ">"))
+	return n, err == nil && n >= 0
+}
+
+// postProcessor returns the TemplateProcessing post-processor adding the special tokens the slow tokenizer adds:
+// [CLS] A [SEP] for BERT-like tokenizers, A <sep> <cls> for XLNet, or else the bos and eos tokens if the tokenizer adds
+// them. It returns nil if the tokenizer adds no special tokens.
+func (c *legacyConfig) postProcessor(addedTokens []addedToken, byteLevel bool, addBos bool, addEos bool) map[string]any {
+	ids := map[string]uint32{}
+	for _, token := range addedTokens {
+		ids[token.Content] = token.ID
+	}
+	cls, sep := content(c.ClsToken), content(c.SepToken)
+	_, hasCls := ids[cls]
+	_, hasSep := ids[sep]
+	var single, pair []string
+	switch {
+	case hasCls && hasSep && strings.Contains(c.TokenizerClass, "XLNet"):
+		single = []string{"$A:0", sep + ":0", cls + ":2"}
+		pair = []string{"$A:0", sep + ":0", "$B:1", sep + ":1", cls + ":2"}
+	case hasCls && hasSep && c.pairsWithDoubleSeparator(byteLevel):
+		single = []string{cls + ":0", "$A:0", sep + ":0"}
+		pair = []string{cls + ":0", "$A:0", sep + ":0", sep + ":0", "$B:0", sep + ":0"}
+	case hasCls && hasSep:
+		single = []string{cls + ":0", "$A:0", sep + ":0"}
+		pair = []string{cls + ":0", "$A:0", sep + ":0", "$B:1", sep + ":1"}
+	default:
+		if c.AddBosToken != nil {
+			addBos = *c.AddBosToken
+		}
+		if c.AddEosToken != nil {
+			addEos = *c.AddEosToken
+		}
+		bos, eos := content(c.BosToken), content(c.EosToken)
+		_, hasBos := ids[bos]
+		_, hasEos := ids[eos]
+		addBos, addEos = addBos && hasBos, addEos && hasEos
+		if !addBos && !addEos {
+			return nil
+		}
+		single, pair = []string{"$A:0"}, []string{"$A:0"}
+		if addBos {
+			single = append([]string{bos + ":0"}, single...)
+			pair = append([]string{bos + ":0"}, pair...)
+			pair = append(pair, bos+":1")
+		}
+		if addEos {
+			single = append(single, eos+":0")
+			pair = append(pair, eos+":0")
+		}
+		pair = append(pair, "$B:1")
+		if addEos {
+			pair = append(pair, eos+":1")
+		}
+	}
+	return templateProcessing(single, pair, ids)
+}
+
+// templateProcessing returns the TemplateProcessing post-processor of the single and pair templates, whose pieces are
+// special tokens or the sequences $A and $B, followed by their type id as in "[CLS]:0".
+func templateProcessing(single []string, pair []string, ids map[string]uint32) map[string]any {
+	specialTokens := map[string]any{}
+	template := func(pieces []string) []any {
+		entries := make([]any, len(pieces))
+		for i, piece := range pieces {
+			separator := strings.LastIndex(piece, ":")
+			name := piece[:separator]
+			typeID, _ := strconv.Atoi(piece[separator+1:])
+			if name == "$A" || name == "$B" {
+				entries[i] = map[string]any{"Sequence": map[string]any{"id": name[1:], "type_id": typeID}}
+				continue
+			}
+			entries[i] = map[string]any{"SpecialToken": map[string]any{"id": name, "type_id": typeID}}
+			specialTokens[name] = map[string]any{"id": name, "ids": []uint32{ids[name]}, "tokens": []string{name}}
+		}
+		return entries
+	}
+	return map[string]any{
+		"type":           "TemplateProcessing",
+		"single":         template(single),
+		"pair":           template(pair),
+		"special_tokens": specialTokens,
+	}
+}
+
+// tokenizerFile returns the content of tokenizer.json.
+func tokenizerFile(addedTokens []addedToken, normalizer any, preTokenizer any, postProcessor any, decoder any, model any) ([]byte, error) {
+	if addedTokens == nil {
+		addedTokens = []addedToken{}
+	}
+	return jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(map[string]any{
+		"version":        "1.0",
+		"truncation":     nil,
+		"padding":        nil,
+		"added_tokens":   addedTokens,
+		"normalizer":     normalizer,
+		"pre_tokenizer":  preTokenizer,
+		"post_processor": postProcessor,
+		"decoder":        decoder,
+		"model":          model,
+	})
+}
+
+// nilIfEmpty returns nil for a missing post-processor, so that it is serialized as null.
+func nilIfEmpty(postProcessor map[string]any) any {
+	if postProcessor == nil {
+		return nil
+	}
+	return postProcessor
+}
+
+func boolOr(value *bool, defaultValue bool) bool {
+	if value == nil {
+		return defaultValue
+	}
+	return *value
+}
+
+// convertWordPiece converts the vocab.txt of a BERT-like tokenizer, one token per line.
+func convertWordPiece(modelPath string, config *legacyConfig) ([]byte, error) {
+	vocabBytes, err := util.ReadFileBytes(util.PathJoinSafe(modelPath, "vocab.txt"))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(vocabBytes), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	ids := make(map[string]uint32, len(lines))
+	for i, line := range lines {
+		ids[strings.TrimSuffix(line, "\r")] = uint32(i)
+	}
+
+	setDefault(&config.UnkToken, "[UNK]", ids)
+	setDefault(&config.ClsToken, "[CLS]", ids)
+	setDefault(&config.SepToken, "[SEP]", ids)
+	setDefault(&config.PadToken, "[PAD]", ids)
+	setDefault(&config.MaskToken, "[MASK]", ids)
+	addedTokens, err := config.addedTokens(ids, len(lines), nil)
+	if err != nil {
+		return nil, err
+	}
+	unkToken := content(config.UnkToken)
+	if unkToken == "" {
+		unkToken = "[UNK]"
+	}
+
+	return tokenizerFile(addedTokens,
+		map[string]any{
+			"type":                 "BertNormalizer",
+			"clean_text":           true,
+			"handle_chinese_chars": boolOr(config.TokenizeChineseChars, true),
+			"strip_accents":        config.StripAccents,
+			"lowercase":            boolOr(config.DoLowerCase, true),
+		},
+		map[string]any{"type": "BertPreTokenizer"},
+		nilIfEmpty(config.postProcessor(addedTokens, false, false, false)),
+		map[string]any{"type": "WordPiece", "prefix": "##", "cleanup": true},
+		map[string]any{
+			"type":                      "WordPiece",
+			"unk_token":                 unkToken,
+			"continuing_subword_prefix": "##",
+			"max_input_chars_per_word":  100,
+			"vocab":                     ids,
+		},
+	)
+}
+
+// convertByteLevelBPE converts the vocab.json and merges.txt of a GPT-2 or RoBERTa-like tokenizer.
+func convertByteLevelBPE(modelPath string, config *legacyConfig) ([]byte, error) {
+	vocabBytes, err := util.ReadFileBytes(util.PathJoinSafe(modelPath, "vocab.json"))
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]uint32{}
+	if err = jsoniter.Unmarshal(vocabBytes, &ids); err != nil {
+		return nil, fmt.Errorf("reading vocab.json: %w", err)
+	}
+	mergesBytes, err := util.ReadFileBytes(util.PathJoinSafe(modelPath, "merges.txt"))
+	if err != nil {
+		return nil, err
+	}
+	merges := []string{}
+	for _, line := range strings.Split(string(mergesBytes), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#version") {
+			continue
+		}
+		merges = append(merges, line)
+	}
+
+	setDefault(&config.BosToken, "<s>", ids)
+	setDefault(&config.EosToken, "</s>", ids)
+	setDefault(&config.UnkToken, "<unk>", ids)
+	setDefault(&config.PadToken, "<pad>", ids)
+	setDefault(&config.MaskToken, "<mask>", ids)
+	setDefault(&config.BosToken, "<|endoftext|>", ids)
+	setDefault(&config.EosToken, "<|endoftext|>", ids)
+	setDefault(&config.UnkToken, "<|endoftext|>", ids)
+	if config.MaskToken != nil && config.MaskToken.Content == "<mask>" && !config.MaskToken.LStrip {
+		// the mask token of RoBERTa includes the space before it
+		config.MaskToken.LStrip = true
+	}
+	vocabSize := 0
+	for _, id := range ids {
+		if int(id) >= vocabSize {
+			vocabSize = int(id) + 1
+		}
+	}
+	addedTokens, err := config.addedTokens(ids, vocabSize, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	addPrefixSpace := boolOr(config.AddPrefixSpace, false)
+	byteLevel := map[string]any{"type": "ByteLevel", "add_prefix_space": addPrefixSpace, "trim_offsets": true, "use_regex": true}
+	var postProcessor any = map[string]any{"type": "ByteLevel", "add_prefix_space": addPrefixSpace, "trim_offsets": false, "use_regex": true}
+	if template := config.postProcessor(addedTokens, true, false, false); template != nil {
+		// the special tokens are added after the offsets of the tokens are trimmed, as in RobertaProcessing
+		postProcessor = map[string]any{"type": "Sequence", "processors": []any{byteLevel, template}}
+	}
+	var unkToken any
+	if config.UnkToken != nil {
+		unkToken = config.UnkToken.Content
+	}
+
+	return tokenizerFile(addedTokens,
+		nil,
+		byteLevel,
+		postProcessor,
+		map[string]any{"type": "ByteLevel", "add_prefix_space": true, "trim_offsets": true, "use_regex": true},
+		map[string]any{
+			"type":                      "BPE",
+			"dropout":                   nil,
+			"unk_token":                 unkToken,
+			"continuing_subword_prefix": "",
+			"end_of_word_suffix":        "",
+			"fuse_unk":                  false,
+			"byte_fallback":             false,
+			"vocab":                     ids,
+			"merges":                    merges,
+		},
+	)
+}
@@ -0,0 +1,182 @@
+package tokenizer
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		check(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+func readLegacyTokenizer(t *testing.T, dir string) *Tokenizer {
+	t.Helper()
+	data, err := ReadJSON(dir)
+	check(t, err)
+	tk, err := FromBytes(data)
+	check(t, err)
+	return tk
+}
+
+// sentencePieceModelBytes encodes the ModelProto of a sentencepiece model with the pieces, given with their score
+// and type.
+func sentencePieceModelBytes(modelType int, pieces []sentencePiece) []byte {
+	var model []byte
+	for _, piece := range pieces {
+		var message []byte
+		message = protowire.AppendTag(message, 1, protowire.BytesType)
+		message = protowire.AppendString(message, piece.piece)
+		message = protowire.AppendTag(message, 2, protowire.Fixed32Type)
+		message = protowire.AppendFixed32(message, math.Float32bits(float32(piece.score)))
+		message = protowire.AppendTag(message, 3, protowire.VarintType)
+		message = protowire.AppendVarint(message, uint64(piece.kind))
+		model = protowire.AppendTag(model, 1, protowire.BytesType)
+		model = protowire.AppendBytes(model, message)
+	}
+	var trainerSpec []byte
+	trainerSpec = protowire.AppendTag(trainerSpec, 3, protowire.VarintType)
+	trainerSpec = protowire.AppendVarint(trainerSpec, uint64(modelType))
+	model = protowire.AppendTag(model, 2, protowire.BytesType)
+	return protowire.AppendBytes(model, trainerSpec)
+}
+
+func TestLegacyWordPiece(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"vocab.txt":             "[PAD]\n[UNK]\n[CLS]\n[SEP]\n[MASK]\nhello\nworld\n!\n,\nun\n##aff\n##able\ncafe\n",
+		"tokenizer_config.json": `{"do_lower_case": true, "tokenizer_class": "BertTokenizer"}`,
+	})
+	tk := readLegacyTokenizer(t, dir)
+	expected, err := FromBytes([]byte(wordPieceJSON))
+	check(t, err)
+
+	// the converted tokenizer encodes as the tokenizer.json of the same vocabulary
+	for _, input := range []string{"Hello, unaffable World!", "[MASK] xyz café", ""} {
+		assert.Equal(t, expected.Encode(input, true), tk.Encode(input, true), input)
+	}
+	assert.Equal(t, "hello, unaffable world!", tk.Decode(tk.Encode("Hello, unaffable World!", true).IDs, true))
+
+	// casing is kept if the tokenizer does not lowercase
+	check(t, os.WriteFile(filepath.Join(dir, "tokenizer_config.json"), []byte(`{"do_lower_case": false}`), 0o644))
+	tk = readLegacyTokenizer(t, dir)
+	assert.Equal(t, []uint32{2, 1, 6, 3}, tk.Encode("Hello world", true).IDs)
+}
+
+func TestLegacyByteLevelBPE(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"vocab.json":              `{"h": 0, "e": 1, "l": 2, "o": 3, "Ġ": 4, "w": 5, "r": 6, "d": 7, "he": 8, "ll": 9, "hell": 10, "hello": 11, "Ġw": 12, "or": 13, "Ġwor": 14, "Ġworl": 15, "Ġworld": 16, "<|endoftext|>": 17}`,
+		"merges.txt":              "#version: 0.2\nh e\nl l\nhe ll\nhell o\nĠ w\no r\nĠw or\nĠwor l\nĠworl d\n",
+		"special_tokens_map.json": `{"bos_token": "<|endoftext|>", "eos_token": "<|endoftext|>", "unk_token": {"content": "<|endoftext|>", "lstrip": false, "normalized": true, "rstrip": false, "single_word": false}}`,
+	})
+	tk := readLegacyTokenizer(t, dir)
+
+	encoding := tk.Encode("hello world<|endoftext|>", true)
+	assert.Equal(t, []uint32{11, 16, 17}, encoding.IDs)
+	assert.Equal(t, []string{"hello", "Ġworld", "<|endoftext|>"}, encoding.Tokens)
+	// as for GPT-2, the offsets are not trimmed and no special tokens are added
+	assert.Equal(t, []Offset{{0, 5}, {5, 11}, {11, 24}}, encoding.Offsets)
+	assert.Equal(t, "hello world", tk.Decode(encoding.IDs, true))
+}
+
+func TestLegacyByteLevelBPEWithSpecialTokens(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"vocab.json":              `{"<s>": 0, "<pad>": 1, "</s>": 2, "<unk>": 3, "h": 4, "e": 5, "l": 6, "o": 7, "he": 8, "ll": 9, "hell": 10, "hello": 11, "Ġ": 12, "Ġhello": 13, "<mask>": 14}`,
+		"merges.txt":              "#version: 0.2\nh e\nl l\nhe ll\nhell o\nĠ hello\n",
+		"tokenizer_config.json":   `{"tokenizer_class": "RobertaTokenizer"}`,
+		"special_tokens_map.json": `{"bos_token": "<s>", "eos_token": "</s>", "unk_token": "<unk>", "sep_token": "</s>", "cls_token": "<s>", "pad_token": "<pad>", "mask_token": "<mask>"}`,
+	})
+	tk := readLegacyTokenizer(t, dir)
+
+	encoding := tk.Encode("hello hello <mask>", true)
+	assert.Equal(t, []uint32{0, 11, 13, 14, 2}, encoding.IDs)
+	assert.Equal(t, []uint32{1, 0, 0, 0, 1}, encoding.SpecialTokensMask)
+	// the offsets are trimmed as in RoBERTa, and the mask token includes the space before it
+	assert.Equal(t, []Offset{{0, 0}, {0, 5}, {6, 11}, {11, 18}, {0, 0}}, encoding.Offsets)
+}
+
+func TestLegacySentencePieceUnigram(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"spiece.model": string(sentencePieceModelBytes(sentencePieceUnigram, []sentencePiece{
+			{piece: "<unk>", kind: pieceUnknown},
+			{piece: "</s>", kind: pieceControl},
+			{piece: "▁", score: -2, kind: pieceNormal},
+			{piece: "▁hello", score: -1, kind: pieceNormal},
+			{piece: "▁world", score: -1.5, kind: pieceNormal},
+			{piece: "▁wor", score: -3, kind: pieceNormal},
+			{piece: "ld", score: -3, kind: pieceNormal},
+			{piece: "▁he", score: -4, kind: pieceNormal},
+			{piece: "llo", score: -4, kind: pieceNormal},
+		})),
+		"tokenizer_config.json": `{"tokenizer_class": "T5Tokenizer", "eos_token": "</s>", "unk_token": "<unk>", "extra_ids": 2, "additional_special_tokens": ["<extra_id_0>", "<extra_id_1>"]}`,
+	})
+	tk := readLegacyTokenizer(t, dir)
+	expected, err := FromBytes([]byte(unigramJSON))
+	check(t, err)
+
+	for _, input := range []string{"hello world", "hello xyz"} {
+		assert.Equal(t, expected.Encode(input, true).IDs, tk.Encode(input, true).IDs, input)
+		assert.Equal(t, expected.Encode(input, true).Offsets, tk.Encode(input, true).Offsets, input)
+	}
+	assert.Equal(t, "hello world", tk.Decode(tk.Encode("hello world", true).IDs, true))
+	// as sentencepiece does, runs of spaces are collapsed
+	assert.Equal(t, []uint32{3, 4, 2, 1}, tk.Encode("hello   world  ", true).IDs)
+
+	// the sentinel tokens are numbered down from the end of the vocabulary
+	id, ok := tk.TokenToID("<extra_id_0>")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(10), id)
+	id, ok = tk.TokenToID("<extra_id_1>")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(9), id)
+}
+
+func TestLegacySentencePieceBPE(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"tokenizer.model": string(sentencePieceModelBytes(sentencePieceBPE, []sentencePiece{
+			{piece: "<unk>", kind: pieceUnknown},
+			{piece: "<s>", kind: pieceControl},
+			{piece: "</s>", kind: pieceControl},
+			{piece: "▁", score: -1, kind: pieceNormal},
+			{piece: "h", score: -2, kind: pieceNormal},
+			{piece: "e", score: -3, kind: pieceNormal},
+			{piece: "l", score: -4, kind: pieceNormal},
+			{piece: "o", score: -5, kind: pieceNormal},
+			{piece: "ll", score: -6, kind: pieceNormal},
+			{piece: "▁h", score: -7, kind: pieceNormal},
+			{piece: "▁he", score: -8, kind: pieceNormal},
+			{piece: "▁hell", score: -9, kind: pieceNormal},
+			{piece: "▁hello", score: -10, kind: pieceNormal},
+		})),
+	})
+	tk := readLegacyTokenizer(t, dir)
+
+	// the merges are rebuilt from the pieces, and the bos token is added as in Llama
+	encoding := tk.Encode("hello x", true)
+	assert.Equal(t, []uint32{1, 12, 3, 0}, encoding.IDs)
+	assert.Equal(t, []string{"<s>", "▁hello", "▁", "<unk>"}, encoding.Tokens)
+	assert.Equal(t, "hello o", tk.Decode([]uint32{1, 12, 3, 7}, true))
+}
+
+func TestLegacyMissingTokenizer(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"config.json": "{}"})
+	_, err := ReadJSON(dir)
+	assert.Error(t, err)
+
+	// tokenizer.json takes precedence over the files of the slow tokenizer
+	dir = writeFiles(t, map[string]string{"tokenizer.json": byteLevelBPEJSON, "vocab.txt": "[UNK]\n"})
+	data, err := ReadJSON(dir)
+	check(t, err)
+	assert.Equal(t, byteLevelBPEJSON, string(data))
+
+	assert.True(t, IsTokenizerFile("spiece.model"))
+	assert.False(t, IsTokenizerFile("vocab.json"))
+}
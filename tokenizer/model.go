@@ -0,0 +1,109 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// model splits a word of the pre-tokenized input into the tokens of its vocabulary.
+type model interface {
+	tokenize(word string) []token
+	tokenToID(token string) (uint32, bool)
+	idToToken(id uint32) (string, bool)
+	vocabSize() int
+}
+
+// token is a token of a word, with its span in bytes in the word.
+type token struct {
+	id    uint32
+	value string
+	start int
+	end   int
+}
+
+type modelJSON struct {
+	Type                    string                `json:"type"`
+	Vocab                   jsoniter.RawMessage   `json:"vocab"`
+	Merges                  []jsoniter.RawMessage `json:"merges"`
+	UnkToken                *string               `json:"unk_token"`
+	UnkID                   *int                  `json:"unk_id"`
+	ContinuingSubwordPrefix *string               `json:"continuing_subword_prefix"`
+	EndOfWordSuffix         *string               `json:"end_of_word_suffix"`
+	MaxInputCharsPerWord    int                   `json:"max_input_chars_per_word"`
+	FuseUnk                 bool                  `json:"fuse_unk"`
+	ByteFallback            bool                  `json:"byte_fallback"`
+	IgnoreMerges            bool                  `json:"ignore_merges"`
+}
+
+// newModel creates the model of the tokenizer. The added tokens complete its vocabulary for the unknown token, which
+// some tokenizer.json files only list in their added tokens.
+func newModel(config *modelJSON, addedTokenIDs map[string]uint32) (model, error) {
+	if config == nil {
+		return nil, errors.New("the tokenizer has no model")
+	}
+	modelType := config.Type
+	if modelType == "" {
+		// older tokenizer.json files do not have the type of the model
+		switch {
+		case config.Merges != nil:
+			modelType = "BPE"
+		case len(config.Vocab) > 0 && config.Vocab[0] == '[':
+			modelType = "Unigram"
+		default:
+			modelType = "WordPiece"
+		}
+	}
+	switch modelType {
+	case "WordPiece":
+		return newWordPiece(config, addedTokenIDs)
+	case "BPE":
+		return newBPE(config, addedTokenIDs)
+	case "Unigram":
+		return newUnigram(config)
+	default:
+		return nil, fmt.Errorf("model of type %s is not supported", modelType)
+	}
+}
+
+// vocabulary maps the tokens of a model to their ids and back.
+type vocabulary struct {
+	ids    map[string]uint32
+	tokens map[uint32]string
+}
+
+func newVocabulary(data jsoniter.RawMessage) (vocabulary, error) {
+	v := vocabulary{}
+	if err := jsoniter.Unmarshal(data, &v.ids); err != nil {
+		return v, fmt.Errorf("reading the vocabulary of the model: %w", err)
+	}
+	v.tokens = make(map[uint32]string, len(v.ids))
+	for token, id := range v.ids {
+		v.tokens[id] = token
+	}
+	return v, nil
+}
+
+// unknownTokenID returns the id of the unknown token, from the vocabulary or else from the added tokens.
+func (v vocabulary) unknownTokenID(token string, addedTokenIDs map[string]uint32) (uint32, bool) {
+	if id, ok := v.ids[token]; ok {
+		return id, true
+	}
+	id, ok := addedTokenIDs[token]
+	return id, ok
+}
+
+func (v vocabulary) tokenToID(token string) (uint32, bool) {
+	id, ok := v.ids[token]
+	return id, ok
+}
+
+func (v vocabulary) idToToken(id uint32) (string, bool) {
+	token, ok := v.tokens[id]
+	return token, ok
+}
+
+func (v vocabulary) vocabSize() int {
+	return len(v.ids)
+}
@@ -0,0 +1,336 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizedString is a part of the input as transformed by the normalizer and the pre-tokenizer, with the span in
+// the input of each of its bytes, so that the offsets of its tokens can be mapped back to the input.
+type normalizedString struct {
+	text  string
+	spans []Offset // spans[i] is the span in the input of the byte i of text
+}
+
+// newNormalizedString creates the normalized string of a part of the input starting at the byte start of the input.
+func newNormalizedString(text string, start int) normalizedString {
+	spans := make([]Offset, len(text))
+	for i := 0; i < len(text); {
+		_, length := utf8.DecodeRuneInString(text[i:])
+		for j := i; j < i+length; j++ {
+			spans[j] = Offset{uint(start + i), uint(start + i + length)}
+		}
+		i += length
+	}
+	return normalizedString{text: text, spans: spans}
+}
+
+// transform replaces the string part by part: replace is called with the rest of the text, and returns the length in
+// bytes of the part it replaces and its replacement. A length of zero keeps the next rune as it is. All the bytes of a
+// replacement have the span of the part it replaces.
+func (n normalizedString) transform(replace func(rest string) (int, string)) normalizedString {
+	var text strings.Builder
+	text.Grow(len(n.text))
+	spans := make([]Offset, 0, len(n.spans))
+	for i := 0; i < len(n.text); {
+		length, replacement := replace(n.text[i:])
+		if length <= 0 {
+			_, length = utf8.DecodeRuneInString(n.text[i:])
+			replacement = n.text[i : i+length]
+		}
+		span := Offset{n.spans[i][0], n.spans[i+length-1][1]}
+		text.WriteString(replacement)
+		for j := 0; j < len(replacement); j++ {
+			spans = append(spans, span)
+		}
+		i += length
+	}
+	return normalizedString{text: text.String(), spans: spans}
+}
+
+// mapRunes replaces each rune of the string with the string returned by f.
+func (n normalizedString) mapRunes(f func(r rune) string) normalizedString {
+	return n.transform(func(rest string) (int, string) {
+		r, length := utf8.DecodeRuneInString(rest)
+		return length, f(r)
+	})
+}
+
+// prepend inserts the prefix at the start of the string, with the span of its first rune.
+func (n normalizedString) prepend(prefix string) normalizedString {
+	if n.text == "" {
+		return n
+	}
+	first := true
+	return n.transform(func(rest string) (int, string) {
+		if !first {
+			return 0, ""
+		}
+		first = false
+		_, length := utf8.DecodeRuneInString(rest)
+		return length, prefix + rest[:length]
+	})
+}
+
+// slice returns the part of the string between the bytes start and end.
+func (n normalizedString) slice(start int, end int) normalizedString {
+	return normalizedString{text: n.text[start:end], spans: n.spans[start:end]}
+}
+
+// span returns the span in the input of the bytes of the string between start and end.
+func (n normalizedString) span(start int, end int) Offset {
+	if start >= end || end > len(n.spans) {
+		return Offset{}
+	}
+	return Offset{n.spans[start][0], n.spans[end-1][1]}
+}
+
+// normalizer transforms the input before it is split into words, e.g. to lowercase it.
+type normalizer interface {
+	normalize(n normalizedString) normalizedString
+}
+
+type normalizerJSON struct {
+	Type                string           `json:"type"`
+	CleanText           *bool            `json:"clean_text"`
+	HandleChineseChars  *bool            `json:"handle_chinese_chars"`
+	StripAccents        *bool            `json:"strip_accents"`
+	Lowercase           *bool            `json:"lowercase"`
+	StripLeft           bool             `json:"strip_left"`
+	StripRight          bool             `json:"strip_right"`
+	Pattern             patternJSON      `json:"pattern"`
+	Content             string           `json:"content"`
+	Prepend             string           `json:"prepend"`
+	PrecompiledCharsmap []byte           `json:"precompiled_charsmap"`
+	Normalizers         []normalizerJSON `json:"normalizers"`
+}
+
+// patternJSON is the pattern of the Replace normalizer and of the Split pre-tokenizer, a string or a regex.
+type patternJSON struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+func newNormalizer(config *normalizerJSON) (normalizer, error) {
+	if config == nil {
+		return nil, nil
+	}
+	switch config.Type {
+	case "BertNormalizer":
+		bert := bertNormalizer{cleanText: true, handleChineseChars: true, lowercase: true}
+		if config.CleanText != nil {
+			bert.cleanText = *config.CleanText
+		}
+		if config.HandleChineseChars != nil {
+			bert.handleChineseChars = *config.HandleChineseChars
+		}
+		if config.Lowercase != nil {
+			bert.lowercase = *config.Lowercase
+		}
+		// accents are stripped when lowercasing unless explicitly disabled
+		bert.stripAccents = bert.lowercase
+		if config.StripAccents != nil {
+			bert.stripAccents = *config.StripAccents
+		}
+		return bert, nil
+	case "Lowercase":
+		return normalizerFunc(lowercase), nil
+	case "NFC":
+		return unicodeNormalizer{form: norm.NFC}, nil
+	case "NFD":
+		return unicodeNormalizer{form: norm.NFD}, nil
+	case "NFKC":
+		return unicodeNormalizer{form: norm.NFKC}, nil
+	case "NFKD":
+		return unicodeNormalizer{form: norm.NFKD}, nil
+	case "StripAccents":
+		return normalizerFunc(stripAccents), nil
+	case "Strip":
+		return stripNormalizer{left: config.StripLeft, right: config.StripRight}, nil
+	case "Replace":
+		pattern, err := newPattern(config.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return replaceNormalizer{pattern: pattern, content: config.Content}, nil
+	case "Prepend":
+		return prependNormalizer{prefix: config.Prepend}, nil
+	case "Precompiled":
+		return newPrecompiled(config.PrecompiledCharsmap)
+	case "Sequence":
+		var sequence sequenceNormalizer
+		for i := range config.Normalizers {
+			n, err := newNormalizer(&config.Normalizers[i])
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				sequence = append(sequence, n)
+			}
+		}
+		return sequence, nil
+	default:
+		return nil, fmt.Errorf("normalizer of type %s is not supported", config.Type)
+	}
+}
+
+type normalizerFunc func(n normalizedString) normalizedString
+
+func (f normalizerFunc) normalize(n normalizedString) normalizedString {
+	return f(n)
+}
+
+type sequenceNormalizer []normalizer
+
+func (s sequenceNormalizer) normalize(n normalizedString) normalizedString {
+	for _, normalizer := range s {
+		n = normalizer.normalize(n)
+	}
+	return n
+}
+
+// bertNormalizer is the normalizer of the original BERT tokenizer.
+type bertNormalizer struct {
+	cleanText          bool
+	handleChineseChars bool
+	stripAccents       bool
+	lowercase          bool
+}
+
+func (b bertNormalizer) normalize(n normalizedString) normalizedString {
+	if b.cleanText {
+		n = n.mapRunes(func(r rune) string {
+			switch {
+			case r == 0 || r == utf8.RuneError || isControl(r):
+				return ""
+			case unicode.IsSpace(r):
+				return " "
+			default:
+				return string(r)
+			}
+		})
+	}
+	if b.handleChineseChars {
+		n = n.mapRunes(func(r rune) string {
+			if isChineseChar(r) {
+				return " " + string(r) + " "
+			}
+			return string(r)
+		})
+	}
+	if b.stripAccents {
+		n = stripAccents(n)
+	}
+	if b.lowercase {
+		n = lowercase(n)
+	}
+	return n
+}
+
+func lowercase(n normalizedString) normalizedString {
+	return n.mapRunes(func(r rune) string {
+		return strings.ToLower(string(r))
+	})
+}
+
+func stripAccents(n normalizedString) normalizedString {
+	return unicodeNormalizer{form: norm.NFD}.normalize(n).mapRunes(func(r rune) string {
+		if unicode.Is(unicode.Mn, r) {
+			return ""
+		}
+		return string(r)
+	})
+}
+
+// unicodeNormalizer applies a unicode normalization form.
+type unicodeNormalizer struct {
+	form norm.Form
+}
+
+func (u unicodeNormalizer) normalize(n normalizedString) normalizedString {
+	return n.transform(func(rest string) (int, string) {
+		length := u.form.NextBoundaryInString(rest, true)
+		if length <= 0 {
+			length = len(rest)
+		}
+		return length, u.form.String(rest[:length])
+	})
+}
+
+type stripNormalizer struct {
+	left  bool
+	right bool
+}
+
+func (s stripNormalizer) normalize(n normalizedString) normalizedString {
+	start, end := 0, len(n.text)
+	if s.left {
+		start = len(n.text) - len(strings.TrimLeftFunc(n.text, unicode.IsSpace))
+	}
+	if s.right {
+		end = len(strings.TrimRightFunc(n.text, unicode.IsSpace))
+	}
+	if start >= end {
+		return normalizedString{}
+	}
+	return n.slice(start, end)
+}
+
+type replaceNormalizer struct {
+	pattern pattern
+	content string
+}
+
+func (r replaceNormalizer) normalize(n normalizedString) normalizedString {
+	matches := r.pattern.findAll(n.text)
+	if len(matches) == 0 {
+		return n
+	}
+	next := 0
+	return n.transform(func(rest string) (int, string) {
+		position := len(n.text) - len(rest)
+		for next < len(matches) && matches[next][0] < position {
+			next++
+		}
+		if next < len(matches) && matches[next][0] == position && matches[next][1] > position {
+			length := matches[next][1] - position
+			next++
+			return length, r.content
+		}
+		return 0, ""
+	})
+}
+
+type prependNormalizer struct {
+	prefix string
+}
+
+func (p prependNormalizer) normalize(n normalizedString) normalizedString {
+	return n.prepend(p.prefix)
+}
+
+// isControl reports whether the rune is a control character, not counting the tab and line breaks that are handled
+// as whitespace.
+func isControl(r rune) bool {
+	switch r {
+	case '\t', '\n', '\r':
+		return false
+	}
+	return unicode.In(r, unicode.Cc, unicode.Cf, unicode.Co, unicode.Cs)
+}
+
+// isChineseChar reports whether the rune is in the CJK Unicode blocks.
+func isChineseChar(r rune) bool {
+	return (r >= 0x4E00 && r <= 0x9FFF) ||
+		(r >= 0x3400 && r <= 0x4DBF) ||
+		(r >= 0x20000 && r <= 0x2A6DF) ||
+		(r >= 0x2A700 && r <= 0x2B73F) ||
+		(r >= 0x2B740 && r <= 0x2B81F) ||
+		(r >= 0x2B920 && r <= 0x2CEAF) ||
+		(r >= 0xF900 && r <= 0xFAFF) ||
+		(r >= 0x2F800 && r <= 0x2FA1F)
+}
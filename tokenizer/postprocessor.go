@@ -0,0 +1,187 @@
+package tokenizer
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// postProcessor adds the special tokens of the model around the tokens of a sequence, e.g. [CLS] and [SEP], and
+// trims the spaces from the offsets of byte level tokens.
+type postProcessor struct {
+	template       []templatePiece
+	trimOffsets    bool
+	addPrefixSpace bool
+}
+
+// templatePiece is the sequence of tokens, or special tokens to insert, of the post-processor template.
+type templatePiece struct {
+	sequence bool
+	typeID   uint32
+	ids      []uint32
+	tokens   []string
+}
+
+type postProcessorJSON struct {
+	Type           string                         `json:"type"`
+	Single         []map[string]templateEntryJSON `json:"single"`
+	SpecialTokens  map[string]specialTokenJSON    `json:"special_tokens"`
+	Sep            []any                          `json:"sep"`
+	Cls            []any                          `json:"cls"`
+	TrimOffsets    *bool                          `json:"trim_offsets"`
+	AddPrefixSpace *bool                          `json:"add_prefix_space"`
+	Processors     []postProcessorJSON            `json:"processors"`
+}
+
+type templateEntryJSON struct {
+	ID     string `json:"id"`
+	TypeID uint32 `json:"type_id"`
+}
+
+type specialTokenJSON struct {
+	IDs    []uint32 `json:"ids"`
+	Tokens []string `json:"tokens"`
+}
+
+func newPostProcessor(config *postProcessorJSON) (*postProcessor, error) {
+	p := &postProcessor{template: []templatePiece{{sequence: true}}}
+	if config == nil {
+		return p, nil
+	}
+	return p, p.configure(config)
+}
+
+func (p *postProcessor) configure(config *postProcessorJSON) error {
+	switch config.Type {
+	case "TemplateProcessing":
+		p.template = nil
+		for _, entry := range config.Single {
+			if sequence, ok := entry["Sequence"]; ok {
+				p.template = append(p.template, templatePiece{sequence: true, typeID: sequence.TypeID})
+			} else if special, ok := entry["SpecialToken"]; ok {
+				token, found := config.SpecialTokens[special.ID]
+				if !found {
+					return fmt.Errorf("special token %s of the post-processor template is not defined", special.ID)
+				}
+				p.template = append(p.template, templatePiece{typeID: special.TypeID, ids: token.IDs, tokens: token.Tokens})
+			}
+		}
+	case "BertProcessing", "RobertaProcessing":
+		cls, err := specialTokenPiece(config.Cls)
+		if err != nil {
+			return err
+		}
+		sep, err := specialTokenPiece(config.Sep)
+		if err != nil {
+			return err
+		}
+		p.template = []templatePiece{cls, {sequence: true}, sep}
+		if config.Type == "RobertaProcessing" {
+			p.configureByteLevel(config)
+		}
+	case "ByteLevel":
+		p.configureByteLevel(config)
+	case "Sequence":
+		for i := range config.Processors {
+			if err := p.configure(&config.Processors[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("post-processor of type %s is not supported", config.Type)
+	}
+	return nil
+}
+
+func (p *postProcessor) configureByteLevel(config *postProcessorJSON) {
+	p.trimOffsets, p.addPrefixSpace = true, true
+	if config.TrimOffsets != nil {
+		p.trimOffsets = *config.TrimOffsets
+	}
+	if config.AddPrefixSpace != nil {
+		p.addPrefixSpace = *config.AddPrefixSpace
+	}
+}
+
+// specialTokenPiece parses a ["token", id] tuple of the Bert and Roberta post-processors.
+func specialTokenPiece(tuple []any) (templatePiece, error) {
+	if len(tuple) != 2 {
+		return templatePiece{}, fmt.Errorf("malformed special token %v in the post-processor", tuple)
+	}
+	token, okToken := tuple[0].(string)
+	id, okID := tuple[1].(float64)
+	if !okToken || !okID {
+		return templatePiece{}, fmt.Errorf("malformed special token %v in the post-processor", tuple)
+	}
+	return templatePiece{ids: []uint32{uint32(id)}, tokens: []string{token}}, nil
+}
+
+// process trims the offsets of the encoding and, if addSpecialTokens is set, adds the special tokens of the template.
+func (p *postProcessor) process(encoding Encoding, addSpecialTokens bool) Encoding {
+	if p.trimOffsets {
+		trimByteLevelOffsets(encoding, p.addPrefixSpace)
+	}
+	if !addSpecialTokens {
+		return encoding
+	}
+	processed := Encoding{}
+	for _, piece := range p.template {
+		if piece.sequence {
+			for i := range encoding.IDs {
+				processed.append(encoding.IDs[i], encoding.Tokens[i], encoding.Offsets[i], piece.typeID, 0)
+			}
+			continue
+		}
+		for i, id := range piece.ids {
+			token := ""
+			if i < len(piece.tokens) {
+				token = piece.tokens[i]
+			}
+			processed.append(id, token, Offset{}, piece.typeID, 1)
+		}
+	}
+	return processed
+}
+
+// trimByteLevelOffsets removes the spaces, encoded as Ġ by the byte level pre-tokenizer, from the offsets of the
+// tokens, except for the space added in front of the first token.
+func trimByteLevelOffsets(encoding Encoding, addPrefixSpace bool) {
+	space := byteToChar[' ']
+	isSpace := func(r rune) bool { return r == space || unicode.IsSpace(r) }
+	for i, token := range encoding.Tokens {
+		leading, trailing := 0, 0
+		for rest := token; rest != ""; {
+			r, size := utf8.DecodeRuneInString(rest)
+			if !isSpace(r) {
+				break
+			}
+			leading++
+			rest = rest[size:]
+		}
+		for rest := token; rest != ""; {
+			r, size := utf8.DecodeLastRuneInString(rest)
+			if !isSpace(r) {
+				break
+			}
+			trailing++
+			rest = rest[:len(rest)-size]
+		}
+		offsets := &encoding.Offsets[i]
+		if leading > 0 {
+			if (i == 0 || offsets[0] == 0) && addPrefixSpace && leading == 1 {
+				// the space added by the pre-tokenizer is not in the input
+				leading = 0
+			}
+			offsets[0] += uint(leading)
+			if offsets[0] > offsets[1] {
+				offsets[0] = offsets[1]
+			}
+		}
+		if trailing > 0 && offsets[1] >= uint(trailing) {
+			offsets[1] -= uint(trailing)
+			if offsets[1] < offsets[0] {
+				offsets[1] = offsets[0]
+			}
+		}
+	}
+}
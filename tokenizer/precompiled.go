@@ -0,0 +1,90 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf8"
+)
+
+// precompiled is the normalizer of sentencepiece models, whose rules are compiled into a double array trie (the
+// darts-clone format) mapping sequences of bytes of the input to their null terminated normalized strings.
+type precompiled struct {
+	trie       []uint32
+	normalized []byte
+}
+
+func newPrecompiled(charsmap []byte) (normalizer, error) {
+	if len(charsmap) == 0 {
+		// no rules, the input is left as is
+		return sequenceNormalizer{}, nil
+	}
+	if len(charsmap) < 4 {
+		return nil, errors.New("the precompiled charsmap of the normalizer is truncated")
+	}
+	trieSize := int(binary.LittleEndian.Uint32(charsmap))
+	if trieSize%4 != 0 || 4+trieSize > len(charsmap) {
+		return nil, errors.New("the precompiled charsmap of the normalizer is malformed")
+	}
+	p := &precompiled{trie: make([]uint32, trieSize/4), normalized: charsmap[4+trieSize:]}
+	for i := range p.trie {
+		p.trie[i] = binary.LittleEndian.Uint32(charsmap[4+4*i:])
+	}
+	return p, nil
+}
+
+func (p *precompiled) normalize(n normalizedString) normalizedString {
+	return n.transform(func(rest string) (int, string) {
+		_, length := utf8.DecodeRuneInString(rest)
+		if replacement, ok := p.lookup(rest[:length]); ok {
+			return length, replacement
+		}
+		return 0, ""
+	})
+}
+
+// lookup returns the normalized string of the shortest prefix of the text that has one, as sentencepiece does.
+func (p *precompiled) lookup(text string) (string, bool) {
+	position := 0
+	unit := p.unit(position)
+	position ^= unitOffset(unit)
+	for i := 0; i < len(text); i++ {
+		c := uint32(text[i])
+		if c == 0 {
+			return "", false
+		}
+		position ^= int(c)
+		unit = p.unit(position)
+		if unitLabel(unit) != c {
+			return "", false
+		}
+		position ^= unitOffset(unit)
+		if unit>>8&1 == 1 {
+			// the leaf holds the index of the normalized string
+			start := int(p.unit(position) & (1<<31 - 1))
+			if start >= len(p.normalized) {
+				return "", false
+			}
+			end := start
+			for end < len(p.normalized) && p.normalized[end] != 0 {
+				end++
+			}
+			return string(p.normalized[start:end]), true
+		}
+	}
+	return "", false
+}
+
+func (p *precompiled) unit(position int) uint32 {
+	if position < 0 || position >= len(p.trie) {
+		return 0
+	}
+	return p.trie[position]
+}
+
+func unitOffset(unit uint32) int {
+	return int((unit >> 10) << ((unit & (1 << 9)) >> 6))
+}
+
+func unitLabel(unit uint32) uint32 {
+	return unit & (1<<31 | 0xFF)
+}
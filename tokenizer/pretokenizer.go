@@ -0,0 +1,378 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+)
+
+// preTokenizer splits the normalized input into the words that are tokenized by the model.
+type preTokenizer interface {
+	preTokenize(words []normalizedString) []normalizedString
+}
+
+type preTokenizerJSON struct {
+	Type             string             `json:"type"`
+	AddPrefixSpace   *bool              `json:"add_prefix_space"`
+	TrimOffsets      *bool              `json:"trim_offsets"`
+	UseRegex         *bool              `json:"use_regex"`
+	Replacement      string             `json:"replacement"`
+	PrependScheme    string             `json:"prepend_scheme"`
+	Split            *bool              `json:"split"`
+	Pattern          patternJSON        `json:"pattern"`
+	Behavior         string             `json:"behavior"`
+	Invert           bool               `json:"invert"`
+	Delimiter        string             `json:"delimiter"`
+	IndividualDigits bool               `json:"individual_digits"`
+	PreTokenizers    []preTokenizerJSON `json:"pretokenizers"`
+}
+
+// gpt2Pattern is the regex splitting words in the GPT-2 byte level pre-tokenizer.
+const gpt2Pattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+func newPreTokenizer(config *preTokenizerJSON) (preTokenizer, error) {
+	if config == nil {
+		return nil, nil
+	}
+	switch config.Type {
+	case "BertPreTokenizer":
+		return splitPreTokenizer{pattern: funcPattern(isWhitespaceOrPunctuation), behavior: "Isolated", removeWhitespace: true}, nil
+	case "Whitespace":
+		pattern, err := newRegexPattern(`\w+|[^\w\s]+`)
+		if err != nil {
+			return nil, err
+		}
+		return splitPreTokenizer{pattern: pattern, behavior: "Removed", invert: true}, nil
+	case "WhitespaceSplit":
+		return splitPreTokenizer{pattern: funcPattern(unicode.IsSpace), behavior: "Removed"}, nil
+	case "CharDelimiterSplit":
+		delimiter, _ := utf8.DecodeRuneInString(config.Delimiter)
+		return splitPreTokenizer{pattern: funcPattern(func(r rune) bool { return r == delimiter }), behavior: "Removed"}, nil
+	case "Punctuation":
+		return splitPreTokenizer{pattern: funcPattern(isPunctuation), behavior: behaviorOrDefault(config.Behavior, "Isolated")}, nil
+	case "Digits":
+		if config.IndividualDigits {
+			return splitPreTokenizer{pattern: funcPattern(unicode.IsDigit), behavior: "Isolated"}, nil
+		}
+		return splitPreTokenizer{pattern: funcPattern(unicode.IsDigit), behavior: "Contiguous"}, nil
+	case "Split":
+		pattern, err := newPattern(config.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return splitPreTokenizer{pattern: pattern, behavior: behaviorOrDefault(config.Behavior, "Removed"), invert: config.Invert}, nil
+	case "Metaspace":
+		return newMetaspace(config), nil
+	case "ByteLevel":
+		byteLevel := byteLevelPreTokenizer{addPrefixSpace: true, useRegex: true}
+		if config.AddPrefixSpace != nil {
+			byteLevel.addPrefixSpace = *config.AddPrefixSpace
+		}
+		if config.UseRegex != nil {
+			byteLevel.useRegex = *config.UseRegex
+		}
+		if byteLevel.useRegex {
+			pattern, err := newRegexPattern(gpt2Pattern)
+			if err != nil {
+				return nil, err
+			}
+			byteLevel.split = splitPreTokenizer{pattern: pattern, behavior: "Isolated"}
+		}
+		return byteLevel, nil
+	case "Sequence":
+		var sequence sequencePreTokenizer
+		for i := range config.PreTokenizers {
+			p, err := newPreTokenizer(&config.PreTokenizers[i])
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				sequence = append(sequence, p)
+			}
+		}
+		return sequence, nil
+	default:
+		return nil, fmt.Errorf("pre-tokenizer of type %s is not supported", config.Type)
+	}
+}
+
+func behaviorOrDefault(behavior string, defaultBehavior string) string {
+	if behavior == "" {
+		return defaultBehavior
+	}
+	return behavior
+}
+
+type sequencePreTokenizer []preTokenizer
+
+func (s sequencePreTokenizer) preTokenize(words []normalizedString) []normalizedString {
+	for _, p := range s {
+		words = p.preTokenize(words)
+	}
+	return words
+}
+
+// splitPreTokenizer splits the words on the matches of a pattern. The behavior sets what becomes of the matches:
+// Removed, Isolated (a word of their own), MergedWithPrevious, MergedWithNext or Contiguous (consecutive matches form
+// a single word). If invert is set, the parts between the matches are split on instead.
+type splitPreTokenizer struct {
+	pattern  pattern
+	behavior string
+	invert   bool
+	// removeWhitespace removes the whitespace matches while isolating the others, as the BERT pre-tokenizer does
+	removeWhitespace bool
+}
+
+func (s splitPreTokenizer) preTokenize(words []normalizedString) []normalizedString {
+	var split []normalizedString
+	for _, word := range words {
+		split = append(split, s.split(word)...)
+	}
+	return split
+}
+
+func (s splitPreTokenizer) split(word normalizedString) []normalizedString {
+	// the parts of the word, matches or not, in order
+	type part struct {
+		start, end int
+		match      bool
+	}
+	var parts []part
+	previous := 0
+	for _, match := range s.pattern.findAll(word.text) {
+		if match[0] == match[1] {
+			continue
+		}
+		if match[0] > previous {
+			parts = append(parts, part{start: previous, end: match[0], match: s.invert})
+		}
+		parts = append(parts, part{start: match[0], end: match[1], match: !s.invert})
+		previous = match[1]
+	}
+	if previous < len(word.text) {
+		parts = append(parts, part{start: previous, end: len(word.text), match: s.invert})
+	}
+
+	var words []normalizedString
+	start := -1 // start of the pending word
+	flush := func(end int) {
+		if start >= 0 && end > start {
+			words = append(words, word.slice(start, end))
+		}
+		start = -1
+	}
+	for i, p := range parts {
+		if !p.match {
+			if start < 0 {
+				start = p.start
+			}
+			if s.behavior != "MergedWithPrevious" || i == len(parts)-1 || !parts[i+1].match {
+				flush(p.end)
+			}
+			continue
+		}
+		switch s.behavior {
+		case "Removed":
+			flush(p.start)
+		case "Isolated":
+			flush(p.start)
+			if s.removeWhitespace && strings.TrimFunc(word.text[p.start:p.end], unicode.IsSpace) == "" {
+				continue
+			}
+			start = p.start
+			flush(p.end)
+		case "MergedWithPrevious":
+			if start < 0 {
+				start = p.start
+			}
+			flush(p.end)
+		case "MergedWithNext":
+			flush(p.start)
+			start = p.start
+			if i == len(parts)-1 {
+				flush(p.end)
+			}
+		case "Contiguous":
+			if start < 0 || i == 0 || !parts[i-1].match {
+				flush(p.start)
+				start = p.start
+			}
+			if i == len(parts)-1 || !parts[i+1].match {
+				flush(p.end)
+			}
+		}
+	}
+	return words
+}
+
+// metaspacePreTokenizer replaces the spaces with a visible replacement character, ▁ in sentencepiece models, and
+// splits the words before it.
+type metaspacePreTokenizer struct {
+	replacement   string
+	prependScheme string // always, first or never
+	split         bool
+}
+
+func newMetaspace(config *preTokenizerJSON) metaspacePreTokenizer {
+	metaspace := metaspacePreTokenizer{replacement: config.Replacement, prependScheme: config.PrependScheme, split: true}
+	if metaspace.replacement == "" {
+		metaspace.replacement = "▁"
+	}
+	if metaspace.prependScheme == "" {
+		metaspace.prependScheme = "always"
+		if config.AddPrefixSpace != nil && !*config.AddPrefixSpace {
+			metaspace.prependScheme = "never"
+		}
+	}
+	if config.Split != nil {
+		metaspace.split = *config.Split
+	}
+	return metaspace
+}
+
+func (m metaspacePreTokenizer) preTokenize(words []normalizedString) []normalizedString {
+	var split []normalizedString
+	for i, word := range words {
+		word = word.mapRunes(func(r rune) string {
+			if r == ' ' {
+				return m.replacement
+			}
+			return string(r)
+		})
+		if !strings.HasPrefix(word.text, m.replacement) {
+			if m.prependScheme == "always" || (m.prependScheme == "first" && i == 0 && len(word.spans) > 0 && word.spans[0][0] == 0) {
+				word = word.prepend(m.replacement)
+			}
+		}
+		if !m.split {
+			split = append(split, word)
+			continue
+		}
+		replacement := []rune(m.replacement)[0]
+		split = append(split, splitPreTokenizer{pattern: funcPattern(func(r rune) bool { return r == replacement }), behavior: "MergedWithNext"}.split(word)...)
+	}
+	return split
+}
+
+// byteLevelPreTokenizer splits the words with the GPT-2 regex and maps their bytes to printable characters, so that
+// the BPE model works on bytes.
+type byteLevelPreTokenizer struct {
+	addPrefixSpace bool
+	useRegex       bool
+	split          splitPreTokenizer
+}
+
+func (b byteLevelPreTokenizer) preTokenize(words []normalizedString) []normalizedString {
+	var split []normalizedString
+	for _, word := range words {
+		if b.addPrefixSpace && !strings.HasPrefix(word.text, " ") {
+			word = word.prepend(" ")
+		}
+		if b.useRegex {
+			split = append(split, b.split.split(word)...)
+		} else {
+			split = append(split, word)
+		}
+	}
+	for i, word := range split {
+		split[i] = word.transform(func(rest string) (int, string) {
+			_, length := utf8.DecodeRuneInString(rest)
+			return length, bytesToChars(rest[:length])
+		})
+	}
+	return split
+}
+
+// pattern finds the spans in bytes of the matches of a pattern in a text.
+type pattern interface {
+	findAll(text string) [][2]int
+}
+
+func newPattern(config patternJSON) (pattern, error) {
+	switch {
+	case config.String != nil:
+		return stringPattern(*config.String), nil
+	case config.Regex != nil:
+		return newRegexPattern(*config.Regex)
+	default:
+		return nil, fmt.Errorf("pattern without a string or a regex")
+	}
+}
+
+type stringPattern string
+
+func (s stringPattern) findAll(text string) [][2]int {
+	if s == "" {
+		return nil
+	}
+	var matches [][2]int
+	for start := 0; ; {
+		index := strings.Index(text[start:], string(s))
+		if index < 0 {
+			return matches
+		}
+		matches = append(matches, [2]int{start + index, start + index + len(s)})
+		start += index + len(s)
+	}
+}
+
+// funcPattern matches each rune for which the function is true.
+type funcPattern func(r rune) bool
+
+func (f funcPattern) findAll(text string) [][2]int {
+	var matches [][2]int
+	for i := 0; i < len(text); {
+		r, length := utf8.DecodeRuneInString(text[i:])
+		if f(r) {
+			matches = append(matches, [2]int{i, i + length})
+		}
+		i += length
+	}
+	return matches
+}
+
+// regexPattern matches a regex. The regexes of tokenizer.json are written for oniguruma, and often use look-ahead
+// assertions that the regexp package does not support, so they are compiled with regexp2.
+type regexPattern struct {
+	regex *regexp2.Regexp
+}
+
+func newRegexPattern(expression string) (regexPattern, error) {
+	regex, err := regexp2.Compile(expression, regexp2.None)
+	if err != nil {
+		return regexPattern{}, fmt.Errorf("compiling regex %s: %w", expression, err)
+	}
+	return regexPattern{regex: regex}, nil
+}
+
+func (r regexPattern) findAll(text string) [][2]int {
+	// regexp2 matches on runes, the positions are converted to bytes
+	runes := make([]rune, 0, len(text))
+	positions := make([]int, 0, len(text)+1)
+	for i := 0; i < len(text); {
+		r, length := utf8.DecodeRuneInString(text[i:])
+		runes = append(runes, r)
+		positions = append(positions, i)
+		i += length
+	}
+	positions = append(positions, len(text))
+	var matches [][2]int
+	match, err := r.regex.FindRunesMatch(runes)
+	for err == nil && match != nil {
+		matches = append(matches, [2]int{positions[match.Index], positions[match.Index+match.Length]})
+		match, err = r.regex.FindNextMatch(match)
+	}
+	return matches
+}
+
+// isPunctuation reports whether the rune is an ASCII punctuation character or in a Unicode punctuation category.
+func isPunctuation(r rune) bool {
+	return (r >= 33 && r <= 47) || (r >= 58 && r <= 64) || (r >= 91 && r <= 96) || (r >= 123 && r <= 126) || unicode.IsPunct(r)
+}
+
+func isWhitespaceOrPunctuation(r rune) bool {
+	return unicode.IsSpace(r) || isPunctuation(r)
+}
@@ -0,0 +1,301 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// The types of the pieces of a sentencepiece model.
+const (
+	pieceNormal      = 1
+	pieceUnknown     = 2
+	pieceControl     = 3
+	pieceUserDefined = 4
+)
+
+// The types of sentencepiece models.
+const (
+	sentencePieceUnigram = 1
+	sentencePieceBPE     = 2
+)
+
+// sentencePieceModel is the part of the ModelProto of a sentencepiece model the conversion needs.
+type sentencePieceModel struct {
+	pieces                 []sentencePiece
+	modelType              int
+	unkID                  int
+	byteFallback           bool
+	precompiledCharsmap    []byte
+	addDummyPrefix         bool
+	removeExtraWhitespaces bool
+}
+
+type sentencePiece struct {
+	piece string
+	score float64
+	kind  int
+}
+
+// parseSentencePieceModel parses the protobuf of a sentencepiece model.
+func parseSentencePieceModel(data []byte) (*sentencePieceModel, error) {
+	model := &sentencePieceModel{modelType: sentencePieceUnigram, addDummyPrefix: true, removeExtraWhitespaces: true}
+	err := parseMessage(data, func(number protowire.Number, value []byte, _ uint64) error {
+		switch number {
+		case 1: // pieces
+			piece := sentencePiece{kind: pieceNormal}
+			err := parseMessage(value, func(number protowire.Number, value []byte, scalar uint64) error {
+				switch number {
+				case 1:
+					piece.piece = string(value)
+				case 2:
+					piece.score = float64(math.Float32frombits(uint32(scalar)))
+				case 3:
+					piece.kind = int(scalar)
+				}
+				return nil
+			})
+			model.pieces = append(model.pieces, piece)
+			return err
+		case 2: // trainer_spec
+			return parseMessage(value, func(number protowire.Number, _ []byte, scalar uint64) error {
+				switch number {
+				case 3:
+					model.modelType = int(scalar)
+				case 35:
+					model.byteFallback = scalar != 0
+				case 40:
+					model.unkID = int(int32(scalar))
+				}
+				return nil
+			})
+		case 3: // normalizer_spec
+			return parseMessage(value, func(number protowire.Number, value []byte, scalar uint64) error {
+				switch number {
+				case 2:
+					model.precompiledCharsmap = value
+				case 3:
+					model.addDummyPrefix = scalar != 0
+				case 4:
+					model.removeExtraWhitespaces = scalar != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading the sentencepiece model: %w", err)
+	}
+	if len(model.pieces) == 0 {
+		return nil, errors.New("the sentencepiece model has no pieces")
+	}
+	return model, nil
+}
+
+// parseMessage calls field for each field of the protobuf message, with the content of length delimited fields and
+// the value of the scalar ones.
+func parseMessage(data []byte, field func(number protowire.Number, value []byte, scalar uint64) error) error {
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value []byte
+		var scalar uint64
+		switch wireType {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			scalar, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			var fixed uint32
+			fixed, n = protowire.ConsumeFixed32(data)
+			scalar = uint64(fixed)
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := field(number, value, scalar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertSentencePiece converts the sentencepiece model of a T5, ALBERT, DeBERTa-v2 or Llama-like tokenizer. Unigram
+// models are split into words on spaces by the Metaspace pre-tokenizer, while BPE models are converted as the
+// transformers library converts the Llama tokenizer, with the merges rebuilt from the vocabulary.
+func convertSentencePiece(path string, config *legacyConfig) ([]byte, error) {
+	if config.isFairseq() {
+		return nil, fmt.Errorf("the %s tokenizer can only be loaded from its tokenizer.json", config.TokenizerClass)
+	}
+	data, err := util.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	model, err := parseSentencePieceModel(data)
+	if err != nil {
+		return nil, err
+	}
+	if model.unkID < 0 || model.unkID >= len(model.pieces) {
+		return nil, fmt.Errorf("the unknown token id %d of the sentencepiece model is not in its vocabulary", model.unkID)
+	}
+
+	ids := make(map[string]uint32, len(model.pieces))
+	var extraTokens []addedToken
+	for i, piece := range model.pieces {
+		ids[piece.piece] = uint32(i)
+		switch piece.kind {
+		case pieceUnknown, pieceControl:
+			extraTokens = append(extraTokens, addedToken{Content: piece.piece, Special: true})
+		case pieceUserDefined:
+			extraTokens = append(extraTokens, addedToken{Content: piece.piece})
+		}
+	}
+	unkToken := model.pieces[model.unkID].piece
+	setDefault(&config.UnkToken, unkToken, ids)
+	setDefault(&config.BosToken, "<s>", ids)
+	setDefault(&config.EosToken, "</s>", ids)
+	setDefault(&config.PadToken, "<pad>", ids)
+	addedTokens, err := config.addedTokens(ids, len(model.pieces), extraTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalizers []any
+	if config.KeepAccents != nil && !*config.KeepAccents {
+		normalizers = append(normalizers, map[string]any{"type": "NFKD"}, map[string]any{"type": "StripAccents"})
+	}
+	if boolOr(config.DoLowerCase, false) {
+		normalizers = append(normalizers, map[string]any{"type": "Lowercase"})
+	}
+	if len(model.precompiledCharsmap) > 0 {
+		normalizers = append(normalizers, map[string]any{"type": "Precompiled", "precompiled_charsmap": model.precompiledCharsmap})
+	}
+
+	switch model.modelType {
+	case sentencePieceUnigram:
+		if model.removeExtraWhitespaces {
+			normalizers = append(normalizers, map[string]any{"type": "Replace", "pattern": map[string]any{"Regex": " {2,}"}, "content": " "})
+		}
+		prependScheme := "never"
+		if model.addDummyPrefix {
+			prependScheme = "always"
+		}
+		metaspace := map[string]any{
+			"type":             "Metaspace",
+			"replacement":      "▁",
+			"add_prefix_space": model.addDummyPrefix,
+			"prepend_scheme":   prependScheme,
+			"split":            true,
+		}
+		vocab := make([]any, len(model.pieces))
+		for i, piece := range model.pieces {
+			vocab[i] = []any{piece.piece, piece.score}
+		}
+		return tokenizerFile(addedTokens,
+			normalizerSequenceJSON(normalizers),
+			metaspace,
+			nilIfEmpty(config.postProcessor(addedTokens, false, false, strings.Contains(config.TokenizerClass, "T5"))),
+			metaspace,
+			map[string]any{"type": "Unigram", "unk_id": model.unkID, "vocab": vocab, "byte_fallback": model.byteFallback},
+		)
+	case sentencePieceBPE:
+		if model.addDummyPrefix {
+			normalizers = append(normalizers, map[string]any{"type": "Prepend", "prepend": "▁"})
+		}
+		normalizers = append(normalizers, map[string]any{"type": "Replace", "pattern": map[string]any{"String": " "}, "content": "▁"})
+		decoders := []any{
+			map[string]any{"type": "Replace", "pattern": map[string]any{"String": "▁"}, "content": " "},
+			map[string]any{"type": "ByteFallback"},
+			map[string]any{"type": "Fuse"},
+		}
+		if model.addDummyPrefix {
+			decoders = append(decoders, map[string]any{"type": "Strip", "content": " ", "start": 1, "stop": 0})
+		}
+		return tokenizerFile(addedTokens,
+			normalizerSequenceJSON(normalizers),
+			nil,
+			nilIfEmpty(config.postProcessor(addedTokens, false, true, false)),
+			map[string]any{"type": "Sequence", "decoders": decoders},
+			map[string]any{
+				"type":                      "BPE",
+				"dropout":                   nil,
+				"unk_token":                 unkToken,
+				"continuing_subword_prefix": nil,
+				"end_of_word_suffix":        nil,
+				"fuse_unk":                  true,
+				"byte_fallback":             model.byteFallback,
+				"vocab":                     ids,
+				"merges":                    sentencePieceMerges(model.pieces, ids),
+			},
+		)
+	default:
+		return nil, fmt.Errorf("sentencepiece models of type %d are not supported", model.modelType)
+	}
+}
+
+func normalizerSequenceJSON(normalizers []any) any {
+	switch len(normalizers) {
+	case 0:
+		return nil
+	case 1:
+		return normalizers[0]
+	default:
+		return map[string]any{"type": "Sequence", "normalizers": normalizers}
+	}
+}
+
+// sentencePieceMerges rebuilds the merges of a BPE sentencepiece model, which only has the scores of its pieces: each
+// piece is the merge of any two pieces it can be split into, ranked by the score of the piece.
+func sentencePieceMerges(pieces []sentencePiece, ids map[string]uint32) []string {
+	type merge struct {
+		left, right string
+		score       float64
+	}
+	var merges []merge
+	for _, piece := range pieces {
+		if strings.Contains(piece.piece, " ") {
+			// merges are written "left right"
+			continue
+		}
+		var local []merge
+		for i := range piece.piece {
+			if i == 0 {
+				continue
+			}
+			left, right := piece.piece[:i], piece.piece[i:]
+			if _, ok := ids[left]; !ok {
+				continue
+			}
+			if _, ok := ids[right]; !ok {
+				continue
+			}
+			local = append(local, merge{left: left, right: right, score: piece.score})
+		}
+		sort.SliceStable(local, func(i, j int) bool {
+			if ids[local[i].left] != ids[local[j].left] {
+				return ids[local[i].left] < ids[local[j].left]
+			}
+			return ids[local[i].right] < ids[local[j].right]
+		})
+		merges = append(merges, local...)
+	}
+	sort.SliceStable(merges, func(i, j int) bool { return merges[i].score > merges[j].score })
+	lines := make([]string, len(merges))
+	for i, m := range merges {
+		lines[i] = m.left + " " + m.right
+	}
+	return lines
+}
@@ -0,0 +1,218 @@
+// Package tokenizer is a pure Go implementation of the Huggingface tokenizers, loaded from the tokenizer.json file
+// of a model. It supports the WordPiece (BERT and its derivatives), byte level BPE (GPT-2, RoBERTa) and Unigram
+// (sentencepiece models such as T5 and DeBERTa-v3) models, with their normalizers, pre-tokenizers, post-processors
+// and decoders. It needs neither cgo nor the rust tokenizers library, at the cost of some speed.
+//
+// The truncation and padding settings of tokenizer.json are ignored: hugot pipelines truncate and pad the inputs
+// themselves.
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	util "github.com/knights-analytics/hugot/utils"
+)
+
+// Offset is the span in bytes of a token in the input.
+type Offset [2]uint
+
+// Encoding is an encoded input, with one element per token in each slice.
+type Encoding struct {
+	IDs               []uint32
+	TypeIDs           []uint32
+	SpecialTokensMask []uint32 // 1 for the special tokens added by the post-processor, e.g. [CLS], 0 otherwise
+	AttentionMask     []uint32
+	Tokens            []string
+	Offsets           []Offset
+}
+
+func (e *Encoding) append(id uint32, token string, offset Offset, typeID uint32, special uint32) {
+	e.IDs = append(e.IDs, id)
+	e.TypeIDs = append(e.TypeIDs, typeID)
+	e.SpecialTokensMask = append(e.SpecialTokensMask, special)
+	e.AttentionMask = append(e.AttentionMask, 1)
+	e.Tokens = append(e.Tokens, token)
+	e.Offsets = append(e.Offsets, offset)
+}
+
+// Tokenizer encodes texts into tokens and decodes tokens back into texts. It is safe for concurrent use.
+type Tokenizer struct {
+	normalizer        normalizer
+	preTokenizer      preTokenizer
+	model             model
+	postProcessor     *postProcessor
+	decoder           decoder
+	addedTokens       map[uint32]addedToken
+	addedTokenIDs     map[string]uint32
+	rawMatcher        addedTokenMatcher // added tokens matched in the input
+	normalizedMatcher addedTokenMatcher // added tokens matched in the normalized input
+}
+
+type tokenizerJSON struct {
+	AddedTokens   []addedToken       `json:"added_tokens"`
+	Normalizer    *normalizerJSON    `json:"normalizer"`
+	PreTokenizer  *preTokenizerJSON  `json:"pre_tokenizer"`
+	Model         *modelJSON         `json:"model"`
+	PostProcessor *postProcessorJSON `json:"post_processor"`
+	Decoder       *decoderJSON       `json:"decoder"`
+}
+
+// FromFile loads the tokenizer from a tokenizer.json file.
+func FromFile(path string) (*Tokenizer, error) {
+	data, err := util.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(data)
+}
+
+// FromBytes loads the tokenizer from the content of a tokenizer.json file.
+func FromBytes(data []byte) (*Tokenizer, error) {
+	config := tokenizerJSON{}
+	if err := jsoniter.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("reading tokenizer.json: %w", err)
+	}
+
+	t := &Tokenizer{addedTokens: map[uint32]addedToken{}, addedTokenIDs: map[string]uint32{}}
+	for _, token := range config.AddedTokens {
+		t.addedTokens[token.ID] = token
+		t.addedTokenIDs[token.Content] = token.ID
+	}
+	var err error
+	if t.normalizer, err = newNormalizer(config.Normalizer); err != nil {
+		return nil, err
+	}
+	if t.preTokenizer, err = newPreTokenizer(config.PreTokenizer); err != nil {
+		return nil, err
+	}
+	if t.model, err = newModel(config.Model, t.addedTokenIDs); err != nil {
+		return nil, err
+	}
+	if t.postProcessor, err = newPostProcessor(config.PostProcessor); err != nil {
+		return nil, err
+	}
+	if t.decoder, err = newDecoder(config.Decoder); err != nil {
+		return nil, err
+	}
+
+	var raw, normalized []addedToken
+	for _, token := range config.AddedTokens {
+		if token.Normalized && t.normalizer != nil {
+			// normalized tokens are matched in the normalized input, with their normalized content
+			token.Content = t.normalizer.normalize(newNormalizedString(token.Content, 0)).text
+			normalized = append(normalized, token)
+		} else {
+			raw = append(raw, token)
+		}
+	}
+	t.rawMatcher = newAddedTokenMatcher(raw)
+	t.normalizedMatcher = newAddedTokenMatcher(normalized)
+	return t, nil
+}
+
+// Encode encodes the text into tokens. If addSpecialTokens is set, the special tokens of the model, e.g. [CLS] and
+// [SEP], are added by the post-processor.
+func (t *Tokenizer) Encode(text string, addSpecialTokens bool) Encoding {
+	encoding := Encoding{}
+	addMatch := func(match addedTokenMatch, offset Offset) {
+		encoding.append(match.token.ID, match.token.Content, offset, 0, 0)
+	}
+
+	previous := 0
+	for _, match := range t.rawMatcher.find(text) {
+		t.encodeSegment(&encoding, newNormalizedString(text[previous:match.start], previous), addMatch)
+		addMatch(match, Offset{uint(match.start), uint(match.end)})
+		previous = match.end
+	}
+	t.encodeSegment(&encoding, newNormalizedString(text[previous:], previous), addMatch)
+	return t.postProcessor.process(encoding, addSpecialTokens)
+}
+
+// encodeSegment encodes a part of the input between the added tokens found in the input.
+func (t *Tokenizer) encodeSegment(encoding *Encoding, segment normalizedString, addMatch func(addedTokenMatch, Offset)) {
+	if segment.text == "" {
+		return
+	}
+	if t.normalizer != nil {
+		segment = t.normalizer.normalize(segment)
+	}
+	previous := 0
+	for _, match := range t.normalizedMatcher.find(segment.text) {
+		t.encodeWords(encoding, segment.slice(previous, match.start))
+		addMatch(match, segment.span(match.start, match.end))
+		previous = match.end
+	}
+	t.encodeWords(encoding, segment.slice(previous, len(segment.text)))
+}
+
+// encodeWords splits a normalized part of the input into words and tokenizes them with the model.
+func (t *Tokenizer) encodeWords(encoding *Encoding, segment normalizedString) {
+	if segment.text == "" {
+		return
+	}
+	words := []normalizedString{segment}
+	if t.preTokenizer != nil {
+		words = t.preTokenizer.preTokenize(words)
+	}
+	for _, word := range words {
+		for _, token := range t.model.tokenize(word.text) {
+			encoding.append(token.id, token.value, word.span(token.start, token.end), 0, 0)
+		}
+	}
+}
+
+// Decode decodes the tokens back into text. If skipSpecialTokens is set, the special tokens are left out.
+func (t *Tokenizer) Decode(ids []uint32, skipSpecialTokens bool) string {
+	tokens := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if added, ok := t.addedTokens[id]; ok {
+			if !skipSpecialTokens || !added.Special {
+				tokens = append(tokens, added.Content)
+			}
+			continue
+		}
+		if token, ok := t.model.idToToken(id); ok {
+			tokens = append(tokens, token)
+		}
+	}
+	if t.decoder == nil {
+		return strings.Join(tokens, " ")
+	}
+	return strings.Join(t.decoder.decodeChain(tokens), "")
+}
+
+// TokenToID returns the id of the token, if it is in the vocabulary.
+func (t *Tokenizer) TokenToID(token string) (uint32, bool) {
+	if id, ok := t.addedTokenIDs[token]; ok {
+		return id, true
+	}
+	return t.model.tokenToID(token)
+}
+
+// IDToToken returns the token of the id, if it is in the vocabulary.
+func (t *Tokenizer) IDToToken(id uint32) (string, bool) {
+	if added, ok := t.addedTokens[id]; ok {
+		return added.Content, true
+	}
+	return t.model.idToToken(id)
+}
+
+// VocabSize returns the number of tokens of the vocabulary, added tokens included.
+func (t *Tokenizer) VocabSize() int {
+	size := t.model.vocabSize()
+	for id := range t.addedTokens {
+		if _, ok := t.model.idToToken(id); !ok {
+			size++
+		}
+	}
+	return size
+}
+
+// Close releases the resources of the tokenizer. The pure Go tokenizer holds none, it is there for symmetry with the
+// rust tokenizers.
+func (t *Tokenizer) Close() error {
+	return nil
+}
@@ -0,0 +1,141 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const wordPieceJSON = `{
+	"added_tokens": [
+		{"id": 0, "content": "[PAD]", "special": true},
+		{"id": 1, "content": "[UNK]", "special": true},
+		{"id": 2, "content": "[CLS]", "special": true},
+		{"id": 3, "content": "[SEP]", "special": true},
+		{"id": 4, "content": "[MASK]", "special": true}
+	],
+	"normalizer": {"type": "BertNormalizer", "clean_text": true, "handle_chinese_chars": true, "strip_accents": null, "lowercase": true},
+	"pre_tokenizer": {"type": "BertPreTokenizer"},
+	"post_processor": {
+		"type": "TemplateProcessing",
+		"single": [{"SpecialToken": {"id": "[CLS]", "type_id": 0}}, {"Sequence": {"id": "A", "type_id": 0}}, {"SpecialToken": {"id": "[SEP]", "type_id": 0}}],
+		"special_tokens": {"[CLS]": {"id": "[CLS]", "ids": [2], "tokens": ["[CLS]"]}, "[SEP]": {"id": "[SEP]", "ids": [3], "tokens": ["[SEP]"]}}
+	},
+	"decoder": {"type": "WordPiece", "prefix": "##", "cleanup": true},
+	"model": {
+		"type": "WordPiece",
+		"unk_token": "[UNK]",
+		"continuing_subword_prefix": "##",
+		"max_input_chars_per_word": 100,
+		"vocab": {"[PAD]": 0, "[UNK]": 1, "[CLS]": 2, "[SEP]": 3, "[MASK]": 4, "hello": 5, "world": 6, "!": 7, ",": 8, "un": 9, "##aff": 10, "##able": 11, "cafe": 12}
+	}
+}`
+
+const byteLevelBPEJSON = `{
+	"added_tokens": [{"id": 17, "content": "<|endoftext|>", "special": true}],
+	"normalizer": null,
+	"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": true, "use_regex": true},
+	"post_processor": {"type": "ByteLevel", "add_prefix_space": true, "trim_offsets": true, "use_regex": true},
+	"decoder": {"type": "ByteLevel", "add_prefix_space": true, "trim_offsets": true, "use_regex": true},
+	"model": {
+		"type": "BPE",
+		"vocab": {"h": 0, "e": 1, "l": 2, "o": 3, "Ġ": 4, "w": 5, "r": 6, "d": 7, "he": 8, "ll": 9, "hell": 10, "hello": 11, "Ġw": 12, "or": 13, "Ġwor": 14, "Ġworl": 15, "Ġworld": 16, "<|endoftext|>": 17},
+		"merges": ["h e", "l l", "he ll", "hell o", "Ġ w", "o r", "Ġw or", "Ġwor l", "Ġworl d"]
+	}
+}`
+
+const unigramJSON = `{
+	"added_tokens": [{"id": 0, "content": "<unk>", "special": true}, {"id": 1, "content": "</s>", "special": true}],
+	"normalizer": null,
+	"pre_tokenizer": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "always", "split": true},
+	"post_processor": {
+		"type": "TemplateProcessing",
+		"single": [{"Sequence": {"id": "A", "type_id": 0}}, {"SpecialToken": {"id": "</s>", "type_id": 0}}],
+		"special_tokens": {"</s>": {"id": "</s>", "ids": [1], "tokens": ["</s>"]}}
+	},
+	"decoder": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "always", "split": true},
+	"model": {
+		"type": "Unigram",
+		"unk_id": 0,
+		"vocab": [["<unk>", 0], ["</s>", 0], ["▁", -2], ["▁hello", -1], ["▁world", -1.5], ["▁wor", -3], ["ld", -3], ["▁he", -4], ["llo", -4]]
+	}
+}`
+
+func TestWordPiece(t *testing.T) {
+	tk, err := FromBytes([]byte(wordPieceJSON))
+	check(t, err)
+	defer func() {
+		check(t, tk.Close())
+	}()
+
+	encoding := tk.Encode("Hello, unaffable World!", true)
+	assert.Equal(t, []uint32{2, 5, 8, 9, 10, 11, 6, 7, 3}, encoding.IDs)
+	assert.Equal(t, []string{"[CLS]", "hello", ",", "un", "##aff", "##able", "world", "!", "[SEP]"}, encoding.Tokens)
+	assert.Equal(t, []Offset{{0, 0}, {0, 5}, {5, 6}, {7, 9}, {9, 12}, {12, 16}, {17, 22}, {22, 23}, {0, 0}}, encoding.Offsets)
+	assert.Equal(t, []uint32{1, 0, 0, 0, 0, 0, 0, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, []uint32{0, 0, 0, 0, 0, 0, 0, 0, 0}, encoding.TypeIDs)
+	assert.Equal(t, []uint32{1, 1, 1, 1, 1, 1, 1, 1, 1}, encoding.AttentionMask)
+	assert.Equal(t, "hello, unaffable world!", tk.Decode(encoding.IDs, true))
+	assert.Equal(t, "[CLS] hello, unaffable world! [SEP]", tk.Decode(encoding.IDs, false))
+
+	// added tokens are kept whole, unknown words become [UNK] and accents are stripped with the lowercasing
+	encoding = tk.Encode("[MASK] xyz café", false)
+	assert.Equal(t, []uint32{4, 1, 12}, encoding.IDs)
+	assert.Equal(t, []Offset{{0, 6}, {7, 10}, {11, 16}}, encoding.Offsets)
+
+	encoding = tk.Encode("", true)
+	assert.Equal(t, []uint32{2, 3}, encoding.IDs)
+
+	id, ok := tk.TokenToID("##able")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(11), id)
+	assert.Equal(t, 13, tk.VocabSize())
+}
+
+func TestByteLevelBPE(t *testing.T) {
+	tk, err := FromBytes([]byte(byteLevelBPEJSON))
+	check(t, err)
+
+	encoding := tk.Encode("hello world<|endoftext|>", true)
+	assert.Equal(t, []uint32{11, 16, 17}, encoding.IDs)
+	assert.Equal(t, []string{"hello", "Ġworld", "<|endoftext|>"}, encoding.Tokens)
+	// the offsets of byte level tokens leave out their leading space
+	assert.Equal(t, []Offset{{0, 5}, {6, 11}, {11, 24}}, encoding.Offsets)
+	assert.Equal(t, "hello world", tk.Decode(encoding.IDs, true))
+
+	// words are merged in the order of the merges, not greedily
+	encoding = tk.Encode("hell wor", false)
+	assert.Equal(t, []string{"hell", "Ġwor"}, encoding.Tokens)
+}
+
+func TestUnigram(t *testing.T) {
+	tk, err := FromBytes([]byte(unigramJSON))
+	check(t, err)
+
+	encoding := tk.Encode("hello world", true)
+	assert.Equal(t, []uint32{3, 4, 1}, encoding.IDs)
+	assert.Equal(t, []string{"▁hello", "▁world", "</s>"}, encoding.Tokens)
+	assert.Equal(t, []Offset{{0, 5}, {5, 11}, {0, 0}}, encoding.Offsets)
+	assert.Equal(t, []uint32{0, 0, 1}, encoding.SpecialTokensMask)
+	assert.Equal(t, "hello world", tk.Decode(encoding.IDs, true))
+
+	// unknown characters in a row form a single unknown token
+	encoding = tk.Encode("hello xyz", false)
+	assert.Equal(t, []uint32{3, 2, 0}, encoding.IDs)
+	assert.Equal(t, []string{"▁hello", "▁", "xyz"}, encoding.Tokens)
+	assert.Equal(t, []Offset{{0, 5}, {5, 6}, {6, 9}}, encoding.Offsets)
+}
+
+func TestUnsupportedModel(t *testing.T) {
+	_, err := FromBytes([]byte(`{"model": {"type": "WordLevel", "vocab": {}}}`))
+	assert.Error(t, err)
+	_, err = FromBytes([]byte(`{"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"a": 0}}}`))
+	assert.Error(t, err)
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Test failed with error %s", err.Error())
+	}
+}
@@ -0,0 +1,170 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// unknownPenalty is subtracted from the lowest score of the vocabulary to score unknown characters.
+const unknownPenalty = 10.0
+
+// unigram is the unigram language model of sentencepiece, used by T5, DeBERTa-v3 and XLM-RoBERTa: each token of the
+// vocabulary has a log probability, and words are split into the tokens of highest total log probability with the
+// Viterbi algorithm.
+type unigram struct {
+	vocabulary
+	scores         []float64
+	unkID          uint32
+	hasUnk         bool
+	byteFallback   bool
+	unkScore       float64
+	maxPieceLength int
+}
+
+func newUnigram(config *modelJSON) (*unigram, error) {
+	var pieces [][2]jsoniter.RawMessage
+	if err := jsoniter.Unmarshal(config.Vocab, &pieces); err != nil {
+		return nil, fmt.Errorf("reading the vocabulary of the Unigram model: %w", err)
+	}
+	u := &unigram{
+		vocabulary:   vocabulary{ids: make(map[string]uint32, len(pieces)), tokens: make(map[uint32]string, len(pieces))},
+		scores:       make([]float64, len(pieces)),
+		byteFallback: config.ByteFallback,
+	}
+	minScore := math.Inf(1)
+	for i, piece := range pieces {
+		var value string
+		if err := jsoniter.Unmarshal(piece[0], &value); err != nil {
+			return nil, fmt.Errorf("reading piece %d of the Unigram model: %w", i, err)
+		}
+		if err := jsoniter.Unmarshal(piece[1], &u.scores[i]); err != nil {
+			return nil, fmt.Errorf("reading the score of piece %d of the Unigram model: %w", i, err)
+		}
+		u.ids[value] = uint32(i)
+		u.tokens[uint32(i)] = value
+		if u.scores[i] < minScore {
+			minScore = u.scores[i]
+		}
+		if len(value) > u.maxPieceLength {
+			u.maxPieceLength = len(value)
+		}
+	}
+	if len(pieces) == 0 {
+		return nil, errors.New("the Unigram model has an empty vocabulary")
+	}
+	u.unkScore = minScore - unknownPenalty
+	if config.UnkID != nil {
+		if *config.UnkID < 0 || *config.UnkID >= len(pieces) {
+			return nil, fmt.Errorf("the unknown token id %d of the Unigram model is not in its vocabulary", *config.UnkID)
+		}
+		u.unkID, u.hasUnk = uint32(*config.UnkID), true
+	}
+	return u, nil
+}
+
+// lattice node: the best split of the word up to a position
+type unigramNode struct {
+	score   float64
+	start   int // start of the last token of the best split
+	id      uint32
+	unknown bool
+	reached bool
+}
+
+func (u *unigram) tokenize(word string) []token {
+	if word == "" {
+		return nil
+	}
+	nodes := make([]unigramNode, len(word)+1)
+	nodes[0].reached = true
+	for start := 0; start < len(word); {
+		_, charLength := utf8.DecodeRuneInString(word[start:])
+		if !nodes[start].reached {
+			start += charLength
+			continue
+		}
+		hasSingleChar := false
+		for end := start + charLength; end <= len(word) && end-start <= u.maxPieceLength; {
+			if id, ok := u.ids[word[start:end]]; ok {
+				if end == start+charLength {
+					hasSingleChar = true
+				}
+				u.relax(nodes, start, end, id, u.scores[id], false)
+			}
+			if end == len(word) {
+				break
+			}
+			_, size := utf8.DecodeRuneInString(word[end:])
+			end += size
+		}
+		if !hasSingleChar {
+			u.relax(nodes, start, start+charLength, u.unkID, u.unkScore, true)
+		}
+		start += charLength
+	}
+
+	// backtrack from the end of the word, unknown characters in a row forming a single token
+	type split struct {
+		token
+		unknown bool
+	}
+	var reversed []split
+	for end := len(word); end > 0; {
+		node := nodes[end]
+		if last := len(reversed) - 1; node.unknown && last >= 0 && reversed[last].unknown {
+			reversed[last].start = node.start
+		} else {
+			reversed = append(reversed, split{token: token{id: node.id, value: u.tokens[node.id], start: node.start, end: end}, unknown: node.unknown})
+		}
+		end = node.start
+	}
+
+	tokens := make([]token, 0, len(reversed))
+	for i := len(reversed) - 1; i >= 0; i-- {
+		t := reversed[i]
+		if !t.unknown {
+			tokens = append(tokens, t.token)
+			continue
+		}
+		// unknown tokens keep the text they replace, or fall back to the tokens of its bytes
+		t.value = word[t.start:t.end]
+		if bytes, ok := u.byteTokens(t.value); ok {
+			for _, id := range bytes {
+				tokens = append(tokens, token{id: id, value: u.tokens[id], start: t.start, end: t.end})
+			}
+			continue
+		}
+		if u.hasUnk {
+			tokens = append(tokens, t.token)
+		}
+	}
+	return tokens
+}
+
+// relax updates the best split ending at end with a token starting at start, if it scores higher.
+func (u *unigram) relax(nodes []unigramNode, start int, end int, id uint32, score float64, unknown bool) {
+	candidate := nodes[start].score + score
+	if !nodes[end].reached || candidate > nodes[end].score {
+		nodes[end] = unigramNode{score: candidate, start: start, id: id, unknown: unknown, reached: true}
+	}
+}
+
+// byteTokens returns the <0xXX> tokens of the bytes of the text, if the model falls back to bytes.
+func (u *unigram) byteTokens(text string) ([]uint32, bool) {
+	if !u.byteFallback {
+		return nil, false
+	}
+	ids := make([]uint32, len(text))
+	for i := 0; i < len(text); i++ {
+		id, ok := u.ids[fmt.Sprintf("<0x%02X>", text[i])]
+		if !ok {
+			return nil, false
+		}
+		ids[i] = id
+	}
+	return ids, true
+}
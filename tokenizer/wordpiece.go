@@ -0,0 +1,67 @@
+package tokenizer
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// wordPiece is the model of BERT and its derivatives: words are split greedily into the longest tokens of the
+// vocabulary from the start, the tokens after the first one being prefixed with ##.
+type wordPiece struct {
+	vocabulary
+	unkToken             string
+	unkID                uint32
+	prefix               string
+	maxInputCharsPerWord int
+}
+
+func newWordPiece(config *modelJSON, addedTokenIDs map[string]uint32) (*wordPiece, error) {
+	vocabulary, err := newVocabulary(config.Vocab)
+	if err != nil {
+		return nil, err
+	}
+	w := &wordPiece{vocabulary: vocabulary, unkToken: "[UNK]", prefix: "##", maxInputCharsPerWord: 100}
+	if config.UnkToken != nil {
+		w.unkToken = *config.UnkToken
+	}
+	if config.ContinuingSubwordPrefix != nil {
+		w.prefix = *config.ContinuingSubwordPrefix
+	}
+	if config.MaxInputCharsPerWord > 0 {
+		w.maxInputCharsPerWord = config.MaxInputCharsPerWord
+	}
+	var ok bool
+	if w.unkID, ok = w.unknownTokenID(w.unkToken, addedTokenIDs); !ok {
+		return nil, fmt.Errorf("the unknown token %s of the WordPiece model is not in its vocabulary", w.unkToken)
+	}
+	return w, nil
+}
+
+func (w *wordPiece) tokenize(word string) []token {
+	if utf8.RuneCountInString(word) > w.maxInputCharsPerWord {
+		return []token{{id: w.unkID, value: w.unkToken, start: 0, end: len(word)}}
+	}
+	var tokens []token
+	for start := 0; start < len(word); {
+		found := false
+		for end := len(word); end > start; {
+			candidate := word[start:end]
+			if start > 0 {
+				candidate = w.prefix + candidate
+			}
+			if id, ok := w.ids[candidate]; ok {
+				tokens = append(tokens, token{id: id, value: candidate, start: start, end: end})
+				start = end
+				found = true
+				break
+			}
+			_, size := utf8.DecodeLastRuneInString(word[start:end])
+			end -= size
+		}
+		if !found {
+			// a word that cannot be split into tokens of the vocabulary is unknown as a whole
+			return []token{{id: w.unkID, value: w.unkToken, start: 0, end: len(word)}}
+		}
+	}
+	return tokens
+}